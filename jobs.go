@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"main/scp"
+)
+
+// newJob builds the queue job for a push or pull command.
+// The host of the job is stored as "user@host:port" so it can be resumed as-is.
+// Placeholders in the destination, such as {{.Date}}, are expanded here so resuming the job keeps it.
+func newJob(command string, from string, to string) (*scp.Job, error) {
+	if command == "push" {
+		host, remotePath, err := splitRemote(to)
+		if err != nil {
+			return nil, err
+		}
+		remotePath, err = scp.ExpandPath(remotePath, scp.NewPathData(scp.Upload, host, from, scp.Tags(tags), time.Now()))
+		if err != nil {
+			return nil, err
+		}
+		return &scp.Job{Direction: scp.Upload, Host: host, Source: from, Destination: remotePath, Tags: scp.Tags(tags), Before: before, After: after}, nil
+	}
+
+	host, remotePath, err := splitRemote(from)
+	if err != nil {
+		return nil, err
+	}
+	to, err = scp.ExpandPath(to, scp.NewPathData(scp.Download, host, remotePath, scp.Tags(tags), time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	return &scp.Job{Direction: scp.Download, Host: host, Source: remotePath, Destination: to, Tags: scp.Tags(tags), Before: before, After: after}, nil
+}
+
+// splitRemote splits an scp style "[user@]host:path" argument into "user@host:port" and the path.
+// IPv6 addresses are written in brackets, as in "user@[::1]:path".
+func splitRemote(arg string) (string, string, error) {
+	// The host ends at the first colon, for IPv6 addresses at the first one after the closing bracket.
+	search := 0
+	if open := strings.Index(arg, "["); open >= 0 && open < strings.Index(arg, ":") && (open == 0 || arg[open-1] == '@') {
+		search = strings.Index(arg, "]")
+	}
+	colon := -1
+	if search >= 0 {
+		colon = strings.Index(arg[search:], ":")
+	}
+	if colon < 0 || search+colon == 0 {
+		return "", "", fmt.Errorf("%q is not a remote path of the form [user@]host:path", arg)
+	}
+	host, remotePath := arg[:search+colon], arg[search+colon+1:]
+
+	userHost, err := normalizeHost(host)
+	if err != nil {
+		return "", "", err
+	}
+	return userHost, remotePath, nil
+}
+
+// normalizeHost turns a "[user@]host[:port]" argument into "user@host:port", defaulting to the current user and -P.
+func normalizeHost(host string) (string, error) {
+	address, user, err := scp.ParseHost(host, *port)
+	if err != nil {
+		return "", err
+	}
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	return user + "@" + address, nil
+}
+
+// runJobs runs the given jobs of the queue, recording them in the history and
+// reporting whether all of them succeeded.
+func runJobs(manager *scp.ConnectionManager, queue *scp.Queue, history *scp.History, jobs []*scp.Job) bool {
+	ok := true
+	for _, job := range jobs {
+		started := time.Now()
+		err := runJob(manager, queue, job)
+		if err := history.AddJob(job, started, err); err != nil {
+			fmt.Print("(couldn't record the transfer in the history: ", err, ") ")
+		}
+		status := "done"
+		if err != nil {
+			status = fmt.Sprint("failed: ", err)
+			ok = false
+		}
+		if len(job.Tags) > 0 {
+			status += " [" + job.Tags.String() + "]"
+		}
+		fmt.Println(status)
+	}
+	_ = queue.Prune()
+	return ok
+}
+
+func runJob(manager *scp.ConnectionManager, queue *scp.Queue, job *scp.Job) error {
+	// Printed once connected, to keep the line clear of the spinner shown while connecting.
+	client, err := connect(manager, job.Host)
+	fmt.Printf("%s %s -> %s ... ", job.Direction, job.Source, job.Destination)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.RunJob(context.Background(), queue, job)
+}
@@ -2,73 +2,1047 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/bramvdbogaerde/go-scp/auth"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 	"main/scp"
+	"main/scp/auth"
 )
 
+const usage = `Usage: go-scp-tui [flags] <command> [arguments]
+
+Commands:
+  push <local file> <[user@]host:remote path>    upload a file, asks for the path when it is left empty
+  push <local path> <@group:remote path>         upload a file or directory to every host of the group
+  push - <[user@]host:remote path>               upload the standard input to a file
+  pull <[user@]host:remote path> <local file>    download a file
+  cat <[user@]host:remote path>                  download a file to the standard output, same as pull <remote> -
+  resume                                         run the transfers left in the queue
+  sync <local dir> <[user@]host:remote dir>      upload the files that changed
+  sync <[user@]host:remote dir> <local dir>      download the files that changed
+  watch <local dir> <[user@]host:remote dir>     upload files as they change
+  broadcast <local path> <remote path> <[user@]host>...
+                                                 upload a file or directory to every host at once
+  collect <remote path> <local dir> <[user@]host>...
+                                                 download a file or directory from every host into <local dir>/<host>
+  preview <[user@]host:remote path>              show the start of a remote file
+  bench <[user@]host[:remote path]>              measure the throughput of uploads and downloads
+  ping <[user@]host>                             check that the host accepts commands and measure the round trip
+  history                                        pick a past transfer to run again, or reversed
+  forget <[user@]host>                           remove the password of the host, and the passphrase of -i, from the keychain
+
+Flags:
+`
+
+var (
+	identity  = flag.String("i", "", "private key used to authenticate, defaults to the ssh agent")
+	port      = flag.Int("P", 22, "port of the SSH server")
+	backend   = flag.String("backend", "scp", "transfer protocol: scp, sftp or auto")
+	checksum  = flag.Bool("checksum", false, "sync: compare files by checksum instead of modification time")
+	dryRun    = flag.Bool("n", false, "sync: only show what would be transferred")
+	remove    = flag.Bool("delete", false, "sync: delete destination files missing from the source, after confirmation")
+	maxDel    = flag.Int("max-delete", scp.DefaultMaxDeletePercent, "sync: refuse to delete more than this percentage of the destination files")
+	keyring   = flag.Bool("keyring", false, "store passwords and passphrases in the keychain of the operating system")
+	password  = flag.String("password", "", "read the password, or the passphrase of -i, from env:NAME, stdin, or askpass[:program]")
+	skipEmpty = flag.Bool("skip-empty-dirs", false, "sync: do not create source directories without any files")
+	noPart    = flag.Bool("no-part", false, "write downloads straight to their destination instead of a .part file renamed once complete")
+	keepPart  = flag.Bool("keep-part", false, "sync: keep the .part file of a failed download instead of deleting it")
+	diskSpace = flag.Bool("check-space", false, "refuse uploads that do not fit in the free space of the remote, as reported by df")
+	benchSize = flag.String("size", "100M", "bench: amount of data to transfer, with an optional K, M or G suffix; push -: the size of the standard input")
+	perHost   = flag.Int("max-sessions-per-host", 0, "open at most this many sessions to a host at once, over all its connections; 0 for no bound")
+	progress  = flag.String("progress", "auto", "how progress is shown: terminal, plain lines for logs, none, or auto for terminal when stdout is one")
+	recordsFD = flag.Int("progress-fd", 0, "also write the progress as lines of JSON to this file descriptor, such as 2 for stderr")
+	archive   = flag.Bool("archive", false, "push a directory as a single <dir>.tar.gz, much faster than file by file for many small files")
+	extract   = flag.Bool("extract", false, "push -archive: extract the archive on the remote into the remote path, a directory, and remove it")
+	compress  = flag.Bool("gzip", false, "compress push and pull with gzip on the fly, running gzip on the remote as well; pays off for text over slow links")
+	textMode  = flag.String("text", "off", "convert line endings of push and pull: lf or crlf for the line endings on the remote, off to transfer files as they are")
+	encrypt   = flag.String("encrypt", "", "encrypt push with this command, such as 'age -r age1...', so the remote stores ciphertext")
+	decrypt   = flag.String("decrypt", "", "decrypt pull with this command, such as 'age -d -i key.txt'")
+	verify    = flag.Bool("verify", false, "compare the sha256sum of uploaded files on the remote with the source, uploading them again when they differ")
+	parallel  = flag.Int("parallel", 8, "broadcast, collect and groups: transfer with at most this many hosts at once, 0 for all of them")
+	tags      = tagFlag{}
+	before    = hookFlag{}
+	after     = hookFlag{}
+	totp      = flag.String("totp", "", "read the base32 TOTP secret answering verification code prompts from env:NAME, stdin, or askpass[:program]")
+)
+
+// config the settings read from the config file.
+type config struct {
+	Theme scp.Theme `json:"theme"`
+
+	// Proxies the proxy URL to connect through by host name, "*" applies to all other hosts.
+	Proxies map[string]string `json:"proxies"`
+
+	// Groups the hosts, as [user@]host[:port], of every group by its name. Groups are addressed as @name.
+	Groups map[string][]string `json:"groups"`
+
+	// RemoteOS the operating system, unix, windows, powershell or auto, by host name, "*" applies to
+	// all other hosts. Hosts not listed are detected.
+	RemoteOS map[string]string `json:"remote_os"`
+
+	// KnownHosts the known_hosts file host keys are checked against and recorded in, OpenSSH's
+	// ~/.ssh/known_hosts when empty.
+	KnownHosts string `json:"known_hosts"`
+}
+
+// proxyFor returns the proxy configured for the host of the address, if any.
+func (c config) proxyFor(address string) string {
+	host, _, _ := net.SplitHostPort(address)
+	if proxy, ok := c.Proxies[host]; ok {
+		return proxy
+	}
+	return c.Proxies["*"]
+}
+
+// remoteOSFor returns the operating system configured for the host of the address, auto when there is none.
+func (c config) remoteOSFor(address string) (scp.RemoteOS, error) {
+	host, _, _ := net.SplitHostPort(address)
+	name, ok := c.RemoteOS[host]
+	if !ok {
+		name, ok = c.RemoteOS["*"]
+	}
+	if !ok {
+		return scp.RemoteOSAuto, nil
+	}
+	for _, o := range []scp.RemoteOS{scp.RemoteUnix, scp.RemoteWindows, scp.RemoteWindowsPowerShell, scp.RemoteOSAuto} {
+		if o.String() == name {
+			return o, nil
+		}
+	}
+	return scp.RemoteOSAuto, fmt.Errorf("unknown remote_os %q for %s, expected unix, windows, powershell or auto", name, host)
+}
+
+var settings config
+
+// passwordSource the source given by -password, nil when there is none.
+var passwordSource auth.PasswordSource
+
+// codeSource generates verification codes from the secret given by -totp, nil when there is none.
+var codeSource auth.PasswordSource
+
+// progressOutput how progress is shown, as given by -progress.
+var progressOutput scp.ProgressOutput
+
+// progressRecords receives the progress as lines of JSON, the file descriptor given by -progress-fd or nil.
+var progressRecords io.Writer
+
 func main() {
-	// Use SSH key authentication from the auth package
-	// we ignore the host key in this example, please change this if you use this library
-	clientConfig, _ := auth.PasswordKey(
-		"claud",
-		"pass",
-		ssh.InsecureIgnoreHostKey(),
-	)
+	flag.Var(tags, "tag", "attach key=value to the transfers, shown in the log and the report; repeatable")
+	flag.Var(&before, "before", "run the command locally before a push or pull starts, or on the remote when prefixed with remote:; the transfer is aborted when it fails; repeatable")
+	flag.Var(&after, "after", "run the command locally after a push or pull succeeded, or on the remote when prefixed with remote:; repeatable")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+
+	if err := loadConfig(configPath("config.json"), &settings); err != nil {
+		fmt.Println("Couldn't load the config ", err)
+		os.Exit(1)
+	}
+
+	if *password != "" {
+		source, err := parsePasswordSource(*password)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		passwordSource = source
+	}
+	if *totp != "" {
+		source, err := parseTOTP(*totp)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		codeSource = source
+	}
+	output, err := parseProgressOutput(*progress)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	progressOutput = output
+	if *recordsFD > 0 {
+		progressRecords = os.NewFile(uintptr(*recordsFD), "progress")
+	}
+
+	queue, err := scp.LoadQueue(configPath("queue.json"))
+	if err != nil {
+		fmt.Println("Couldn't load the transfer queue ", err)
+		os.Exit(1)
+	}
+
+	history, err := scp.LoadHistory(configPath("history.json"))
+	if err != nil {
+		fmt.Println("Couldn't load the transfer history ", err)
+		os.Exit(1)
+	}
+
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	manager := scp.NewConnectionManager()
+	manager.MaxSessionsPerHost = *perHost
+	defer manager.Close()
+
+	var jobs []*scp.Job
+	pending := queue.Pending()
+
+	switch args[0] {
+	case "push", "pull":
+		if len(args) != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		to := args[2]
+		if args[0] == "pull" && to == "-" {
+			runCatCommand(manager, args[1])
+			return
+		}
+		if args[0] == "push" && *archive {
+			if err := runArchiveUpload(manager, args[1], to); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+		if args[0] == "push" && args[1] == "-" {
+			if err := runStdinUpload(manager, to); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+		if args[0] == "push" && strings.HasPrefix(to, "@") {
+			group, remotePath, ok := strings.Cut(to, ":")
+			if !ok {
+				fmt.Printf("%q is not a remote path of the form @group:path\n", to)
+				os.Exit(2)
+			}
+			if err := runBroadcast(manager, args[1], remotePath, []string{group}); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if len(pending) > 0 && confirm(fmt.Sprintf("Resume %d unfinished transfer(s) first?", len(pending))) {
+			jobs = pending
+		}
+		if args[0] == "push" && strings.HasSuffix(to, ":") {
+			if to, err = promptDestination(manager, to); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		job, err := newJob(args[0], args[1], to)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if err := queue.Add(job); err != nil {
+			fmt.Println("Couldn't queue the transfer ", err)
+			os.Exit(1)
+		}
+		jobs = append(jobs, job)
+	case "resume":
+		jobs = pending
+	case "history":
+		entry, err := scp.PickHistory(settings.Theme, history.Entries)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		job := entry.Job()
+		if err := queue.Add(job); err != nil {
+			fmt.Println("Couldn't queue the transfer ", err)
+			os.Exit(1)
+		}
+		jobs = append(jobs, job)
+	case "sync":
+		if len(args) != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := runSync(manager, args[1], args[2]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "broadcast":
+		if len(args) < 4 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := runBroadcast(manager, args[1], args[2], args[3:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "collect":
+		if len(args) < 4 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := runCollect(manager, args[1], args[2], args[3:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "bench":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := runBench(manager, args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "ping":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := runPing(manager, args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "cat":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		runCatCommand(manager, args[1])
+		return
+	case "preview":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := runPreview(manager, args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "forget":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := forget(args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "watch":
+		if len(args) != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := runWatch(manager, args[1], args[2]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	client := scp.NewConfigurer("localhost:22", &clientConfig).Create()
+	if !runJobs(manager, queue, history, jobs) {
+		os.Exit(1)
+	}
+}
 
-	// Connect to the remote server
-	err := client.Connect()
+// runStdinUpload uploads the standard input to the remote file of "[user@]host:path", as in
+// `pg_dump | go-scp-tui push - host:/backups/db.sql`. Its size is given by -size for an accurate
+// progress bar, without it the standard input is read first, spooled to a temporary file when it is long.
+func runStdinUpload(manager *scp.ConnectionManager, remote string) error {
+	host, remotePath, err := splitRemote(remote)
 	if err != nil {
-		fmt.Println("Couldn't establish a connection to the remote server ", err)
+		return err
+	}
+	if remotePath == "" || strings.HasSuffix(remotePath, "/") {
+		return fmt.Errorf("%q names no file to upload the standard input to", remote)
+	}
+	remotePath, err = scp.ExpandPath(remotePath, scp.NewPathData(scp.Upload, host, path.Base(remotePath), scp.Tags(tags), time.Now()))
+	if err != nil {
+		return err
 	}
 
-	// Open a file
-	f, _ := os.OpenFile("./hello.txt", os.O_CREATE, 0644)
+	size := int64(-1)
+	if isFlagSet("size") {
+		if size, err = parseSize(*benchSize); err != nil {
+			return err
+		}
+	}
 
-	// Close client connection after the file has been copied
+	client, err := connect(manager, host)
+	if err != nil {
+		return err
+	}
 	defer client.Close()
 
-	// Close the file after it has been copied
-	defer f.Close()
+	ctx := scp.WithTags(context.Background(), scp.Tags(tags))
+	return client.CopyStreamProgress(ctx, os.Stdin, remotePath, "0644", size)
+}
 
-	fileInfos, err := client.CopyFromRemoteFileInfos(context.Background(), f, "hello.txt", nil)
+// runArchiveUpload uploads the local directory as a gzipped tar archive to the remote path of
+// "[user@]host:path", named after the directory when the path ends with a slash. With -extract the
+// remote path is the directory to extract the archive into, next to which it is uploaded.
+func runArchiveUpload(manager *scp.ConnectionManager, localDir string, remote string) error {
+	host, remotePath, err := splitRemote(remote)
+	if err != nil {
+		return err
+	}
+	remotePath, err = scp.ExpandPath(remotePath, scp.NewPathData(scp.Upload, host, localDir, scp.Tags(tags), time.Now()))
+	if err != nil {
+		return err
+	}
+
+	archivePath := scp.ArchivePath(localDir, remotePath)
+	if *extract {
+		if *encrypt != "" {
+			return errors.New("an encrypted archive can not be extracted on the remote")
+		}
+		dir := path.Clean(remotePath)
+		archivePath = path.Join(path.Dir(dir), "."+path.Base(dir)+".go-scp-tui.tar.gz")
+	}
 
+	client, err := connect(manager, host)
 	if err != nil {
-		fmt.Println("Error while copying file ", err)
-		return
+		return err
+	}
+	defer client.Close()
+
+	ctx := scp.WithTags(context.Background(), scp.Tags(tags))
+	entries, err := client.CopyDirToRemoteArchiveProgress(ctx, localDir, archivePath, scp.TarOptions{})
+	if err != nil {
+		return err
+	}
+	if !*extract {
+		fmt.Printf("archived %d entries to %s\n", len(entries), archivePath)
+		return nil
+	}
+	if err := client.ExtractRemoteArchive(ctx, archivePath, remotePath, true); err != nil {
+		return err
+	}
+	fmt.Printf("extracted %d entries into %s\n", len(entries), remotePath)
+	return nil
+}
+
+// runCatCommand writes the remote file of "[user@]host:path" to the standard output, as in
+// `go-scp-tui cat host:/var/log/app.log | grep ERROR`. Nothing but the file is written to it:
+// progress is not shown, prompts and errors go to stderr instead.
+func runCatCommand(manager *scp.ConnectionManager, remote string) {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	termenv.SetDefaultOutput(termenv.NewOutput(os.Stderr))
+	lipgloss.SetDefaultRenderer(lipgloss.NewRenderer(os.Stderr))
+	progressOutput = scp.ProgressNone
+
+	if err := runCat(manager, remote, stdout); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func runCat(manager *scp.ConnectionManager, remote string, w io.Writer) error {
+	host, remotePath, err := splitRemote(remote)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println(fileInfos)
+	client, err := connect(manager, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
 
-	fileStat, err := os.Stat("./hello.txt")
+	ctx := scp.WithTags(context.Background(), scp.Tags(tags))
+	return client.CopyFromRemoteProgressPassThru(ctx, w, remotePath, nil)
+}
+
+// isFlagSet reports whether the flag of the given name was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// promptDestination asks for the remote path of a push to "[user@]host:", completing it on the remote.
+func promptDestination(manager *scp.ConnectionManager, remote string) (string, error) {
+	host, _, err := splitRemote(remote)
 	if err != nil {
-		fmt.Println("Error while getting file stat ", err)
+		return "", err
 	}
 
+	client, err := connect(manager, host)
 	if err != nil {
-		fmt.Println("Error while getting file stat ", err)
+		return "", err
 	}
+	defer client.Close()
 
-	if fileStat.Size() != fileInfos.Size {
-		fmt.Println("File size does not match")
+	remotePath, err := client.PromptRemotePath(context.Background(), "Remote path:", "")
+	if err != nil {
+		return "", err
 	}
+	return remote + remotePath, nil
+}
 
-	fileStat, _ = os.Stat("C:Users/claud/hello.txt")
-	fmt.Println(fileStat.Mode().Perm())
-	fmt.Println(os.FileMode(fileInfos.Permissions))
-	if fileStat.Mode().Perm() != os.FileMode(fileInfos.Permissions) {
-		fmt.Println("File permissions do not match")
+// runSync syncs a local and a remote directory, the side with the host is the destination
+// when it is the second argument.
+func runSync(manager *scp.ConnectionManager, from string, to string) error {
+	upload := !strings.Contains(from, ":")
+	remote := from
+	if upload {
+		remote = to
+	}
+	host, remoteDir, err := splitRemote(remote)
+	if err != nil {
+		return err
 	}
 
-	if fileStat.ModTime().Unix() != fileInfos.Mtime {
-		fmt.Println(fileStat.ModTime().Local().Unix())
-		fmt.Println(fileInfos.Mtime)
-		fmt.Println("File modification time does not match")
+	client, err := connect(manager, host)
+	if err != nil {
+		return err
 	}
+	defer client.Close()
 
+	opts := scp.SyncOptions{
+		Checksum:         *checksum,
+		DryRun:           *dryRun,
+		Delete:           *remove,
+		MaxDeletePercent: *maxDel,
+		SkipEmptyDirs:    *skipEmpty,
+		ConfirmDelete: func(deletes []scp.SyncEntry) bool {
+			for _, entry := range deletes {
+				fmt.Printf("delete %s\n", entry.Path)
+			}
+			return confirm(fmt.Sprintf("Delete these %d file(s)?", len(deletes)))
+		},
+	}
+	var plan []scp.SyncEntry
+	if upload {
+		plan, err = client.SyncToRemote(scp.WithTags(context.Background(), scp.Tags(tags)), from, remoteDir, opts)
+	} else {
+		plan, err = client.SyncFromRemote(scp.WithTags(context.Background(), scp.Tags(tags)), remoteDir, to, opts)
+	}
+
+	for _, entry := range plan {
+		if entry.Action == scp.SyncSkip {
+			continue
+		}
+		if entry.Err != nil {
+			fmt.Printf("%-6s %s: %v\n", entry.Action, entry.Path, entry.Err)
+			continue
+		}
+		fmt.Printf("%-6s %s\n", entry.Action, entry.Path)
+	}
+	return err
+}
+
+// runWatch uploads the files changing in localDir to the remote directory until the interface is quit.
+func runWatch(manager *scp.ConnectionManager, localDir string, remote string) error {
+	host, remoteDir, err := splitRemote(remote)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(manager, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.WatchProgress(scp.WithTags(context.Background(), scp.Tags(tags)), localDir, remoteDir, scp.WatchOptions{})
+}
+
+// runBroadcast uploads the local file or directory to the same remote path on every host, groups are
+// expanded to their members. A remote path ending with a slash is the directory to upload into.
+func runBroadcast(manager *scp.ConnectionManager, localPath string, remotePath string, hosts []string) error {
+	if strings.HasSuffix(remotePath, "/") {
+		remotePath = path.Join(remotePath, filepath.Base(localPath))
+	}
+	return fanOut(manager, hosts, "upload", func(ctx context.Context, hosts []string, connect scp.BroadcastConnect) []scp.BroadcastResult {
+		return scp.BroadcastProgress(ctx, settings.Theme, hosts, connect, localPath, remotePath, scp.BroadcastOptions{Parallelism: *parallel, Output: progressOutput})
+	})
+}
+
+// runCollect downloads the same remote file or directory from every host into a directory per host
+// below localDir, groups are expanded to their members.
+func runCollect(manager *scp.ConnectionManager, remotePath string, localDir string, hosts []string) error {
+	return fanOut(manager, hosts, "download", func(ctx context.Context, hosts []string, connect scp.BroadcastConnect) []scp.BroadcastResult {
+		return scp.CollectProgress(ctx, settings.Theme, hosts, connect, remotePath, localDir, scp.BroadcastOptions{Parallelism: *parallel, Output: progressOutput})
+	})
+}
+
+// fanOut runs the transfers of run on every host, groups expanded to their members, and reports
+// on how many of them the transfer, described by what, failed.
+func fanOut(
+	manager *scp.ConnectionManager,
+	hosts []string,
+	what string,
+	run func(ctx context.Context, hosts []string, connect scp.BroadcastConnect) []scp.BroadcastResult,
+) error {
+	hosts, err := scp.ExpandGroups(settings.Groups, hosts)
+	if err != nil {
+		return err
+	}
+
+	// Connect up front, so host keys and passwords are asked for before the progress is shown.
+	// The transfers then share these connections.
+	failed := map[string]error{}
+	for i, host := range hosts {
+		userHost, err := normalizeHost(host)
+		if err != nil {
+			return err
+		}
+		hosts[i] = userHost
+
+		client, err := connect(manager, userHost)
+		if err != nil {
+			failed[userHost] = err
+			continue
+		}
+		defer client.Close()
+	}
+
+	ctx := scp.WithTags(context.Background(), scp.Tags(tags))
+	results := run(ctx, hosts, func(ctx context.Context, host string) (scp.Client, error) {
+		if err := failed[host]; err != nil {
+			return scp.Client{}, err
+		}
+		return connect(manager, host)
+	})
+
+	failures := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failures++
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("the %s failed on %d of %d hosts", what, failures, len(hosts))
+	}
+	return nil
+}
+
+// runBench uploads and downloads generated data of -size to the remote path, a file in the home
+// directory when none is given, and prints the results.
+func runBench(manager *scp.ConnectionManager, remote string) error {
+	size, err := parseSize(*benchSize)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(remote, ":") {
+		remote += ":"
+	}
+	host, remotePath, err := splitRemote(remote)
+	if err != nil {
+		return err
+	}
+	if remotePath == "" {
+		remotePath = ".go-scp-tui-bench"
+	}
+
+	client, err := connect(manager, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	results, err := client.Bench(context.Background(), size, remotePath)
+	for _, result := range results {
+		fmt.Println(result)
+	}
+	return err
+}
+
+// runPing connects to "[user@]host" and prints how long running a command on it takes.
+func runPing(manager *scp.ConnectionManager, host string) error {
+	client, err := connect(manager, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rtt, err := client.Ping(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is alive, round trip %s\n", client.Host, rtt.Round(10*time.Microsecond))
+	return nil
+}
+
+// parseSize parses an amount of bytes with an optional K, M or G suffix, as powers of 1024.
+func parseSize(s string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// runPreview shows the start of a remote file without downloading all of it.
+func runPreview(manager *scp.ConnectionManager, remote string) error {
+	host, remotePath, err := splitRemote(remote)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(manager, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.PreviewProgress(context.Background(), remotePath, 0)
+}
+
+// connect returns a client for the "user@host:port" host, sharing connections through the manager.
+func connect(manager *scp.ConnectionManager, userHost string) (scp.Client, error) {
+	host, user, err := scp.ParseHost(userHost, scp.DefaultPort)
+	if err != nil {
+		return scp.Client{}, err
+	}
+
+	clientConfig, commit, err := buildClientConfig(user, host)
+	if err != nil {
+		return scp.Client{}, err
+	}
+
+	transferBackend, err := parseBackend(*backend)
+	if err != nil {
+		return scp.Client{}, err
+	}
+
+	mode, err := parseTextMode(*textMode)
+	if err != nil {
+		return scp.Client{}, err
+	}
+
+	remoteOS, err := settings.remoteOSFor(host)
+	if err != nil {
+		return scp.Client{}, err
+	}
+
+	configurer := scp.NewConfigurer(host, &clientConfig).
+		Backend(transferBackend).
+		Theme(settings.Theme).
+		Proxy(settings.proxyFor(host)).
+		RemoteOS(remoteOS).
+		Compat(scp.CompatAuto).
+		DirectDownloads(*noPart).
+		ExpandTilde(true).
+		CheckRemoteSpace(*diskSpace).
+		ProgressOutput(progressOutput).
+		ProgressJSON(progressRecords).
+		VerifyUploads(*verify).
+		GzipUploads(*compress).
+		GzipDownloads(*compress).
+		TextMode(mode)
+	if *encrypt != "" || *decrypt != "" {
+		configurer.Cipher(scp.CommandCipher{EncryptCommand: *encrypt, DecryptCommand: *decrypt})
+	}
+	if *keepPart {
+		configurer.PartialPolicy(scp.PartialKeep)
+	}
+	client, err := manager.ClientProgress(context.Background(), configurer)
+	if commitErr := commit(err); commitErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", commitErr)
+	}
+	if err != nil {
+		return scp.Client{}, fmt.Errorf("couldn't establish a connection to the remote server: %w", err)
+	}
+	return client, nil
+}
+
+func parseBackend(name string) (scp.Backend, error) {
+	for _, b := range []scp.Backend{scp.BackendSCP, scp.BackendSFTP, scp.BackendAuto} {
+		if b.String() == name {
+			return b, nil
+		}
+	}
+	return scp.BackendSCP, fmt.Errorf("unknown backend %q, expected scp, sftp or auto", name)
+}
+
+func parseTextMode(name string) (scp.TextMode, error) {
+	for _, m := range []scp.TextMode{scp.TextOff, scp.TextRemoteLF, scp.TextRemoteCRLF} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return scp.TextOff, fmt.Errorf("unknown text mode %q, expected off, lf or crlf", name)
+}
+
+func parseProgressOutput(name string) (scp.ProgressOutput, error) {
+	for _, o := range []scp.ProgressOutput{scp.ProgressAuto, scp.ProgressTerminal, scp.ProgressPlain, scp.ProgressNone} {
+		if o.String() == name {
+			return o, nil
+		}
+	}
+	return scp.ProgressAuto, fmt.Errorf("unknown progress output %q, expected auto, terminal, plain or none", name)
+}
+
+// buildClientConfig builds the client config for the host. The returned commit is called with the
+// outcome of connecting, which stores the passwords read with -keyring once they were accepted.
+func buildClientConfig(user string, host string) (ssh.ClientConfig, auth.KeyringCommit, error) {
+	// Unknown hosts are only asked about when there is someone to answer.
+	hostKeyCallback, err := auth.TrustOnFirstUse(knownHostsPath())
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		hostKeyCallback, err = auth.KnownHosts(knownHostsPath(), scp.PromptHostKey(settings.Theme))
+	}
+	if err != nil {
+		return ssh.ClientConfig{}, nil, err
+	}
+
+	// Servers may ask for the password and a second factor through keyboard-interactive instead,
+	// whatever is not given by flags is asked for in the terminal.
+	password, commit := passwordFor(user + "@" + host)
+	methods, err := authMethods(user, password, hostKeyCallback)
+	if err != nil {
+		return ssh.ClientConfig{}, nil, err
+	}
+	code := codeSource
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		if password == nil {
+			password = scp.PromptSecret(settings.Theme)
+		}
+		if code == nil {
+			code = scp.PromptSecret(settings.Theme)
+		}
+	}
+	if password != nil || code != nil {
+		methods = append(methods, ssh.KeyboardInteractive(auth.Challenge(password, code)))
+	}
+	if len(methods) == 0 {
+		return ssh.ClientConfig{}, nil, errors.New("no authentication method available, pass -i or -password or start an ssh agent")
+	}
+
+	config := ssh.ClientConfig{User: user, Auth: methods, HostKeyCallback: hostKeyCallback}
+	// Negotiate the types of the keys recorded for the host, so they can be checked.
+	if config.HostKeyAlgorithms, err = auth.HostKeyAlgorithms(knownHostsPath(), host); err != nil {
+		return ssh.ClientConfig{}, nil, err
+	}
+	return config, commit, nil
+}
+
+// authMethods returns the authentication methods given by the flags, along keyboard-interactive which
+// buildClientConfig adds: the key of -i, else the password of `password` when given, else the ssh agent.
+func authMethods(user string, password auth.PasswordSource, hostKeyCallback ssh.HostKeyCallback) ([]ssh.AuthMethod, error) {
+	var config ssh.ClientConfig
+	var err error
+	switch {
+	case *identity != "":
+		if source, commit := passwordFor(identityAccount()); source != nil {
+			config, err = auth.PrivateKeyWithPassphraseSource(user, source, *identity, hostKeyCallback)
+			// The passphrase is known to be right once the key is parsed.
+			if commitErr := commit(err); err == nil {
+				err = commitErr
+			}
+		} else {
+			config, err = auth.PrivateKey(user, *identity, hostKeyCallback)
+		}
+	case password != nil:
+		config, err = auth.PasswordFromSource(user, password, hostKeyCallback)
+	case os.Getenv("SSH_AUTH_SOCK") != "":
+		config, err = auth.SshAgentWithTouch(user, touchSecurityKey, hostKeyCallback)
+	}
+	return config.Auth, err
+}
+
+// touchSecurityKey asks to touch the security key about to sign, the agent waits for it meanwhile.
+func touchSecurityKey(key ssh.PublicKey) {
+	fmt.Fprintf(os.Stderr, "Confirm user presence for key %s %s\n", key.Type(), ssh.FingerprintSHA256(key))
+}
+
+// passwordFor returns the source of the password of `account`, which is stored in the keychain
+// with -keyring once the returned commit is called with the outcome of using it. The source is
+// nil when neither -password nor -keyring is given.
+func passwordFor(account string) (auth.PasswordSource, auth.KeyringCommit) {
+	if !*keyring {
+		return passwordSource, noCommit
+	}
+	fallback := passwordSource
+	if fallback == nil {
+		fallback = auth.PasswordFromTerminal()
+	}
+	return auth.PasswordFromKeyring(account, fallback)
+}
+
+// noCommit the commit of passwords not stored in the keychain.
+func noCommit(error) error {
+	return nil
+}
+
+// identityAccount the keychain account of the passphrase of -i.
+func identityAccount() string {
+	path, err := filepath.Abs(*identity)
+	if err != nil {
+		return *identity
+	}
+	return path
+}
+
+// forget removes the password of a host, and the passphrase of -i when given, from the keychain.
+func forget(host string) error {
+	userHost, err := normalizeHost(host)
+	if err != nil {
+		return err
+	}
+	if err := auth.ForgetKeyring(userHost); err != nil {
+		return err
+	}
+	if *identity != "" {
+		return auth.ForgetKeyring(identityAccount())
+	}
+	return nil
+}
+
+// tagFlag collects the key=value pairs of the repeatable -tag flag.
+type tagFlag map[string]string
+
+func (t tagFlag) String() string {
+	return scp.Tags(t).String()
+}
+
+func (t tagFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("tag %q is not of the form key=value", value)
+	}
+	t[key] = val
+	return nil
+}
+
+// hookFlag collects the commands of the repeatable -before and -after flags, those prefixed with "remote:" run on the remote.
+type hookFlag []scp.Hook
+
+func (h *hookFlag) String() string {
+	commands := make([]string, len(*h))
+	for i, hook := range *h {
+		commands[i] = hook.String()
+	}
+	return strings.Join(commands, ", ")
+}
+
+func (h *hookFlag) Set(value string) error {
+	command, remote := strings.CutPrefix(value, "remote:")
+	if strings.TrimSpace(command) == "" {
+		return errors.New("the command of a hook is empty")
+	}
+	*h = append(*h, scp.Hook{Command: command, Remote: remote})
+	return nil
+}
+
+// parseTOTP reads the TOTP secret from the source given by -totp.
+func parseTOTP(spec string) (auth.PasswordSource, error) {
+	source, err := parsePasswordSource(spec)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := source("TOTP secret: ")
+	if err != nil {
+		return nil, err
+	}
+	return auth.TOTP(string(secret))
+}
+
+// parsePasswordSource parses the -password flag: "env:NAME", "stdin", "askpass" or "askpass:program".
+func parsePasswordSource(spec string) (auth.PasswordSource, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch {
+	case kind == "env" && arg != "":
+		return auth.PasswordFromEnv(arg), nil
+	case kind == "stdin" && arg == "":
+		return auth.PasswordFromReader(os.Stdin), nil
+	case kind == "askpass":
+		return auth.PasswordFromAskpass(arg), nil
+	}
+	return nil, fmt.Errorf("invalid password source %q, expected env:NAME, stdin or askpass[:program]", spec)
+}
+
+// confirm asks a yes/no question in the terminal, defaulting to no.
+func confirm(question string) bool {
+	return scp.PromptConfirm(settings.Theme)(question)
+}
+
+// configPath returns the path of a file in the configuration directory of the tool.
+func configPath(name string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "go-scp-tui", name)
+}
+
+// knownHostsPath returns the known_hosts file of the config, by default the one of OpenSSH, so hosts
+// trusted there are trusted here as well.
+func knownHostsPath() string {
+	if settings.KnownHosts != "" {
+		return settings.KnownHosts
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return configPath("known_hosts")
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// loadConfig reads the JSON config file at path into c, a missing file keeps the defaults.
+func loadConfig(path string, c *config) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
 }
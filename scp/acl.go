@@ -0,0 +1,236 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The PAX records GNU tar keeps ACLs and SELinux contexts in. ACLs are written as text, such as
+// "user::rw-,user:1000:r--,group::r--,mask::r--,other::r--", where Linux exposes them as
+// extended attributes in a binary form.
+const (
+	paxACLAccess  = "SCHILY.acl.access"
+	paxACLDefault = "SCHILY.acl.default"
+	paxSELinux    = "RHT.security.selinux"
+
+	xattrACLAccess  = "system.posix_acl_access"
+	xattrACLDefault = "system.posix_acl_default"
+	xattrSELinux    = "security.selinux"
+)
+
+// The tags of the entries of a POSIX ACL in its extended attribute.
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+
+	aclXattrVersion = 2
+	aclUndefinedID  = 0xffffffff
+)
+
+// paxRecord returns the PAX record holding the extended attribute name with value, the way GNU tar writes it.
+func paxRecord(name string, value string) (string, string, error) {
+	switch name {
+	case xattrACLAccess, xattrACLDefault:
+		text, err := aclText([]byte(value))
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if name == xattrACLAccess {
+			return paxACLAccess, text, nil
+		}
+		return paxACLDefault, text, nil
+	case xattrSELinux:
+		return paxSELinux, strings.TrimRight(value, "\x00"), nil
+	default:
+		return paxXattrPrefix + name, value, nil
+	}
+}
+
+// paxXattr returns the extended attribute held by the PAX record key with value, reporting false
+// for records not holding one.
+func paxXattr(key string, value string) (string, string, bool, error) {
+	switch key {
+	case paxACLAccess, paxACLDefault:
+		acl, err := aclXattr(value)
+		if err != nil {
+			return "", "", false, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		if key == paxACLAccess {
+			return xattrACLAccess, string(acl), true, nil
+		}
+		return xattrACLDefault, string(acl), true, nil
+	case paxSELinux:
+		return xattrSELinux, value, true, nil
+	}
+	name, ok := strings.CutPrefix(key, paxXattrPrefix)
+	return name, value, ok, nil
+}
+
+// aclText writes the ACL in the binary form of its extended attribute as text, with numeric ids.
+func aclText(acl []byte) (string, error) {
+	if len(acl) < 4 || binary.LittleEndian.Uint32(acl) != aclXattrVersion || (len(acl)-4)%8 != 0 {
+		return "", fmt.Errorf("malformed ACL of %d bytes", len(acl))
+	}
+	var entries []string
+	for rest := acl[4:]; len(rest) > 0; rest = rest[8:] {
+		tag := binary.LittleEndian.Uint16(rest)
+		perm := binary.LittleEndian.Uint16(rest[2:])
+		id := binary.LittleEndian.Uint32(rest[4:])
+
+		var entry string
+		switch tag {
+		case aclUserObj:
+			entry = "user:"
+		case aclUser:
+			entry = "user:" + strconv.FormatUint(uint64(id), 10)
+		case aclGroupObj:
+			entry = "group:"
+		case aclGroup:
+			entry = "group:" + strconv.FormatUint(uint64(id), 10)
+		case aclMask:
+			entry = "mask:"
+		case aclOther:
+			entry = "other:"
+		default:
+			return "", fmt.Errorf("unknown ACL tag %#x", tag)
+		}
+		entries = append(entries, entry+":"+aclPerms(perm))
+	}
+	return strings.Join(entries, ","), nil
+}
+
+func aclPerms(perm uint16) string {
+	perms := []byte("---")
+	for i, c := range "rwx" {
+		if perm&(4>>i) != 0 {
+			perms[i] = byte(c)
+		}
+	}
+	return string(perms)
+}
+
+// aclXattr reads the ACL from text and returns it in the binary form of its extended attribute.
+// Entries are separated by commas or new lines, and name users and groups by name or id. The id
+// star and GNU tar add as a fourth field takes precedence over the name.
+func aclXattr(text string) ([]byte, error) {
+	type aclEntry struct {
+		tag  uint16
+		perm uint16
+		id   uint32
+	}
+	var entries []aclEntry
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+		field, _, _ = strings.Cut(field, "#")
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.Split(field, ":")
+		if len(parts) < 3 || len(parts) > 4 {
+			return nil, fmt.Errorf("malformed ACL entry %q", field)
+		}
+
+		entry := aclEntry{id: aclUndefinedID}
+		qualified := parts[1] != ""
+		switch parts[0] {
+		case "user", "u":
+			entry.tag = aclUserObj
+			if qualified {
+				entry.tag = aclUser
+			}
+		case "group", "g":
+			entry.tag = aclGroupObj
+			if qualified {
+				entry.tag = aclGroup
+			}
+		case "mask", "m":
+			entry.tag = aclMask
+		case "other", "o":
+			entry.tag = aclOther
+		default:
+			return nil, fmt.Errorf("malformed ACL entry %q", field)
+		}
+		if qualified && entry.tag != aclUser && entry.tag != aclGroup {
+			return nil, fmt.Errorf("malformed ACL entry %q", field)
+		}
+
+		for _, c := range parts[2] {
+			switch c {
+			case 'r':
+				entry.perm |= 4
+			case 'w':
+				entry.perm |= 2
+			case 'x':
+				entry.perm |= 1
+			case '-':
+			default:
+				return nil, fmt.Errorf("malformed ACL entry %q", field)
+			}
+		}
+
+		if qualified {
+			qualifier := parts[1]
+			if len(parts) == 4 {
+				qualifier = parts[3]
+			}
+			id, err := aclID(qualifier, entry.tag == aclUser)
+			if err != nil {
+				return nil, err
+			}
+			entry.id = id
+		}
+		entries = append(entries, entry)
+	}
+
+	// The kernel takes entries ordered by their tag and id only.
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].tag != entries[j].tag {
+			return entries[i].tag < entries[j].tag
+		}
+		return entries[i].id < entries[j].id
+	})
+	acl := binary.LittleEndian.AppendUint32(nil, aclXattrVersion)
+	for _, entry := range entries {
+		acl = binary.LittleEndian.AppendUint16(acl, entry.tag)
+		acl = binary.LittleEndian.AppendUint16(acl, entry.perm)
+		acl = binary.LittleEndian.AppendUint32(acl, entry.id)
+	}
+	return acl, nil
+}
+
+// aclID resolves the user, or group, named by an ACL entry to its id.
+func aclID(qualifier string, isUser bool) (uint32, error) {
+	if id, err := strconv.ParseUint(qualifier, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	var id string
+	if isUser {
+		u, err := user.Lookup(qualifier)
+		if err != nil {
+			return 0, err
+		}
+		id = u.Uid
+	} else {
+		g, err := user.LookupGroup(qualifier)
+		if err != nil {
+			return 0, err
+		}
+		id = g.Gid
+	}
+	n, err := strconv.ParseUint(id, 10, 32)
+	return uint32(n), err
+}
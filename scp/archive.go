@@ -0,0 +1,105 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ArchivePath returns the remote path of the archive of localDir uploaded to remotePath by
+// CopyDirToRemoteArchive: remotePath itself, or `<name of localDir>.tar.gz` inside it when it
+// ends with a slash.
+func ArchivePath(localDir string, remotePath string) string {
+	if remotePath == "" || strings.HasSuffix(remotePath, "/") {
+		return path.Join(remotePath, filepath.Base(filepath.Clean(localDir))+".tar.gz")
+	}
+	return remotePath
+}
+
+// CopyDirToRemoteArchive uploads the local directory `localDir` as a single gzipped tar archive, to the
+// remote file returned by ArchivePath. The archive is written while it is sent, by `cat` on the remote,
+// which is much faster than copying many small files one by one and needs nothing but a shell on the
+// remote. The size of the archive is not known ahead, so the progress is the one of the files archived.
+// It returns an entry for every file and directory written to the archive, see TarOptions for the
+// metadata preserved. With a Cipher the archive is encrypted as well.
+func (a *Client) CopyDirToRemoteArchive(ctx context.Context, localDir string, remotePath string, opts TarOptions) ([]TransferEntry, error) {
+	progress := progressOrNop(opts.Progress)
+	totalBytes, totalFiles, err := scanTree(localDir)
+	if err != nil {
+		return nil, err
+	}
+	progress.Start(totalBytes, totalFiles)
+
+	archive, w := io.Pipe()
+	var entries []TransferEntry
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		zw := gzip.NewWriter(w)
+		var err error
+		entries, err = writeTar(zw, localDir, opts, progress, a.BufferSize)
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+		w.CloseWithError(err)
+	}()
+
+	encode := func(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil }
+	if a.Cipher != nil {
+		encode = a.Cipher.Encrypt
+	}
+	result := &UploadResult{RemotePath: ArchivePath(localDir, remotePath), Permissions: "0644", Size: -1}
+	result.Filename = path.Base(result.RemotePath)
+	_, err = a.pipeUpload(ctx, archive, result, nil, nil, "cat", encode)
+	// Stops writing the archive when sending it failed.
+	archive.CloseWithError(err)
+	<-done
+	return tagEntries(ctx, entries), err
+}
+
+// CopyDirToRemoteArchiveProgress is the same as CopyDirToRemoteArchive but renders an overall progress bar
+// and a progress bar for the file currently in flight in the terminal.
+func (a *Client) CopyDirToRemoteArchiveProgress(ctx context.Context, localDir string, remotePath string, opts TarOptions) ([]TransferEntry, error) {
+	var entries []TransferEntry
+	err := a.runProgress(ctx, a.label(Upload, path.Base(ArchivePath(localDir, remotePath))), func(ctx context.Context, progress Progress) error {
+		opts.Progress = progress
+		var err error
+		entries, err = a.CopyDirToRemoteArchive(ctx, localDir, remotePath, opts)
+		return err
+	})
+	return entries, err
+}
+
+// ExtractRemoteArchive extracts the gzipped tar archive at archivePath on the remote into dir, which is
+// created when missing, such as one uploaded by CopyDirToRemoteArchive. With remove the archive is
+// deleted once extracted. This needs tar with gzip support on the remote, as found on stock servers.
+func (a *Client) ExtractRemoteArchive(ctx context.Context, archivePath string, dir string, remove bool) error {
+	script := fmt.Sprintf("mkdir -p %s && tar -xzf %s -C %s", a.shellPath(dir), a.shellPath(archivePath), a.shellPath(dir))
+	if remove {
+		script += " && rm -f " + a.shellPath(archivePath)
+	}
+	out, err := a.runOutput(ctx, "("+script+") 2>&1")
+	if err != nil {
+		if output := strings.TrimSpace(string(out)); output != "" {
+			return fmt.Errorf("failed to extract %s: %w: %s", archivePath, err, output)
+		}
+		return fmt.Errorf("failed to extract %s: %w", archivePath, err)
+	}
+	a.logf(ctx, LogInfo, "extracted %s into %s", archivePath, dir)
+	return nil
+}
+
+// nopWriteCloser a writer with a Close doing nothing.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
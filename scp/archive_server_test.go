@@ -0,0 +1,102 @@
+package scp_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"main/scp"
+)
+
+func TestCopyDirToRemoteArchive(t *testing.T) {
+	client := newTestClient(t, nil)
+	dir := t.TempDir()
+	site := filepath.Join(dir, "site")
+	writeTree(t, site, map[string]string{"index.html": "<h1>hi</h1>", "css/main.css": "body {}"}, time.Unix(1700000000, 0))
+	remote := filepath.Join(dir, "remote")
+	if err := os.Mkdir(remote, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	progress := &recordingProgress{}
+	entries, err := client.CopyDirToRemoteArchive(context.Background(), site, remote+"/", scp.TarOptions{Progress: progress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.totalBytes != 18 || progress.totalFiles != 2 || progress.bytes != 18 {
+		t.Errorf("the progress started with %d bytes of %d files and received %d", progress.totalBytes, progress.totalFiles, progress.bytes)
+	}
+
+	// A single archive, named after the directory, holds the files.
+	f, err := os.Open(filepath.Join(remote, "site.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archived := map[string]string{}
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		archived[header.Name] = string(data)
+	}
+	if archived["index.html"] != "<h1>hi</h1>" || archived["css/main.css"] != "body {}" {
+		t.Errorf("the archive holds %q", archived)
+	}
+	if len(entries) != len(archived) {
+		t.Errorf("returned %d entries for the %d of the archive", len(entries), len(archived))
+	}
+}
+
+func TestExtractRemoteArchive(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	dir := t.TempDir()
+	site := filepath.Join(dir, "site")
+	writeTree(t, site, map[string]string{"index.html": "<h1>hi</h1>", "css/main.css": "body {}"}, time.Unix(1700000000, 0))
+
+	archive := filepath.Join(dir, "site.tar.gz")
+	if _, err := client.CopyDirToRemoteArchive(ctx, site, archive, scp.TarOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// The directory is created, and the archive removed once extracted.
+	target := filepath.Join(dir, "srv", "site")
+	if err := client.ExtractRemoteArchive(ctx, archive, target, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(target, "css", "main.css")); err != nil || string(got) != "body {}" {
+		t.Errorf("extracted css/main.css as %q, %v", got, err)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("the archive was not removed: %v", err)
+	}
+
+	// What is not an archive fails with the output of tar, and is kept.
+	bogus := filepath.Join(dir, "bogus.tar.gz")
+	if err := os.WriteFile(bogus, []byte("not gzip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := client.ExtractRemoteArchive(ctx, bogus, target, true)
+	if err == nil || !strings.Contains(err.Error(), "failed to extract") || !strings.Contains(err.Error(), "gzip") {
+		t.Errorf("extracting what is not an archive returned %v", err)
+	}
+	if _, err := os.Stat(bogus); err != nil {
+		t.Errorf("the file failing to extract was removed: %v", err)
+	}
+}
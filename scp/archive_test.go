@@ -0,0 +1,19 @@
+package scp
+
+import "testing"
+
+func TestArchivePath(t *testing.T) {
+	tests := []struct {
+		localDir, remotePath, want string
+	}{
+		{"build/site", "/srv/site.tgz", "/srv/site.tgz"},
+		{"build/site", "/srv/", "/srv/site.tar.gz"},
+		{"build/site/", "/srv/", "/srv/site.tar.gz"},
+		{"build/site", "", "site.tar.gz"},
+	}
+	for _, test := range tests {
+		if got := ArchivePath(test.localDir, test.remotePath); got != test.want {
+			t.Errorf("ArchivePath(%q, %q) = %q, want %q", test.localDir, test.remotePath, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileAtime returns the access time of the file, or its modification time
+// when the access time is not available.
+func fileAtime(stat os.FileInfo) time.Time {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(sys.Atim.Unix())
+	}
+	return stat.ModTime()
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"os"
+	"time"
+)
+
+// fileAtime the access time is not portably available outside of Linux,
+// the modification time is used instead.
+func fileAtime(stat os.FileInfo) time.Time {
+	return stat.ModTime()
+}
@@ -0,0 +1,83 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// verificationPrompts the phrases by which servers ask for a second factor, rather than the password.
+var verificationPrompts = []string{"verification code", "one-time", "otp", "token", "passcode", "authenticator"}
+
+// Challenge answers the keyboard-interactive questions of a server, reading the password from `password`
+// and verification codes, such as those of a TOTP second factor, from `code`. Either may be nil when the
+// server is not expected to ask for it.
+func Challenge(password PasswordSource, code PasswordSource) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			source, kind := password, "password"
+			if isVerificationPrompt(question) {
+				source, kind = code, "verification code"
+			}
+			if source == nil {
+				return nil, fmt.Errorf("auth: the server asked for a %s (%q) but there is no source for it", kind, question)
+			}
+
+			answer, err := source(question)
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = string(answer)
+		}
+		return answers, nil
+	}
+}
+
+func isVerificationPrompt(question string) bool {
+	question = strings.ToLower(question)
+	for _, prompt := range verificationPrompts {
+		if strings.Contains(question, prompt) {
+			return true
+		}
+	}
+	return false
+}
+
+// TOTP returns the current code of the time-based one-time password (RFC 6238) with the given base32
+// secret, as shown by authenticator apps, so a second factor can be answered without anyone present.
+func TOTP(secret string) (PasswordSource, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("auth: the TOTP secret is not valid base32: %w", err)
+	}
+	return func(string) ([]byte, error) {
+		return []byte(totpCode(key, time.Now())), nil
+	}, nil
+}
+
+// totpCode computes the 6 digit code of the 30 second period `t` falls in.
+func totpCode(key []byte, t time.Time) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/30))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// The SHA-1 vectors of RFC 6238, appendix B, of which the code is the last 6 of the 8 digits.
+	key := []byte("12345678901234567890")
+	tests := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range tests {
+		if got := totpCode(key, time.Unix(unix, 0)); got != want {
+			t.Errorf("totpCode at %d = %s, want %s", unix, got, want)
+		}
+	}
+
+	source, err := TOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := totpCode(key, time.Now())
+	// The period may end while the code is computed.
+	if code, err := source("Verification code: "); err != nil || string(code) != before && string(code) != totpCode(key, time.Now()) {
+		t.Errorf("TOTP returned %q, %v, want the current code", code, err)
+	}
+	if _, err := TOTP("not base32!"); err == nil {
+		t.Error("TOTP took a secret that is not base32")
+	}
+}
+
+func TestIsVerificationPrompt(t *testing.T) {
+	tests := map[string]bool{
+		"Password: ":                                 false,
+		"bram@example.com's password: ":              false,
+		"Verification code: ":                        true,
+		"One-time password (OATH) for `bram': ":      true,
+		"Enter PASSCODE: ":                           true,
+		"Duo two-factor login, enter your token: ":   true,
+		"Authenticator app code: ":                   true,
+		"Enter your OTP: ":                           true,
+		"(bram@example.com) Password for bram: ":     false,
+		"Please enter the code from your device: \n": false,
+	}
+	for question, want := range tests {
+		if got := isVerificationPrompt(question); got != want {
+			t.Errorf("isVerificationPrompt(%q) = %v, want %v", question, got, want)
+		}
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	password := func(string) ([]byte, error) { return []byte("secret"), nil }
+	code := func(string) ([]byte, error) { return []byte("123456"), nil }
+
+	answers, err := Challenge(password, code)("", "", []string{"Password: ", "Verification code: "}, []bool{false, false})
+	if err != nil || len(answers) != 2 || answers[0] != "secret" || answers[1] != "123456" {
+		t.Errorf("Challenge answered %q, %v", answers, err)
+	}
+	if _, err := Challenge(password, nil)("", "", []string{"Verification code: "}, []bool{false}); err == nil {
+		t.Error("Challenge answered a verification code without a source for it")
+	}
+}
@@ -0,0 +1,149 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ErrHostKeyChanged is returned when a host presents a different key than the one recorded for it,
+// which may mean someone is intercepting the connection.
+var ErrHostKeyChanged = errors.New("auth: host key changed, someone could be eavesdropping")
+
+// UnknownHostFunc decides whether the key of a host that is not in the known_hosts file is trusted,
+// returning nil to trust and record it.
+type UnknownHostFunc func(hostname string, remote net.Addr, key ssh.PublicKey) error
+
+// TrustOnFirstUse returns a HostKeyCallback trusting the key a host presents on the first connection,
+// like OpenSSH's StrictHostKeyChecking=accept-new. The key is recorded in the known_hosts file at `path`,
+// which is created when it does not exist, and connections presenting a different key afterwards are
+// rejected with ErrHostKeyChanged.
+func TrustOnFirstUse(path string) (ssh.HostKeyCallback, error) {
+	return KnownHosts(path, func(string, net.Addr, ssh.PublicKey) error { return nil })
+}
+
+// KnownHosts returns a HostKeyCallback checking hosts against the known_hosts file at `path`, which is
+// created when it does not exist. The keys of unknown hosts are passed to `unknown` and recorded when it
+// returns nil. Hosts presenting a different key than the recorded one of its type are rejected with
+// ErrHostKeyChanged. A key of a type not recorded for the host is taken as the key of an unknown host,
+// pass HostKeyAlgorithms to the ClientConfig so the recorded types are negotiated whenever possible.
+func KnownHosts(path string, unknown UnknownHostFunc) (ssh.HostKeyCallback, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	var mu sync.Mutex
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Read the file on every connection to see keys recorded since.
+		check, err := knownhosts.New(path)
+		if err != nil {
+			return err
+		}
+
+		err = check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		for _, want := range keyErr.Want {
+			if want.Key.Type() == key.Type() {
+				return fmt.Errorf("%w: %s presented a %s key with fingerprint %s", ErrHostKeyChanged, hostname, key.Type(), ssh.FingerprintSHA256(key))
+			}
+		}
+
+		if err := unknown(hostname, remote, key); err != nil {
+			return err
+		}
+		return appendKnownHost(path, hostname, key)
+	}, nil
+}
+
+// HostKeyAlgorithms returns the host key algorithms for ClientConfig.HostKeyAlgorithms of a connection
+// to `address`, such as "example.com:22": those of the key types recorded for it in the known_hosts file
+// at `path` first, so the server presents a key that can be checked, followed by the others. Hosts
+// without recorded keys, and a missing file, leave the preference to the server, returning nil.
+func HostKeyAlgorithms(path string, address string) ([]string, error) {
+	check, err := knownhosts.New(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keyErr *knownhosts.KeyError
+	if err := check(address, &net.TCPAddr{}, noKey{}); !errors.As(err, &keyErr) {
+		return nil, err
+	}
+	if len(keyErr.Want) == 0 {
+		return nil, nil
+	}
+	var recorded []string
+	for _, want := range keyErr.Want {
+		recorded = append(recorded, keyAlgorithms(want.Key.Type())...)
+	}
+	algorithms := recorded
+	for _, typ := range defaultKeyTypes {
+		for _, algorithm := range keyAlgorithms(typ) {
+			if !slices.Contains(recorded, algorithm) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return algorithms, nil
+}
+
+// defaultKeyTypes the host key types negotiated by default, in order of preference.
+var defaultKeyTypes = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSA,
+}
+
+// keyAlgorithms the signature algorithms of host keys of type typ, RSA keys sign with SHA-2 as well.
+func keyAlgorithms(typ string) []string {
+	if typ == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{typ}
+}
+
+// noKey a key of no type, which knownhosts reports the keys recorded for a host for.
+type noKey struct{}
+
+func (noKey) Type() string                        { return "none" }
+func (noKey) Marshal() []byte                     { return []byte("none") }
+func (noKey) Verify([]byte, *ssh.Signature) error { return errors.New("auth: not a key") }
+
+func appendKnownHost(path string, hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	return err
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func testKey(t *testing.T, typ string) ssh.PublicKey {
+	var key any
+	var err error
+	switch typ {
+	case ssh.KeyAlgoED25519:
+		key, _, err = ed25519.GenerateKey(rand.Reader)
+	case ssh.KeyAlgoECDSA256:
+		var private *ecdsa.PrivateKey
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err == nil {
+			key = &private.PublicKey
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := ssh.NewPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public
+}
+
+func TestKnownHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	recorded := testKey(t, ssh.KeyAlgoED25519)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize("known.example:22")}, recorded)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var asked []string
+	check, err := KnownHosts(path, func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		asked = append(asked, hostname)
+		if hostname == "refused.example:22" {
+			return errors.New("refused")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+
+	if err := check("known.example:22", remote, recorded); err != nil {
+		t.Errorf("the recorded key was refused: %v", err)
+	}
+
+	// Keys of unknown hosts are recorded once accepted.
+	unknown := testKey(t, ssh.KeyAlgoED25519)
+	if err := check("new.example:2222", remote, unknown); err != nil {
+		t.Errorf("the key of an unknown host was refused: %v", err)
+	}
+	if err := check("new.example:2222", remote, unknown); err != nil {
+		t.Errorf("the recorded key of a new host was refused: %v", err)
+	}
+	if err := check("refused.example:22", remote, unknown); err == nil {
+		t.Error("a key refused by unknown was accepted")
+	}
+
+	if err := check("known.example:22", remote, testKey(t, ssh.KeyAlgoED25519)); !errors.Is(err, ErrHostKeyChanged) {
+		t.Errorf("a changed key returned %v, want ErrHostKeyChanged", err)
+	}
+
+	// A key of another type than the recorded one is not a changed key.
+	other := testKey(t, ssh.KeyAlgoECDSA256)
+	if err := check("known.example:22", remote, other); err != nil {
+		t.Errorf("a key of another type was refused: %v", err)
+	}
+	if err := check("known.example:22", remote, recorded); err != nil {
+		t.Errorf("the first recorded key was refused after recording another type: %v", err)
+	}
+
+	want := []string{"new.example:2222", "refused.example:22", "known.example:22"}
+	if strings.Join(asked, ",") != strings.Join(want, ",") {
+		t.Errorf("asked about %v, want %v", asked, want)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || strings.Count(string(data), "\n") != 3 {
+		t.Errorf("the file holds %q, %v, want three keys", data, err)
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if algorithms, err := HostKeyAlgorithms(path, "known.example:22"); err != nil || algorithms != nil {
+		t.Errorf("HostKeyAlgorithms without a file = %v, %v", algorithms, err)
+	}
+
+	line := knownhosts.Line([]string{knownhosts.Normalize("known.example:22")}, testKey(t, ssh.KeyAlgoECDSA256))
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	algorithms, err := HostKeyAlgorithms(path, "known.example:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{ssh.KeyAlgoECDSA256, ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	if strings.Join(algorithms, ",") != strings.Join(want, ",") {
+		t.Errorf("HostKeyAlgorithms = %v, want %v", algorithms, want)
+	}
+	if algorithms, err := HostKeyAlgorithms(path, "unknown.example:22"); err != nil || algorithms != nil {
+		t.Errorf("HostKeyAlgorithms of an unknown host = %v, %v", algorithms, err)
+	}
+}
@@ -25,7 +25,7 @@ func PrivateKey(username string, path string, keyCallBack ssh.HostKeyCallback) (
 	signer, err := ssh.ParsePrivateKey(privateKey)
 
 	if err != nil {
-		return ssh.ClientConfig{}, err
+		return ssh.ClientConfig{}, securityKeyError(path, err)
 	}
 
 	return ssh.ClientConfig{
@@ -0,0 +1,92 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+)
+
+// KeyringService the service credentials are stored under in the keychain of the operating system.
+const KeyringService = "go-scp-tui"
+
+// KeyringCommit tells the keyring whether the secret of PasswordFromKeyring was accepted, passing the
+// error of authenticating, or of parsing the key the secret is the passphrase of. A secret read from the
+// fallback is only stored once it was accepted, with a nil error, so a mistyped one is asked for again
+// the next time. A stored secret that was rejected is removed. Other errors, such as the host being
+// unreachable, tell nothing about the secret and leave the keyring as it is.
+type KeyringCommit func(err error) error
+
+// PasswordFromKeyring returns the password stored for `account`, such as "user@host:22", in the keychain of
+// the operating system: the macOS Keychain, the Windows Credential Manager or the Secret Service of libsecret.
+// When none is stored yet it is read from `fallback`, and stored for the next time once the returned
+// KeyringCommit is called with the outcome of using it.
+// Use ForgetKeyring to remove a stored password, for example when it changed.
+func PasswordFromKeyring(account string, fallback PasswordSource) (PasswordSource, KeyringCommit) {
+	var (
+		mu      sync.Mutex
+		pending []byte
+		stored  bool
+	)
+	source := func(prompt string) ([]byte, error) {
+		password, err := keyring.Get(KeyringService, account)
+		if err == nil {
+			mu.Lock()
+			stored = true
+			mu.Unlock()
+			return []byte(password), nil
+		}
+		if !errors.Is(err, keyring.ErrNotFound) {
+			return nil, fmt.Errorf("auth: failed to read %s from the keyring: %w", account, err)
+		}
+
+		secret, err := fallback(prompt)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		pending = secret
+		mu.Unlock()
+		return secret, nil
+	}
+	commit := func(err error) error {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err == nil && pending != nil:
+			if err := keyring.Set(KeyringService, account, string(pending)); err != nil {
+				return fmt.Errorf("auth: failed to store %s in the keyring: %w", account, err)
+			}
+			pending, stored = nil, true
+		case err != nil && stored && rejected(err):
+			stored = false
+			return ForgetKeyring(account)
+		}
+		return nil
+	}
+	return source, commit
+}
+
+// rejected tells whether err is the failure of a wrong password or passphrase.
+func rejected(err error) bool {
+	// The ssh package reports failed authentication only as text.
+	return errors.Is(err, x509.IncorrectPasswordError) || strings.Contains(err.Error(), "unable to authenticate")
+}
+
+// ForgetKeyring removes the password stored for `account` from the keychain of the operating system,
+// it is not an error when none is stored.
+func ForgetKeyring(account string) error {
+	err := keyring.Delete(KeyringService, account)
+	if err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("auth: failed to remove %s from the keyring: %w", account, err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestPasswordFromKeyring(t *testing.T) {
+	keyring.MockInit()
+	const account = "bram@example.com:22"
+	unableToAuthenticate := errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password], no supported methods remain")
+
+	typed := "mistyped"
+	fallback := func(string) ([]byte, error) { return []byte(typed), nil }
+
+	// A rejected password read from the fallback is not stored.
+	source, commit := PasswordFromKeyring(account, fallback)
+	if password, err := source("password: "); err != nil || string(password) != "mistyped" {
+		t.Fatalf("source = %q, %v", password, err)
+	}
+	if _, err := keyring.Get(KeyringService, account); !errors.Is(err, keyring.ErrNotFound) {
+		t.Errorf("the password was stored before it was accepted: %v", err)
+	}
+	if err := commit(unableToAuthenticate); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.Get(KeyringService, account); !errors.Is(err, keyring.ErrNotFound) {
+		t.Errorf("a rejected password was stored: %v", err)
+	}
+
+	// An accepted one is.
+	typed = "secret"
+	source, commit = PasswordFromKeyring(account, fallback)
+	source("password: ")
+	if err := commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if password, err := keyring.Get(KeyringService, account); err != nil || password != "secret" {
+		t.Errorf("stored %q, %v, want the accepted password", password, err)
+	}
+
+	// Other failures leave the stored password alone, a rejection removes it.
+	typed = "not asked"
+	source, commit = PasswordFromKeyring(account, fallback)
+	if password, _ := source("password: "); string(password) != "secret" {
+		t.Errorf("source = %q, want the stored password", password)
+	}
+	if err := commit(fmt.Errorf("dial tcp: connection refused")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.Get(KeyringService, account); err != nil {
+		t.Errorf("a connection failure removed the password: %v", err)
+	}
+	if err := commit(fmt.Errorf("failed to parse the key: %w", x509.IncorrectPasswordError)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.Get(KeyringService, account); !errors.Is(err, keyring.ErrNotFound) {
+		t.Errorf("a rejected stored password was kept: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// PasswordSource returns a password or passphrase, `prompt` describes what is asked for.
+type PasswordSource func(prompt string) ([]byte, error)
+
+// PasswordFromEnv reads the password from the environment variable `name`.
+func PasswordFromEnv(name string) PasswordSource {
+	return func(string) ([]byte, error) {
+		password, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("auth: environment variable %s is not set", name)
+		}
+		return []byte(password), nil
+	}
+}
+
+// PasswordFromReader reads the password from the first line of `r`, such as os.Stdin when it is piped
+// from a secret manager. The line is only read once and returned for every later prompt as well.
+func PasswordFromReader(r io.Reader) PasswordSource {
+	var (
+		once     sync.Once
+		password []byte
+		err      error
+	)
+	return func(string) ([]byte, error) {
+		once.Do(func() {
+			password, err = bufio.NewReader(r).ReadBytes('\n')
+			if err == io.EOF && len(password) > 0 {
+				err = nil
+			}
+			password = bytes.TrimRight(password, "\r\n")
+		})
+		return password, err
+	}
+}
+
+// PasswordFromTerminal asks for the password in the terminal without echoing it.
+func PasswordFromTerminal() PasswordSource {
+	return func(prompt string) ([]byte, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		defer fmt.Fprintln(os.Stderr)
+		return term.ReadPassword(int(os.Stdin.Fd()))
+	}
+}
+
+// PasswordFromAskpass runs `program` with the prompt as its argument and reads the password from its
+// output, like OpenSSH does with SSH_ASKPASS. The program of SSH_ASKPASS is used when `program` is empty.
+func PasswordFromAskpass(program string) PasswordSource {
+	return func(prompt string) ([]byte, error) {
+		if program == "" {
+			program = os.Getenv("SSH_ASKPASS")
+		}
+		if program == "" {
+			return nil, errors.New("auth: no askpass program given and SSH_ASKPASS is not set")
+		}
+
+		output, err := exec.Command(program, prompt).Output()
+		if err != nil {
+			return nil, fmt.Errorf("auth: askpass program %s failed: %w", program, err)
+		}
+		return bytes.TrimRight(output, "\r\n"), nil
+	}
+}
+
+// Creates a configuration for a client that authenticates using username and a password read from `source`
+// once the server asks for it
+func PasswordFromSource(username string, source PasswordSource, keyCallBack ssh.HostKeyCallback) (ssh.ClientConfig, error) {
+	return ssh.ClientConfig{
+		User: username,
+		Auth: []ssh.AuthMethod{
+			ssh.PasswordCallback(func() (string, error) {
+				password, err := source(fmt.Sprintf("%s's password: ", username))
+				return string(password), err
+			}),
+		},
+		HostKeyCallback: keyCallBack,
+	}, nil
+}
+
+// Creates the configuration for a client that authenticates with a private key, reading its passphrase
+// from `source` when the key is protected by one
+func PrivateKeyWithPassphraseSource(username string, source PasswordSource, path string, keyCallBack ssh.HostKeyCallback) (ssh.ClientConfig, error) {
+	privateKey, err := os.ReadFile(path)
+	if err != nil {
+		return ssh.ClientConfig{}, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		var passphrase []byte
+		passphrase, err = source(fmt.Sprintf("Enter passphrase for key '%s': ", path))
+		if err != nil {
+			return ssh.ClientConfig{}, err
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(privateKey, passphrase)
+	}
+	if err != nil {
+		return ssh.ClientConfig{}, securityKeyError(path, err)
+	}
+
+	return ssh.ClientConfig{
+		User: username,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+		},
+		HostKeyCallback: keyCallBack,
+	}, nil
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPasswordSources(t *testing.T) {
+	t.Setenv("SCP_TEST_PASSWORD", "from env")
+	if password, err := PasswordFromEnv("SCP_TEST_PASSWORD")(""); err != nil || string(password) != "from env" {
+		t.Errorf("PasswordFromEnv returned %q, %v", password, err)
+	}
+	if _, err := PasswordFromEnv("SCP_TEST_UNSET")(""); err == nil {
+		t.Error("PasswordFromEnv of an unset variable succeeded")
+	}
+
+	// Only the first line is read, for every prompt.
+	source := PasswordFromReader(strings.NewReader("from stdin\r\nnext line\n"))
+	for i := 0; i < 2; i++ {
+		if password, err := source(""); err != nil || string(password) != "from stdin" {
+			t.Errorf("PasswordFromReader returned %q, %v", password, err)
+		}
+	}
+	if password, err := PasswordFromReader(strings.NewReader("no newline"))(""); err != nil || string(password) != "no newline" {
+		t.Errorf("PasswordFromReader of a line without newline returned %q, %v", password, err)
+	}
+	if _, err := PasswordFromReader(strings.NewReader(""))(""); err == nil {
+		t.Error("PasswordFromReader of nothing succeeded")
+	}
+
+	askpass := filepath.Join(t.TempDir(), "askpass")
+	if err := os.WriteFile(askpass, []byte("#!/bin/sh\necho \"answer to $1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if password, err := PasswordFromAskpass(askpass)("Password: "); err != nil || string(password) != "answer to Password: " {
+		t.Errorf("PasswordFromAskpass returned %q, %v", password, err)
+	}
+	t.Setenv("SSH_ASKPASS", askpass)
+	if password, err := PasswordFromAskpass("")("Passphrase: "); err != nil || string(password) != "answer to Passphrase: " {
+		t.Errorf("PasswordFromAskpass of SSH_ASKPASS returned %q, %v", password, err)
+	}
+	t.Setenv("SSH_ASKPASS", "")
+	if _, err := PasswordFromAskpass("")(""); err == nil {
+		t.Error("PasswordFromAskpass without a program succeeded")
+	}
+}
+
+func TestPrivateKeyWithPassphraseSource(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(private, "", []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var prompts []string
+	source := func(prompt string) ([]byte, error) {
+		prompts = append(prompts, prompt)
+		return []byte("passphrase"), nil
+	}
+	config, err := PrivateKeyWithPassphraseSource("user", source, path, ssh.InsecureIgnoreHostKey())
+	if err != nil || len(config.Auth) != 1 {
+		t.Errorf("PrivateKeyWithPassphraseSource returned %d auth methods, %v", len(config.Auth), err)
+	}
+	if len(prompts) != 1 || !strings.Contains(prompts[0], path) {
+		t.Errorf("asked for the passphrase with %q", prompts)
+	}
+
+	wrong := func(string) ([]byte, error) { return []byte("wrong"), nil }
+	if _, err := PrivateKeyWithPassphraseSource("user", wrong, path, ssh.InsecureIgnoreHostKey()); err == nil {
+		t.Error("PrivateKeyWithPassphraseSource took the wrong passphrase")
+	}
+}
@@ -0,0 +1,102 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrSecurityKeyFile is returned for the private key files of FIDO2 security keys (sk-ecdsa and sk-ed25519),
+// which can only sign through the ssh agent.
+var ErrSecurityKeyFile = errors.New("auth: security keys can not be read from a file, load them into the ssh agent with ssh-add")
+
+// TouchFunc is called before a FIDO2 security key is asked to sign, which usually waits for it to be touched.
+type TouchFunc func(key ssh.PublicKey)
+
+// IsSecurityKey reports whether the key is one of a FIDO2 security key.
+func IsSecurityKey(key ssh.PublicKey) bool {
+	return key.Type() == ssh.KeyAlgoSKECDSA256 || key.Type() == ssh.KeyAlgoSKED25519
+}
+
+// Creates a configuration for a client that fetches its keys from the SSH agent, including those of
+// FIDO2 security keys, calling touch before such a key signs so the user can be asked to touch it
+func SshAgentWithTouch(username string, touch TouchFunc, keyCallBack ssh.HostKeyCallback) (ssh.ClientConfig, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return ssh.ClientConfig{}, err
+	}
+
+	agentClient := agent.NewClient(conn)
+	return ssh.ClientConfig{
+		User: username,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				signers, err := agentClient.Signers()
+				if err != nil {
+					return nil, err
+				}
+				return NotifyTouch(signers, touch), nil
+			}),
+		},
+		HostKeyCallback: keyCallBack,
+	}, nil
+}
+
+// NotifyTouch wraps the signers of security keys so that touch is called before they sign.
+func NotifyTouch(signers []ssh.Signer, touch TouchFunc) []ssh.Signer {
+	wrapped := make([]ssh.Signer, len(signers))
+	for i, signer := range signers {
+		wrapped[i] = signer
+		if IsSecurityKey(signer.PublicKey()) {
+			wrapped[i] = touchSigner{Signer: signer, touch: touch}
+		}
+	}
+	return wrapped
+}
+
+// touchSigner calls touch before every signature of the signer.
+type touchSigner struct {
+	ssh.Signer
+	touch TouchFunc
+}
+
+func (s touchSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.touch(s.PublicKey())
+	return s.Signer.Sign(rand, data)
+}
+
+func (s touchSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	if as, ok := s.Signer.(ssh.AlgorithmSigner); ok {
+		s.touch(s.PublicKey())
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+	if algorithm != "" && algorithm != s.PublicKey().Type() {
+		return nil, fmt.Errorf("auth: signer of %s does not support algorithm %s", s.PublicKey().Type(), algorithm)
+	}
+	return s.Sign(rand, data)
+}
+
+// securityKeyError returns ErrSecurityKeyFile when the key at path failed to parse because it belongs
+// to a security key, going by its public key next to it, and err otherwise.
+func securityKeyError(path string, err error) error {
+	public, readErr := os.ReadFile(path + ".pub")
+	if readErr != nil {
+		return err
+	}
+	key, _, _, _, parseErr := ssh.ParseAuthorizedKey(public)
+	if parseErr != nil || !IsSecurityKey(key) {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrSecurityKeyFile, path)
+}
@@ -0,0 +1,136 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// Backend the protocol used to transfer single files.
+type Backend int
+
+const (
+	// BackendSCP the classic SCP protocol, requires the scp binary on the remote.
+	BackendSCP Backend = iota
+
+	// BackendSFTP the SFTP subsystem of the SSH server.
+	BackendSFTP
+
+	// BackendAuto chooses between SCP and SFTP based on the remote, see DetectBackend.
+	BackendAuto
+)
+
+func (b Backend) String() string {
+	switch b {
+	case BackendSCP:
+		return "scp"
+	case BackendSFTP:
+		return "sftp"
+	case BackendAuto:
+		return "auto"
+	default:
+		return "Backend(" + strconv.Itoa(int(b)) + ")"
+	}
+}
+
+var openSSHVersion = regexp.MustCompile(`OpenSSH_(\d+)\.(\d+)`)
+
+// backendDetection remembers the backend chosen by BackendAuto,
+// it is shared by copies of a Client so the remote is only probed once.
+// Failing to detect it is no outcome, the next transfer detects it again.
+type backendDetection struct {
+	mu      sync.Mutex
+	done    bool
+	backend Backend
+}
+
+// DetectBackend chooses the backend fitting the remote. Servers running OpenSSH 9 or newer
+// have deprecated the SCP protocol in favour of SFTP and some distributions no longer ship
+// the scp binary, or ship a shim that only speaks SFTP. On those servers SFTP is used unless
+// a working scp binary is found, or when probing for it fails but the sftp subsystem starts,
+// such as on servers refusing to run commands. Every other server uses classic SCP.
+func (a *Client) DetectBackend(ctx context.Context) (Backend, error) {
+	client, err := a.connected(ctx)
+	if err != nil {
+		return BackendSCP, err
+	}
+	major, _, ok := ServerOpenSSHVersion(client.ServerVersion())
+	if !ok || major < 9 {
+		return BackendSCP, nil
+	}
+
+	_, err = a.DetectRemoteBinary(ctx)
+	if errors.Is(err, ErrRemoteBinaryMissing) {
+		return BackendSFTP, nil
+	}
+	if err != nil {
+		if a.probeSFTP(ctx) == nil {
+			return BackendSFTP, nil
+		}
+		return BackendSCP, err
+	}
+
+	return BackendSCP, nil
+}
+
+// probeSFTP starts the sftp subsystem on a new session, returning why it did not start.
+func (a *Client) probeSFTP(ctx context.Context) error {
+	session, release, err := a.newSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+	return session.RequestSubsystem("sftp")
+}
+
+// ServerOpenSSHVersion extracts the OpenSSH version from an SSH version banner
+// such as "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13". The last value is false if the
+// banner does not belong to OpenSSH.
+func ServerOpenSSHVersion(banner []byte) (major int, minor int, ok bool) {
+	match := openSSHVersion.FindSubmatch(banner)
+	if match == nil {
+		return 0, 0, false
+	}
+	major, _ = strconv.Atoi(string(match[1]))
+	minor, _ = strconv.Atoi(string(match[2]))
+	return major, minor, true
+}
+
+// resolveBackend returns the backend to use for a transfer, detecting it once for BackendAuto.
+func (a *Client) resolveBackend(ctx context.Context) (Backend, error) {
+	if a.Backend != BackendAuto {
+		return a.Backend, nil
+	}
+	if a.backendDetection == nil {
+		// Clients not built by a configurer detect on every transfer.
+		return a.DetectBackend(ctx)
+	}
+	// Not being connected yet is no outcome of the detection to remember.
+	if _, err := a.connected(ctx); err != nil {
+		return BackendSCP, err
+	}
+
+	a.backendDetection.mu.Lock()
+	defer a.backendDetection.mu.Unlock()
+	if a.backendDetection.done {
+		return a.backendDetection.backend, nil
+	}
+	backend, err := a.DetectBackend(ctx)
+	if err != nil {
+		a.logf(ctx, LogError, "failed to detect the backend: %v", err)
+		return backend, err
+	}
+	a.backendDetection.done, a.backendDetection.backend = true, backend
+	a.logf(ctx, LogInfo, "using the %s backend", backend)
+	return backend, nil
+}
@@ -0,0 +1,58 @@
+package scp_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"main/scp"
+)
+
+func TestDetectBackendFallsBackToSFTP(t *testing.T) {
+	// The probe for the scp binary is killed, like on a server limiting the commands it runs.
+	server, _ := probingServer(t, func(io.Writer) int { return 137 })
+	server.SetVersion("SSH-2.0-OpenSSH_9.6")
+	client := server.Client(t)
+
+	if _, err := client.DetectBackend(context.Background()); err == nil {
+		t.Error("DetectBackend succeeded without the probe or the sftp subsystem")
+	}
+	server.SFTP = true
+	if backend, err := client.DetectBackend(context.Background()); err != nil || backend != scp.BackendSFTP {
+		t.Errorf("DetectBackend = %s, %v, want SFTP as the sftp subsystem starts", backend, err)
+	}
+}
+
+func TestAutoBackendRetriesFailedDetection(t *testing.T) {
+	var once sync.Once
+	server, probes := probingServer(t, func(stdout io.Writer) int {
+		first := false
+		once.Do(func() { first = true })
+		if first {
+			return 137
+		}
+		io.WriteString(stdout, "/usr/bin/scp\n")
+		return 0
+	})
+	server.SetVersion("SSH-2.0-OpenSSH_9.6")
+	client := server.Configurer().Backend(scp.BackendAuto).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.CopyFile(ctx, strings.NewReader("x"), "file", "0644"); err == nil {
+		t.Error("upload succeeded while the backend could not be detected")
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.CopyFile(ctx, strings.NewReader("x"), "file", "0644"); err != nil {
+			t.Fatalf("upload once the backend was detected returned %v", err)
+		}
+	}
+	if n := probes(); n != 2 {
+		t.Errorf("probed %d times, want once more after the failure and then never again", n)
+	}
+}
@@ -0,0 +1,124 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// benchBlockSize the size of the block of random data repeated to generate the data of a Bench.
+const benchBlockSize = 1 << 20
+
+// BenchResult the outcome of transferring the generated data of a Bench in one direction.
+type BenchResult struct {
+	Direction Direction
+	Bytes     int64
+	Duration  time.Duration
+
+	// FirstByte the time from starting the transfer until the first byte of the data flowed,
+	// which includes opening a session and starting the remote scp.
+	FirstByte time.Duration
+
+	// CPU the user and system CPU time this process spent during the transfer, or -1 when it
+	// can not be measured on this platform.
+	CPU time.Duration
+}
+
+// Throughput returns the bytes transferred per second.
+func (r BenchResult) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+func (r BenchResult) String() string {
+	cpu := "unknown"
+	if r.CPU >= 0 {
+		cpu = r.CPU.Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("%-8s %d bytes in %s, %.1f MB/s, first byte after %s, CPU %s",
+		r.Direction, r.Bytes, r.Duration.Round(time.Millisecond), r.Throughput()/1e6,
+		r.FirstByte.Round(time.Millisecond), cpu)
+}
+
+// Bench measures the transfers of the client by uploading size bytes of generated data to remotePath,
+// downloading them again and removing the file, to help tuning options such as BufferSize, the
+// ciphers of the ClientConfig or the Backend. The data is random, so compression does not skew the
+// results. It returns the result of the upload and of the download.
+func (a *Client) Bench(ctx context.Context, size int64, remotePath string) ([]BenchResult, error) {
+	block := make([]byte, benchBlockSize)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(block)
+
+	upload, err := benchTransfer(Upload, func(passThru PassThru) error {
+		return a.CopyPassThru(ctx, io.LimitReader(&repeatReader{block: block}, size), remotePath, "0600", size, passThru)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	download, err := benchTransfer(Download, func(passThru PassThru) error {
+		_, err := a.CopyFromRemoteWithOptions(ctx, io.Discard, remotePath, DownloadOptions{PassThru: passThru})
+		return err
+	})
+	return []BenchResult{upload, download}, errors.Join(err, a.Remove(ctx, remotePath))
+}
+
+// benchTransfer times the transfer, which reports its data through the given PassThru.
+func benchTransfer(direction Direction, transfer func(passThru PassThru) error) (BenchResult, error) {
+	result := BenchResult{Direction: direction}
+	var first time.Time
+	counter := func(r io.Reader, total int64) io.Reader {
+		return &benchReader{r: r, first: &first, bytes: &result.Bytes}
+	}
+
+	cpu := cpuTime()
+	started := time.Now()
+	err := transfer(counter)
+	result.Duration = time.Since(started)
+	result.CPU = -1
+	if cpu >= 0 {
+		result.CPU = cpuTime() - cpu
+	}
+	if !first.IsZero() {
+		result.FirstByte = first.Sub(started)
+	}
+	return result, err
+}
+
+// benchReader counts the bytes read and records when the first ones were.
+type benchReader struct {
+	r     io.Reader
+	first *time.Time
+	bytes *int64
+}
+
+func (b *benchReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if n > 0 && b.first.IsZero() {
+		*b.first = time.Now()
+	}
+	*b.bytes += int64(n)
+	return n, err
+}
+
+// repeatReader endlessly repeats block.
+type repeatReader struct {
+	block  []byte
+	offset int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, r.block[r.offset:])
+	r.offset = (r.offset + n) % len(r.block)
+	return n, nil
+}
@@ -0,0 +1,40 @@
+package scp_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"main/scp"
+)
+
+func TestBench(t *testing.T) {
+	client := newTestClient(t, nil)
+	remote := filepath.Join(t.TempDir(), "bench")
+
+	// More than a block of generated data, which is repeated.
+	const size = 3<<20 + 1000
+	results, err := client.Bench(context.Background(), size, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Direction != scp.Upload || results[1].Direction != scp.Download {
+		t.Fatalf("Bench returned %+v, want an upload and a download", results)
+	}
+	for _, result := range results {
+		if result.Bytes != size {
+			t.Errorf("the %s transferred %d bytes, want %d", result.Direction, result.Bytes, size)
+		}
+		if result.FirstByte <= 0 || result.FirstByte > result.Duration || result.Throughput() <= 0 {
+			t.Errorf("the %s took %s, the first byte after %s", result.Direction, result.Duration, result.FirstByte)
+		}
+		if runtime.GOOS == "linux" && result.CPU < 0 {
+			t.Errorf("the CPU time of the %s was not measured", result.Direction)
+		}
+	}
+	if _, err := os.Stat(remote); !os.IsNotExist(err) {
+		t.Errorf("the generated file was not removed: %v", err)
+	}
+}
@@ -0,0 +1,532 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/progress"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// BroadcastConnect returns a connected client for the host of a Broadcast or a Collect, which closes it.
+type BroadcastConnect func(ctx context.Context, host string) (Client, error)
+
+// BroadcastOptions configures a Broadcast or a Collect.
+type BroadcastOptions struct {
+	// Parallelism the amount of hosts transferring at once, all of them when zero.
+	Parallelism int
+
+	// Progress returns the Progress receiving the transfer of the host, may be nil.
+	Progress func(host string) Progress
+
+	// Output how BroadcastProgress and CollectProgress show the progress, see ProgressOutput.
+	Output ProgressOutput
+}
+
+// BroadcastResult the outcome of a Broadcast or a Collect for a single host.
+type BroadcastResult struct {
+	Host string
+
+	// Entries the files, and for directories the directories, transferred.
+	Entries  []TransferEntry
+	Duration time.Duration
+
+	// Err why connecting or transferring failed, nil when the transfer succeeded.
+	Err error
+}
+
+// Broadcast uploads the local file or directory to remotePath on every host concurrently, for example
+// to deploy an artifact to a fleet. A failing host does not affect the others, the result of every host
+// is returned in the order of hosts. Files are uploaded like by sync, creating the parent directories
+// of remotePath; directories are streamed through tar into remotePath, which is created, so the remote
+// needs GNU tar.
+func Broadcast(
+	ctx context.Context,
+	hosts []string,
+	connect BroadcastConnect,
+	localPath string,
+	remotePath string,
+	opts BroadcastOptions,
+) []BroadcastResult {
+	return fanOut(ctx, hosts, connect, opts, Upload, path.Base(remotePath), func(ctx context.Context, client *Client, _ string, progress Progress) ([]TransferEntry, error) {
+		return client.uploadPath(ctx, localPath, remotePath, progress)
+	})
+}
+
+// Collect downloads the remote file or directory from every host concurrently into a directory
+// per host below localDir, named after the host and its port when it is not DefaultPort, such as
+// collected/web1/app.log. It is meant for gathering logs from many hosts. Like for a Broadcast,
+// a failing host does not affect the others and directories need GNU tar on the remote.
+func Collect(
+	ctx context.Context,
+	hosts []string,
+	connect BroadcastConnect,
+	remotePath string,
+	localDir string,
+	opts BroadcastOptions,
+) []BroadcastResult {
+	return fanOut(ctx, hosts, connect, opts, Download, path.Base(remotePath), func(ctx context.Context, client *Client, host string, progress Progress) ([]TransferEntry, error) {
+		return client.downloadPath(ctx, remotePath, filepath.Join(localDir, hostDir(host)), progress)
+	})
+}
+
+// fanOut connects to every host and runs transfer on it, at most opts.Parallelism at once.
+// The direction and name of the transfers label their progress records.
+func fanOut(
+	ctx context.Context,
+	hosts []string,
+	connect BroadcastConnect,
+	opts BroadcastOptions,
+	direction Direction,
+	name string,
+	transfer func(ctx context.Context, client *Client, host string, progress Progress) ([]TransferEntry, error),
+) []BroadcastResult {
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = len(hosts)
+	}
+	slots := make(chan struct{}, parallelism)
+
+	results := make([]BroadcastResult, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			var progress Progress
+			if opts.Progress != nil {
+				progress = opts.Progress(host)
+			}
+			started := time.Now()
+			entries, err := func() ([]TransferEntry, error) {
+				client, err := connect(ctx, host)
+				if err != nil {
+					return nil, err
+				}
+				defer client.Close()
+
+				var entries []TransferEntry
+				err = client.withRecords(client.label(direction, name), func(ctx context.Context, progress Progress) error {
+					var err error
+					entries, err = transfer(ctx, &client, host, progressOrNop(progress))
+					return err
+				})(ctx, progress)
+				return tagEntries(ctx, entries), err
+			}()
+			results[i] = BroadcastResult{Host: host, Entries: entries, Duration: time.Since(started), Err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// uploadPath uploads the local file or directory to remotePath, see Broadcast.
+func (a *Client) uploadPath(ctx context.Context, localPath string, remotePath string, progress Progress) ([]TransferEntry, error) {
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		if err := a.MkdirAll(ctx, remotePath, stat.Mode().Perm()); err != nil {
+			return nil, err
+		}
+		return a.CopyDirToRemoteTar(ctx, localPath, remotePath, TarOptions{Progress: progress})
+	}
+
+	entry := TransferEntry{
+		Path:      filepath.Base(localPath),
+		Size:      stat.Size(),
+		Mode:      stat.Mode(),
+		ModTime:   stat.ModTime(),
+		Direction: Upload,
+	}
+	entry.finish(a.pushFile(ctx, localPath, remotePath, progressPassThru(progress, path.Base(remotePath), nil, a.BufferSize)))
+	return []TransferEntry{entry}, entry.Err
+}
+
+// downloadPath downloads the remote file or directory into localDir, keeping its name.
+func (a *Client) downloadPath(ctx context.Context, remotePath string, localDir string, progress Progress) ([]TransferEntry, error) {
+	isDir, err := a.IsDir(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	localPath := filepath.Join(localDir, path.Base(remotePath))
+	if isDir {
+		return a.CopyDirFromRemoteTar(ctx, remotePath, localPath, TarOptions{Progress: progress})
+	}
+
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return nil, err
+	}
+	entry := TransferEntry{Path: path.Base(remotePath), Direction: Download}
+	fileInfos, err := a.CopyFromRemoteToPath(ctx, remotePath, localPath, DownloadOptions{PreserveTimes: true, Progress: progress})
+	if fileInfos != nil {
+		entry.Size = fileInfos.Size
+		entry.Mode = fs.FileMode(fileInfos.Permissions).Perm()
+		entry.ModTime = time.Unix(fileInfos.Mtime, 0)
+		// Like the files of a directory, which tar extracts with their mode and times.
+		err = errors.Join(os.Chmod(localPath, entry.Mode), os.Chtimes(localPath, time.Unix(fileInfos.Atime, 0), entry.ModTime))
+	}
+	entry.finish(err)
+	return []TransferEntry{entry}, entry.Err
+}
+
+// hostDir the name of the directory Collect downloads the files of the host to, the host without
+// the user and, when it is DefaultPort, the port.
+func hostDir(host string) string {
+	if _, address, ok := strings.Cut(host, "@"); ok {
+		host = address
+	}
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+	if port == strconv.Itoa(DefaultPort) {
+		return name
+	}
+	return name + "_" + port
+}
+
+// ExpandGroups replaces the @name of groups in hosts by their members in groups, keeping the order.
+// A group that is missing or has no members is an error.
+func ExpandGroups(groups map[string][]string, hosts []string) ([]string, error) {
+	var expanded []string
+	for _, host := range hosts {
+		name, ok := strings.CutPrefix(host, "@")
+		if !ok {
+			expanded = append(expanded, host)
+			continue
+		}
+		members, ok := groups[name]
+		if !ok || len(members) == 0 {
+			return nil, fmt.Errorf("there is no group %q in the config", name)
+		}
+		expanded = append(expanded, members...)
+	}
+	return expanded, nil
+}
+
+// BroadcastProgress is the same as Broadcast but renders a progress bar for every host in the terminal,
+// followed by whether the upload to it succeeded once all are done. Quitting the interface cancels
+// the uploads still running.
+func BroadcastProgress(
+	ctx context.Context,
+	theme Theme,
+	hosts []string,
+	connect BroadcastConnect,
+	localPath string,
+	remotePath string,
+	opts BroadcastOptions,
+) []BroadcastResult {
+	return fanOutProgress(ctx, theme, localPath+" -> "+remotePath, hosts, connect, opts, func(ctx context.Context, connect BroadcastConnect, opts BroadcastOptions) []BroadcastResult {
+		return Broadcast(ctx, hosts, connect, localPath, remotePath, opts)
+	})
+}
+
+// CollectProgress is the same as Collect but renders a progress bar for every host in the terminal,
+// followed by whether the download from it succeeded once all are done. Quitting the interface
+// cancels the downloads still running.
+func CollectProgress(
+	ctx context.Context,
+	theme Theme,
+	hosts []string,
+	connect BroadcastConnect,
+	remotePath string,
+	localDir string,
+	opts BroadcastOptions,
+) []BroadcastResult {
+	return fanOutProgress(ctx, theme, remotePath+" -> "+localDir, hosts, connect, opts, func(ctx context.Context, connect BroadcastConnect, opts BroadcastOptions) []BroadcastResult {
+		return Collect(ctx, hosts, connect, remotePath, localDir, opts)
+	})
+}
+
+// fanOutProgress runs the transfers of run, rendering the progress of every host.
+func fanOutProgress(
+	ctx context.Context,
+	theme Theme,
+	title string,
+	hosts []string,
+	connect BroadcastConnect,
+	opts BroadcastOptions,
+	run func(ctx context.Context, connect BroadcastConnect, opts BroadcastOptions) []BroadcastResult,
+) []BroadcastResult {
+	switch opts.Output.resolve() {
+	case ProgressNone:
+		return run(ctx, connect, opts)
+	case ProgressPlain:
+		return fanOutPlain(ctx, os.Stdout, title, connect, opts, run)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	theme = theme.withDefaults()
+	keys := newKeyMap()
+	keys.ToggleLog.SetEnabled(false)
+	keys.ScrollUp.SetEnabled(false)
+	keys.ScrollDown.SetEnabled(false)
+
+	m := broadcastModel{
+		title: title,
+		bar:   theme.progressBar(),
+		keys:  keys,
+		help:  newHelp(theme),
+		theme: theme,
+	}
+	index := map[string]int{}
+	for i, host := range hosts {
+		m.rows = append(m.rows, broadcastRow{host: host, total: -1, pending: new(atomic.Int64)})
+		index[host] = i
+	}
+	m.resize(theme.Width)
+	p := tea.NewProgram(m, programOptions...)
+
+	progressFor := opts.Progress
+	opts.Progress = func(host string) Progress {
+		progress := hostProgress{p: p, row: index[host], pending: m.rows[index[host]].pending}
+		if progressFor != nil {
+			return multiProgress{progress, progressOrNop(progressFor(host))}
+		}
+		return progress
+	}
+
+	done := make(chan []BroadcastResult, 1)
+	go func() {
+		results := run(ctx, func(ctx context.Context, host string) (Client, error) {
+			client, err := connect(ctx, host)
+			if err == nil {
+				p.Send(hostStateMsg{row: index[host], state: "transferring"})
+			}
+			return client, err
+		}, opts)
+		p.Send(broadcastDoneMsg(results))
+		done <- results
+	}()
+
+	_, _ = p.Run()
+	cancel()
+	return <-done
+}
+
+// fanOutPlain runs the transfers of run, printing the progress of every host to w as plain lines
+// and whether its transfer succeeded once all are done.
+func fanOutPlain(
+	ctx context.Context,
+	w io.Writer,
+	title string,
+	connect BroadcastConnect,
+	opts BroadcastOptions,
+	run func(ctx context.Context, connect BroadcastConnect, opts BroadcastOptions) []BroadcastResult,
+) []BroadcastResult {
+	fmt.Fprintln(w, title)
+	progressFor := opts.Progress
+	opts.Progress = func(host string) Progress {
+		progress := newTextProgress(w, "  "+host+" ", plainInterval)
+		if progressFor != nil {
+			return multiProgress{progress, progressOrNop(progressFor(host))}
+		}
+		return progress
+	}
+
+	results := run(ctx, connect, opts)
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(w, "  %s failed: %v\n", result.Host, result.Err)
+		} else {
+			fmt.Fprintf(w, "  %s done in %s\n", result.Host, result.Duration.Round(time.Second))
+		}
+	}
+	return results
+}
+
+// multiProgress forwards every update to all of its members.
+type multiProgress []Progress
+
+func (m multiProgress) Start(totalBytes int64, totalFiles int) {
+	for _, p := range m {
+		p.Start(totalBytes, totalFiles)
+	}
+}
+
+func (m multiProgress) File(name string, size int64) {
+	for _, p := range m {
+		p.File(name, size)
+	}
+}
+
+func (m multiProgress) Add(n int64) {
+	for _, p := range m {
+		p.Add(n)
+	}
+}
+
+type hostStartMsg struct {
+	row   int
+	total int64
+}
+
+type hostStateMsg struct {
+	row   int
+	state string
+}
+
+type broadcastDoneMsg []BroadcastResult
+
+// hostProgress forwards the progress of the transfer of one host into the running bubbletea program.
+// The bytes transferred are added to pending, which the model collects every redrawInterval.
+type hostProgress struct {
+	p       *tea.Program
+	row     int
+	pending *atomic.Int64
+}
+
+func (h hostProgress) Start(totalBytes int64, _ int) {
+	h.p.Send(hostStartMsg{row: h.row, total: totalBytes})
+}
+
+func (h hostProgress) File(string, int64) {}
+
+func (h hostProgress) Add(n int64) {
+	h.pending.Add(n)
+}
+
+// broadcastRow the state of the transfer of one host.
+type broadcastRow struct {
+	host   string
+	state  string
+	total  int64
+	done   int64
+	result *BroadcastResult
+
+	// pending the bytes transferred since they were last collected.
+	pending *atomic.Int64
+}
+
+// broadcastModel renders a progress bar for every host, and whether the transfer succeeded.
+type broadcastModel struct {
+	title string
+	rows  []broadcastRow
+	bar   progress.Model
+
+	// hostWidth the width of the column of host names.
+	hostWidth int
+
+	keys keyMap
+	help help.Model
+
+	theme Theme
+}
+
+// resize fits the progress bars next to the host names within width.
+func (m *broadcastModel) resize(width int) {
+	m.hostWidth = 0
+	for _, row := range m.rows {
+		m.hostWidth = max(m.hostWidth, len(row.host))
+	}
+	m.bar.Width = max(10, width-m.hostWidth-1-sizeWidth)
+	m.help.Width = width
+}
+
+func (m broadcastModel) Init() tea.Cmd {
+	return redraw()
+}
+
+// collect adds the bytes transferred since the last call to every row.
+func (m *broadcastModel) collect() {
+	for i := range m.rows {
+		m.rows[i].done += m.rows[i].pending.Swap(0)
+	}
+}
+
+func (m broadcastModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		}
+		return m, nil
+
+	case tea.WindowSizeMsg:
+		m.resize(min(msg.Width-padding*2-4, m.theme.Width))
+		return m, nil
+
+	case hostStateMsg:
+		m.rows[msg.row].state = msg.state
+		return m, nil
+
+	case hostStartMsg:
+		m.rows[msg.row].total = msg.total
+		return m, nil
+
+	case redrawMsg:
+		m.collect()
+		return m, redraw()
+
+	case broadcastDoneMsg:
+		m.collect()
+		for i := range msg {
+			m.rows[i].result = &msg[i]
+		}
+		return m, tea.Quit
+
+	default:
+		return m, nil
+	}
+}
+
+func (m broadcastModel) View() string {
+	pad := strings.Repeat(" ", padding)
+	view := "\n" + pad + m.title + "\n\n"
+
+	finished := true
+	for _, row := range m.rows {
+		host := fmt.Sprintf("%-*s", m.hostWidth, row.host)
+		switch {
+		case row.result == nil:
+			finished = false
+			state := row.state
+			if state == "" {
+				state = "connecting"
+			}
+			if row.total >= 0 {
+				view += pad + host + " " + m.bar.ViewAs(ratio(row.done, row.total)) + " " + style(m.theme.Muted)(sizes(row.done, row.total)) + "\n"
+			} else {
+				view += pad + host + " " + style(m.theme.Muted)(state+"...") + "\n"
+			}
+		case row.result.Err != nil:
+			view += pad + host + " " + style(m.theme.Failure)("✗ "+row.result.Err.Error()) + "\n"
+		default:
+			view += pad + host + " " + style(m.theme.Success)("✓ done in "+row.result.Duration.Round(time.Second).String()) + "\n"
+		}
+	}
+	if finished {
+		return view + "\n"
+	}
+	return view + "\n" + indent(m.help.View(m.keys), pad) + "\n"
+}
@@ -0,0 +1,115 @@
+package scp_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"main/scp"
+	"main/scp/scptest"
+)
+
+var errHostDown = errors.New("host down")
+
+// connectShell connects to a shell server of its own for every host but "down", which fails to connect.
+func connectShell(t *testing.T) scp.BroadcastConnect {
+	return func(ctx context.Context, host string) (scp.Client, error) {
+		if host == "down" {
+			return scp.Client{}, errHostDown
+		}
+		client := scptest.NewShellServer(t).Configurer().Create()
+		return client, client.ConnectContext(ctx)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	dir := t.TempDir()
+	artifact := filepath.Join(dir, "app.tar.gz")
+	if err := os.WriteFile(artifact, []byte("release"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	remote := filepath.Join(dir, "web1", "opt", "app.tar.gz")
+	progress := &recordingProgress{}
+	results := scp.Broadcast(context.Background(), []string{"web1"}, connectShell(t), artifact, remote, scp.BroadcastOptions{
+		Progress: func(host string) scp.Progress { return progress },
+	})
+	if len(results) != 1 || results[0].Err != nil || len(results[0].Entries) != 1 || results[0].Entries[0].Size != 7 {
+		t.Fatalf("Broadcast to web1 returned %+v", results)
+	}
+	// The parent directories were created.
+	if got, err := os.ReadFile(remote); err != nil || string(got) != "release" {
+		t.Errorf("web1 received %q, %v", got, err)
+	}
+	if progress.bytes != 7 {
+		t.Errorf("the progress of web1 received %d bytes", progress.bytes)
+	}
+
+	// A failing host does not stop the others, the results are in the order of the hosts.
+	hosts := []string{"web1", "down", "web2"}
+	results = scp.Broadcast(context.Background(), hosts, connectShell(t), artifact, filepath.Join(dir, "all", "app.tar.gz"), scp.BroadcastOptions{Parallelism: 1})
+	if len(results) != len(hosts) {
+		t.Fatalf("Broadcast returned %d results for %d hosts", len(results), len(hosts))
+	}
+	for i, result := range results {
+		if result.Host != hosts[i] {
+			t.Errorf("result %d is of %s, want %s", i, result.Host, hosts[i])
+		}
+		if wantErr := result.Host == "down"; (result.Err != nil) != wantErr || wantErr && !errors.Is(result.Err, errHostDown) {
+			t.Errorf("the result of %s has error %v", result.Host, result.Err)
+		}
+	}
+
+	// Directories are uploaded into the remote path.
+	tree := filepath.Join(dir, "tree")
+	writeTree(t, tree, map[string]string{"bin/app": "binary", "etc/app.conf": "config"}, time.Unix(1700000000, 0))
+	results = scp.Broadcast(context.Background(), []string{"web1"}, connectShell(t), tree, filepath.Join(dir, "deployed"), scp.BroadcastOptions{})
+	if results[0].Err != nil || len(results[0].Entries) < 2 {
+		t.Fatalf("Broadcast of a directory returned %+v", results)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "deployed", "etc", "app.conf")); err != nil || string(got) != "config" {
+		t.Errorf("the directory was received with etc/app.conf %q, %v", got, err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "var", "log")
+	writeTree(t, remote, map[string]string{"app.log": "started", "old/app.log.1": "stopped"}, time.Unix(1700000000, 0))
+	collected := filepath.Join(dir, "collected")
+
+	hosts := []string{"deploy@web1:22", "down", "web2:2222"}
+	results := scp.Collect(context.Background(), hosts, connectShell(t), filepath.Join(remote, "app.log"), collected, scp.BroadcastOptions{})
+	for i, result := range results {
+		if result.Host != hosts[i] {
+			t.Errorf("result %d is of %s, want %s", i, result.Host, hosts[i])
+		}
+		if wantErr := result.Host == "down"; (result.Err != nil) != wantErr {
+			t.Errorf("the result of %s has error %v", result.Host, result.Err)
+		}
+	}
+	// Every host has a directory of its own, named without the user and the default port.
+	for _, name := range []string{"web1", "web2_2222"} {
+		file := filepath.Join(collected, name, "app.log")
+		if got, err := os.ReadFile(file); err != nil || string(got) != "started" {
+			t.Errorf("collected %s as %q, %v", file, got, err)
+		}
+		if stat, err := os.Stat(file); err == nil && !stat.ModTime().Equal(time.Unix(1700000000, 0)) {
+			t.Errorf("%s was modified at %s, want the time of the remote file", file, stat.ModTime())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(collected, "down")); !os.IsNotExist(err) {
+		t.Errorf("a directory was created for the host that failed to connect: %v", err)
+	}
+
+	// Directories are collected with their name.
+	results = scp.Collect(context.Background(), []string{"web1"}, connectShell(t), remote, collected, scp.BroadcastOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("Collect of a directory returned %v", results[0].Err)
+	}
+	if got, err := os.ReadFile(filepath.Join(collected, "web1", "log", "old", "app.log.1")); err != nil || string(got) != "stopped" {
+		t.Errorf("the directory was collected with old/app.log.1 %q, %v", got, err)
+	}
+}
@@ -0,0 +1,39 @@
+package scp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandGroups(t *testing.T) {
+	groups := map[string][]string{
+		"web":   {"deploy@web1", "deploy@web2:2222"},
+		"db":    {"db1"},
+		"empty": {},
+	}
+
+	hosts, err := ExpandGroups(groups, []string{"@web", "cache1", "@db"})
+	if want := []string{"deploy@web1", "deploy@web2:2222", "cache1", "db1"}; err != nil || !reflect.DeepEqual(hosts, want) {
+		t.Errorf("ExpandGroups returned %q, %v, want %q", hosts, err, want)
+	}
+	for _, group := range []string{"@missing", "@empty"} {
+		if _, err := ExpandGroups(groups, []string{"web1", group}); err == nil {
+			t.Errorf("ExpandGroups of %s returned no error", group)
+		}
+	}
+}
+
+func TestHostDir(t *testing.T) {
+	tests := map[string]string{
+		"web1":              "web1",
+		"deploy@web1":       "web1",
+		"deploy@web1:22":    "web1",
+		"web1:2222":         "web1_2222",
+		"deploy@[::1]:2222": "::1_2222",
+	}
+	for host, want := range tests {
+		if got := hostDir(host); got != want {
+			t.Errorf("hostDir(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
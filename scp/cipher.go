@@ -0,0 +1,138 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Cipher encrypts files before they leave the machine and decrypts them once downloaded, so hosts
+// that are not trusted only ever store ciphertext. It matches the API of filippo.io/age, whose
+// age.Encrypt and age.Decrypt (wrapped with io.NopCloser) can be plugged in as they are, CommandCipher
+// runs programs such as `age` or `gpg` instead.
+type Cipher interface {
+	// Encrypt returns a writer encrypting what is written to it into w. Close flushes it.
+	Encrypt(w io.Writer) (io.WriteCloser, error)
+
+	// Decrypt returns a reader of the plaintext of r. Close tells whether decrypting succeeded.
+	Decrypt(r io.Reader) (io.ReadCloser, error)
+}
+
+// CommandCipher a Cipher piping files through local commands, run like local hooks with `sh -c`,
+// reading from their standard input and writing to their standard output. Transfers in the
+// direction of an empty command fail.
+//
+//	CommandCipher{EncryptCommand: "age -r age1...", DecryptCommand: "age -d -i ~/.config/age/key.txt"}
+//	CommandCipher{EncryptCommand: "gpg --batch -e -r backup@example.com", DecryptCommand: "gpg --batch -d"}
+type CommandCipher struct {
+	EncryptCommand string
+	DecryptCommand string
+}
+
+func (c CommandCipher) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if c.EncryptCommand == "" {
+		return nil, errors.New("no command to encrypt with")
+	}
+	cmd := shellCommand(context.Background(), c.EncryptCommand)
+	cmd.Stdout = w
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &syncBuffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %q: %w", c.EncryptCommand, err)
+	}
+	return &cipherCommand{WriteCloser: stdin, cmd: cmd, stderr: stderr}, nil
+}
+
+func (c CommandCipher) Decrypt(r io.Reader) (io.ReadCloser, error) {
+	if c.DecryptCommand == "" {
+		return nil, errors.New("no command to decrypt with")
+	}
+	cmd := shellCommand(context.Background(), c.DecryptCommand)
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &syncBuffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %q: %w", c.DecryptCommand, err)
+	}
+	return &cipherCommand{ReadCloser: stdout, cmd: cmd, stderr: stderr}, nil
+}
+
+// cipherCommand the end of the pipe of a running CommandCipher command. Close closes it and waits
+// for the command, returning its error output when it failed.
+type cipherCommand struct {
+	io.WriteCloser
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *syncBuffer
+}
+
+func (c *cipherCommand) Close() error {
+	if c.WriteCloser != nil {
+		c.WriteCloser.Close()
+	}
+	err := c.cmd.Wait()
+	if err == nil {
+		return nil
+	}
+	if output := strings.TrimSpace(string(c.stderr.Bytes())); output != "" {
+		return fmt.Errorf("%q: %w: %s", c.cmd.Args[len(c.cmd.Args)-1], err, output)
+	}
+	return fmt.Errorf("%q: %w", c.cmd.Args[len(c.cmd.Args)-1], err)
+}
+
+// cipherUpload encrypts r with the Cipher of the client into `cat` on the remote, which writes
+// it to result.RemotePath.
+func (a *Client) cipherUpload(ctx context.Context, r io.Reader, result *UploadResult, passThru PassThru, times *FileInfos) (*UploadResult, error) {
+	return a.pipeUpload(ctx, r, result, passThru, times, "cat", a.Cipher.Encrypt)
+}
+
+// cipherDownload downloads the remote file with download and writes it decrypted with the Cipher
+// of the client to w. The returned FileInfos describe the encrypted file on the remote.
+func (a *Client) cipherDownload(w io.Writer, download func(w io.Writer) (*FileInfos, error)) (*FileInfos, error) {
+	encrypted, encryptedW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		plain, err := a.Cipher.Decrypt(encrypted)
+		if err == nil {
+			_, err = copyBuffer(w, plain, a.BufferSize)
+			if err != nil {
+				// Ends the command, which waits for the rest of the download otherwise.
+				encrypted.CloseWithError(err)
+			}
+			if closeErr := plain.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			// Unblocks the download.
+			encrypted.CloseWithError(err)
+		} else {
+			_, _ = io.Copy(io.Discard, encrypted)
+		}
+		done <- err
+	}()
+
+	fileInfos, err := download(encryptedW)
+	encryptedW.CloseWithError(err)
+	if decryptErr := <-done; err == nil && decryptErr != nil {
+		err = fmt.Errorf("failed to decrypt: %w", decryptErr)
+	}
+	return fileInfos, err
+}
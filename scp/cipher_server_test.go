@@ -0,0 +1,49 @@
+package scp_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"main/scp"
+)
+
+func TestCipher(t *testing.T) {
+	ctx := context.Background()
+	// ROT13 stands in for age or gpg, it is its own inverse.
+	rot13 := "tr a-zA-Z n-za-mN-ZA-M"
+	client := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.Cipher(scp.CommandCipher{EncryptCommand: rot13, DecryptCommand: rot13}).VerifyUploads(true)
+	})
+	remote := filepath.Join(t.TempDir(), "secret")
+
+	if err := client.Copy(ctx, strings.NewReader("Hello World\n"), remote, "0600", 12); err != nil {
+		t.Fatalf("encrypted upload returned %v", err)
+	}
+	// The remote only stores the ciphertext.
+	if got, err := os.ReadFile(remote); err != nil || string(got) != "Uryyb Jbeyq\n" {
+		t.Errorf("the remote stores %q, %v", got, err)
+	}
+
+	var buf bytes.Buffer
+	if err := client.CopyFromRemotePassThru(ctx, &buf, remote, nil); err != nil || buf.String() != "Hello World\n" {
+		t.Errorf("the download was decrypted to %q, %v", buf.String(), err)
+	}
+
+	// A command failing to decrypt fails the download with its error output.
+	failing := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.Cipher(scp.CommandCipher{DecryptCommand: "cat > /dev/null; echo no identity matched >&2; exit 1"})
+	})
+	err := failing.CopyFromRemotePassThru(ctx, io.Discard, remote, nil)
+	if err == nil || !strings.Contains(err.Error(), "failed to decrypt") || !strings.Contains(err.Error(), "no identity matched") {
+		t.Errorf("the download failing to decrypt returned %v", err)
+	}
+	// As does a transfer in the direction without a command.
+	if err := failing.Copy(ctx, strings.NewReader("x"), remote, "0600", 1); err == nil {
+		t.Error("the upload without a command to encrypt with succeeded")
+	}
+}
@@ -7,11 +7,12 @@
 package scp
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"sync"
@@ -22,14 +23,14 @@ import (
 
 // Callback for freeing managed resources
 type ICloseHandler interface {
-	Close()
+	Close() error
 }
 
 // Close handler equivalent to a no-op. Used by default
 // when no resources have to be cleaned.
 type EmptyHandler struct{}
 
-func (EmptyHandler) Close() {}
+func (EmptyHandler) Close() error { return nil }
 
 // Close handler to close an SSH client
 type CloseSSHCLient struct {
@@ -37,12 +38,43 @@ type CloseSSHCLient struct {
 	sshClient *ssh.Client
 }
 
-func (scp CloseSSHCLient) Close() {
-	scp.sshClient.Close()
+func (scp CloseSSHCLient) Close() error {
+	err := scp.sshClient.Close()
+	if errors.Is(err, net.ErrClosed) {
+		// Closed already, such as by a copy of the client.
+		return nil
+	}
+	return err
 }
 
 type PassThru func(r io.Reader, total int64) io.Reader
 
+// UploadResult describes what an upload sent to the remote.
+type UploadResult struct {
+	// RemotePath the path the remote scp was asked to write to.
+	RemotePath string
+
+	// Filename the file name announced to the remote.
+	Filename string
+
+	// Permissions the permissions announced to the remote.
+	Permissions string
+
+	// Size the size announced to the remote.
+	Size int64
+
+	// BytesWritten the amount of file content written to the remote.
+	BytesWritten int64
+
+	// Acked whether the remote acknowledged the complete file.
+	Acked bool
+}
+
+// Client transfers files over a single SSH connection. Once connected it is safe for concurrent use
+// by multiple goroutines: every transfer runs in a session of its own, as many at once as allowed by
+// ClientConfigurer.MaxSessions with the rest waiting for a free one, and Close may be called while
+// transfers run, which ends them. Connecting must not happen concurrently with other calls, and the
+// exported fields must not be changed while the client is in use.
 type Client struct {
 	// Host the host to connect to.
 	Host string
@@ -50,40 +82,348 @@ type Client struct {
 	// ClientConfig the client config to use.
 	ClientConfig *ssh.ClientConfig
 
-	// Keep the ssh client around for generating new sessions
-	sshClient *ssh.Client
+	// The connection sessions are opened over and its close handler, shared by copies of the client
+	state *connState
+
+	// Bounds the amount of sessions opened concurrently over the connection
+	sessions *sessionPool
+
+	// Bounds the amount of sessions opened concurrently to Host over every connection
+	// of the ConnectionManager the client was created by
+	hostSessions *sessionPool
 
 	// Timeout the maximal amount of time to wait for a file transfer to complete.
 	// Deprecated: use context.Context for each function instead.
 	Timeout time.Duration
 
+	// IdleTimeout the maximal amount of time a transfer may go without any bytes flowing
+	// before it is aborted with ErrStalled. Zero disables stall detection.
+	IdleTimeout time.Duration
+
 	// RemoteBinary the absolute path to the remote SCP binary.
 	RemoteBinary string
 
+	// Set when the remote binary has to be detected before the first transfer
+	detection *binaryDetection
+
+	// Backend the protocol used to transfer files, defaults to BackendSCP.
+	Backend Backend
+
+	// Remembers the backend chosen when Backend is BackendAuto
+	backendDetection *backendDetection
+
+	// RemoteOS the operating system of the remote, which decides how the command lines of the remote
+	// scp are quoted, defaults to RemoteUnix. See RemoteOS.
+	RemoteOS RemoteOS
+
+	// Remembers the operating system detected when RemoteOS is RemoteOSAuto
+	osDetection *osDetection
+
+	// Compat the quirks of the remote scp transfers work around, defaults to CompatOpenSSH. See Compat.
+	Compat Compat
+
+	// Remembers the profile detected when Compat is CompatAuto
+	compatDetection *compatDetection
+
+	// Theme the look of the terminal interfaces, empty fields fall back to DefaultTheme.
+	Theme Theme
+
+	// BufferSize the size of the buffers file contents are copied through,
+	// DefaultBufferSize when zero.
+	BufferSize int
+
+	// Dialer opens the connection to Host, a net.Dialer when nil. Set it to connect over
+	// unix sockets, tunnels or in-memory pipes. When Proxy is set, it dials the proxy instead.
+	Dialer ContextDialer
+
+	// Proxy the URL of the SOCKS5 or HTTP proxy to connect through, empty to connect directly.
+	// See ProxyDialer for the supported proxies.
+	Proxy string
+
+	// ConnectTimeout the maximal amount of time connecting, including the SSH handshake, may take.
+	// It is independent of Timeout, zero leaves it to ClientConfig.Timeout and the context.
+	ConnectTimeout time.Duration
+
+	// TCPBufferSize the size of the receive and send buffers of the TCP connection made by Connect,
+	// zero keeps the defaults of the operating system. See ClientConfigurer.TCPBufferSize.
+	TCPBufferSize int
+
+	// Logger receives events such as connecting, detecting the remote and stalled transfers, may be nil.
+	Logger Logger
+
+	// NoShellQuoting passes remote paths to the remote scp as they are, instead of quoting them with
+	// ShellQuote. Only set it for servers that do not run commands through a POSIX shell, such as
+	// the built-in scp of some Go SSH servers, paths are re-parsed by the shell otherwise.
+	NoShellQuoting bool
+
+	// ExpandTilde treats a leading `~` or `~user` of remote paths as the home directory of the user,
+	// leaving it to the shell to expand. By default remote paths are literal, so `~/file` refers to
+	// a directory named `~`. Relative paths are relative to the home directory either way.
+	ExpandTilde bool
+
+	// DirectDownloads writes downloads to a local path straight to it, instead of to a .part file
+	// renamed once the download is complete. See CopyFromRemoteToPath.
+	DirectDownloads bool
+
+	// PartialPolicy what happens to the .part file of a download that failed, PartialDelete removes it.
+	// Downloads run by a Queue always keep it, so they can be resumed.
+	PartialPolicy PartialPolicy
+
+	// CheckRemoteSpace runs `df -P` on the destination directory before every upload of a known size,
+	// refusing one that can not fit with ErrInsufficientSpace. When the free space can not be
+	// determined the upload goes ahead.
+	CheckRemoteSpace bool
+
+	// VerifyUploads compares the sha256sum of every uploaded file on the remote with the checksum
+	// of the bytes sent, once the remote acknowledged it. Uploads that do not match are repeated
+	// up to VerifyRetries times when their reader can seek back, and fail with ErrVerifyFailed otherwise.
+	VerifyUploads bool
+
+	// VerifyRetries how often an upload failing verification is repeated, see VerifyUploads.
+	VerifyRetries int
+
+	// GzipUploads compresses uploads with gzip on the fly, decompressed by `gzip -dc` on the remote,
+	// and GzipDownloads has the remote compress downloads with `gzip -c`. Both only pay off for files
+	// that compress well over slow links, independent of the compression of SSH itself. The progress
+	// reports the compressed bytes on the wire, whose total is not known ahead.
+	GzipUploads   bool
+	GzipDownloads bool
+
+	// Cipher encrypts uploaded files before they leave the machine, written by `cat` on the remote
+	// so it stores only ciphertext, and decrypts downloaded files, may be nil. GzipUploads and
+	// VerifyUploads have no effect on encrypted uploads, and jobs of a Queue start over instead
+	// of resuming. See CommandCipher.
+	Cipher Cipher
+
+	// TextMode converts the line endings of transferred files, TextOff transfers them as they are.
+	// Converted uploads are spooled to a temporary file first, to announce their converted size.
+	// Jobs of a Queue converting line endings start over instead of resuming.
+	TextMode TextMode
+
+	// SpoolThreshold how much of a reader of unknown size, such as one given to CopyFile, is held in memory
+	// to learn its size before it is sent. Longer readers are spooled to a temporary file instead.
+	// DefaultSpoolThreshold when zero, negative to always spool to a file.
+	SpoolThreshold int64
+
+	// LazyConnect connects on the first transfer or other call needing the connection instead of
+	// returning ErrNotConnected when Connect was not called. Closed clients are not reconnected.
+	LazyConnect bool
+
+	// ProgressOutput how the functions rendering progress show it, see ProgressOutput.
+	ProgressOutput ProgressOutput
+
+	// ProgressJSON receives the progress of transfers as lines of JSON, may be nil. See ProgressRecord.
+	ProgressJSON io.Writer
+}
+
+// connState the connection of a Client. The lock allows closing it while other goroutines use it.
+type connState struct {
+	mu        sync.Mutex
+	sshClient *ssh.Client
+	// closed is set by Close, so LazyConnect does not reconnect a closed client.
+	closed bool
+
+	// connecting is held by the goroutine connecting lazily, the others wait for its connection.
+	connecting sync.Mutex
+
 	// Handler called when calling `Close` to clean up any remaining
 	// resources managed by `Client`.
 	closeHandler ICloseHandler
 }
 
+// conn returns the connection sessions are opened over, nil before connecting.
+func (a *Client) conn() *ssh.Client {
+	if a.state == nil {
+		return nil
+	}
+	a.state.mu.Lock()
+	defer a.state.mu.Unlock()
+	return a.state.sshClient
+}
+
+// setConn makes client the connection of the client, closed by closeHandler, and closes the connection
+// it replaces. A client closed while it connected stays closed: the new connection is closed instead
+// and ErrNotConnected returned, see reopen.
+func (a *Client) setConn(client *ssh.Client, closeHandler ICloseHandler) error {
+	if a.state == nil {
+		a.state = &connState{}
+	}
+	a.state.mu.Lock()
+	if a.state.closed {
+		a.state.mu.Unlock()
+		closeHandler.Close()
+		return ErrNotConnected
+	}
+	previous := a.state.closeHandler
+	a.state.sshClient = client
+	a.state.closeHandler = closeHandler
+	a.state.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// reopen undoes Close for a client connected again explicitly, unlike LazyConnect which does not
+// connect a closed client.
+func (a *Client) reopen() {
+	if a.state == nil {
+		a.state = &connState{}
+	}
+	a.state.mu.Lock()
+	defer a.state.mu.Unlock()
+	a.state.closed = false
+}
+
+// connected returns the connection sessions are opened over, connecting first when LazyConnect is
+// set and the client was neither connected nor closed. ErrNotConnected is returned otherwise.
+func (a *Client) connected(ctx context.Context) (*ssh.Client, error) {
+	if client := a.conn(); client != nil {
+		return client, nil
+	}
+	if a.state == nil || !a.LazyConnect || a.isClosed() {
+		return nil, ErrNotConnected
+	}
+
+	a.state.connecting.Lock()
+	defer a.state.connecting.Unlock()
+	// Another goroutine may have connected while this one waited.
+	if client := a.conn(); client != nil {
+		return client, nil
+	}
+	if err := a.connect(ctx); err != nil {
+		return nil, err
+	}
+	if client := a.conn(); client != nil {
+		return client, nil
+	}
+	// Closed while connecting.
+	return nil, ErrNotConnected
+}
+
+// isClosed tells whether Close was called since the client last connected.
+func (a *Client) isClosed() bool {
+	a.state.mu.Lock()
+	defer a.state.mu.Unlock()
+	return a.state.closed
+}
+
 // Connect connects to the remote SSH server, returns error if it couldn't establish a session to the SSH server.
 func (a *Client) Connect() error {
-	client, err := ssh.Dial("tcp", a.Host, a.ClientConfig)
+	return a.ConnectContext(context.Background())
+}
+
+// ConnectContext connects to the remote SSH server like Connect, giving up once the context is done
+// or ConnectTimeout has passed. Cancelling the context after it returned does not affect the connection.
+func (a *Client) ConnectContext(ctx context.Context) error {
+	a.reopen()
+	return a.connect(ctx)
+}
+
+// connect is ConnectContext without reopening a closed client, see connected.
+func (a *Client) connect(ctx context.Context) error {
+	a.logf(ctx, LogInfo, "connecting to %s", a.Host)
+	client, err := a.dial(ctx)
 	if err != nil {
+		a.logf(ctx, LogError, "failed to connect to %s: %v", a.Host, err)
 		return err
 	}
+	a.logf(ctx, LogInfo, "connected to %s (%s)", a.Host, client.ServerVersion())
 
-	a.sshClient = client
-	a.closeHandler = CloseSSHCLient{sshClient: client}
-	return nil
+	return a.setConn(client, CloseSSHCLient{sshClient: client})
+}
+
+// ConnectWithConn performs the SSH handshake over an already established connection, such as a
+// unix socket or one end of a net.Pipe, instead of dialing Host. Host is only used to verify the host key.
+// Closing the client closes the connection.
+func (a *Client) ConnectWithConn(conn net.Conn) error {
+	a.reopen()
+	ctx := context.Background()
+	if a.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.ConnectTimeout)
+		defer cancel()
+	}
+
+	client, err := a.handshake(ctx, conn)
+	if err != nil {
+		a.logf(ctx, LogError, "failed to connect to %s: %v", a.Host, err)
+		return err
+	}
+	a.logf(ctx, LogInfo, "connected to %s (%s)", a.Host, client.ServerVersion())
+
+	return a.setConn(client, CloseSSHCLient{sshClient: client})
+}
+
+// dial opens the connection with the Dialer, through the Proxy if any, tunes it with TCPBufferSize
+// and performs the SSH handshake over it.
+func (a *Client) dial(ctx context.Context) (*ssh.Client, error) {
+	if a.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.ConnectTimeout)
+		defer cancel()
+	}
+
+	reportPhase(ctx, phaseResolve)
+	dialer := reportingDialer(a.Dialer, a.ClientConfig.Timeout)
+	if a.Dialer != nil || a.Proxy != "" {
+		// The address is resolved by the dialer or the proxy.
+		reportPhase(ctx, phaseConnect)
+	}
+	if a.Proxy != "" {
+		var err error
+		if dialer, err = ProxyDialer(a.Proxy, dialer); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", a.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if tcp, ok := conn.(*net.TCPConn); ok && a.TCPBufferSize > 0 {
+		if err := tcp.SetReadBuffer(a.TCPBufferSize); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := tcp.SetWriteBuffer(a.TCPBufferSize); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return a.handshake(ctx, conn)
+}
+
+// handshake performs the SSH handshake over conn, which is closed when it fails.
+func (a *Client) handshake(ctx context.Context, conn net.Conn) (*ssh.Client, error) {
+	// The handshake does not take a context, closing the connection aborts it.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	reportPhase(ctx, phaseHandshake)
+	c, chans, reqs, err := ssh.NewClientConn(conn, a.Host, reportingConfig(ctx, a.ClientConfig))
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, context.Cause(ctx)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
 }
 
 // Returns the underlying SSH client, this should be used carefully as
 // it will be closed by `client.Close`.
 func (a *Client) SSHClient() *ssh.Client {
-	return a.sshClient
+	return a.conn()
 }
 
 // CopyFromFile copies the contents of an os.File to a remote location, it will get the length of the file by looking it up from the filesystem.
+// Permissions are octal, such as "0644", see ParseMode. Empty permissions take the ones of the file.
 func (a *Client) CopyFromFile(
 	ctx context.Context,
 	file os.File,
@@ -106,11 +446,55 @@ func (a *Client) CopyFromFilePassThru(
 	if err != nil {
 		return fmt.Errorf("failed to stat file: %w", err)
 	}
+	if permissions == "" {
+		permissions = FormatMode(stat.Mode())
+	}
 	return a.CopyPassThru(ctx, &file, remotePath, permissions, stat.Size(), passThru)
 }
 
+// CopyFromFilePreserve copies the contents of an os.File to a remote location like CopyFromFile, but also
+// preserves the access and modification times of the file on the remote, like `scp -p`.
+func (a *Client) CopyFromFilePreserve(
+	ctx context.Context,
+	file os.File,
+	remotePath string,
+	permissions string,
+	passThru PassThru,
+) error {
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if permissions == "" {
+		permissions = FormatMode(stat.Mode())
+	}
+	times := &FileInfos{Mtime: stat.ModTime().Unix(), Atime: fileAtime(stat).Unix()}
+
+	_, err = a.copyToRemote(ctx, &file, remotePath, permissions, stat.Size(), passThru, times)
+	return err
+}
+
+// CopyFromFileProgress is the same as CopyFromFile but renders a progress bar with the speed and
+// the estimated time left in the terminal.
+func (a *Client) CopyFromFileProgress(
+	ctx context.Context,
+	file os.File,
+	remotePath string,
+	permissions string,
+) error {
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if permissions == "" {
+		permissions = FormatMode(stat.Mode())
+	}
+	return a.CopyToRemoteProgressPassThru(ctx, &file, remotePath, permissions, stat.Size(), nil)
+}
+
 // CopyFile copies the contents of an io.Reader to a remote location, the length is determined by reading the io.Reader until EOF
-// if the file length in know in advance please use "Copy" instead.
+// if the file length in know in advance please use "Copy" instead. Readers that can seek, such as files, are not read
+// into memory, their length is determined by seeking.
 func (a *Client) CopyFile(
 	ctx context.Context,
 	fileReader io.Reader,
@@ -121,7 +505,9 @@ func (a *Client) CopyFile(
 }
 
 // CopyFilePassThru copies the contents of an io.Reader to a remote location, the length is determined by reading the io.Reader until EOF
-// if the file length in know in advance please use "Copy" instead.
+// if the file length in know in advance please use "Copy" instead. Readers that can seek are sent from their current offset
+// without reading them into memory first, others are held in memory up to SpoolThreshold bytes and spooled to a temporary
+// file beyond.
 // Access copied bytes by providing a PassThru reader factory.
 func (a *Client) CopyFilePassThru(
 	ctx context.Context,
@@ -130,25 +516,46 @@ func (a *Client) CopyFilePassThru(
 	permissions string,
 	passThru PassThru,
 ) error {
-	contentsBytes, err := ioutil.ReadAll(fileReader)
+	if size, ok := seekSize(fileReader); ok {
+		return a.CopyPassThru(ctx, fileReader, remotePath, permissions, size, passThru)
+	}
+
+	contents, size, release, err := a.spool(ctx, fileReader)
 	if err != nil {
-		return fmt.Errorf("failed to read all data from reader: %w", err)
+		return err
 	}
-	bytesReader := bytes.NewReader(contentsBytes)
+	defer release()
 
-	return a.CopyPassThru(
-		ctx,
-		bytesReader,
-		remotePath,
-		permissions,
-		int64(len(contentsBytes)),
-		passThru,
-	)
+	return a.CopyPassThru(ctx, contents, remotePath, permissions, size, passThru)
+}
+
+// seekSize returns the amount of bytes left in r when it can seek, by seeking to its end and back.
+// Pipes and terminals are files too, but fail to seek.
+func seekSize(r io.Reader) (int64, bool) {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		return 0, false
+	}
+	return end - offset, end >= offset
 }
 
 // wait waits for the waitgroup for the specified max timeout.
-// Returns true if waiting timed out.
-func wait(wg *sync.WaitGroup, ctx context.Context) error {
+// If the context is done before the waitgroup completes, the remote command is
+// signalled and the session is closed so that the goroutines working on it unblock,
+// the goroutines are then drained before the cause of the cancellation is returned.
+// They must so not block on anything but the session, see interruptibleReader.
+func wait(wg *sync.WaitGroup, ctx context.Context, session *ssh.Session) error {
 	c := make(chan struct{})
 	go func() {
 		defer close(c)
@@ -160,23 +567,103 @@ func wait(wg *sync.WaitGroup, ctx context.Context) error {
 		return nil
 
 	case <-ctx.Done():
-		return ctx.Err()
+		terminate(session)
+		<-c
+		return context.Cause(ctx)
+	}
+}
+
+// watchIdle returns a context that is cancelled with ErrStalled once the watchdog
+// has not seen any activity for longer than IdleTimeout, and a function
+// releasing the watcher which must be called when the transfer is finished.
+func (a *Client) watchIdle(ctx context.Context, dog *watchdog) (context.Context, func()) {
+	if a.IdleTimeout <= 0 {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	go dog.watch(ctx, a.IdleTimeout, func(cause error) {
+		a.logf(ctx, LogWarning, "no bytes flowed for %s, aborting the transfer", a.IdleTimeout)
+		cancel(cause)
+	})
+	return ctx, func() { cancel(nil) }
+}
+
+// terminate asks the remote command to stop and tears down the session.
+// Not every server honours signals, closing the session makes sure the
+// channel is gone regardless.
+func terminate(session *ssh.Session) {
+	_ = session.Signal(ssh.SIGTERM)
+	_ = session.Close()
+}
+
+// remoteExitError maps the exit status 127 of the remote shell, command not found, to ErrRemoteBinaryMissing.
+// Other exit errors result in err, or exitErr itself when err is nil.
+func (a *Client) remoteExitError(exitErr error, err error) error {
+	var exit *ssh.ExitError
+	if errors.As(exitErr, &exit) && exit.ExitStatus() == 127 {
+		return fmt.Errorf("%w: %s was not found", ErrRemoteBinaryMissing, a.remoteBinary())
+	}
+	if err == nil {
+		return exitErr
+	}
+	return err
+}
+
+// remoteStartError checks why the remote command failed to answer its first protocol message.
+// When the remote closed the output, the command exited and its exit status tells whether it
+// could not be started at all, which is reported as ErrRemoteBinaryMissing instead of the EOF.
+// Other failures are reported by the exit error, which remoteError explains.
+func (a *Client) remoteStartError(session *ssh.Session, err error) error {
+	if !errors.Is(err, io.EOF) {
+		return err
 	}
+	if exitErr := session.Wait(); exitErr != nil {
+		return a.remoteExitError(exitErr, nil)
+	}
+	return err
 }
 
 // checkResponse checks the response it reads from the remote, and will return a single error in case
-// of failure.
-func checkResponse(r io.Reader) error {
+// of failure. A warning sent in its place is logged and taken as the response, as the remote carries
+// on after it, and returned as well to explain the remote exiting with an error in the end.
+func (a *Client) checkResponse(ctx context.Context, r *bufio.Reader) (*RemoteWarning, error) {
 	_, err := ParseResponse(r, nil)
-	if err != nil {
-		return err
+	var warning *RemoteWarning
+	if errors.As(err, &warning) {
+		a.logf(ctx, LogWarning, "%s: %s", a.Host, warning)
+		return warning, nil
 	}
+	return nil, err
+}
 
-	return nil
+// readFileRecord reads the records announcing the file a remote scp running in source mode sends.
+// Warnings sent before it are logged, the last of them is returned when no file follows.
+func (a *Client) readFileRecord(ctx context.Context, r *bufio.Reader, in io.Writer) (*FileInfos, error) {
+	var warning *RemoteWarning
+	for {
+		fileInfos, err := ParseResponse(r, in)
+		if !errors.As(err, &warning) {
+			if warning != nil && errors.Is(err, io.EOF) {
+				return fileInfos, warning
+			}
+			return fileInfos, err
+		}
+		a.logf(ctx, LogWarning, "%s: %s", a.Host, warning)
+	}
+}
 
+// explainExit adds the last warning of the remote to the error of it exiting with an error,
+// as warnings such as failing to write a file do fail the transfer after all.
+func explainExit(err error, warning *RemoteWarning) error {
+	var exit *ssh.ExitError
+	if warning == nil || !errors.As(err, &exit) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", warning, err)
 }
 
 // Copy copies the contents of an io.Reader to a remote location.
+// Permissions are octal, such as "0644", see ParseMode. Empty permissions default to DefaultFileMode.
 func (a *Client) Copy(
 	ctx context.Context,
 	r io.Reader,
@@ -197,19 +684,164 @@ func (a *Client) CopyPassThru(
 	size int64,
 	passThru PassThru,
 ) error {
-	session, err := a.sshClient.NewSession()
+	_, err := a.copyToRemote(ctx, r, remotePath, permissions, size, passThru, nil)
+
+	return err
+}
+
+// CopyToRemoteProgress is the same as Copy but renders a progress bar with the speed and
+// the estimated time left in the terminal.
+func (a *Client) CopyToRemoteProgress(
+	ctx context.Context,
+	r io.Reader,
+	remotePath string,
+	permissions string,
+	size int64,
+) error {
+	return a.CopyToRemoteProgressPassThru(ctx, r, remotePath, permissions, size, nil)
+}
+
+// CopyToRemoteProgressPassThru is the same as CopyPassThru but renders a progress bar with the speed and
+// the estimated time left in the terminal. Quitting the interface cancels the transfer.
+func (a *Client) CopyToRemoteProgressPassThru(
+	ctx context.Context,
+	r io.Reader,
+	remotePath string,
+	permissions string,
+	size int64,
+	passThru PassThru,
+) error {
+	return a.runProgress(ctx, a.label(Upload, path.Base(remotePath)), func(ctx context.Context, progress Progress) error {
+		return a.CopyPassThru(ctx, r, remotePath, permissions, size, progressPassThru(progress, path.Base(remotePath), passThru, a.BufferSize))
+	})
+}
+
+// CopyToRemoteResult copies the contents of an io.Reader to a remote location and returns an UploadResult
+// describing what was sent to the remote and whether the remote confirmed the transfer.
+// The result is returned alongside an error as well, to tell how far the transfer got.
+func (a *Client) CopyToRemoteResult(
+	ctx context.Context,
+	r io.Reader,
+	remotePath string,
+	permissions string,
+	size int64,
+	passThru PassThru,
+) (*UploadResult, error) {
+	return a.copyToRemote(ctx, r, remotePath, permissions, size, passThru, nil)
+}
+
+// copyToRemote uploads the contents of r, verifying the upload when VerifyUploads is set. When times
+// is not nil its access and modification times are applied to the remote file, like `scp -p` does.
+func (a *Client) copyToRemote(
+	ctx context.Context,
+	r io.Reader,
+	remotePath string,
+	permissions string,
+	size int64,
+	passThru PassThru,
+	times *FileInfos,
+) (*UploadResult, error) {
+	if a.TextMode != TextOff {
+		// Describes the upload when converting fails before it starts.
+		result := &UploadResult{RemotePath: remotePath, Filename: path.Base(remotePath), Permissions: permissions, Size: -1}
+		err := a.textUpload(ctx, r, func(r io.Reader, size int64) error {
+			var err error
+			result, err = a.copyToRemoteVerified(ctx, r, remotePath, permissions, size, passThru, times)
+			return err
+		})
+		return result, err
+	}
+	return a.copyToRemoteVerified(ctx, r, remotePath, permissions, size, passThru, times)
+}
+
+// copyToRemoteVerified uploads the contents of r, verifying the upload when VerifyUploads is set.
+func (a *Client) copyToRemoteVerified(
+	ctx context.Context,
+	r io.Reader,
+	remotePath string,
+	permissions string,
+	size int64,
+	passThru PassThru,
+	times *FileInfos,
+) (*UploadResult, error) {
+	// The remote checksum of encrypted uploads can not match the one of the plaintext.
+	if !a.VerifyUploads || a.Cipher != nil {
+		return a.copyToRemoteOnce(ctx, r, remotePath, permissions, size, passThru, times)
+	}
+	return a.verifiedUpload(ctx, r, remotePath, func(r io.Reader) (*UploadResult, error) {
+		return a.copyToRemoteOnce(ctx, r, remotePath, permissions, size, passThru, times)
+	})
+}
+
+// copyToRemoteOnce uploads the contents of r, see copyToRemote.
+func (a *Client) copyToRemoteOnce(
+	ctx context.Context,
+	r io.Reader,
+	remotePath string,
+	permissions string,
+	size int64,
+	passThru PassThru,
+	times *FileInfos,
+) (*UploadResult, error) {
+	filename := path.Base(remotePath)
+	permissions, err := uploadPermissions(permissions, DefaultFileMode)
+	result := &UploadResult{
+		RemotePath:  remotePath,
+		Filename:    filename,
+		Permissions: permissions,
+		Size:        size,
+	}
 	if err != nil {
-		return fmt.Errorf("Error creating ssh session in copy to remote: %v", err)
+		return result, err
 	}
-	defer session.Close()
 
-	stdout, err := session.StdoutPipe()
+	if a.CheckRemoteSpace && size > 0 {
+		if err := a.checkRemoteSpace(ctx, path.Dir(remotePath), size); err != nil {
+			return result, err
+		}
+	}
+	if a.Cipher != nil {
+		return a.cipherUpload(ctx, r, result, passThru, times)
+	}
+	if a.GzipUploads {
+		return a.gzipUpload(ctx, r, result, passThru, times)
+	}
+
+	backend, err := a.resolveBackend(ctx)
 	if err != nil {
-		return err
+		return result, err
+	}
+	if backend == BackendSFTP {
+		return a.sftpUpload(ctx, r, result, passThru, 0, times)
+	}
+
+	if err := checkRemotePath(remotePath); err != nil {
+		return result, err
 	}
+	if err := a.resolveRemoteBinary(ctx); err != nil {
+		return result, err
+	}
+	if a.remoteOS().windows() {
+		// Named apart from backslashes and drive letters, see windowsPath.
+		filename = path.Base(a.windowsPath(remotePath))
+		result.Filename = filename
+	}
+
+	session, release, err := a.newSession(ctx)
+	if err != nil {
+		return result, fmt.Errorf("Error creating ssh session in copy to remote: %w", err)
+	}
+	defer release()
+
+	pipe, err := session.StdoutPipe()
+	if err != nil {
+		return result, err
+	}
+	// Buffered once, so an acknowledgement following a warning is not lost.
+	stdout := bufio.NewReader(pipe)
 	w, err := session.StdinPipe()
 	if err != nil {
-		return err
+		return result, err
 	}
 	defer w.Close()
 
@@ -217,37 +849,87 @@ func (a *Client) CopyPassThru(
 		r = passThru(r, size)
 	}
 
-	filename := path.Base(remotePath)
+	dog := newWatchdog()
+	r = dog.Reader(r, a.BufferSize)
 
 	// Start the command first and get confirmation that it has been started
 	// before sending anything through the pipes.
-	err = session.Start(fmt.Sprintf("%s -qt %q", a.RemoteBinary, remotePath))
+	if times != nil && !a.preserveTimes(ctx, remotePath) {
+		times = nil
+	}
+	flags := "-qt"
+	if times != nil {
+		flags = "-qtp"
+	}
+	err = session.Start(a.scpCommand(flags, remotePath))
 	if err != nil {
-		return err
+		return result, err
 	}
 
+	// If there is a timeout, stop the transfer if it has been exceeded
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	ctx, stopWatch := a.watchIdle(ctx, dog)
+	defer stopWatch()
+
+	// A read of r that blocks, such as of a pipe, would keep wait from returning once the context is done.
+	r = &interruptibleReader{ctx: ctx, r: r}
+
 	wg := sync.WaitGroup{}
 	wg.Add(2)
 
 	errCh := make(chan error, 2)
+	// The last warning of the remote, read once the goroutines are done.
+	var warning *RemoteWarning
 
 	// SCP protocol and file sending
-	go func() {
+	go func(ctx context.Context) {
 		defer wg.Done()
 		defer w.Close()
 
-		_, err = fmt.Fprintln(w, "C"+permissions, size, filename)
+		check := func() error {
+			warned, err := a.checkResponse(ctx, stdout)
+			if warned != nil {
+				warning = warned
+			}
+			return err
+		}
+
+		// The remote acknowledges that it started before the first record, and every record and
+		// the contents after it.
+		if err = check(); err != nil {
+			errCh <- err
+			return
+		}
+
+		if times != nil {
+			_, err = fmt.Fprintf(w, "T%d 0 %d 0\n", times.Mtime, times.Atime)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if err = check(); err != nil {
+				errCh <- err
+				return
+			}
+		}
+
+		_, err = io.WriteString(w, createRecord(permissions, size, filename))
 		if err != nil {
 			errCh <- err
 			return
 		}
 
-		if err = checkResponse(stdout); err != nil {
+		if err = check(); err != nil {
 			errCh <- err
 			return
 		}
 
-		_, err = io.Copy(w, r)
+		result.BytesWritten, err = copyBuffer(w, r, a.BufferSize)
 		if err != nil {
 			errCh <- err
 			return
@@ -259,44 +941,47 @@ func (a *Client) CopyPassThru(
 			return
 		}
 
-		if err = checkResponse(stdout); err != nil {
+		if err = check(); err != nil {
 			errCh <- err
 			return
 		}
-	}()
+		result.Acked = true
+	}(ctx)
 
 	// Wait for the process to exit
 	go func() {
 		defer wg.Done()
-		err := session.Wait()
+		err := a.exitError(session.Wait())
 		if err != nil {
 			errCh <- err
 			return
 		}
 	}()
 
-	// If there is a timeout, stop the transfer if it has been exceeded
-	if a.Timeout > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
-		defer cancel()
-	}
-
-	// Wait for one of the conditions (error/timeout/completion) to occur
-	if err := wait(&wg, ctx); err != nil {
-		return err
+	// Wait for one of the conditions (error/timeout/stall/completion) to occur
+	if err := wait(&wg, ctx, session); err != nil {
+		return result, err
 	}
 
 	close(errCh)
 
-	// Collect any errors from the error channel
+	// Collect any errors from the error channel. A missing binary makes the protocol fail
+	// on the closed output as well, the exit status of the remote tells the actual cause.
+	var firstErr error
 	for err := range errCh {
-		if err != nil {
-			return err
+		if missing := a.remoteExitError(err, nil); errors.Is(missing, ErrRemoteBinaryMissing) {
+			return result, missing
+		}
+		if firstErr == nil {
+			firstErr = err
 		}
 	}
+	if firstErr != nil && result.Acked && a.lostTimes(warning) {
+		a.logf(ctx, LogWarning, "%s: the times of %s were not preserved: %s", a.Host, remotePath, warning)
+		return result, nil
+	}
 
-	return nil
+	return result, remoteError(session, explainExit(firstErr, warning))
 }
 
 // CopyFromRemote copies a file from the remote to the local file given by the `file`
@@ -315,11 +1000,31 @@ func (a *Client) CopyFromRemotePassThru(
 	remotePath string,
 	passThru PassThru,
 ) error {
-	_, err := a.copyFromRemote(ctx, w, remotePath, passThru, false)
+	_, err := a.CopyFromRemoteWithOptions(ctx, w, remotePath, DownloadOptions{PassThru: passThru})
 
 	return err
 }
 
+// CopyFromRemoteProgress is the same as CopyFromRemote but renders a progress bar with the speed and
+// the estimated time left in the terminal.
+func (a *Client) CopyFromRemoteProgress(ctx context.Context, file *os.File, remotePath string) error {
+	return a.CopyFromRemoteProgressPassThru(ctx, file, remotePath, nil)
+}
+
+// CopyFromRemoteProgressPassThru is the same as CopyFromRemotePassThru but renders a progress bar with the
+// speed and the estimated time left in the terminal. Quitting the interface cancels the transfer.
+func (a *Client) CopyFromRemoteProgressPassThru(
+	ctx context.Context,
+	w io.Writer,
+	remotePath string,
+	passThru PassThru,
+) error {
+	return a.runProgress(ctx, a.label(Download, path.Base(remotePath)), func(ctx context.Context, progress Progress) error {
+		_, err := a.CopyFromRemoteWithOptions(ctx, w, remotePath, DownloadOptions{PassThru: passThru, Progress: progress})
+		return err
+	})
+}
+
 // CopyFroRemoteFileInfos copies a file from the remote to a given writer and return a FileInfos struct
 // containing information about the file such as permissions, the file size, modification time and access time
 func (a *Client) CopyFromRemoteFileInfos(
@@ -328,119 +1033,239 @@ func (a *Client) CopyFromRemoteFileInfos(
 	remotePath string,
 	passThru PassThru,
 ) (*FileInfos, error) {
-	return a.copyFromRemote(ctx, w, remotePath, passThru, true)
+	return a.CopyFromRemoteWithOptions(ctx, w, remotePath, DownloadOptions{PreserveTimes: true, PassThru: passThru})
 }
 
-func (a *Client) copyFromRemote(
+// CopyFromRemoteWriterAt copies a file from the remote into the given io.WriterAt, writing the first byte
+// of the remote file at `offset`. This allows downloading into preallocated files, resuming a download
+// or writing several transfers into chunks of the same file concurrently.
+// It returns a FileInfos struct describing the remote file, like CopyFromRemoteFileInfos.
+func (a *Client) CopyFromRemoteWriterAt(
 	ctx context.Context,
-	w io.Writer,
+	w io.WriterAt,
+	offset int64,
 	remotePath string,
 	passThru PassThru,
-	preserveFileTimes bool,
 ) (*FileInfos, error) {
-	session, err := a.sshClient.NewSession()
-	if err != nil {
-		return nil, fmt.Errorf("Error creating ssh session in copy from remote: %v", err)
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset %d: must not be negative", offset)
 	}
-	defer session.Close()
+	return a.CopyFromRemoteWithOptions(ctx, io.NewOffsetWriter(w, offset), remotePath, DownloadOptions{PreserveTimes: true, PassThru: passThru})
+}
 
-	wg := sync.WaitGroup{}
-	errCh := make(chan error, 4)
-	var fileInfos *FileInfos
+// CopyFromRemoteSparse copies a file from the remote into `file`, starting at its current offset, but
+// seeks over blocks that only contain zeros instead of writing them. On file systems supporting it,
+// this keeps sparse files such as VM images or database files from taking their full size on disk.
+// The destination should be empty (or truncated), since skipped blocks are not overwritten.
+func (a *Client) CopyFromRemoteSparse(
+	ctx context.Context,
+	file SparseFile,
+	remotePath string,
+	passThru PassThru,
+) (*FileInfos, error) {
+	w, err := newSparseWriter(file)
+	if err != nil {
+		return nil, err
+	}
 
-	wg.Add(1)
-	go func() {
-		var err error
+	fileInfos, err := a.CopyFromRemoteWithOptions(ctx, w, remotePath, DownloadOptions{PreserveTimes: true, PassThru: passThru})
+	if err != nil {
+		return fileInfos, err
+	}
 
-		defer func() {
-			// NOTE: this might send an already sent error another time, but since we only receive opne, this is fine. On the "happy-path" of this function, the error will be `nil` therefore completing the "err<-errCh" at the bottom of the function.
-			errCh <- err
-			// We must unblock the go routine first as we block on reading the channel later
-			wg.Done()
+	return fileInfos, w.Finish()
+}
 
-		}()
+// DownloadOptions configures a download by CopyFromRemoteWithOptions.
+type DownloadOptions struct {
+	// PreserveTimes asks the remote for the access and modification times of the file,
+	// which are returned in the FileInfos.
+	PreserveTimes bool
 
-		r, err := session.StdoutPipe()
-		if err != nil {
-			errCh <- err
-			return
-		}
+	// PassThru wraps the reader of the file contents, may be nil.
+	PassThru PassThru
 
-		in, err := session.StdinPipe()
-		if err != nil {
-			errCh <- err
-			return
-		}
-		defer in.Close()
+	// Progress receives the progress of the download, may be nil.
+	Progress Progress
+}
 
-		if preserveFileTimes {
-			err = session.Start(fmt.Sprintf("%s -pf %q", a.RemoteBinary, remotePath))
-		} else {
-			err = session.Start(fmt.Sprintf("%s -f %q", a.RemoteBinary, remotePath))
-		}
-		if err != nil {
-			errCh <- err
-			return
-		}
+// CopyFromRemoteWithOptions copies a file from the remote to the given writer and returns a FileInfos struct
+// describing the remote file. All other CopyFromRemote variants are built on it.
+func (a *Client) CopyFromRemoteWithOptions(
+	ctx context.Context,
+	w io.Writer,
+	remotePath string,
+	opts DownloadOptions,
+) (*FileInfos, error) {
+	if a.TextMode != TextOff {
+		return a.textDownload(w, func(w io.Writer) (*FileInfos, error) {
+			return a.decryptedDownload(ctx, w, remotePath, opts)
+		})
+	}
+	return a.decryptedDownload(ctx, w, remotePath, opts)
+}
 
-		err = Ack(in)
-		if err != nil {
-			errCh <- err
-			return
-		}
+// decryptedDownload downloads the file decrypted with the Cipher, when there is one.
+func (a *Client) decryptedDownload(
+	ctx context.Context,
+	w io.Writer,
+	remotePath string,
+	opts DownloadOptions,
+) (*FileInfos, error) {
+	if a.Cipher != nil {
+		return a.cipherDownload(w, func(w io.Writer) (*FileInfos, error) {
+			return a.copyFromRemote(ctx, w, remotePath, opts)
+		})
+	}
+	return a.copyFromRemote(ctx, w, remotePath, opts)
+}
 
-		fileInfo, err := ParseResponse(r, in)
-		if err != nil {
-			errCh <- err
-			return
-		}
+// copyFromRemote downloads the file as it is stored on the remote, see CopyFromRemoteWithOptions.
+func (a *Client) copyFromRemote(
+	ctx context.Context,
+	w io.Writer,
+	remotePath string,
+	opts DownloadOptions,
+) (*FileInfos, error) {
+	passThru := opts.PassThru
+	if opts.Progress != nil {
+		passThru = progressPassThru(opts.Progress, path.Base(remotePath), passThru, a.BufferSize)
+	}
+	if a.GzipDownloads {
+		return a.gzipDownload(ctx, w, remotePath, passThru)
+	}
 
-		fileInfos = fileInfo
+	backend, err := a.resolveBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if backend == BackendSFTP {
+		return a.sftpDownload(ctx, w, remotePath, passThru, 0)
+	}
 
-		err = Ack(in)
-		if err != nil {
-			errCh <- err
-			return
-		}
+	if err := checkNUL(remotePath); err != nil {
+		return nil, err
+	}
+	if err := a.resolveRemoteBinary(ctx); err != nil {
+		return nil, err
+	}
 
-		if passThru != nil {
-			r = passThru(r, fileInfo.Size)
-		}
+	session, release, err := a.newSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating ssh session in copy from remote: %w", err)
+	}
+	defer release()
 
-		_, err = CopyN(w, r, fileInfo.Size)
-		if err != nil {
-			errCh <- err
-			return
-		}
+	wg := sync.WaitGroup{}
+	errCh := make(chan error, 1)
+	var fileInfos *FileInfos
+	dog := newWatchdog()
 
-		err = Ack(in)
-		if err != nil {
-			errCh <- err
-			return
-		}
+	wg.Add(1)
+	go func(ctx context.Context) {
+		defer wg.Done()
 
-		err = session.Wait()
-		if err != nil {
-			errCh <- err
-			return
-		}
-	}()
+		var err error
+		fileInfos, err = a.receiveFile(ctx, session, dog, w, remotePath, opts.PreserveTimes, passThru)
+		errCh <- err
+	}(ctx)
 
 	if a.Timeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
 		defer cancel()
 	}
+	ctx, stopWatch := a.watchIdle(ctx, dog)
+	defer stopWatch()
 
-	if err := wait(&wg, ctx); err != nil {
+	if err := wait(&wg, ctx, session); err != nil {
 		return nil, err
 	}
 
-	finalErr := <-errCh
-	close(errCh)
-	return fileInfos, finalErr
+	return fileInfos, remoteError(session, <-errCh)
+}
+
+// receiveFile runs the remote scp in source mode on the session and writes the single file it sends to w.
+func (a *Client) receiveFile(
+	ctx context.Context,
+	session *ssh.Session,
+	dog *watchdog,
+	w io.Writer,
+	remotePath string,
+	preserveFileTimes bool,
+	passThru PassThru,
+) (*FileInfos, error) {
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	// Buffered once, so the record following a warning is not lost.
+	buffered := bufio.NewReader(dog.Reader(stdout, a.BufferSize))
+
+	in, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	flags := "-f"
+	if preserveFileTimes && a.preserveTimes(ctx, remotePath) {
+		flags = "-pf"
+	}
+	if err := session.Start(a.scpCommand(flags, remotePath)); err != nil {
+		return nil, err
+	}
+
+	if err := Ack(in); err != nil {
+		return nil, a.remoteStartError(session, err)
+	}
+
+	// Nothing is streamed before the remote announced the file, which also verifies it started.
+	fileInfos, err := a.readFileRecord(ctx, buffered, in)
+	if err != nil {
+		return nil, a.remoteStartError(session, err)
+	}
+
+	// Not acknowledging the file stops the remote before it sends anything.
+	if err := checkLocalSpace(w, fileInfos.Size); err != nil {
+		return fileInfos, err
+	}
+
+	if err := Ack(in); err != nil {
+		return fileInfos, err
+	}
+
+	var r io.Reader = buffered
+	if passThru != nil {
+		r = passThru(r, fileInfos.Size)
+	}
+
+	if _, err := copyN(ctx, w, r, fileInfos.Size, a.BufferSize); err != nil {
+		return fileInfos, err
+	}
+
+	if err := Ack(in); err != nil {
+		return fileInfos, err
+	}
+
+	return fileInfos, a.exitError(session.Wait())
 }
 
-func (a *Client) Close() {
-	a.closeHandler.Close()
+// Close closes the connection of the client, or releases its share of the connection when it was
+// created by a ConnectionManager, which ends the sessions of any transfers still running over it.
+// It may be called before connecting and more than once, only the first call has an effect.
+func (a *Client) Close() error {
+	if a.state == nil {
+		return nil
+	}
+	a.state.mu.Lock()
+	closeHandler := a.state.closeHandler
+	a.state.sshClient = nil
+	a.state.closed = true
+	a.state.closeHandler = EmptyHandler{}
+	a.state.mu.Unlock()
+	if closeHandler == nil {
+		return nil
+	}
+	return closeHandler.Close()
 }
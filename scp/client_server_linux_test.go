@@ -0,0 +1,37 @@
+package scp_test
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"main/scp/scptest"
+)
+
+func TestTCPBufferSize(t *testing.T) {
+	server := scptest.NewServer(t)
+	dialer := &recordingDialer{}
+	client := server.Configurer().Dialer(dialer).TCPBufferSize(4096).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	raw, err := dialer.conns[0].(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rcv, snd int
+	err = raw.Control(func(fd uintptr) {
+		rcv, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		snd, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Linux doubles the sizes asked for, to make room for its bookkeeping.
+	if rcv != 2*4096 || snd != 2*4096 {
+		t.Errorf("the receive buffer is %d and the send buffer %d bytes, want twice 4096", rcv, snd)
+	}
+	upload(t, client)
+}
@@ -0,0 +1,590 @@
+package scp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"main/scp"
+	"main/scp/scptest"
+)
+
+// newTestClient returns a client, configured by configure, connected to a server sharing the local
+// file system, so remote paths are local paths. Commands other than scp run with the local sh.
+func newTestClient(t *testing.T, configure func(c *scp.ClientConfigurer)) *scp.Client {
+	configurer := scptest.NewShellServer(t).Configurer()
+	if configure != nil {
+		configure(configurer)
+	}
+	client := configurer.Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return &client
+}
+
+// fakeRemoteBinary writes a shell script run in place of the remote scp.
+func fakeRemoteBinary(t *testing.T, script string) string {
+	name := filepath.Join(t.TempDir(), "scp.sh")
+	if err := os.WriteFile(name, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return "sh " + name
+}
+
+func TestNotConnectedAfterClose(t *testing.T) {
+	client := newTestClient(t, nil)
+	client.Close()
+	var buf bytes.Buffer
+	if _, err := client.CopyFromRemoteWithOptions(context.Background(), &buf, "/etc/hostname", scp.DownloadOptions{}); !errors.Is(err, scp.ErrNotConnected) {
+		t.Errorf("download after Close returned %v, want ErrNotConnected", err)
+	}
+}
+
+func TestLazyConnect(t *testing.T) {
+	client := scptest.NewShellServer(t).Configurer().LazyConnect(true).Create()
+	defer client.Close()
+	remote := filepath.Join(t.TempDir(), "lazy")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = client.CopyFile(context.Background(), strings.NewReader("lazy"), remote, "0644")
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("CopyFile of a lazy client returned %v", err)
+		}
+	}
+	if got, err := os.ReadFile(remote); err != nil || string(got) != "lazy" {
+		t.Fatalf("uploaded %q, %v", got, err)
+	}
+
+	client.Close()
+	if err := client.CopyFile(context.Background(), strings.NewReader("x"), remote, "0644"); !errors.Is(err, scp.ErrNotConnected) {
+		t.Errorf("CopyFile after Close returned %v, want ErrNotConnected", err)
+	}
+}
+
+func TestConcurrentCopies(t *testing.T) {
+	client := newTestClient(t, func(c *scp.ClientConfigurer) { c.MaxSessions(4) })
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			contents := strings.Repeat(strconv.Itoa(i), 1000+i)
+			remotePath := filepath.Join(dir, fmt.Sprintf("file-%d", i))
+			if err := client.CopyFile(ctx, strings.NewReader(contents), remotePath, "0644"); err != nil {
+				errs <- fmt.Errorf("uploading %d: %w", i, err)
+				return
+			}
+			var downloaded bytes.Buffer
+			if err := client.CopyFromRemotePassThru(ctx, &downloaded, remotePath, nil); err != nil {
+				errs <- fmt.Errorf("downloading %d: %w", i, err)
+				return
+			}
+			if downloaded.String() != contents {
+				errs <- fmt.Errorf("file %d came back as %d bytes, want %d", i, downloaded.Len(), len(contents))
+			}
+			if _, err := client.Ping(ctx); err != nil {
+				errs <- fmt.Errorf("ping %d: %w", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestCloseDuringTransfers(t *testing.T) {
+	client := newTestClient(t, nil)
+	ctx := context.Background()
+	// Large enough to still be in flight when the client is closed, without taking up any space.
+	remotePath := filepath.Join(t.TempDir(), "sparse")
+	if err := os.WriteFile(remotePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(remotePath, 1<<32); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.CopyFromRemotePassThru(ctx, io.Discard, remotePath, nil); err == nil {
+				t.Error("download completed despite closing the client")
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := client.Close(); err != nil {
+		t.Errorf("Close returned %v", err)
+	}
+	wg.Wait()
+}
+
+func TestRemoteWarnings(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var warnings []string
+	logger := func(entry scp.LogEntry) {
+		if entry.Level == scp.LogWarning {
+			mu.Lock()
+			warnings = append(warnings, entry.Message)
+			mu.Unlock()
+		}
+	}
+
+	download := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.Logger(logger).RemoteBinary(fakeRemoteBinary(t, `
+head -c 1 >/dev/null
+printf '\001scp: a.txt: Permission denied\nC0644 5 b.txt\n'
+head -c 1 >/dev/null
+printf 'hello\000'
+head -c 1 >/dev/null
+`))
+	})
+	var buf bytes.Buffer
+	if _, err := download.CopyFromRemoteWithOptions(ctx, &buf, "b.txt", scp.DownloadOptions{}); err != nil || buf.String() != "hello" {
+		t.Errorf("download after a warning = %q, %v", buf.String(), err)
+	}
+
+	upload := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.Logger(logger).RemoteBinary(fakeRemoteBinary(t, `
+printf '\000\000\001scp: b.txt: set times: Operation not permitted\n'
+cat >/dev/null
+`))
+	})
+	if err := upload.Copy(ctx, strings.NewReader("hello"), "b.txt", "0644", 5); err != nil {
+		t.Errorf("upload acknowledged by a warning failed: %v", err)
+	}
+
+	mu.Lock()
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "Permission denied") || !strings.Contains(warnings[1], "set times") {
+		t.Errorf("logged warnings %q", warnings)
+	}
+	mu.Unlock()
+
+	failing := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.RemoteBinary(fakeRemoteBinary(t, `
+printf '\000\000\001scp: b.txt: No space left on device\n'
+cat >/dev/null
+exit 1
+`))
+	})
+	err := failing.Copy(ctx, strings.NewReader("hello"), "b.txt", "0644", 5)
+	var warning *scp.RemoteWarning
+	if !errors.As(err, &warning) || !strings.Contains(err.Error(), "No space left") {
+		t.Errorf("upload exiting with an error after a warning returned %v", err)
+	}
+}
+
+func TestRemoteStderr(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.RemoteBinary(fakeRemoteBinary(t, "echo 'scp: /srv/file: Permission denied' >&2\nexit 1\n"))
+	})
+	_, err := client.CopyFromRemoteWithOptions(ctx, io.Discard, "/srv/file", scp.DownloadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "scp: /srv/file: Permission denied") {
+		t.Errorf("download returned %v, want the standard error of the remote", err)
+	}
+
+	if _, err := client.RunOutput(ctx, "echo one >&2; echo two >&2; exit 3"); err == nil || !strings.HasSuffix(err.Error(), ": one; two") {
+		t.Errorf("runOutput returned %v, want the lines of the standard error", err)
+	}
+}
+
+func TestCancelBlockedUpload(t *testing.T) {
+	client := newTestClient(t, nil)
+	// Nothing is ever written to the pipe, so reading it blocks until the test ends.
+	r, w := io.Pipe()
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Copy(ctx, r, filepath.Join(t.TempDir(), "blocked"), "0644", 5)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("canceled upload returned %v, want context.Canceled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the upload did not return once canceled while reading its input")
+	}
+}
+
+func TestCloseDuringLazyConnect(t *testing.T) {
+	server := scptest.NewShellServer(t)
+	dialer := &recordingDialer{gate: make(chan struct{})}
+	client := server.Configurer().LazyConnect(true).Dialer(dialer).Create()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.CopyFile(context.Background(), strings.NewReader("x"), filepath.Join(t.TempDir(), "lazy"), "0644")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	client.Close()
+	close(dialer.gate)
+
+	if err := <-done; !errors.Is(err, scp.ErrNotConnected) {
+		t.Errorf("CopyFile of a client closed while connecting returned %v, want ErrNotConnected", err)
+	}
+	if _, err := dialer.conns[0].Write([]byte("x")); err == nil {
+		t.Error("the connection of a client closed while connecting was kept open")
+	}
+	if err := client.CopyFile(context.Background(), strings.NewReader("x"), "lazy", "0644"); !errors.Is(err, scp.ErrNotConnected) {
+		t.Errorf("CopyFile after Close returned %v, want ErrNotConnected", err)
+	}
+}
+
+func TestReconnectClosesThePreviousConnection(t *testing.T) {
+	dialer := &recordingDialer{}
+	client := scptest.NewShellServer(t).Configurer().Dialer(dialer).Create()
+	defer client.Close()
+	for i := 0; i < 2; i++ {
+		if err := client.Connect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dialer.conns[0].Write([]byte("x")); err == nil {
+		t.Error("connecting again left the previous connection open")
+	}
+	if _, err := client.Ping(context.Background()); err != nil {
+		t.Errorf("Ping over the new connection returned %v", err)
+	}
+}
+
+func TestRemoteWarningFollowedByAck(t *testing.T) {
+	// The warning and the acknowledgement after it arrive in a single read.
+	client := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.Timeout(5 * time.Second).RemoteBinary(fakeRemoteBinary(t, `
+printf '\000\001scp: b.txt: set times: Operation not permitted\n\000'
+cat >/dev/null
+`))
+	})
+	if err := client.Copy(context.Background(), strings.NewReader("hello"), "b.txt", "0644", 5); err != nil {
+		t.Errorf("upload acknowledged right after a warning failed: %v", err)
+	}
+}
+
+func TestRemotePathStartingWithDash(t *testing.T) {
+	server := scptest.NewShellServer(t)
+	client := server.Client(t)
+	ctx := context.Background()
+
+	if err := client.CopyFile(ctx, strings.NewReader("dash"), "-rf", "0644"); err != nil {
+		t.Fatalf("upload to -rf returned %v", err)
+	}
+	if err := client.Chmod(ctx, "-rf", 0600); err != nil {
+		t.Errorf("Chmod of -rf returned %v", err)
+	}
+	if err := client.MkdirAll(ctx, "-p", 0755); err != nil {
+		t.Errorf("MkdirAll of -p returned %v", err)
+	}
+	stat, err := os.Stat(filepath.Join(server.Home, "-rf"))
+	if err != nil || stat.Mode().Perm() != 0600 {
+		t.Fatalf("the uploaded file is %v, %v, want its mode changed", stat, err)
+	}
+	if stat, err := os.Stat(filepath.Join(server.Home, "-p")); err != nil || !stat.IsDir() {
+		t.Errorf("the directory -p is %v, %v", stat, err)
+	}
+	if err := client.RemoveAll(ctx, "-rf"); err != nil {
+		t.Errorf("RemoveAll of -rf returned %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(server.Home, "-rf")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("-rf was not removed: %v", err)
+	}
+}
+
+func TestCopyToRemoteResult(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	remote := filepath.Join(t.TempDir(), "file.txt")
+	result, err := client.CopyToRemoteResult(ctx, strings.NewReader("hello"), remote, "0640", 5, nil)
+	want := scp.UploadResult{RemotePath: remote, Filename: "file.txt", Permissions: "0640", Size: 5, BytesWritten: 5, Acked: true}
+	if err != nil || result == nil || *result != want {
+		t.Errorf("CopyToRemoteResult returned %+v, %v, want %+v", result, err, want)
+	}
+
+	// The remote receives the whole file but fails to write it.
+	rejecting := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.RemoteBinary(fakeRemoteBinary(t, `
+printf '\000\000\002scp: file.txt: No space left on device\n'
+cat >/dev/null
+exit 1
+`))
+	})
+	result, err = rejecting.CopyToRemoteResult(ctx, strings.NewReader("hello"), "file.txt", "0644", 5, nil)
+	if err == nil || result == nil || result.Acked || result.BytesWritten != 5 {
+		t.Errorf("rejected upload returned %+v, %v, want all bytes written but none acknowledged", result, err)
+	}
+}
+
+func TestCopyFromRemoteWriterAt(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	if err := os.WriteFile(first, []byte("hello "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "joined"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// The chunks of one file, downloaded at once.
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, chunk := range []struct {
+		path   string
+		offset int64
+	}{{first, 0}, {second, 6}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = client.CopyFromRemoteWriterAt(ctx, f, chunk.offset, chunk.path, nil)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(f.Name()); err != nil || string(got) != "hello world" {
+		t.Errorf("the chunks were joined to %q, %v", got, err)
+	}
+
+	if _, err := client.CopyFromRemoteWriterAt(ctx, f, -1, first, nil); err == nil {
+		t.Error("download at a negative offset succeeded")
+	}
+}
+
+func TestCopyFromRemoteWithOptions(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	remote := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(remote, []byte("hello world"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1700000000, 0)
+	if err := os.Chtimes(remote, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	var passed int64 = -1
+	progress := &recordingProgress{}
+	infos, err := client.CopyFromRemoteWithOptions(ctx, &buf, remote, scp.DownloadOptions{
+		PreserveTimes: true,
+		PassThru: func(r io.Reader, total int64) io.Reader {
+			passed = total
+			return r
+		},
+		Progress: progress,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hello world" {
+		t.Errorf("downloaded %q", buf.String())
+	}
+	if infos.Filename != "file.txt" || infos.Size != 11 || infos.Permissions != 0640 || infos.Mtime != mtime.Unix() {
+		t.Errorf("the download returned %+v", infos)
+	}
+	if passed != 11 {
+		t.Errorf("the PassThru was handed a total of %d", passed)
+	}
+	if progress.totalBytes != 11 || progress.files["file.txt"] != 11 || progress.bytes != 11 {
+		t.Errorf("the progress was %+v", progress)
+	}
+
+	// Without PreserveTimes the remote sends no times.
+	if infos, err := client.CopyFromRemoteWithOptions(ctx, io.Discard, remote, scp.DownloadOptions{}); err != nil || infos.Mtime != 0 {
+		t.Errorf("the download without times returned %+v, %v", infos, err)
+	}
+}
+
+// readSizes is a PassThru recording the size of the largest read asked of the reader.
+type readSizes struct {
+	mu      sync.Mutex
+	largest int
+}
+
+func (s *readSizes) passThru(r io.Reader, total int64) io.Reader {
+	return readFunc(func(p []byte) (int, error) {
+		s.mu.Lock()
+		s.largest = max(s.largest, len(p))
+		s.mu.Unlock()
+		return r.Read(p)
+	})
+}
+
+type readFunc func(p []byte) (int, error)
+
+func (f readFunc) Read(p []byte) (int, error) { return f(p) }
+
+func TestBufferSize(t *testing.T) {
+	ctx := context.Background()
+	contents := strings.Repeat("x", 100*1024)
+	remote := filepath.Join(t.TempDir(), "file")
+
+	for _, size := range []int{4096, 64 * 1024} {
+		client := newTestClient(t, func(c *scp.ClientConfigurer) { c.BufferSize(size) })
+		upload, download := &readSizes{}, &readSizes{}
+		if err := client.CopyPassThru(ctx, strings.NewReader(contents), remote, "0644", int64(len(contents)), upload.passThru); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.CopyFromRemotePassThru(ctx, io.Discard, remote, download.passThru); err != nil {
+			t.Fatal(err)
+		}
+		if upload.largest != size || download.largest != size {
+			t.Errorf("with a buffer of %d bytes the upload read up to %d and the download up to %d bytes at once", size, upload.largest, download.largest)
+		}
+	}
+}
+
+// silentListener accepts connections without ever answering the SSH handshake.
+func silentListener(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		l.Close()
+		<-done
+	})
+	go func() {
+		defer close(done)
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestConnectContext(t *testing.T) {
+	addr := silentListener(t)
+	configure := func() *scp.ClientConfigurer {
+		return scptest.NewServer(t).Configurer().Host(addr).Timeout(time.Minute)
+	}
+
+	client := configure().ConnectTimeout(100 * time.Millisecond).Create()
+	start := time.Now()
+	if err := client.Connect(); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("a hanging handshake returned %v after %s, want it to time out after ConnectTimeout", err, time.Since(start))
+	}
+
+	client = configure().Create()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if err := client.ConnectContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("a cancelled ConnectContext returned %v", err)
+	}
+
+	// Once connected, the context no longer matters.
+	client = scptest.NewServer(t).Configurer().Create()
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := client.ConnectContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	cancel()
+	upload(t, client)
+}
+
+// fixedDialer connects to addr whatever address it is asked for, like a tunnel would.
+type fixedDialer struct{ addr string }
+
+func (d fixedDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, network, d.addr)
+}
+
+func TestCustomTransports(t *testing.T) {
+	server := scptest.NewServer(t)
+
+	// Host only names the server, the dialer knows how to reach it.
+	client := server.Configurer().Host("server.invalid:22").Dialer(fixedDialer{server.Addr}).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("connecting with the dialer returned %v", err)
+	}
+	defer client.Close()
+	upload(t, client)
+
+	conn, err := net.Dial("tcp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = server.Configurer().Create()
+	if err := client.ConnectWithConn(conn); err != nil {
+		t.Fatalf("ConnectWithConn returned %v", err)
+	}
+	upload(t, client)
+	client.Close()
+	if _, err := conn.Write([]byte("x")); err == nil {
+		t.Error("the connection is still open after closing the client")
+	}
+}
+
+func TestRemoteBinaryMissing(t *testing.T) {
+	ctx := context.Background()
+	// Not named scp, which the server would run itself.
+	client := newTestClient(t, func(c *scp.ClientConfigurer) { c.RemoteBinary("/nonexistent/bin/scp2") })
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := client.CopyFile(ctx, strings.NewReader("x"), filepath.Join(dir, "upload"), "0644")
+	if !errors.Is(err, scp.ErrRemoteBinaryMissing) || !strings.Contains(err.Error(), "/nonexistent/bin/scp2") {
+		t.Errorf("upload with a missing remote scp returned %v, want ErrRemoteBinaryMissing naming it", err)
+	}
+	err = client.CopyFromRemotePassThru(ctx, io.Discard, filepath.Join(dir, "file"), nil)
+	if !errors.Is(err, scp.ErrRemoteBinaryMissing) {
+		t.Errorf("download with a missing remote scp returned %v, want ErrRemoteBinaryMissing", err)
+	}
+}
@@ -0,0 +1,77 @@
+package scp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSeekSize(t *testing.T) {
+	r := bytes.NewReader([]byte("0123456789"))
+	r.Read(make([]byte, 4))
+	size, ok := seekSize(r)
+	if !ok || size != 6 {
+		t.Errorf("seekSize of a partly read reader = %d, %v, want the 6 bytes left", size, ok)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "456789" {
+		t.Errorf("seekSize moved the reader to %q", rest)
+	}
+
+	if _, ok := seekSize(strings.NewReader("")); !ok {
+		t.Error("seekSize of an empty strings.Reader failed")
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+	if _, ok := seekSize(pr); ok {
+		t.Error("seekSize of a pipe succeeded")
+	}
+	if _, ok := seekSize(io.MultiReader(strings.NewReader("x"))); ok {
+		t.Error("seekSize of a reader that can not seek succeeded")
+	}
+}
+
+func TestCloseUnconnected(t *testing.T) {
+	var client Client
+	for i := 0; i < 2; i++ {
+		if err := client.Close(); err != nil {
+			t.Errorf("Close of an unconnected client returned %v", err)
+		}
+	}
+	client = NewClient("example.com:22", nil)
+	if err := client.Close(); err != nil {
+		t.Errorf("Close of a configured client returned %v", err)
+	}
+}
+
+func TestNotConnected(t *testing.T) {
+	ctx := context.Background()
+	var zero Client
+	if err := zero.CopyFile(ctx, strings.NewReader("x"), "/tmp/x", "0644"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("CopyFile of a zero client returned %v, want ErrNotConnected", err)
+	}
+
+	unconnected := NewClient("example.com:22", nil)
+	if err := unconnected.CopyFile(ctx, strings.NewReader("x"), "/tmp/x", "0644"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("CopyFile before Connect returned %v, want ErrNotConnected", err)
+	}
+	if _, err := unconnected.Ping(ctx); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Ping before Connect returned %v, want ErrNotConnected", err)
+	}
+}
+
+func TestStderrBuffer(t *testing.T) {
+	var stderr stderrBuffer
+	stderr.Write(bytes.Repeat([]byte("x"), maxStderr+10))
+	if output := stderr.String(); len(output) != maxStderr+len(" ...") {
+		t.Errorf("kept %d bytes of the standard error, want at most %d", len(output), maxStderr)
+	}
+}
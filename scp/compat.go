@@ -0,0 +1,188 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Compat a compatibility profile: the quirks of the scp of a remote, and of its SSH server, that
+// transfers work around.
+type Compat int
+
+const (
+	// CompatOpenSSH OpenSSH's scp, and every scp behaving like it.
+	CompatOpenSSH Compat = iota
+
+	// CompatDropbear the scp of Dropbear, common on routers and embedded devices. Dropbear at times
+	// closes the session of a command without its exit status, and its scp fails an upload with
+	// -p on filesystems that can not set times, such as the FAT of a USB stick, after writing the
+	// file. With this profile a transfer the remote acknowledged completely succeeds without an
+	// exit status, and an upload that only failed to set the times succeeds with a warning that
+	// they were lost.
+	CompatDropbear
+
+	// CompatBusyBox the scp of BusyBox, common in containers and appliances, which lacks -q and -p.
+	// The remote scp runs without them, so the times of files are not preserved, which transfers
+	// asked to preserve them log as a warning. Stat reads the times with `stat -c` instead.
+	// BusyBox usually runs behind Dropbear, whose quirks are worked around as well. SourceSession
+	// and SinkSession send and receive Time records as told, only their flags are adjusted.
+	CompatBusyBox
+
+	// CompatAuto detects the profile before the first transfer, see DetectCompat.
+	CompatAuto
+)
+
+func (c Compat) String() string {
+	switch c {
+	case CompatOpenSSH:
+		return "openssh"
+	case CompatDropbear:
+		return "dropbear"
+	case CompatBusyBox:
+		return "busybox"
+	case CompatAuto:
+		return "auto"
+	default:
+		return "Compat(" + strconv.Itoa(int(c)) + ")"
+	}
+}
+
+// compatDetection remembers the profile detected for CompatAuto,
+// it is shared by copies of a Client so the remote is only probed once.
+// Failing to probe is no outcome, the next transfer probes again.
+type compatDetection struct {
+	mu     sync.Mutex
+	done   bool
+	compat Compat
+}
+
+// DetectCompat chooses the compatibility profile of the remote from the version its SSH server
+// announced, such as "SSH-2.0-dropbear_2022.83" for CompatDropbear. Remotes not running OpenSSH
+// are asked where their scp command leads as well, which is BusyBox for CompatBusyBox. Every other
+// remote gets CompatOpenSSH.
+func (a *Client) DetectCompat(ctx context.Context) (Compat, error) {
+	client, err := a.connected(ctx)
+	if err != nil {
+		return CompatOpenSSH, err
+	}
+	compat := compatFromVersion(client.ServerVersion())
+	if _, _, openSSH := ServerOpenSSHVersion(client.ServerVersion()); openSSH {
+		return compat, nil
+	}
+
+	// BusyBox provides its commands as links to its own binary.
+	binary, _, _ := strings.Cut(a.remoteBinary(), " ")
+	out, err := a.runOutput(ctx, fmt.Sprintf(`readlink -f "$(command -v %s)"`, ShellQuote(binary)))
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) {
+		// Not knowing where scp leads is no reason to fail, the version tells enough.
+		a.logf(ctx, LogInfo, "failed to resolve the remote scp command: %v", err)
+		return compat, nil
+	}
+	if err != nil {
+		return compat, err
+	}
+	if path.Base(strings.TrimSpace(string(out))) == "busybox" {
+		return CompatBusyBox, nil
+	}
+	return compat, nil
+}
+
+// compatFromVersion the profile of a remote announcing the SSH version banner.
+func compatFromVersion(banner []byte) Compat {
+	if bytes.Contains(bytes.ToLower(banner), []byte("dropbear")) {
+		return CompatDropbear
+	}
+	return CompatOpenSSH
+}
+
+// resolveCompat detects the profile before the first transfer for CompatAuto, see compat.
+// The outcome of the first detection is reused afterwards, failures to probe are not.
+func (a *Client) resolveCompat(ctx context.Context) error {
+	if a.compatDetection == nil {
+		return nil
+	}
+	// Not being connected yet is no outcome of the detection to remember.
+	if _, err := a.connected(ctx); err != nil {
+		return err
+	}
+
+	a.compatDetection.mu.Lock()
+	defer a.compatDetection.mu.Unlock()
+	if a.compatDetection.done {
+		return nil
+	}
+	compat, err := a.DetectCompat(ctx)
+	if err != nil {
+		a.logf(ctx, LogError, "failed to detect the compatibility profile: %v", err)
+		return err
+	}
+	a.compatDetection.done, a.compatDetection.compat = true, compat
+	a.logf(ctx, LogInfo, "using the %s compatibility profile", compat)
+	return nil
+}
+
+// compat the profile transfers work around the quirks of: the one detected by resolveCompat or
+// Compat. Clients not built by a configurer take CompatAuto as CompatOpenSSH.
+func (a *Client) compat() Compat {
+	if a.compatDetection != nil {
+		return a.compatDetection.compat
+	}
+	if a.Compat == CompatAuto {
+		return CompatOpenSSH
+	}
+	return a.Compat
+}
+
+// dropbear tells whether the profile works around the quirks of Dropbear.
+func (c Compat) dropbear() bool {
+	return c == CompatDropbear || c == CompatBusyBox
+}
+
+// exitError returns the error of the remote scp exiting, err as returned by waiting for its session,
+// once the protocol completed. Dropbear closing the session without an exit status is no failure then.
+func (a *Client) exitError(err error) error {
+	var missing *ssh.ExitMissingError
+	if a.compat().dropbear() && errors.As(err, &missing) {
+		return nil
+	}
+	return err
+}
+
+// lostTimes tells whether the remote only failed to set the times of an upload it acknowledged,
+// telling so in the warning, which the profile does not fail the upload for.
+func (a *Client) lostTimes(warning *RemoteWarning) bool {
+	return a.compat().dropbear() && warning != nil && strings.Contains(warning.Message, "set times")
+}
+
+// scpFlags drops the flags the remote scp lacks from flags, such as "-qtp", see CompatBusyBox.
+func (a *Client) scpFlags(flags string) string {
+	if a.compat() != CompatBusyBox {
+		return flags
+	}
+	return strings.NewReplacer("q", "", "p", "").Replace(flags)
+}
+
+// preserveTimes tells whether the remote scp can preserve the times of the file at remotePath, and
+// warns that they are lost when it can not.
+func (a *Client) preserveTimes(ctx context.Context, remotePath string) bool {
+	if a.compat() != CompatBusyBox {
+		return true
+	}
+	a.logf(ctx, LogWarning, "%s: the times of %s are not preserved, the scp of BusyBox lacks -p", a.Host, remotePath)
+	return false
+}
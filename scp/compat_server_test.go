@@ -0,0 +1,98 @@
+package scp_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"main/scp"
+)
+
+// timedFile returns a local file holding contents, with a modification time uploads preserve.
+func timedFile(t *testing.T, contents string) *os.File {
+	name := filepath.Join(t.TempDir(), "timed.txt")
+	if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1700000000, 0)
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestDropbearLostTimes(t *testing.T) {
+	// The file is written, setting its times fails and scp exits with status 1.
+	script := `
+printf '\000\000\000\001scp: b.txt: set times: Operation not permitted\n\000'
+cat >/dev/null
+exit 1
+`
+	ctx := context.Background()
+
+	dropbear := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.Compat(scp.CompatDropbear).RemoteBinary(fakeRemoteBinary(t, script))
+	})
+	if err := dropbear.CopyFromFilePreserve(ctx, *timedFile(t, "hello"), "b.txt", "0644", nil); err != nil {
+		t.Errorf("the Dropbear profile failed an upload that only lost its times: %v", err)
+	}
+
+	openSSH := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.RemoteBinary(fakeRemoteBinary(t, script))
+	})
+	if err := openSSH.CopyFromFilePreserve(ctx, *timedFile(t, "hello"), "b.txt", "0644", nil); err == nil {
+		t.Error("an upload exiting with status 1 succeeded without a profile")
+	}
+}
+
+func TestBusyBox(t *testing.T) {
+	// BusyBox links its commands to its own binary, which records the flags it was run with here.
+	// The link is not named scp, which the test server would answer itself.
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$1\" > " + scp.ShellQuote(args) + "\nprintf '\\000\\000\\000\\000'\ncat >/dev/null\n"
+	if err := os.WriteFile(filepath.Join(dir, "busybox"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(dir, "busybox-scp")
+	if err := os.Symlink("busybox", binary); err != nil {
+		t.Skip(err)
+	}
+
+	var mu sync.Mutex
+	var warnings []string
+	client := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.Compat(scp.CompatAuto).RemoteBinary(binary).Logger(func(entry scp.LogEntry) {
+			if entry.Level == scp.LogWarning {
+				mu.Lock()
+				warnings = append(warnings, entry.Message)
+				mu.Unlock()
+			}
+		})
+	})
+	ctx := context.Background()
+	if compat, err := client.DetectCompat(ctx); err != nil || compat != scp.CompatBusyBox {
+		t.Fatalf("DetectCompat = %s, %v, want busybox", compat, err)
+	}
+
+	if err := client.CopyFromFilePreserve(ctx, *timedFile(t, "hello"), "b.txt", "0644", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(args); err != nil || strings.TrimSpace(string(got)) != "-t" {
+		t.Errorf("BusyBox's scp ran with %q, %v, want -t", got, err)
+	}
+	mu.Lock()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "not preserved") {
+		t.Errorf("logged warnings %q, want one about the lost times", warnings)
+	}
+	mu.Unlock()
+}
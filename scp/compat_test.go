@@ -0,0 +1,32 @@
+package scp
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCompatFromVersion(t *testing.T) {
+	tests := map[string]Compat{
+		"SSH-2.0-dropbear_2022.83":               CompatDropbear,
+		"SSH-2.0-dropbear":                       CompatDropbear,
+		"SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13": CompatOpenSSH,
+		"SSH-2.0-OpenSSH_for_Windows_8.1":        CompatOpenSSH,
+		"SSH-2.0-Go":                             CompatOpenSSH,
+	}
+	for banner, want := range tests {
+		if got := compatFromVersion([]byte(banner)); got != want {
+			t.Errorf("compatFromVersion(%q) = %s, want %s", banner, got, want)
+		}
+	}
+}
+
+func TestDropbearExitWithoutStatus(t *testing.T) {
+	missing := &ssh.ExitMissingError{}
+	if err := (&Client{Compat: CompatDropbear}).exitError(missing); err != nil {
+		t.Errorf("a missing exit status failed the Dropbear profile: %v", err)
+	}
+	if err := (&Client{}).exitError(missing); err == nil {
+		t.Error("a missing exit status was no failure without a profile")
+	}
+}
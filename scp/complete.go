@@ -0,0 +1,228 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pkg/sftp"
+)
+
+// completionListSize the amount of candidates listed below an input field.
+const completionListSize = 10
+
+// CompleteRemotePath returns the remote paths `partial` can be completed to, like the tab-completion
+// of a shell: the entries of its directory whose name starts with its last component.
+// Directories end with a slash, hidden entries are only returned when the last component starts with a dot.
+func (a *Client) CompleteRemotePath(ctx context.Context, partial string) ([]string, error) {
+	dir, prefix := path.Split(partial)
+	listDir := dir
+	if listDir == "" {
+		listDir = "."
+	}
+
+	names, err := a.listRemoteDir(ctx, listDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		candidates = append(candidates, dir+name)
+	}
+	sort.Strings(candidates)
+	return candidates, nil
+}
+
+// listRemoteDir returns the names of the entries of the remote directory, directories end with a slash.
+func (a *Client) listRemoteDir(ctx context.Context, dir string) ([]string, error) {
+	backend, err := a.resolveBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if backend == BackendSFTP {
+		err := a.withSFTP(ctx, newWatchdog(), func(client *sftp.Client) error {
+			entries, err := client.ReadDir(dir)
+			for _, entry := range entries {
+				name := entry.Name()
+				if entry.IsDir() {
+					name += "/"
+				}
+				names = append(names, name)
+			}
+			return err
+		})
+		return names, err
+	}
+
+	out, err := a.runOutput(ctx, fmt.Sprintf("find %s -mindepth 1 -maxdepth 1 -printf '%%y %%f\\n'", a.shellPath(dir)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		// <type> <name>
+		kind, name, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if kind == "d" {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// PromptRemotePath asks for a remote path in the terminal, completing it with tab like a shell does.
+// It returns context.Canceled when the prompt is dismissed.
+func (a *Client) PromptRemotePath(ctx context.Context, prompt string, initial string) (string, error) {
+	theme := a.Theme.withDefaults()
+	keys := newKeyMap()
+	keys.Quit.SetKeys("esc", "ctrl+c")
+	keys.Quit.SetHelp("esc", "cancel")
+	keys.ToggleLog.SetEnabled(false)
+	keys.ScrollUp.SetEnabled(false)
+	keys.ScrollDown.SetEnabled(false)
+	keys.Help.SetEnabled(false)
+	keys.Complete.SetEnabled(true)
+	keys.Accept.SetEnabled(true)
+
+	input := textinput.New()
+	input.Prompt = prompt + " "
+	input.SetValue(initial)
+	input.Focus()
+
+	result, err := tea.NewProgram(promptModel{
+		input: input,
+		complete: func(partial string) ([]string, error) {
+			return a.CompleteRemotePath(ctx, partial)
+		},
+		keys:  keys,
+		help:  newHelp(theme),
+		theme: theme,
+	}).Run()
+	if err != nil {
+		return "", err
+	}
+
+	m := result.(promptModel)
+	if !m.accepted {
+		return "", context.Canceled
+	}
+	return m.input.Value(), nil
+}
+
+type completionMsg struct {
+	partial    string
+	candidates []string
+	err        error
+}
+
+// promptModel an input field completing remote paths.
+type promptModel struct {
+	input      textinput.Model
+	complete   func(partial string) ([]string, error)
+	candidates []string
+	err        error
+	accepted   bool
+
+	keys  keyMap
+	help  help.Model
+	theme Theme
+}
+
+func (m promptModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m promptModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Accept):
+			m.accepted = true
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Complete):
+			partial := m.input.Value()
+			// Listing the remote takes a round trip, keep the input responsive meanwhile.
+			return m, func() tea.Msg {
+				candidates, err := m.complete(partial)
+				return completionMsg{partial: partial, candidates: candidates, err: err}
+			}
+		}
+
+	case completionMsg:
+		if msg.partial != m.input.Value() {
+			// The input changed while listing.
+			return m, nil
+		}
+		m.err = msg.err
+		m.candidates = msg.candidates
+		if completed := commonPrefix(msg.candidates); len(completed) > len(msg.partial) {
+			m.input.SetValue(completed)
+			m.input.CursorEnd()
+		}
+		if len(msg.candidates) == 1 {
+			m.candidates = nil
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+func (m promptModel) View() string {
+	pad := strings.Repeat(" ", padding)
+	view := "\n" + pad + m.input.View() + "\n"
+
+	if m.err != nil {
+		view += pad + style(m.theme.Failure)(m.err.Error()) + "\n"
+	}
+	for i, candidate := range m.candidates {
+		if i == completionListSize {
+			view += pad + style(m.theme.Muted)(fmt.Sprintf("... %d more", len(m.candidates)-i)) + "\n"
+			break
+		}
+		view += pad + style(m.theme.Muted)(candidate) + "\n"
+	}
+	return view + "\n" + indent(m.help.View(m.keys), pad) + "\n"
+}
+
+// commonPrefix returns the longest prefix shared by all values.
+func commonPrefix(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	// Shortening may have cut a multi-byte character in half.
+	return strings.ToValidUTF8(prefix, "")
+}
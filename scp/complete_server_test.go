@@ -0,0 +1,35 @@
+package scp_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompleteRemotePath(t *testing.T) {
+	client := newTestClient(t, nil)
+	dir := t.TempDir()
+	for _, name := range []string{"alps", ".hidden", "beta"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "alpha"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		dir + "/al":      {dir + "/alpha/", dir + "/alps"},
+		dir + "/":        {dir + "/alpha/", dir + "/alps", dir + "/beta"},
+		dir + "/.":       {dir + "/.hidden"},
+		dir + "/missing": nil,
+	}
+	for partial, want := range tests {
+		got, err := client.CompleteRemotePath(context.Background(), partial)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("CompleteRemotePath(%q) = %q, %v, want %q", partial, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package scp
+
+import (
+	"testing"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestPromptCompletes(t *testing.T) {
+	keys := newKeyMap()
+	keys.Complete.SetEnabled(true)
+	input := textinput.New()
+	input.SetValue("/srv/al")
+	var m tea.Model = promptModel{input: input, keys: keys, help: newHelp(DefaultTheme()), theme: DefaultTheme()}
+
+	// The candidates share more than was typed.
+	m = update(m, completionMsg{partial: "/srv/al", candidates: []string{"/srv/alpha/", "/srv/alps"}})
+	if got := m.(promptModel); got.input.Value() != "/srv/alp" || len(got.candidates) != 2 {
+		t.Errorf("completed to %q with candidates %q", got.input.Value(), got.candidates)
+	}
+	// A single candidate is taken as it is.
+	m = update(m, completionMsg{partial: "/srv/alp", candidates: []string{"/srv/alpha/"}})
+	if got := m.(promptModel); got.input.Value() != "/srv/alpha/" || got.candidates != nil {
+		t.Errorf("completed to %q with candidates %q", got.input.Value(), got.candidates)
+	}
+	// Listings of what is no longer typed are dropped.
+	m = update(m, completionMsg{partial: "/srv/al", candidates: []string{"/srv/alps"}})
+	if got := m.(promptModel); got.input.Value() != "/srv/alpha/" {
+		t.Errorf("a stale listing completed to %q", got.input.Value())
+	}
+}
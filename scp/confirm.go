@@ -0,0 +1,75 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// PromptConfirm returns a function asking a yes or no question in the terminal, such as whether to
+// resume the unfinished transfers of the queue. It answers no unless the question is accepted.
+func PromptConfirm(theme Theme) func(question string) bool {
+	theme = theme.withDefaults()
+	return func(question string) bool {
+		keys := newKeyMap()
+		keys.Accept.SetKeys("y")
+		keys.Accept.SetHelp("y", "yes")
+		keys.Accept.SetEnabled(true)
+		keys.Quit.SetKeys("n", "enter", "esc", "ctrl+c")
+		keys.Quit.SetHelp("n", "no")
+		keys.ToggleLog.SetEnabled(false)
+		keys.ScrollUp.SetEnabled(false)
+		keys.ScrollDown.SetEnabled(false)
+		keys.Help.SetEnabled(false)
+
+		result, err := tea.NewProgram(confirmModel{
+			question: question,
+			keys:     keys,
+			help:     newHelp(theme),
+			theme:    theme,
+		}).Run()
+		return err == nil && result.(confirmModel).accepted
+	}
+}
+
+// confirmModel asks a yes or no question.
+type confirmModel struct {
+	question string
+	accepted bool
+
+	keys  keyMap
+	help  help.Model
+	theme Theme
+}
+
+func (m confirmModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m confirmModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Accept):
+			m.accepted = true
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+func (m confirmModel) View() string {
+	pad := strings.Repeat(" ", padding)
+	return "\n" +
+		pad + style(m.theme.Active)(m.question) + "\n\n" +
+		indent(m.help.View(m.keys), pad) + "\n"
+}
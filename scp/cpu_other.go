@@ -0,0 +1,16 @@
+//go:build !unix
+
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import "time"
+
+// cpuTime the CPU time of the process is not portably available outside of Unix, -1 tells it is unknown.
+func cpuTime() time.Duration {
+	return -1
+}
@@ -0,0 +1,510 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultDeltaBlockSize the block size used by delta transfers when none is given.
+// Smaller blocks find more matches but make the remote spawn more processes.
+const DefaultDeltaBlockSize = 256 * 1024
+
+// The delta transfers are a variant of the rsync algorithm that only needs POSIX tools on the
+// remote. The remote splits its copy of the file into blocks and describes every block with
+// the CRC-32 printed by `cksum` and the MD5 printed by `md5sum`. The CRC-32 of `cksum` can be
+// rolled, so the local side slides a window over its copy byte by byte to find the remote
+// blocks at any offset, not only at block boundaries. Only the parts that can not be found
+// travel over the wire.
+
+// blockSignature describes a block of the remote file.
+type blockSignature struct {
+	index int
+	size  int64
+	crc   uint32
+	md5   string
+}
+
+// remoteSignature describes the remote file, split in blocks of blockSize.
+type remoteSignature struct {
+	size      int64
+	blockSize int64
+	blocks    []blockSignature
+}
+
+// full returns the blocks of exactly blockSize bytes indexed by their checksum,
+// a trailing partial block can not be found by a window of blockSize.
+func (s *remoteSignature) full() map[uint32][]blockSignature {
+	index := map[uint32][]blockSignature{}
+	for _, block := range s.blocks {
+		if block.size == s.blockSize {
+			index[block.crc] = append(index[block.crc], block)
+		}
+	}
+	return index
+}
+
+// signatureBatch how many blocks the remote splits off and checksums at once, so the processes
+// it spawns come down to a few per batch rather than a few per block.
+const signatureBatch = 64
+
+// signature asks the remote to describe remotePath in blocks of blockSize.
+func (a *Client) signature(ctx context.Context, remotePath string, blockSize int64) (*remoteSignature, error) {
+	// split names the blocks xaa, xab and so on, which the shell lists in order.
+	script := fmt.Sprintf(`f=%s; b=%d; n=%d
+s=$(wc -c < "$f") || exit 1
+echo $s
+d=$(mktemp -d) || exit 1
+trap 'rm -rf "$d"' EXIT
+mkdir "$d/b" || exit 1
+i=0
+while [ $((i * b)) -lt $s ]; do
+	dd if="$f" bs=$b skip=$i count=$n 2>/dev/null | (cd "$d/b" && split -b $b - x) || exit 1
+	(cd "$d/b" && cksum x* > ../c && md5sum x* > ../m && paste -d ' ' ../c ../m && rm -f x*) || exit 1
+	i=$((i + n))
+done`, a.shellPath(remotePath), blockSize, signatureBatch)
+
+	out, err := a.runOutput(ctx, script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute the block signature of %s: %w", remotePath, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	size, err := strconv.ParseInt(strings.TrimSpace(lines[0]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected size in block signature: %q", lines[0])
+	}
+
+	sig := &remoteSignature{size: size, blockSize: blockSize}
+	for i, line := range lines[1:] {
+		// <crc> <length> <name> <md5> <name>
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("unexpected line in block signature: %q", line)
+		}
+		crc, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		sig.blocks = append(sig.blocks, blockSignature{index: i, size: length, crc: uint32(crc), md5: fields[3]})
+	}
+	return sig, nil
+}
+
+// runOutput runs the shell script on the remote and returns its standard output.
+func (a *Client) runOutput(ctx context.Context, script string) ([]byte, error) {
+	session, release, err := a.newSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var out bytes.Buffer
+	session.Stdout = &out
+	if err := session.Start(script); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		terminate(session)
+		<-done
+		return nil, context.Cause(ctx)
+	}
+	return out.Bytes(), remoteError(session, err)
+}
+
+// blockMatch a window of the local file matching a block of the remote file.
+type blockMatch struct {
+	offset int64
+	block  blockSignature
+}
+
+// scanBlocks slides a window of blockSize over r and calls found for every window matching one
+// of the given blocks. When found returns true the window jumps past the match, otherwise it
+// slides on by a single byte. Bytes not covered by a match are reported to literal.
+func scanBlocks(
+	r io.Reader,
+	blockSize int64,
+	blocks map[uint32][]blockSignature,
+	found func(match blockMatch) bool,
+	literal func(b byte) error,
+) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	window := make([]byte, blockSize)
+	roll := newRollingCksum(int(blockSize))
+
+	var offset int64
+	fill := func() (bool, error) {
+		n, err := io.ReadFull(br, window)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			for _, b := range window[:n] {
+				if err := literal(b); err != nil {
+					return false, err
+				}
+			}
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		roll.reset(window)
+		return true, nil
+	}
+
+	full, err := fill()
+	if err != nil || !full {
+		return err
+	}
+
+	// head is the position of the oldest byte of the window in the ring.
+	head := 0
+	for {
+		matched := false
+		if candidates, ok := blocks[roll.sum()]; ok {
+			sum := md5.New()
+			sum.Write(window[head:])
+			sum.Write(window[:head])
+			digest := hex.EncodeToString(sum.Sum(nil))
+
+			for _, block := range candidates {
+				if block.md5 == digest && found(blockMatch{offset: offset, block: block}) {
+					matched = true
+					break
+				}
+			}
+		}
+
+		if matched {
+			offset += blockSize
+			head = 0
+			if full, err = fill(); err != nil || !full {
+				return err
+			}
+			continue
+		}
+
+		in, err := br.ReadByte()
+		if err == io.EOF {
+			for _, b := range append(window[head:], window[:head]...) {
+				if err := literal(b); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		out := window[head]
+		if err := literal(out); err != nil {
+			return err
+		}
+		roll.roll(out, in)
+		window[head] = in
+		head = (head + 1) % int(blockSize)
+		offset++
+	}
+}
+
+// deltaOp one step rebuilding a file: either a run of blocks copied from the remote
+// file, or a run of literal bytes.
+type deltaOp struct {
+	block  int
+	blocks int
+	// literal the length of literal data, 0 for block copies
+	literal int64
+}
+
+// deltaOps returns the steps rebuilding the contents of r from the blocks of the remote file described
+// by sig, and writes the literal bytes they need to literals. Adjacent blocks and literals are
+// coalesced into a single step.
+func deltaOps(r io.Reader, sig *remoteSignature, literals io.ByteWriter) ([]deltaOp, error) {
+	var ops []deltaOp
+	err := scanBlocks(r, sig.blockSize, sig.full(),
+		func(match blockMatch) bool {
+			if n := len(ops); n > 0 && ops[n-1].literal == 0 && ops[n-1].block+ops[n-1].blocks == match.block.index {
+				ops[n-1].blocks++
+			} else {
+				ops = append(ops, deltaOp{block: match.block.index, blocks: 1})
+			}
+			return true
+		},
+		func(b byte) error {
+			if n := len(ops); n > 0 && ops[n-1].literal > 0 {
+				ops[n-1].literal++
+			} else {
+				ops = append(ops, deltaOp{literal: 1})
+			}
+			return literals.WriteByte(b)
+		},
+	)
+	return ops, err
+}
+
+// CopyToRemoteDelta uploads `file` to `remotePath` transferring only the parts that are not present in
+// the existing remote file yet. The remote needs a POSIX shell with dd, split, cksum, md5sum, paste,
+// mktemp and head. When the remote file does not exist the file is uploaded in full. `blockSize` of zero
+// selects DefaultDeltaBlockSize.
+func (a *Client) CopyToRemoteDelta(
+	ctx context.Context,
+	file *os.File,
+	remotePath string,
+	permissions string,
+	blockSize int64,
+) error {
+	if blockSize <= 0 {
+		blockSize = DefaultDeltaBlockSize
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	// Also keeps the permissions safe to put into the script rebuilding the file.
+	permissions, err = uploadPermissions(permissions, stat.Mode())
+	if err != nil {
+		return err
+	}
+
+	sig, err := a.signature(ctx, remotePath, blockSize)
+	if err != nil {
+		// Nothing to compare against, send everything.
+		return a.CopyFromFile(ctx, *file, remotePath, permissions)
+	}
+
+	literals, err := os.CreateTemp("", "scp-delta-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(literals.Name())
+	defer literals.Close()
+
+	literalWriter := bufio.NewWriter(literals)
+	ops, err := deltaOps(file, sig, literalWriter)
+	if err != nil {
+		return err
+	}
+	if err := literalWriter.Flush(); err != nil {
+		return err
+	}
+	if _, err := literals.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	literalPath := remotePath + ".scp-delta"
+	if err := a.CopyFromFile(ctx, *literals, literalPath, "0600"); err != nil {
+		return err
+	}
+
+	// Rebuild the file next to the original from the old blocks and the uploaded literals.
+	// Literals are read in order from a single stream, which head consumes exactly.
+	script := fmt.Sprintf("f=%s; l=%s; t=%s\n{\n", a.shellPath(remotePath), a.shellPath(literalPath), a.shellPath(remotePath+".scp-delta-new"))
+	for _, op := range ops {
+		if op.literal > 0 {
+			script += fmt.Sprintf("head -c %d <&3\n", op.literal)
+		} else {
+			script += fmt.Sprintf("dd if=\"$f\" bs=%d skip=%d count=%d 2>/dev/null\n", blockSize, op.block, op.blocks)
+		}
+	}
+	script += fmt.Sprintf("} 3<\"$l\" > \"$t\" && chmod %s \"$t\" && mv \"$t\" \"$f\"; r=$?; rm -f \"$l\" \"$t\"; exit $r", permissions)
+
+	_, err = a.runOutput(ctx, script)
+	return err
+}
+
+// CopyFromRemoteDelta downloads `remotePath` into `localPath`, reusing the parts of the existing local
+// file that are still present in the remote file and only fetching the rest. The remote needs a
+// POSIX shell with dd, split, cksum, md5sum, paste and mktemp. When the local file does not exist
+// the file is downloaded in full. `blockSize` of zero selects DefaultDeltaBlockSize.
+func (a *Client) CopyFromRemoteDelta(ctx context.Context, remotePath string, localPath string, blockSize int64) error {
+	if blockSize <= 0 {
+		blockSize = DefaultDeltaBlockSize
+	}
+
+	old, err := os.Open(localPath)
+	if errors.Is(err, os.ErrNotExist) {
+		f, err := os.Create(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return a.CopyFromRemote(ctx, f, remotePath)
+	}
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	sig, err := a.signature(ctx, remotePath, blockSize)
+	if err != nil {
+		return err
+	}
+
+	// Find the remote blocks anywhere in the local file.
+	local := map[int]int64{}
+	err = scanBlocks(old, blockSize, sig.full(),
+		func(match blockMatch) bool {
+			if _, ok := local[match.block.index]; !ok {
+				local[match.block.index] = match.offset
+			}
+			return true
+		},
+		func(byte) error { return nil },
+	)
+	if err != nil {
+		return err
+	}
+
+	// Fetch the missing blocks in one stream, in order.
+	script := fmt.Sprintf("f=%s\n", a.shellPath(remotePath))
+	for i := 0; i < len(sig.blocks); {
+		if _, ok := local[i]; ok {
+			i++
+			continue
+		}
+		start := i
+		for i < len(sig.blocks) {
+			if _, ok := local[i]; ok {
+				break
+			}
+			i++
+		}
+		script += fmt.Sprintf("dd if=\"$f\" bs=%d skip=%d count=%d 2>/dev/null\n", blockSize, start, i-start)
+	}
+
+	stat, err := old.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".scp-delta-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err = a.runStream(ctx, script, func(stdin io.WriteCloser, fetched io.Reader) error {
+		stdin.Close()
+
+		w := bufio.NewWriter(tmp)
+		for _, block := range sig.blocks {
+			var src io.Reader
+			if offset, ok := local[block.index]; ok {
+				src = io.NewSectionReader(old, offset, block.size)
+			} else {
+				src = fetched
+			}
+			if _, err := copyN(ctx, w, src, block.size, a.BufferSize); err != nil {
+				return fmt.Errorf("failed to rebuild block %d: %w", block.index, err)
+			}
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tmp.Chmod(stat.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), localPath)
+}
+
+// cksumPoly the CRC-32 polynomial used by POSIX cksum.
+const cksumPoly = 0x04C11DB7
+
+var cksumTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ cksumPoly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func cksumUpdate(crc uint32, b byte) uint32 {
+	return crc<<8 ^ cksumTable[byte(crc>>24)^b]
+}
+
+// rollingCksum computes the checksum printed by POSIX cksum over a sliding window.
+// The CRC without its length suffix is linear, removing the byte leaving the window
+// therefore comes down to xor-ing the CRC of that byte followed by the window's zeros.
+type rollingCksum struct {
+	size int
+	crc  uint32
+	out  [256]uint32
+}
+
+func newRollingCksum(size int) *rollingCksum {
+	r := &rollingCksum{size: size}
+
+	// Appending zeros is linear as well, so it is enough to do it for every single bit.
+	var bits [8]uint32
+	for i := range bits {
+		crc := cksumUpdate(0, byte(1)<<i)
+		for j := 0; j < size; j++ {
+			crc = cksumUpdate(crc, 0)
+		}
+		bits[i] = crc
+	}
+	for b := range r.out {
+		for i := range bits {
+			if b&(1<<i) != 0 {
+				r.out[b] ^= bits[i]
+			}
+		}
+	}
+	return r
+}
+
+func (r *rollingCksum) reset(window []byte) {
+	r.crc = 0
+	for _, b := range window {
+		r.crc = cksumUpdate(r.crc, b)
+	}
+}
+
+func (r *rollingCksum) roll(out byte, in byte) {
+	r.crc = cksumUpdate(r.crc, in) ^ r.out[out]
+}
+
+// sum finishes the CRC the way cksum does: the length is appended and the result inverted.
+func (r *rollingCksum) sum() uint32 {
+	crc := r.crc
+	for n := r.size; n > 0; n >>= 8 {
+		crc = cksumUpdate(crc, byte(n))
+	}
+	return ^crc
+}
@@ -0,0 +1,72 @@
+package scp_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"main/scp/scptest"
+)
+
+func TestCopyToRemoteDeltaTilde(t *testing.T) {
+	server := scptest.NewShellServer(t)
+	client := server.Configurer().ExpandTilde(true).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	old := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	remote := filepath.Join(server.Home, "delta file.bin")
+	if err := os.WriteFile(remote, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed := append([]byte("prefix"), old...)
+	local := filepath.Join(t.TempDir(), "local.bin")
+	if err := os.WriteFile(local, changed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := client.CopyToRemoteDelta(context.Background(), f, "~/delta file.bin", "0644", 1024); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(remote); err != nil || !bytes.Equal(got, changed) {
+		t.Errorf("the remote file holds %d bytes, %v, want the %d of the local one", len(got), err, len(changed))
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(server.Home, "*.scp-delta*")); len(leftovers) > 0 {
+		t.Errorf("left %v behind", leftovers)
+	}
+}
+
+func TestCopyFromRemoteDelta(t *testing.T) {
+	client := newTestClient(t, nil)
+	dir := t.TempDir()
+
+	// More blocks than the remote checksums at once, and a partial one at the end.
+	remote := make([]byte, 200*64+10)
+	for i := range remote {
+		remote[i] = byte(i * 7 / 64)
+	}
+	local := append(append([]byte("changed"), remote[:5000]...), remote[6000:]...)
+	remotePath, localPath := filepath.Join(dir, "remote.bin"), filepath.Join(dir, "local.bin")
+	if err := os.WriteFile(remotePath, remote, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(localPath, local, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.CopyFromRemoteDelta(context.Background(), remotePath, localPath, 64); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(localPath); err != nil || !bytes.Equal(got, remote) {
+		t.Errorf("the local file holds %d bytes, %v, want the %d of the remote one", len(got), err, len(remote))
+	}
+}
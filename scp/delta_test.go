@@ -0,0 +1,131 @@
+package scp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// testSignature describes data the way the remote does, see signature.
+func testSignature(data []byte, blockSize int64) *remoteSignature {
+	sig := &remoteSignature{size: int64(len(data)), blockSize: blockSize}
+	for i := 0; int64(i)*blockSize < int64(len(data)); i++ {
+		block := data[int64(i)*blockSize : min(int64(i+1)*blockSize, int64(len(data)))]
+		roll := newRollingCksum(len(block))
+		roll.reset(block)
+		sum := md5.Sum(block)
+		sig.blocks = append(sig.blocks, blockSignature{index: i, size: int64(len(block)), crc: roll.sum(), md5: hex.EncodeToString(sum[:])})
+	}
+	return sig
+}
+
+func TestRollingCksum(t *testing.T) {
+	// The sums printed by `cksum` for the input.
+	sums := map[string]uint32{
+		"":                        4294967295,
+		"a":                       1220704766,
+		"123456789":               930766865,
+		"The quick brown fox jum": 3218537287,
+	}
+	for input, want := range sums {
+		roll := newRollingCksum(len(input))
+		roll.reset([]byte(input))
+		if got := roll.sum(); got != want {
+			t.Errorf("cksum of %q = %d, want %d", input, got, want)
+		}
+	}
+
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(data)
+	for _, size := range []int{1, 3, 64, 1000} {
+		roll := newRollingCksum(size)
+		roll.reset(data[:size])
+		fresh := newRollingCksum(size)
+		for i := size; i < len(data); i++ {
+			roll.roll(data[i-size], data[i])
+			fresh.reset(data[i-size+1 : i+1])
+			if roll.sum() != fresh.sum() {
+				t.Fatalf("the window of %d bytes rolled to %d has sum %d, want %d", size, i, roll.sum(), fresh.sum())
+			}
+		}
+	}
+}
+
+func TestDeltaOps(t *testing.T) {
+	const blockSize = 16
+	old := make([]byte, 4*blockSize)
+	rand.New(rand.NewSource(2)).Read(old)
+
+	tests := []struct {
+		name     string
+		old      []byte
+		new      []byte
+		ops      []deltaOp
+		literals int
+	}{
+		{"identical", old, old, []deltaOp{{block: 0, blocks: 4}}, 0},
+		{"appended", old, append(old[:len(old):len(old)], "tail"...), []deltaOp{{block: 0, blocks: 4}, {literal: 4}}, 4},
+		{"shifted", old, append([]byte("ab"), old...), []deltaOp{{literal: 2}, {block: 0, blocks: 4}}, 2},
+		{"truncated", old, old[:3*blockSize+5], []deltaOp{{block: 0, blocks: 3}, {literal: 5}}, 5},
+		{"reordered", old, append(append([]byte{}, old[2*blockSize:]...), old[:2*blockSize]...), []deltaOp{{block: 2, blocks: 2}, {block: 0, blocks: 2}}, 0},
+		{"empty", old, nil, nil, 0},
+		{"from nothing", nil, old[:20], []deltaOp{{literal: 20}}, 20},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var literals bytes.Buffer
+			ops, err := deltaOps(bytes.NewReader(test.new), testSignature(test.old, blockSize), &literals)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ops, test.ops) {
+				t.Errorf("ops = %+v, want %+v", ops, test.ops)
+			}
+			if literals.Len() != test.literals {
+				t.Errorf("sent %d literal bytes, want %d", literals.Len(), test.literals)
+			}
+
+			// Rebuilding from the old file and the literals gives the new one.
+			var rebuilt []byte
+			for _, op := range ops {
+				if op.literal > 0 {
+					rebuilt = append(rebuilt, literals.Next(int(op.literal))...)
+				} else {
+					rebuilt = append(rebuilt, test.old[op.block*blockSize:(op.block+op.blocks)*blockSize]...)
+				}
+			}
+			if !bytes.Equal(rebuilt, test.new) {
+				t.Errorf("rebuilt %d bytes which differ from the %d of the new file", len(rebuilt), len(test.new))
+			}
+		})
+	}
+}
+
+func TestScanBlocksSkipsDeclinedMatches(t *testing.T) {
+	data := make([]byte, 3*16)
+	rand.New(rand.NewSource(3)).Read(data)
+	sig := testSignature(data, 16)
+
+	// Declining a match slides the window on by a byte instead of past the match.
+	var offsets []int64
+	literals := 0
+	err := scanBlocks(bytes.NewReader(data), 16, sig.full(),
+		func(match blockMatch) bool {
+			offsets = append(offsets, match.offset)
+			return match.offset != 0
+		},
+		func(byte) error { literals++; return nil },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{0, 16, 32}; !reflect.DeepEqual(offsets, want) {
+		t.Errorf("matched at %v, want %v", offsets, want)
+	}
+	if literals != 16 {
+		t.Errorf("reported %d literal bytes, want the 16 of the declined match", literals)
+	}
+}
@@ -0,0 +1,177 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// WritableFS a file system downloads are written to in place of the local disk, such as memory or
+// object storage. Names are slash-separated and valid as told by fs.ValidPath, like those of an fs.FS.
+// LocalFS is the implementation writing to a local directory.
+type WritableFS interface {
+	// Create creates or truncates the file name for writing, along with its missing parent
+	// directories. The download only succeeds when Close does.
+	Create(name string, perm fs.FileMode) (io.WriteCloser, error)
+
+	// MkdirAll creates the directory name along with its missing parents.
+	MkdirAll(name string, perm fs.FileMode) error
+}
+
+// LocalFS returns a WritableFS writing below the local directory dir.
+func LocalFS(dir string) WritableFS {
+	return localFS(dir)
+}
+
+type localFS string
+
+func (dir localFS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	target := filepath.Join(string(dir), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+}
+
+func (dir localFS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.MkdirAll(filepath.Join(string(dir), filepath.FromSlash(name)), perm)
+}
+
+// CopyFromRemoteToFS downloads a remote file to the file `name` of fsys. It is created with
+// DefaultFileMode, like CopyFromRemoteToPath creates local files, and left as far as it got when
+// the download fails. The returned FileInfos describe the remote file.
+func (a *Client) CopyFromRemoteToFS(ctx context.Context, remotePath string, fsys WritableFS, name string, opts DownloadOptions) (*FileInfos, error) {
+	w, err := fsys.Create(name, DefaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+	fileInfos, err := a.CopyFromRemoteWithOptions(ctx, w, remotePath, opts)
+	if closeErr := w.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write %s: %w", name, closeErr)
+	}
+	return fileInfos, err
+}
+
+// CopyDirFromRemoteTarToFS copies the remote directory `remoteDir` into fsys, like CopyDirFromRemoteTar
+// does into a local directory. Only regular files and directories are written, with their permissions,
+// other entries of the archive are returned as skipped. Ownership, extended attributes and
+// modification times are not applied, of the TarOptions only SkipEmptyDirs and Progress apply.
+func (a *Client) CopyDirFromRemoteTarToFS(ctx context.Context, remoteDir string, fsys WritableFS, opts TarOptions) ([]TransferEntry, error) {
+	cmd := fmt.Sprintf("tar -c -f - -C %s .", a.shellPath(remoteDir))
+	progress := progressOrNop(opts.Progress)
+
+	if opts.Progress != nil {
+		totalBytes, totalFiles, err := a.scanRemoteTree(ctx, remoteDir)
+		if err != nil {
+			// The scan is only used for reporting, carry on without totals.
+			totalBytes, totalFiles = -1, -1
+		}
+		progress.Start(totalBytes, totalFiles)
+	}
+
+	var entries []TransferEntry
+	err := a.runStream(ctx, cmd, func(stdin io.WriteCloser, stdout io.Reader) error {
+		stdin.Close()
+		var err error
+		entries, err = extractTarFS(stdout, fsys, opts, progress, a.BufferSize)
+		return err
+	})
+	return tagEntries(ctx, entries), err
+}
+
+// CopyDirFromRemoteTarToFSProgress is the same as CopyDirFromRemoteTarToFS but renders an overall
+// progress bar and a progress bar for the file currently in flight in the terminal.
+func (a *Client) CopyDirFromRemoteTarToFSProgress(ctx context.Context, remoteDir string, fsys WritableFS, opts TarOptions) ([]TransferEntry, error) {
+	var entries []TransferEntry
+	err := a.runProgress(ctx, a.label(Download, path.Base(remoteDir)+"/"), func(ctx context.Context, progress Progress) error {
+		opts.Progress = progress
+		var err error
+		entries, err = a.CopyDirFromRemoteTarToFS(ctx, remoteDir, fsys, opts)
+		return err
+	})
+	return entries, err
+}
+
+// extractTarFS extracts the regular files and directories of the tar archive read from r into fsys,
+// returning an entry for every entry read.
+func extractTarFS(r io.Reader, fsys WritableFS, opts TarOptions, progress Progress, bufferSize int) ([]TransferEntry, error) {
+	held := emptyDirs{skip: opts.SkipEmptyDirs}
+	var entries []TransferEntry
+	extract := func(tr *tar.Reader, hdr *tar.Header) error {
+		name := path.Clean(hdr.Name)
+		if name == "." {
+			// The root of the archive is not an entry of the transfer.
+			return nil
+		}
+		entry := tarEntry(hdr, Download)
+		if !fs.ValidPath(name) {
+			entry.finish(fmt.Errorf("refusing to extract %q outside of the file system", hdr.Name))
+			entries = append(entries, entry)
+			return entry.Err
+		}
+
+		var err error
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			// Kept writable by the owner for the files extracted into it.
+			err = fsys.MkdirAll(name, hdr.FileInfo().Mode().Perm()|0700)
+		case tar.TypeReg:
+			err = extractFileFS(tr, fsys, name, hdr, progress, bufferSize)
+		default:
+			entry.Status = TransferSkipped
+			entries = append(entries, entry)
+			return nil
+		}
+		entry.finish(err)
+		entries = append(entries, entry)
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+
+		err = held.write(hdr, func(hdr *tar.Header) error { return extract(tr, hdr) })
+		if err != nil {
+			return entries, err
+		}
+	}
+}
+
+// extractFileFS writes the contents of the regular file read from tr to name in fsys.
+func extractFileFS(tr *tar.Reader, fsys WritableFS, name string, hdr *tar.Header, progress Progress, bufferSize int) error {
+	w, err := fsys.Create(name, hdr.FileInfo().Mode().Perm())
+	if err != nil {
+		return err
+	}
+	progress.File(hdr.Name, hdr.Size)
+	_, err = copyBuffer(w, &progressReader{r: tr, progress: progress, bufferSize: bufferSize}, bufferSize)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
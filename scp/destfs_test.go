@@ -0,0 +1,112 @@
+package scp
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// memFS a WritableFS keeping the files written to it in memory.
+type memFS struct {
+	files map[string]*bytes.Buffer
+	modes map[string]fs.FileMode
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string]*bytes.Buffer{}, modes: map[string]fs.FileMode{}}
+}
+
+func (m *memFS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	m.files[name] = &bytes.Buffer{}
+	m.modes[name] = perm
+	return nopWriteCloser{m.files[name]}, nil
+}
+
+func (m *memFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.modes[name] = fs.ModeDir | perm
+	return nil
+}
+
+func testArchive(t *testing.T, headers ...*tar.Header) *bytes.Buffer {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write(bytes.Repeat([]byte("x"), int(hdr.Size)))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &archive
+}
+
+func TestExtractTarFS(t *testing.T) {
+	archive := testArchive(t,
+		&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "./logs/", Typeflag: tar.TypeDir, Mode: 0750},
+		&tar.Header{Name: "./logs/app.log", Typeflag: tar.TypeReg, Mode: 0640, Size: 5},
+		&tar.Header{Name: "./empty/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "./link", Typeflag: tar.TypeSymlink, Linkname: "logs/app.log", Mode: 0777},
+	)
+
+	fsys := newMemFS()
+	entries, err := extractTarFS(archive, fsys, TarOptions{SkipEmptyDirs: true}, noProgress{}, DefaultBufferSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fsys.files["logs/app.log"].String(); got != "xxxxx" {
+		t.Errorf("logs/app.log holds %q", got)
+	}
+	if fsys.modes["logs/app.log"] != 0640 || fsys.modes["logs"] != fs.ModeDir|0750 {
+		t.Errorf("modes %v, want the ones of the archive", fsys.modes)
+	}
+	if _, ok := fsys.modes["empty"]; ok {
+		t.Error("created the empty directory with SkipEmptyDirs")
+	}
+	if _, ok := fsys.files["link"]; ok {
+		t.Error("extracted the symlink")
+	}
+	if len(entries) != 3 || entries[2].Path != "link" || entries[2].Status != TransferSkipped {
+		t.Errorf("entries %+v, want logs, logs/app.log and the skipped link", entries)
+	}
+}
+
+func TestExtractTarFSRefusesEscapes(t *testing.T) {
+	for _, name := range []string{"../escape", "/etc/passwd"} {
+		archive := testArchive(t, &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+		fsys := newMemFS()
+		if _, err := extractTarFS(archive, fsys, TarOptions{}, noProgress{}, DefaultBufferSize); err == nil {
+			t.Errorf("extracted %s", name)
+		}
+		if len(fsys.files) != 0 {
+			t.Errorf("wrote %v for %s", fsys.files, name)
+		}
+	}
+}
+
+func TestLocalFS(t *testing.T) {
+	dir := t.TempDir()
+	fsys := LocalFS(dir)
+	w, err := fsys.Create("a/b/file", 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("data"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "a", "b", "file")); err != nil || string(data) != "data" {
+		t.Errorf("read %q, %v", data, err)
+	}
+	if _, err := fsys.Create("../escape", 0600); err == nil {
+		t.Error("created a file outside of the directory")
+	}
+}
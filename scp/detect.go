@@ -0,0 +1,111 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// remoteBinaryCandidates locations probed for the scp binary when it is not on the PATH.
+var remoteBinaryCandidates = []string{
+	"/usr/bin/scp",
+	"/bin/scp",
+	"/usr/local/bin/scp",
+	"/opt/homebrew/bin/scp",
+	"/usr/libexec/scp",
+}
+
+// binaryDetection remembers the outcome of the remote binary detection,
+// it is shared by copies of a Client so the remote is only probed once.
+// Failing to probe is no outcome, the next transfer probes again.
+type binaryDetection struct {
+	mu     sync.Mutex
+	done   bool
+	binary string
+	err    error
+}
+
+// DetectRemoteBinary probes the remote for the scp binary, first on the PATH of the remote shell and then
+// in a couple of common locations, and returns its path. Windows remotes are asked for scp.exe with
+// where.exe, which both of their shells run alike. ErrRemoteBinaryMissing is returned
+// when the probe ran and found nothing, other errors, such as of the connection, as they are.
+func (a *Client) DetectRemoteBinary(ctx context.Context) (string, error) {
+	script := "command -v scp"
+	for _, candidate := range remoteBinaryCandidates {
+		script += " || { [ -x " + candidate + " ] && echo " + candidate + "; }"
+	}
+	if a.remoteOS().windows() {
+		script = "where.exe scp.exe " + CmdQuote(windowsBinaryDir+":scp.exe")
+	}
+
+	out, err := a.runOutput(ctx, script)
+	// where.exe lists every match, on lines ending in CRLF, and fails when any pattern had none.
+	binary, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	binary = strings.TrimSpace(binary)
+	var exit *ssh.ExitError
+	switch {
+	case binary != "" && (err == nil || a.remoteOS().windows()):
+		return binary, nil
+	case err == nil:
+		return "", ErrRemoteBinaryMissing
+	case errors.As(err, &exit) && (exit.ExitStatus() == 127 || binary == "" && !errors.Is(err, ErrRemoteKilled)):
+		// The probe fails when it found nothing, with 127 when the remote lacks even its commands.
+		return "", ErrRemoteBinaryMissing
+	}
+	return "", err
+}
+
+// resolveRemoteBinary detects the binary before the first transfer when detection is enabled,
+// see remoteBinary, and the operating system and compatibility profile of the remote for
+// RemoteOSAuto and CompatAuto before that. The outcome of the first detection is reused afterwards.
+func (a *Client) resolveRemoteBinary(ctx context.Context) error {
+	if err := a.resolveRemoteOS(ctx); err != nil {
+		return err
+	}
+	if err := a.resolveCompat(ctx); err != nil {
+		return err
+	}
+	if a.detection == nil {
+		return nil
+	}
+	// Not being connected yet is no outcome of the detection to remember.
+	if _, err := a.connected(ctx); err != nil {
+		return err
+	}
+
+	a.detection.mu.Lock()
+	defer a.detection.mu.Unlock()
+	if a.detection.done {
+		return a.detection.err
+	}
+	binary, err := a.DetectRemoteBinary(ctx)
+	if err != nil && !errors.Is(err, ErrRemoteBinaryMissing) {
+		a.logf(ctx, LogWarning, "failed to probe for the remote scp binary: %v", err)
+		return err
+	}
+	a.detection.done, a.detection.binary, a.detection.err = true, binary, err
+	if err != nil {
+		a.logf(ctx, LogError, "failed to detect the remote scp binary: %v", err)
+	} else {
+		a.logf(ctx, LogInfo, "found the remote scp binary at %s", binary)
+	}
+	return err
+}
+
+// remoteBinary the scp binary transfers run: the one detected by resolveRemoteBinary, which is
+// not written to RemoteBinary so transfers running at the same time do not race, or RemoteBinary.
+func (a *Client) remoteBinary() string {
+	if a.detection != nil && a.detection.binary != "" {
+		return a.detection.binary
+	}
+	return a.RemoteBinary
+}
@@ -0,0 +1,102 @@
+package scp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"main/scp"
+	"main/scp/scptest"
+)
+
+// probingServer returns a server answering the probe for the scp binary with probe, and counting
+// how often it was asked.
+func probingServer(t *testing.T, probe func(stdout io.Writer) int) (*scptest.Server, func() int) {
+	server := scptest.NewServer(t)
+	var mu sync.Mutex
+	probes := 0
+	server.Exec = func(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+		if !strings.HasPrefix(command, "command -v scp") {
+			return 127
+		}
+		mu.Lock()
+		probes++
+		mu.Unlock()
+		return probe(stdout)
+	}
+	return server, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return probes
+	}
+}
+
+func TestDetectRemoteBinaryRetriesFailedProbes(t *testing.T) {
+	started, unblock := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	server, probes := probingServer(t, func(stdout io.Writer) int {
+		first := false
+		once.Do(func() { first = true })
+		if first {
+			close(started)
+			select {
+			case <-unblock:
+			case <-time.After(10 * time.Second):
+			}
+			return 1
+		}
+		io.WriteString(stdout, "/usr/libexec/scp\n")
+		return 0
+	})
+	client := server.Configurer().DetectRemoteBinary(true).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	err := client.CopyFile(ctx, strings.NewReader("x"), "file", "0644")
+	close(unblock)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CopyFile canceled while probing returned %v, want context.Canceled", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := client.CopyFile(context.Background(), strings.NewReader("x"), "file", "0644"); err != nil {
+			t.Fatalf("CopyFile after a canceled probe returned %v", err)
+		}
+	}
+	if got, err := os.ReadFile(server.Path("file")); err != nil || string(got) != "x" {
+		t.Errorf("uploaded %q, %v", got, err)
+	}
+	if n := probes(); n != 2 {
+		t.Errorf("probed %d times, want once more after the canceled probe only", n)
+	}
+}
+
+func TestDetectRemoteBinaryMissing(t *testing.T) {
+	server, probes := probingServer(t, func(io.Writer) int { return 1 })
+	client := server.Configurer().DetectRemoteBinary(true).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := client.CopyFile(context.Background(), strings.NewReader("x"), "file", "0644"); !errors.Is(err, scp.ErrRemoteBinaryMissing) {
+			t.Errorf("CopyFile without a remote scp returned %v, want ErrRemoteBinaryMissing", err)
+		}
+	}
+	if n := probes(); n != 1 {
+		t.Errorf("probed %d times, want a missing binary to be remembered", n)
+	}
+}
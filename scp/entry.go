@@ -0,0 +1,73 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"io/fs"
+	"time"
+)
+
+// TransferStatus the outcome of a single file of a multi-file transfer.
+type TransferStatus string
+
+const (
+	// TransferPending the file was not transferred, as in a dry run or after the transfer was aborted.
+	TransferPending TransferStatus = "pending"
+
+	// TransferDone the file was transferred.
+	TransferDone TransferStatus = "done"
+
+	// TransferSkipped the file was left alone, as it is the same at the destination.
+	TransferSkipped TransferStatus = "skipped"
+
+	// TransferFailed transferring the file failed, see TransferEntry.Err.
+	TransferFailed TransferStatus = "failed"
+)
+
+// TransferEntry the outcome for a single file of a multi-file transfer, such as a sync or a directory
+// copy, so callers can tell which files made it rather than getting a single error for all of them.
+type TransferEntry struct {
+	// Path the path of the file relative to the transferred directories, separated by slashes.
+	Path string
+
+	// Size the size of the file, zero for directories and symlinks.
+	Size int64
+
+	// Mode the type and permissions of the file at the source.
+	Mode fs.FileMode
+
+	// ModTime the modification time of the file at the source.
+	ModTime time.Time
+
+	Direction Direction
+	Status    TransferStatus
+
+	// Err the error transferring this file, if any.
+	Err error
+
+	// Tags the tags of the transfer the entry belongs to, see WithTags.
+	Tags Tags
+}
+
+// tagEntries attaches the tags of ctx to the entries of a transfer started with it.
+func tagEntries(ctx context.Context, entries []TransferEntry) []TransferEntry {
+	tags := TagsFromContext(ctx)
+	for i := range entries {
+		entries[i].Tags = tags
+	}
+	return entries
+}
+
+// finish records the outcome of transferring the entry.
+func (e *TransferEntry) finish(err error) {
+	e.Err = err
+	e.Status = TransferDone
+	if err != nil {
+		e.Status = TransferFailed
+	}
+}
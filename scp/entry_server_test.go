@@ -0,0 +1,64 @@
+package scp_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"main/scp"
+)
+
+func TestTransferEntries(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	local := t.TempDir()
+	modTime := time.Unix(1700000000, 0)
+	writeTree(t, local, map[string]string{"sub/file": "hello", "blocked/file": "blocked"}, modTime)
+
+	entries, err := client.CopyDirToRemoteTar(ctx, local, t.TempDir(), scp.TarOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := map[string]scp.TransferEntry{}
+	for _, entry := range entries {
+		byPath[entry.Path] = entry
+		if entry.Direction != scp.Upload || entry.Status != scp.TransferDone || entry.Err != nil {
+			t.Errorf("the entry of %s is %s %s, %v", entry.Path, entry.Direction, entry.Status, entry.Err)
+		}
+	}
+	if len(byPath) != 4 || !byPath["sub"].Mode.IsDir() || !byPath["blocked"].Mode.IsDir() {
+		t.Errorf("the entries are %+v, want the two files and their directories", entries)
+	}
+	if file := byPath["sub/file"]; file.Size != 5 || file.Mode != 0644 || !file.ModTime.Equal(modTime) {
+		t.Errorf("the entry of the file is %+v", file)
+	}
+
+	// The remote has a file where a directory is needed, one file fails while the other makes it.
+	remote := t.TempDir()
+	writeTree(t, remote, map[string]string{"blocked": "in the way"}, modTime)
+	plan, err := client.SyncToRemote(ctx, local, remote, scp.SyncOptions{})
+	if err == nil {
+		t.Error("the sync with a failing file succeeded")
+	}
+	for _, entry := range plan {
+		switch entry.Path {
+		case "blocked/file":
+			if entry.Status != scp.TransferFailed || entry.Err == nil {
+				t.Errorf("the blocked file is %s, %v, want it failed", entry.Status, entry.Err)
+			}
+		case "sub/file":
+			if entry.Status != scp.TransferDone || entry.Err != nil {
+				t.Errorf("the other file is %s, %v, want it done", entry.Status, entry.Err)
+			}
+		default:
+			t.Errorf("unexpected entry %+v", entry)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(remote, "sub", "file")); errors.Is(err, fs.ErrNotExist) {
+		t.Error("the failure of one file stopped the others")
+	}
+}
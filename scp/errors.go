@@ -0,0 +1,64 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import "errors"
+
+// ErrStalled is returned when no bytes have flowed for longer than the
+// configured idle timeout.
+var ErrStalled = errors.New("scp: transfer stalled, no progress within the idle timeout")
+
+// ErrRemoteBinaryMissing is returned when the scp binary can not be found on the remote.
+var ErrRemoteBinaryMissing = errors.New("scp: remote has no scp binary; set RemoteBinary to its location or try the SFTP backend")
+
+// ErrTooManyDeletes is returned when a sync would delete more destination files than
+// allowed by SyncOptions.MaxDeletePercent.
+var ErrTooManyDeletes = errors.New("scp: sync refuses to delete that many destination files")
+
+// ErrDeleteNotConfirmed is returned when a sync with Delete was not confirmed by SyncOptions.ConfirmDelete.
+var ErrDeleteNotConfirmed = errors.New("scp: sync deletions were not confirmed")
+
+// ErrHostKeyRejected is returned when the key of an unknown host was not accepted at the prompt.
+var ErrHostKeyRejected = errors.New("scp: host key was not accepted")
+
+// ErrInsufficientSpace is returned when the destination of a transfer has not enough free space for the file.
+var ErrInsufficientSpace = errors.New("scp: not enough free space at the destination")
+
+// ErrVerifyFailed is returned when the checksum of an uploaded file on the remote does not match the
+// bytes sent, after repeating the upload as often as VerifyRetries allows. See VerifyUploads.
+var ErrVerifyFailed = errors.New("scp: uploaded file does not match the checksum of the source")
+
+// ErrHookFailed is returned when the command of a Hook could not be run or exited with an error.
+var ErrHookFailed = errors.New("scp: hook failed")
+
+// ErrResumeMismatch is returned when the file of a resumed upload differs from its source in size
+// or checksum once complete, for example because the partial remote file was changed meanwhile.
+var ErrResumeMismatch = errors.New("scp: resumed upload does not match its source")
+
+// ErrSizeMismatch is returned when a stream uploaded with CopyStream yields another amount of bytes than its size.
+var ErrSizeMismatch = errors.New("scp: stream size does not match the announced size")
+
+// ErrInvalidFilename is returned for files whose name can not be transferred, such as one containing a
+// newline over SCP, and for names received from the remote that do not name a file, such as "..".
+var ErrInvalidFilename = errors.New("scp: invalid file name")
+
+// ErrInvalidPermissions is returned for permissions that are not octal file permissions, see ParseMode.
+var ErrInvalidPermissions = errors.New("scp: invalid permissions")
+
+// ErrNotConnected is returned when a Client is used before connecting or after it was closed.
+// See LazyConnect.
+var ErrNotConnected = errors.New("scp: client is not connected; call Connect first or enable LazyConnect")
+
+// ErrRemoteNotFound matches errors of remote commands failing on a file that does not exist, see RemoteExitError.
+var ErrRemoteNotFound = errors.New("scp: no such file or directory on the remote")
+
+// ErrRemotePermissionDenied matches errors of remote commands denied access to a file or not allowed
+// to run, see RemoteExitError.
+var ErrRemotePermissionDenied = errors.New("scp: permission denied on the remote")
+
+// ErrRemoteKilled matches errors of remote commands killed by a signal, see RemoteExitError.
+var ErrRemoteKilled = errors.New("scp: remote command was killed by a signal")
@@ -0,0 +1,101 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// RemoteExitError is returned when a remote command, such as scp, exited with an error. It matches
+// ErrRemoteNotFound, ErrRemotePermissionDenied or ErrRemoteKilled when the cause is one of those,
+// as told by the exit status, the signal or the messages of the remote. scp exits with status 1
+// whatever went wrong, the messages tell the cause then.
+type RemoteExitError struct {
+	// Status the exit status of the command, 128 plus the number of the signal when it was killed by one.
+	Status int
+
+	// Signal the name of the signal that killed the command, such as "KILL", empty when it exited.
+	Signal string
+
+	// Stderr the start of what the command wrote to its standard error, on a single line.
+	Stderr string
+
+	// Err the error the command failed with, an *ssh.ExitError or one wrapping it.
+	Err error
+}
+
+func (e *RemoteExitError) Error() string {
+	if e.Stderr == "" || strings.Contains(e.Err.Error(), e.Stderr) {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Stderr
+}
+
+func (e *RemoteExitError) Unwrap() []error {
+	if cause := exitCause(e.Status, e.Signal, e.Err.Error(), e.Stderr); cause != nil {
+		return []error{cause, e.Err}
+	}
+	return []error{e.Err}
+}
+
+// Is tells whether the warning names a cause such as ErrRemoteNotFound, which a remote scp sends as a
+// warning for a file it can not send.
+func (w *RemoteWarning) Is(target error) bool {
+	return target != nil && exitCause(0, "", w.Message) == target
+}
+
+// exitCause returns the error matching the cause of a remote command failing, or nil when it is not known.
+func exitCause(status int, signal string, messages ...string) error {
+	switch {
+	case signal != "":
+		return ErrRemoteKilled
+	case status == 126:
+		// The shell found the command but could not execute it.
+		return ErrRemotePermissionDenied
+	case status > 128 && status <= 128+64:
+		// The shell reports commands killed by a signal with 128 added to the signal.
+		return ErrRemoteKilled
+	}
+	for _, message := range messages {
+		switch {
+		case strings.Contains(message, "No such file or directory"):
+			return ErrRemoteNotFound
+		case strings.Contains(message, "Permission denied"), strings.Contains(message, "Operation not permitted"):
+			return ErrRemotePermissionDenied
+		}
+	}
+	return nil
+}
+
+// remoteError explains err, the error of the remote command of session, by adding what it wrote to its
+// standard error, as the error of the session exiting only tells the exit status. Exit errors are returned
+// as a RemoteExitError, such as "Process exited with status 1: scp: /path: Permission denied".
+func remoteError(session *ssh.Session, err error) error {
+	if err == nil {
+		return nil
+	}
+	var output string
+	if stderr, ok := session.Stderr.(*stderrBuffer); ok {
+		output = stderr.String()
+	}
+
+	var remoteExit *RemoteExitError
+	var exit *ssh.ExitError
+	switch {
+	case errors.As(err, &remoteExit):
+		return err
+	case errors.As(err, &exit):
+		return &RemoteExitError{Status: exit.ExitStatus(), Signal: exit.Signal(), Stderr: output, Err: err}
+	case output == "" || strings.Contains(err.Error(), output):
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, output)
+}
@@ -0,0 +1,34 @@
+package scp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"main/scp"
+)
+
+func TestRemoteExitErrors(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+
+	_, err := client.RunOutput(ctx, "echo 'cat: /srv/file: Permission denied' >&2; exit 1")
+	var exit *scp.RemoteExitError
+	if !errors.As(err, &exit) || exit.Status != 1 || exit.Stderr != "cat: /srv/file: Permission denied" {
+		t.Errorf("runOutput returned %#v, want a RemoteExitError with the status and standard error", err)
+	}
+	if !errors.Is(err, scp.ErrRemotePermissionDenied) {
+		t.Errorf("%v does not match ErrRemotePermissionDenied", err)
+	}
+
+	if _, err := client.RunOutput(ctx, "sh -c 'kill -KILL $$'"); !errors.Is(err, scp.ErrRemoteKilled) {
+		t.Errorf("runOutput of a killed command returned %v, want ErrRemoteKilled", err)
+	}
+
+	_, err = client.CopyFromRemoteWithOptions(ctx, io.Discard, filepath.Join(t.TempDir(), "missing"), scp.DownloadOptions{})
+	if !errors.Is(err, scp.ErrRemoteNotFound) || errors.Is(err, scp.ErrRemotePermissionDenied) {
+		t.Errorf("download of a missing file returned %v, want ErrRemoteNotFound", err)
+	}
+}
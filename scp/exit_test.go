@@ -0,0 +1,24 @@
+package scp
+
+import "testing"
+
+func TestExitCause(t *testing.T) {
+	for _, test := range []struct {
+		status   int
+		signal   string
+		messages []string
+		want     error
+	}{
+		{1, "", []string{"scp: /srv/file: No such file or directory"}, ErrRemoteNotFound},
+		{1, "", []string{"Process exited with status 1", "scp: /srv: Permission denied"}, ErrRemotePermissionDenied},
+		{126, "", nil, ErrRemotePermissionDenied},
+		{137, "", nil, ErrRemoteKilled},
+		{137, "KILL", nil, ErrRemoteKilled},
+		{1, "", []string{"scp: /srv/file: Is a directory"}, nil},
+		{2, "", nil, nil},
+	} {
+		if got := exitCause(test.status, test.signal, test.messages...); got != test.want {
+			t.Errorf("exitCause(%d, %q, %q) = %v, want %v", test.status, test.signal, test.messages, got, test.want)
+		}
+	}
+}
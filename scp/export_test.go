@@ -0,0 +1,20 @@
+package scp
+
+import (
+	"context"
+	"io"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// The internals reached by the tests of package scp_test, which run against the server of scptest
+// and can so not be part of package scp.
+
+func (a *Client) RunOutput(ctx context.Context, script string) ([]byte, error) {
+	return a.runOutput(ctx, script)
+}
+
+func init() {
+	// ProgressTerminal renders into nothing, the tests have no terminal.
+	programOptions = []tea.ProgramOption{tea.WithInput(nil), tea.WithOutput(io.Discard)}
+}
@@ -0,0 +1,61 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// checkRemotePath tells whether the remote file remotePath can be transferred over SCP. Any byte but
+// NUL reaches the remote through ShellQuote, including invalid UTF-8 and control characters, but the
+// name of the file is sent on a line of its own, so it must not contain a newline. Names the remote
+// scp refuses to create are rejected before connecting as well.
+func checkRemotePath(remotePath string) error {
+	if err := checkNUL(remotePath); err != nil {
+		return err
+	}
+	return checkFilename(remotePathBase(remotePath))
+}
+
+// checkNUL rejects remote paths containing a NUL byte, which can not be part of a command line.
+func checkNUL(remotePath string) error {
+	if strings.ContainsRune(remotePath, 0) {
+		return fmt.Errorf("%w: %s contains a NUL byte", ErrInvalidFilename, displayName(remotePath))
+	}
+	return nil
+}
+
+// checkFilename tells whether name can be sent in the `C` record announcing a file.
+func checkFilename(name string) error {
+	switch {
+	case name == "" || name == "." || name == ".." || strings.Contains(name, "/"):
+		return fmt.Errorf("%w: %s is not the name of a file", ErrInvalidFilename, displayName(name))
+	case strings.ContainsAny(name, "\n\x00"):
+		return fmt.Errorf("%w: %s contains a newline, which the SCP protocol can not carry; try the SFTP backend", ErrInvalidFilename, displayName(name))
+	}
+	return nil
+}
+
+// remotePathBase returns the last element of remotePath, like path.Base but keeping "" and
+// "/" apart from names.
+func remotePathBase(remotePath string) string {
+	remotePath = strings.TrimRight(remotePath, "/")
+	return remotePath[strings.LastIndex(remotePath, "/")+1:]
+}
+
+// displayName returns name as it is when it is printable, or quoted with its control characters
+// and invalid UTF-8 escaped, so names can not corrupt the terminal or a log line.
+func displayName(name string) string {
+	if utf8.ValidString(name) && strings.IndexFunc(name, func(r rune) bool { return !unicode.IsPrint(r) && r != ' ' }) < 0 {
+		return name
+	}
+	return strconv.Quote(name)
+}
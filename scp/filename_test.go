@@ -0,0 +1,85 @@
+package scp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFileInfosExoticNames(t *testing.T) {
+	for _, name := range []string{
+		"with space.txt",
+		"  leading and trailing  ",
+		"tab\tseparated",
+		"invalid \xff\xfe utf-8",
+		"control \x01\x1b[31m",
+		"Exöt1ç ünïcode ☃.txt",
+		"-starts-with-dash",
+	} {
+		record := createRecord("0644", 3, name)
+		fileInfos := NewFileInfos()
+		if err := ParseFileInfos(record, fileInfos); err != nil {
+			t.Errorf("ParseFileInfos(%q) failed: %v", record, err)
+			continue
+		}
+		if fileInfos.Filename != name {
+			t.Errorf("ParseFileInfos(%q) named the file %q, want %q", record, fileInfos.Filename, name)
+		}
+	}
+}
+
+func TestParseFileInfosRejectsPaths(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../escape", "dir/file", "/etc/passwd"} {
+		err := ParseFileInfos(createRecord("0644", 3, name), NewFileInfos())
+		if !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("ParseFileInfos of the name %q returned %v, want ErrInvalidFilename", name, err)
+		}
+	}
+}
+
+func TestCheckRemotePath(t *testing.T) {
+	valid := []string{
+		"file",
+		"/srv/dir/file",
+		"/srv/new\nline dir/file",
+		"/srv/invalid \xff utf-8",
+		"/srv/control \x01 char",
+		"dir/",
+	}
+	for _, remotePath := range valid {
+		if err := checkRemotePath(remotePath); err != nil {
+			t.Errorf("checkRemotePath(%q) = %v, want it transferable", remotePath, err)
+		}
+	}
+
+	invalid := []string{
+		"/srv/new\nline",
+		"/srv/nul\x00byte",
+		"/srv/nul\x00dir/file",
+		"/",
+		"",
+		"/srv/..",
+		".",
+	}
+	for _, remotePath := range invalid {
+		if err := checkRemotePath(remotePath); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("checkRemotePath(%q) = %v, want ErrInvalidFilename", remotePath, err)
+		}
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	tests := map[string]string{
+		"plain.txt":         "plain.txt",
+		"with space":        "with space",
+		"ünïcode ☃":         "ünïcode ☃",
+		"escape \x1b[31m":   `"escape \x1b[31m"`,
+		"new\nline":         `"new\nline"`,
+		"invalid \xff":      `"invalid \xff"`,
+		"bell \a and tab\t": `"bell \a and tab\t"`,
+	}
+	for name, want := range tests {
+		if got := displayName(name); got != want {
+			t.Errorf("displayName(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
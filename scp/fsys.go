@@ -0,0 +1,118 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// CopyFromFS uploads the file `name` of fsys, such as an embed.FS, a zip.Reader or a fstest.MapFS, to
+// remotePath without writing it to the local disk first. Empty permissions take the ones reported by
+// fsys, see ParseMode.
+func (a *Client) CopyFromFS(
+	ctx context.Context,
+	fsys fs.FS,
+	name string,
+	remotePath string,
+	permissions string,
+	passThru PassThru,
+) error {
+	f, size, permissions, err := openFS(fsys, name, permissions)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return a.CopyPassThru(ctx, f, remotePath, permissions, size, passThru)
+}
+
+// CopyFromFSProgress is the same as CopyFromFS but renders a progress bar with the speed and
+// the estimated time left in the terminal.
+func (a *Client) CopyFromFSProgress(
+	ctx context.Context,
+	fsys fs.FS,
+	name string,
+	remotePath string,
+	permissions string,
+) error {
+	f, size, permissions, err := openFS(fsys, name, permissions)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return a.CopyToRemoteProgressPassThru(ctx, f, remotePath, permissions, size, nil)
+}
+
+// openFS opens the regular file name of fsys for an upload, returning its size and the permissions
+// to upload it with.
+func openFS(fsys fs.FS, name string, permissions string) (fs.File, int64, string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !stat.Mode().IsRegular() {
+		f.Close()
+		return nil, 0, "", &fs.PathError{Op: "upload", Path: name, Err: errors.New("not a regular file")}
+	}
+	if permissions == "" {
+		permissions = FormatMode(stat.Mode())
+	}
+	return f, stat.Size(), permissions, nil
+}
+
+// CopyFSToRemoteTar copies the files and directories `names` of fsys into `remoteDir` by streaming a tar
+// archive into `tar -x` on the remote, like CopyDirToRemoteTar does for a local directory. Each of them
+// keeps its last element as name in `remoteDir`, "." copies the contents of fsys. `remoteDir` must exist.
+// Only regular files and directories are copied, other files are returned as skipped entries, and
+// extended attributes are not read; the other TarOptions apply. Permissions are the ones reported by
+// fsys, read-only for an embed.FS, and files without a modification time, such as those of an embed.FS,
+// get the current time.
+func (a *Client) CopyFSToRemoteTar(ctx context.Context, fsys fs.FS, names []string, remoteDir string, opts TarOptions) ([]TransferEntry, error) {
+	flags := ""
+	if opts.Owner {
+		flags = " --same-owner"
+	}
+	cmd := fmt.Sprintf("tar%s -x -f - -C %s", flags, a.shellPath(remoteDir))
+	progress := progressOrNop(opts.Progress)
+
+	totalBytes, totalFiles, err := scanFS(fsys, names)
+	if err != nil {
+		return nil, err
+	}
+	progress.Start(totalBytes, totalFiles)
+
+	var entries []TransferEntry
+	err = a.runStream(ctx, cmd, func(stdin io.WriteCloser, _ io.Reader) error {
+		defer stdin.Close()
+		var err error
+		entries, err = writeTarFS(stdin, fsys, names, "", opts, progress, a.BufferSize)
+		return err
+	})
+	return tagEntries(ctx, entries), err
+}
+
+// CopyFSToRemoteTarProgress is the same as CopyFSToRemoteTar but renders an overall progress bar
+// and a progress bar for the file currently in flight in the terminal.
+func (a *Client) CopyFSToRemoteTarProgress(ctx context.Context, fsys fs.FS, names []string, remoteDir string, opts TarOptions) ([]TransferEntry, error) {
+	var entries []TransferEntry
+	err := a.runProgress(ctx, a.label(Upload, path.Base(remoteDir)+"/"), func(ctx context.Context, progress Progress) error {
+		opts.Progress = progress
+		var err error
+		entries, err = a.CopyFSToRemoteTar(ctx, fsys, names, remoteDir, opts)
+		return err
+	})
+	return entries, err
+}
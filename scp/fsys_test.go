@@ -0,0 +1,87 @@
+package scp
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWriteTarFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"site/index.html":     {Data: []byte("<h1>hi</h1>"), Mode: 0644},
+		"site/css/style.css":  {Data: []byte("body{}"), Mode: 0600},
+		"site/empty":          {Mode: fs.ModeDir | 0755},
+		"site/link":           {Data: []byte("index.html"), Mode: fs.ModeSymlink | 0777},
+		"config/app.yaml":     {Data: []byte("a: 1"), Mode: 0640},
+		"config/ignored.yaml": {Data: []byte("b: 2"), Mode: 0640},
+	}
+
+	var archive bytes.Buffer
+	entries, err := writeTarFS(&archive, fsys, []string{"site", "config/app.yaml"}, "", TarOptions{}, noProgress{}, DefaultBufferSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"site/":              "",
+		"site/css/":          "",
+		"site/css/style.css": "body{}",
+		"site/empty/":        "",
+		"site/index.html":    "<h1>hi</h1>",
+		"app.yaml":           "a: 1",
+	}
+	tr := tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, ok := want[hdr.Name]
+		if !ok {
+			t.Errorf("unexpected entry %s", hdr.Name)
+			continue
+		}
+		delete(want, hdr.Name)
+		data, _ := io.ReadAll(tr)
+		if string(data) != contents {
+			t.Errorf("%s holds %q, want %q", hdr.Name, data, contents)
+		}
+		if hdr.ModTime.IsZero() {
+			t.Errorf("%s has no modification time", hdr.Name)
+		}
+	}
+	for name := range want {
+		t.Errorf("missing entry %s", name)
+	}
+
+	skipped := 0
+	for _, entry := range entries {
+		if entry.Status == TransferSkipped {
+			skipped++
+			if entry.Path != "site/link" {
+				t.Errorf("skipped %s, want only the symlink skipped", entry.Path)
+			}
+		}
+	}
+	if skipped != 1 {
+		t.Errorf("skipped %d entries, want the symlink", skipped)
+	}
+}
+
+func TestScanFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/one": {Data: []byte("12345")},
+		"a/two": {Data: []byte("123")},
+		"b":     {Data: []byte("1")},
+	}
+	bytes, files, err := scanFS(fsys, []string{"a", "b"})
+	if err != nil || bytes != 9 || files != 3 {
+		t.Errorf("scanFS = %d bytes, %d files, %v, want 9 bytes in 3 files", bytes, files, err)
+	}
+}
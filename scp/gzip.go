@@ -0,0 +1,55 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+)
+
+// gzipUpload compresses r into `gzip -dc` on the remote, which writes it to result.RemotePath,
+// see GzipUploads.
+func (a *Client) gzipUpload(ctx context.Context, r io.Reader, result *UploadResult, passThru PassThru, times *FileInfos) (*UploadResult, error) {
+	return a.pipeUpload(ctx, r, result, passThru, times, "gzip -dc", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	})
+}
+
+// gzipDownload writes the remote file decompressed to w, compressed on the wire by `gzip -c` on the
+// remote, see GzipDownloads. The file is described by Stat first, as gzip announces nothing about it.
+func (a *Client) gzipDownload(ctx context.Context, w io.Writer, remotePath string, passThru PassThru) (*FileInfos, error) {
+	fileInfos, err := a.Stat(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLocalSpace(w, fileInfos.Size); err != nil {
+		return fileInfos, err
+	}
+
+	cmd := fmt.Sprintf("gzip -c < %s", a.shellPath(remotePath))
+	err = a.runStream(ctx, cmd, func(stdin io.WriteCloser, stdout io.Reader) error {
+		stdin.Close()
+		if passThru != nil {
+			stdout = passThru(stdout, -1)
+		}
+		zr, err := gzip.NewReader(stdout)
+		if err != nil {
+			return fmt.Errorf("failed to read the compressed stream: %w", err)
+		}
+		n, err := copyBuffer(w, zr, a.BufferSize)
+		if err != nil {
+			return err
+		}
+		if n != fileInfos.Size {
+			return fmt.Errorf("received %d bytes of %s, expected %d", n, remotePath, fileInfos.Size)
+		}
+		return nil
+	})
+	return fileInfos, err
+}
@@ -0,0 +1,71 @@
+package scp_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"main/scp"
+)
+
+// wireBytes is a PassThru counting the bytes read and recording the totals announced.
+type wireBytes struct {
+	mu     sync.Mutex
+	n      int64
+	totals []int64
+}
+
+func (w *wireBytes) passThru(r io.Reader, total int64) io.Reader {
+	w.mu.Lock()
+	w.totals = append(w.totals, total)
+	w.mu.Unlock()
+	return readFunc(func(p []byte) (int, error) {
+		n, err := r.Read(p)
+		w.mu.Lock()
+		w.n += int64(n)
+		w.mu.Unlock()
+		return n, err
+	})
+}
+
+func TestGzipTransfers(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, func(c *scp.ClientConfigurer) { c.GzipUploads(true).GzipDownloads(true) })
+	contents := strings.Repeat("compresses well ", 10000)
+	remote := filepath.Join(t.TempDir(), "file")
+
+	upload := &wireBytes{}
+	if err := client.CopyPassThru(ctx, strings.NewReader(contents), remote, "0640", int64(len(contents)), upload.passThru); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(remote); err != nil || string(got) != contents {
+		t.Errorf("the remote received %d bytes, %v, want the %d of the file decompressed", len(got), err, len(contents))
+	}
+	if stat, err := os.Stat(remote); err == nil && stat.Mode().Perm() != 0640 {
+		t.Errorf("the remote file has the mode %s", stat.Mode().Perm())
+	}
+
+	var buf bytes.Buffer
+	download := &wireBytes{}
+	if err := client.CopyFromRemotePassThru(ctx, &buf, remote, download.passThru); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != contents {
+		t.Errorf("downloaded %d bytes, want the %d of the file decompressed", buf.Len(), len(contents))
+	}
+
+	// Both report the compressed bytes on the wire, whose total is unknown.
+	for name, wire := range map[string]*wireBytes{"upload": upload, "download": download} {
+		if wire.n <= 0 || wire.n >= int64(len(contents))/10 {
+			t.Errorf("the %s sent %d bytes on the wire for %d bytes of the file", name, wire.n, len(contents))
+		}
+		if len(wire.totals) != 1 || wire.totals[0] != -1 {
+			t.Errorf("the %s announced the totals %v, want it unknown", name, wire.totals)
+		}
+	}
+}
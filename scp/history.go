@@ -0,0 +1,339 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/muesli/termenv"
+)
+
+// MaxHistoryEntries the amount of transfers a History keeps, older ones are dropped.
+const MaxHistoryEntries = 1000
+
+// HistoryEntry a finished transfer. Source and Destination are a local path and a remote path,
+// in the order given by Direction, like for a Job.
+type HistoryEntry struct {
+	Direction   Direction     `json:"direction"`
+	Host        string        `json:"host"`
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Size        int64         `json:"size"`
+	Started     time.Time     `json:"started"`
+	Duration    time.Duration `json:"duration"`
+
+	// Error why the transfer failed, empty when it succeeded.
+	Error string `json:"error,omitempty"`
+
+	Tags Tags `json:"tags,omitempty"`
+}
+
+// Job returns a job running the transfer again.
+func (e HistoryEntry) Job() *Job {
+	return &Job{
+		Direction:   e.Direction,
+		Host:        e.Host,
+		Source:      e.Source,
+		Destination: e.Destination,
+		Tags:        e.Tags,
+	}
+}
+
+// Reverse returns the transfer in the opposite direction, downloading what was uploaded and
+// the other way around.
+func (e HistoryEntry) Reverse() HistoryEntry {
+	reversed := e
+	reversed.Source, reversed.Destination = e.Destination, e.Source
+	reversed.Direction = Upload
+	if e.Direction == Upload {
+		reversed.Direction = Download
+	}
+	return reversed
+}
+
+// Location returns the remote side of the transfer as "user@host:path", like scp takes it, or as
+// an "scp://user@host:port/path" URL when the host does not listen on DefaultPort. As for OpenSSH,
+// the path of the URL follows the slash after the port, so absolute paths start with two slashes.
+func (e HistoryEntry) Location() string {
+	remotePath := e.Source
+	if e.Direction == Upload {
+		remotePath = e.Destination
+	}
+
+	user, address, ok := strings.Cut(e.Host, "@")
+	if !ok {
+		user, address = "", e.Host
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, strconv.Itoa(DefaultPort)
+	}
+	if user != "" {
+		user += "@"
+	}
+
+	if port == strconv.Itoa(DefaultPort) {
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		return user + host + ":" + remotePath
+	}
+	location := url.URL{Path: "/" + remotePath}
+	return "scp://" + user + net.JoinHostPort(host, port) + location.EscapedPath()
+}
+
+// Matches reports whether the host, the paths or the tags of the transfer contain query, ignoring case.
+func (e HistoryEntry) Matches(query string) bool {
+	query = strings.ToLower(query)
+	for _, field := range []string{e.Host, e.Source, e.Destination, e.Tags.String()} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// History the finished transfers, oldest first, persisted to a JSON file.
+type History struct {
+	mu   sync.Mutex
+	path string
+
+	Entries []HistoryEntry `json:"entries"`
+}
+
+// LoadHistory reads the history persisted at path, a missing file results in an empty history.
+func LoadHistory(path string) (*History, error) {
+	h := &History{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("failed to parse history %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// Add appends the transfer to the history and persists it, dropping the oldest
+// transfers beyond MaxHistoryEntries.
+func (h *History) Add(entry HistoryEntry) error {
+	h.mu.Lock()
+	h.Entries = append(h.Entries, entry)
+	if len(h.Entries) > MaxHistoryEntries {
+		h.Entries = h.Entries[len(h.Entries)-MaxHistoryEntries:]
+	}
+	h.mu.Unlock()
+
+	return h.Save()
+}
+
+// AddJob records a job of the queue that started at the given time and ended with err,
+// which includes failures before the job ran, such as connecting.
+func (h *History) AddJob(job *Job, started time.Time, err error) error {
+	entry := HistoryEntry{
+		Direction:   job.Direction,
+		Host:        job.Host,
+		Source:      job.Source,
+		Destination: job.Destination,
+		Size:        job.Size,
+		Started:     started,
+		Duration:    time.Since(started),
+		Tags:        job.Tags,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return h.Add(entry)
+}
+
+// Save writes the history to disk, replacing the previous file atomically.
+func (h *History) Save() error {
+	h.mu.Lock()
+	data, err := json.MarshalIndent(h, "", "  ")
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(h.path, data)
+}
+
+// PickHistory lists the transfers of the history in the terminal, newest first, filtered by a search
+// field. It returns the picked transfer to run again, or its reverse when picked with ctrl+r, and
+// context.Canceled when the list is dismissed. ctrl+y copies the Location of the selected transfer
+// to the clipboard, through the terminal, which also works over SSH.
+func PickHistory(theme Theme, entries []HistoryEntry) (HistoryEntry, error) {
+	theme = theme.withDefaults()
+	keys := newKeyMap()
+	keys.Quit.SetKeys("esc", "ctrl+c")
+	keys.Quit.SetHelp("esc", "cancel")
+	keys.ScrollUp.SetKeys("up")
+	keys.ScrollUp.SetHelp("↑", "previous")
+	keys.ScrollDown.SetKeys("down")
+	keys.ScrollDown.SetHelp("↓", "next")
+	keys.ToggleLog.SetEnabled(false)
+	keys.Help.SetEnabled(false)
+	keys.Accept.SetHelp("enter", "run again")
+	keys.Accept.SetEnabled(true)
+	keys.Reverse.SetEnabled(true)
+	keys.Copy.SetEnabled(true)
+
+	newest := make([]HistoryEntry, len(entries))
+	for i, entry := range entries {
+		newest[len(entries)-1-i] = entry
+	}
+
+	input := textinput.New()
+	input.Prompt = "Search: "
+	input.Focus()
+
+	result, err := tea.NewProgram(historyModel{
+		entries: newest,
+		matches: newest,
+		input:   input,
+		keys:    keys,
+		help:    newHelp(theme),
+		theme:   theme,
+	}).Run()
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	m := result.(historyModel)
+	if m.picked == nil {
+		return HistoryEntry{}, context.Canceled
+	}
+	return *m.picked, nil
+}
+
+// historyModel a searchable list of past transfers.
+type historyModel struct {
+	entries  []HistoryEntry
+	matches  []HistoryEntry
+	input    textinput.Model
+	selected int
+	picked   *HistoryEntry
+	copied   string
+
+	keys  keyMap
+	help  help.Model
+	theme Theme
+}
+
+func (m historyModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m historyModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Accept, m.keys.Reverse):
+			if len(m.matches) == 0 {
+				return m, nil
+			}
+			picked := m.matches[m.selected]
+			if key.Matches(msg, m.keys.Reverse) {
+				picked = picked.Reverse()
+			}
+			m.picked = &picked
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Copy):
+			if len(m.matches) == 0 {
+				return m, nil
+			}
+			m.copied = m.matches[m.selected].Location()
+			// Terminals supporting OSC 52 put the text on the clipboard.
+			termenv.Copy(m.copied)
+			return m, nil
+		case key.Matches(msg, m.keys.ScrollUp):
+			if m.selected > 0 {
+				m.selected--
+			}
+			return m, nil
+		case key.Matches(msg, m.keys.ScrollDown):
+			if m.selected < len(m.matches)-1 {
+				m.selected++
+			}
+			return m, nil
+		}
+	}
+
+	query := m.input.Value()
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	if m.input.Value() != query {
+		m.matches = nil
+		for _, entry := range m.entries {
+			if entry.Matches(m.input.Value()) {
+				m.matches = append(m.matches, entry)
+			}
+		}
+		m.selected = 0
+	}
+	return m, cmd
+}
+
+func (m historyModel) View() string {
+	pad := strings.Repeat(" ", padding)
+	view := "\n" + pad + m.input.View() + "\n\n"
+
+	if len(m.matches) == 0 {
+		view += pad + style(m.theme.Muted)("No transfers found.") + "\n"
+	}
+	// Scroll the list so the selected transfer stays in view.
+	first := max(0, m.selected-completionListSize+1)
+	for i := first; i < len(m.matches) && i < first+completionListSize; i++ {
+		entry := m.matches[i]
+		status := style(m.theme.Success)("✓")
+		if entry.Error != "" {
+			status = style(m.theme.Failure)("✗")
+		}
+		line := fmt.Sprintf("%s %s %-8s %s", status, entry.Started.Format("2006-01-02 15:04"), entry.Direction, entry.route())
+		details := fmt.Sprintf("%d bytes in %s", entry.Size, entry.Duration.Round(time.Second))
+		if entry.Error != "" {
+			details = entry.Error
+		}
+		line += " " + style(m.theme.Muted)(details)
+		if i == m.selected {
+			line = style(m.theme.Active)(">") + " " + line
+		} else {
+			line = "  " + line
+		}
+		view += pad + line + "\n"
+	}
+	if m.copied != "" {
+		view += "\n" + pad + style(m.theme.Muted)("Copied "+m.copied) + "\n"
+	}
+	return view + "\n" + indent(m.help.View(m.keys), pad) + "\n"
+}
+
+// route describes the transfer as "source -> destination", the remote path preceded by its host.
+func (e HistoryEntry) route() string {
+	if e.Direction == Upload {
+		return e.Source + " -> " + e.Host + " " + e.Destination
+	}
+	return e.Host + " " + e.Source + " -> " + e.Destination
+}
@@ -0,0 +1,106 @@
+package scp
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	h, err := LoadHistory(path)
+	if err != nil || len(h.Entries) != 0 {
+		t.Fatalf("LoadHistory of a missing file returned %d entries, %v", len(h.Entries), err)
+	}
+	for i := 0; i < MaxHistoryEntries+5; i++ {
+		h.Entries = append(h.Entries, HistoryEntry{Source: "old"})
+	}
+	entry := HistoryEntry{Direction: Upload, Host: "deploy@web1:22", Source: "build/app", Destination: "/opt/app", Size: 42, Started: time.Unix(1700000000, 0).UTC(), Tags: Tags{"env": "prod"}}
+	if err := h.Add(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Entries) != MaxHistoryEntries {
+		t.Errorf("the history kept %d entries, want MaxHistoryEntries", len(loaded.Entries))
+	}
+	if last := loaded.Entries[len(loaded.Entries)-1]; last.Host != entry.Host || last.Destination != entry.Destination || !last.Started.Equal(entry.Started) || last.Tags["env"] != "prod" {
+		t.Errorf("the last entry was read back as %+v", last)
+	}
+
+	reversed := entry.Reverse()
+	if reversed.Direction != Download || reversed.Source != "/opt/app" || reversed.Destination != "build/app" {
+		t.Errorf("Reverse returned %+v", reversed)
+	}
+	for query, want := range map[string]bool{"WEB1": true, "opt/app": true, "env=prod": true, "db1": false} {
+		if entry.Matches(query) != want {
+			t.Errorf("Matches(%q) = %v", query, !want)
+		}
+	}
+}
+
+func TestHistoryModel(t *testing.T) {
+	keys := newKeyMap()
+	keys.Quit.SetKeys("esc", "ctrl+c")
+	keys.ScrollUp.SetKeys("up")
+	keys.ScrollDown.SetKeys("down")
+	keys.Accept.SetEnabled(true)
+	keys.Reverse.SetEnabled(true)
+	input := textinput.New()
+	input.Focus()
+	entries := []HistoryEntry{
+		{Direction: Upload, Host: "web1", Source: "a", Destination: "/srv/a"},
+		{Direction: Download, Host: "db1", Source: "/var/dump", Destination: "dump"},
+		{Direction: Upload, Host: "web2", Source: "b", Destination: "/srv/b"},
+	}
+	var m tea.Model = historyModel{entries: entries, matches: entries, input: input, keys: keys, help: newHelp(DefaultTheme()), theme: DefaultTheme()}
+
+	// Searching narrows the list down, and the selection follows.
+	m = update(m, runes("w"), runes("e"), runes("b"), tea.KeyMsg{Type: tea.KeyDown})
+	if got := m.(historyModel); len(got.matches) != 2 || got.selected != 1 {
+		t.Fatalf("searching for web matched %d entries and selected the %d.", len(got.matches), got.selected)
+	}
+	picked := update(m, tea.KeyMsg{Type: tea.KeyEnter}).(historyModel).picked
+	if picked == nil || picked.Host != "web2" || picked.Direction != Upload {
+		t.Errorf("enter picked %+v", picked)
+	}
+	picked = update(m, tea.KeyMsg{Type: tea.KeyCtrlR}).(historyModel).picked
+	if picked == nil || picked.Host != "web2" || picked.Direction != Download || picked.Source != "/srv/b" {
+		t.Errorf("ctrl+r picked %+v, want the reverse", picked)
+	}
+	if picked := update(m, tea.KeyMsg{Type: tea.KeyEsc}).(historyModel).picked; picked != nil {
+		t.Errorf("esc picked %+v", picked)
+	}
+}
+
+func TestHistoryLocation(t *testing.T) {
+	tests := []struct {
+		entry HistoryEntry
+		want  string
+	}{
+		{HistoryEntry{Direction: Upload, Host: "deploy@web1:22", Source: "app", Destination: "/opt/app"}, "deploy@web1:/opt/app"},
+		{HistoryEntry{Direction: Download, Host: "web1", Source: "logs/app.log", Destination: "app.log"}, "web1:logs/app.log"},
+		{HistoryEntry{Direction: Upload, Host: "deploy@[::1]:22", Destination: "/opt/app"}, "deploy@[::1]:/opt/app"},
+		{HistoryEntry{Direction: Upload, Host: "deploy@web1:2222", Destination: "/opt/my app"}, "scp://deploy@web1:2222//opt/my%20app"},
+		{HistoryEntry{Direction: Download, Host: "[::1]:2222", Source: "notes.txt"}, "scp://[::1]:2222/notes.txt"},
+	}
+	for _, test := range tests {
+		if got := test.entry.Location(); got != test.want {
+			t.Errorf("Location of %+v = %q, want %q", test.entry, got, test.want)
+		}
+	}
+
+	keys := newKeyMap()
+	keys.Copy.SetEnabled(true)
+	entries := []HistoryEntry{tests[0].entry}
+	m := update(historyModel{entries: entries, matches: entries, keys: keys, theme: DefaultTheme()}, tea.KeyMsg{Type: tea.KeyCtrlY}).(historyModel)
+	if m.copied != tests[0].want || m.picked != nil {
+		t.Errorf("ctrl+y copied %q and picked %+v", m.copied, m.picked)
+	}
+}
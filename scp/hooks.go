@@ -0,0 +1,184 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Hook a command run around a transfer, such as `systemctl restart app` on the remote after
+// an upload. Local commands run with `sh -c`, or `cmd /C` on Windows, remote ones through the
+// shell of the user on the remote. Both get the transfer in their environment, see Job.Env.
+type Hook struct {
+	Command string `json:"command"`
+
+	// Remote runs the command on the remote instead of locally.
+	Remote bool `json:"remote,omitempty"`
+}
+
+func (h Hook) String() string {
+	if h.Remote {
+		return "remote hook " + strconv.Quote(h.Command)
+	}
+	return "local hook " + strconv.Quote(h.Command)
+}
+
+// Env returns the environment variables describing the job to its hooks:
+//
+//	SCP_DIRECTION    upload or download
+//	SCP_HOST         the host of the job
+//	SCP_SOURCE       the source of the job
+//	SCP_DESTINATION  the destination of the job
+//	SCP_LOCAL_PATH   the local one of both
+//	SCP_REMOTE_PATH  the remote one of both
+//	SCP_SIZE         the size of the file in bytes
+//	SCP_CHECKSUM     the hex encoded SHA-256 of the file, once the job is done
+//	SCP_STATUS       the status of the job
+//	SCP_TAG_<KEY>    the value of every tag, with its key in upper case and other
+//	                 characters than letters and digits replaced by _
+func (j *Job) Env() []string {
+	local, remote := j.Source, j.Destination
+	if j.Direction == Download {
+		local, remote = remote, local
+	}
+	env := []string{
+		"SCP_DIRECTION=" + string(j.Direction),
+		"SCP_HOST=" + j.Host,
+		"SCP_SOURCE=" + j.Source,
+		"SCP_DESTINATION=" + j.Destination,
+		"SCP_LOCAL_PATH=" + local,
+		"SCP_REMOTE_PATH=" + remote,
+		"SCP_SIZE=" + strconv.FormatInt(j.Size, 10),
+		"SCP_CHECKSUM=" + j.Checksum,
+		"SCP_STATUS=" + string(j.Status),
+	}
+
+	keys := make([]string, 0, len(j.Tags))
+	for key := range j.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, "SCP_TAG_"+envName(key)+"="+j.Tags[key])
+	}
+	return env
+}
+
+// envName turns key into the upper case name of an environment variable.
+func envName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// RunHook runs the command of the hook with the variables of env, as returned by Job.Env, added to its
+// environment. A command exiting with an error fails with ErrHookFailed, together with its output.
+func (a *Client) RunHook(ctx context.Context, hook Hook, env []string) error {
+	var out []byte
+	var err error
+	if hook.Remote {
+		out, err = a.runRemoteHook(ctx, hook.Command, env)
+	} else {
+		out, err = runLocalHook(ctx, hook.Command, env)
+	}
+
+	output := strings.TrimSpace(string(out))
+	if err != nil {
+		if output != "" {
+			return fmt.Errorf("%w: %s: %v: %s", ErrHookFailed, hook, err, output)
+		}
+		return fmt.Errorf("%w: %s: %v", ErrHookFailed, hook, err)
+	}
+	a.logf(ctx, LogInfo, "ran %s", hook)
+	if output != "" {
+		a.logf(ctx, LogInfo, "%s", output)
+	}
+	return nil
+}
+
+func runLocalHook(ctx context.Context, command string, env []string) ([]byte, error) {
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.CombinedOutput()
+}
+
+// shellCommand returns the local command running command with `sh -c`, or `cmd /C` on Windows.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// runRemoteHook runs command on the remote after exporting env, since servers rarely accept
+// environment variables sent along with the session.
+func (a *Client) runRemoteHook(ctx context.Context, command string, env []string) ([]byte, error) {
+	var script strings.Builder
+	for _, variable := range env {
+		name, value, _ := strings.Cut(variable, "=")
+		fmt.Fprintf(&script, "export %s=%s; ", name, ShellQuote(value))
+	}
+	script.WriteString(command)
+
+	session, release, err := a.newSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Both are copied by goroutines of their own.
+	var out syncBuffer
+	session.Stdout = &out
+	session.Stderr = &out
+	if err := session.Start(script.String()); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		terminate(session)
+		<-done
+		return out.Bytes(), context.Cause(ctx)
+	}
+	return out.Bytes(), err
+}
+
+// syncBuffer a bytes.Buffer safe for concurrent writes.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
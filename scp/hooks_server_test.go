@@ -0,0 +1,107 @@
+package scp_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"main/scp"
+)
+
+// hookJob returns an upload job of a file with the given contents, added to a queue of its own.
+func hookJob(t *testing.T, dir string, contents string) (*scp.Queue, *scp.Job) {
+	t.Helper()
+	source := filepath.Join(dir, "source")
+	if err := os.WriteFile(source, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q, err := scp.LoadQueue(filepath.Join(dir, "queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &scp.Job{Direction: scp.Upload, Host: "web1", Source: source, Destination: filepath.Join(dir, "destination"), Size: int64(len(contents))}
+	if err := q.Add(job); err != nil {
+		t.Fatal(err)
+	}
+	return q, job
+}
+
+func TestAfterHooks(t *testing.T) {
+	client := newTestClient(t, nil)
+	dir := t.TempDir()
+	q, job := hookJob(t, dir, "hello")
+	local, remote := filepath.Join(dir, "local.env"), filepath.Join(dir, "remote.env")
+	job.Tags = scp.Tags{"ticket-id": "OPS-1"}
+	job.After = []scp.Hook{
+		{Command: `echo "$SCP_DIRECTION $SCP_LOCAL_PATH $SCP_REMOTE_PATH $SCP_SIZE $SCP_STATUS" > ` + local},
+		// The shell server runs remote commands locally.
+		{Command: `echo "$SCP_CHECKSUM $SCP_TAG_TICKET_ID" > ` + remote, Remote: true},
+	}
+
+	if err := client.RunJob(context.Background(), q, job); err != nil {
+		t.Fatalf("RunJob returned %v", err)
+	}
+	want := map[string]string{
+		local: "upload " + job.Source + " " + job.Destination + " 5 done",
+		// The SHA-256 of hello.
+		remote: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824 OPS-1",
+	}
+	for file, want := range want {
+		if got, err := os.ReadFile(file); err != nil || strings.TrimSpace(string(got)) != want {
+			t.Errorf("the hook wrote %q, %v, want %q", got, err, want)
+		}
+	}
+
+	// A failing hook is reported, but the transfer is done.
+	q, job = hookJob(t, t.TempDir(), "hello")
+	job.After = []scp.Hook{{Command: "echo restart failed; exit 3", Remote: true}, {Command: "touch " + local + ".not"}}
+	err := client.RunJob(context.Background(), q, job)
+	if !errors.Is(err, scp.ErrHookFailed) || !strings.Contains(err.Error(), "restart failed") {
+		t.Errorf("RunJob with a failing hook returned %v, want ErrHookFailed with its output", err)
+	}
+	if job.Status != scp.JobDone {
+		t.Errorf("the job is %s after its hook failed, want done", job.Status)
+	}
+	if _, err := os.Stat(local + ".not"); !os.IsNotExist(err) {
+		t.Errorf("the hook after the failing one ran: %v", err)
+	}
+}
+
+func TestBeforeHooks(t *testing.T) {
+	client := newTestClient(t, nil)
+	dir := t.TempDir()
+	q, job := hookJob(t, dir, "hello")
+	marker := filepath.Join(dir, "quiesced")
+	// Runs before the destination is written.
+	job.Before = []scp.Hook{{Command: `test ! -e "$SCP_DESTINATION" && touch ` + marker, Remote: true}}
+	if err := client.RunJob(context.Background(), q, job); err != nil {
+		t.Fatalf("RunJob returned %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("the hook did not run before the transfer: %v", err)
+	}
+
+	// One failing aborts the job before anything is transferred, and every time it runs.
+	q, job = hookJob(t, t.TempDir(), "hello")
+	after := filepath.Join(dir, "after")
+	job.Before = []scp.Hook{{Command: "echo database busy >&2; exit 1"}}
+	job.After = []scp.Hook{{Command: "touch " + after}}
+	for run := 0; run < 2; run++ {
+		err := client.RunJob(context.Background(), q, job)
+		if !errors.Is(err, scp.ErrHookFailed) || !strings.Contains(err.Error(), "database busy") {
+			t.Errorf("RunJob with a failing hook returned %v, want ErrHookFailed with its output", err)
+		}
+		if job.Status != scp.JobFailed {
+			t.Errorf("the job is %s after its hook failed, want failed", job.Status)
+		}
+	}
+	if _, err := os.Stat(job.Destination); !os.IsNotExist(err) {
+		t.Errorf("the job was transferred after its hook failed: %v", err)
+	}
+	if _, err := os.Stat(after); !os.IsNotExist(err) {
+		t.Errorf("the hooks after the job ran: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort the port of SSH servers.
+const DefaultPort = 22
+
+// ParseHost parses a host given as `host`, `host:port`, `[::1]:2222`, a bare IPv6 address such as `::1`,
+// each optionally prefixed with `user@`, into the address to dial and the user, which is empty when it
+// is not part of the host. Hosts without a port get defaultPort.
+func ParseHost(s string, defaultPort int) (address string, user string, err error) {
+	if at := strings.LastIndex(s, "@"); at >= 0 {
+		user, s = s[:at], s[at+1:]
+	}
+
+	host, port := s, ""
+	switch {
+	case strings.HasPrefix(s, "["):
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return "", "", fmt.Errorf("host %q is missing a closing bracket", s)
+		}
+		host = s[1:end]
+		if rest := s[end+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return "", "", fmt.Errorf("unexpected %q after the address in host %q", rest, s)
+			}
+			port = rest[1:]
+		}
+	case strings.Count(s, ":") == 1:
+		host, port, _ = strings.Cut(s, ":")
+	case strings.Count(s, ":") > 1:
+		if _, err := netip.ParseAddr(s); err != nil {
+			return "", "", fmt.Errorf("host %q is not a valid IPv6 address, use [address]:port to add a port", s)
+		}
+	}
+
+	if host == "" {
+		return "", "", fmt.Errorf("host %q is missing a host name", s)
+	}
+	if port == "" {
+		port = strconv.Itoa(defaultPort)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", "", fmt.Errorf("host %q has an invalid port %q", s, port)
+	}
+
+	return net.JoinHostPort(host, port), user, nil
+}
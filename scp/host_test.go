@@ -0,0 +1,47 @@
+package scp
+
+import "testing"
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		address string
+		user    string
+	}{
+		{"example.com", "example.com:22", ""},
+		{"example.com:2222", "example.com:2222", ""},
+		{"example.com:", "example.com:22", ""},
+		{"bram@example.com", "example.com:22", "bram"},
+		{"bram@example.com:2222", "example.com:2222", "bram"},
+		{"bram@corp@example.com", "example.com:22", "bram@corp"},
+		{"10.0.0.1", "10.0.0.1:22", ""},
+		{"::1", "[::1]:22", ""},
+		{"fe80::1%eth0", "[fe80::1%eth0]:22", ""},
+		{"[::1]", "[::1]:22", ""},
+		{"[::1]:2222", "[::1]:2222", ""},
+		{"bram@[2001:db8::1]:2222", "[2001:db8::1]:2222", "bram"},
+	}
+	for _, test := range tests {
+		address, user, err := ParseHost(test.host, DefaultPort)
+		if err != nil || address != test.address || user != test.user {
+			t.Errorf("ParseHost(%q) = %q, %q, %v, want %q, %q", test.host, address, user, err, test.address, test.user)
+		}
+	}
+
+	for _, host := range []string{
+		"",
+		"bram@",
+		":22",
+		"example.com:ssh",
+		"example.com:0",
+		"example.com:65536",
+		"[::1",
+		"[::1]2222",
+		"[]:22",
+		"not:an:address",
+	} {
+		if address, _, err := ParseHost(host, DefaultPort); err == nil {
+			t.Errorf("ParseHost(%q) = %q, want an error", host, address)
+		}
+	}
+}
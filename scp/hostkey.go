@@ -0,0 +1,95 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+)
+
+// PromptHostKey returns a function asking in the terminal whether the key of an unknown host is trusted,
+// showing its SHA256 fingerprint like OpenSSH does. It returns ErrHostKeyRejected unless the key is accepted,
+// and is meant to be passed to auth.KnownHosts, which records accepted keys.
+func PromptHostKey(theme Theme) func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	theme = theme.withDefaults()
+	return func(hostname string, remote net.Addr, hostKey ssh.PublicKey) error {
+		keys := newKeyMap()
+		keys.Accept.SetKeys("y")
+		keys.Accept.SetHelp("y", "trust and connect")
+		keys.Accept.SetEnabled(true)
+		keys.Quit.SetKeys("n", "esc", "ctrl+c")
+		keys.Quit.SetHelp("n", "reject")
+		keys.ToggleLog.SetEnabled(false)
+		keys.ScrollUp.SetEnabled(false)
+		keys.ScrollDown.SetEnabled(false)
+		keys.Help.SetEnabled(false)
+
+		result, err := tea.NewProgram(hostKeyModel{
+			hostname: hostname,
+			remote:   remote,
+			key:      hostKey,
+			keys:     keys,
+			help:     newHelp(theme),
+			theme:    theme,
+		}).Run()
+		if err != nil {
+			return err
+		}
+		if !result.(hostKeyModel).accepted {
+			return fmt.Errorf("%w: %s", ErrHostKeyRejected, hostname)
+		}
+		return nil
+	}
+}
+
+// hostKeyModel asks whether to trust the key of an unknown host.
+type hostKeyModel struct {
+	hostname string
+	remote   net.Addr
+	key      ssh.PublicKey
+	accepted bool
+
+	keys  keyMap
+	help  help.Model
+	theme Theme
+}
+
+func (m hostKeyModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m hostKeyModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Accept):
+			m.accepted = true
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+func (m hostKeyModel) View() string {
+	pad := strings.Repeat(" ", padding)
+	host := m.hostname
+	if m.remote != nil && m.remote.String() != m.hostname {
+		host = fmt.Sprintf("%s (%s)", m.hostname, m.remote)
+	}
+	return "\n" +
+		pad + style(m.theme.Active)(fmt.Sprintf("The authenticity of host %s can't be established.", host)) + "\n" +
+		pad + fmt.Sprintf("%s key fingerprint is %s.", m.key.Type(), ssh.FingerprintSHA256(m.key)) + "\n" +
+		pad + "Are you sure you want to continue connecting?" + "\n\n" +
+		indent(m.help.View(m.keys), pad) + "\n"
+}
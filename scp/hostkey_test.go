@@ -0,0 +1,55 @@
+package scp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostKeyModel(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := newKeyMap()
+	keys.Accept.SetKeys("y")
+	keys.Accept.SetEnabled(true)
+	keys.Quit.SetKeys("n", "esc", "ctrl+c")
+	prompt := hostKeyModel{
+		hostname: "example.com:22",
+		remote:   &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22},
+		key:      hostKey,
+		keys:     keys,
+		help:     newHelp(DefaultTheme()),
+		theme:    DefaultTheme(),
+	}
+
+	view := prompt.View()
+	for _, want := range []string{"example.com:22 (192.0.2.1:22)", "ssh-ed25519 key fingerprint is " + ssh.FingerprintSHA256(hostKey)} {
+		if !strings.Contains(view, want) {
+			t.Errorf("the prompt does not show %q:\n%s", want, view)
+		}
+	}
+
+	tests := map[string]tea.KeyMsg{
+		"y":   runes("y"),
+		"n":   runes("n"),
+		"esc": {Type: tea.KeyEsc},
+		"x":   runes("x"),
+	}
+	for name, msg := range tests {
+		m, cmd := prompt.Update(msg)
+		if accepted := m.(hostKeyModel).accepted; accepted != (name == "y") || (cmd == nil) != (name == "x") {
+			t.Errorf("pressing %s accepted the key: %v, quit: %v", name, accepted, cmd != nil)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+//go:build integration
+
+// The integration tests run against OpenSSH in a container started with testcontainers, so they need
+// Docker. Run them with:
+//
+//	go test -tags integration -run Integration ./scp
+
+package scp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	tcwait "github.com/testcontainers/testcontainers-go/wait"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshdImage the OpenSSH server the tests run against, the one scp/tests/run_all.sh uses.
+const sshdImage = "panubo/sshd:1.6.0"
+
+var sshd struct {
+	once      sync.Once
+	container testcontainers.Container
+	addr      string
+	err       error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if sshd.container != nil {
+		sshd.container.Terminate(context.Background())
+	}
+	os.Exit(code)
+}
+
+// startSSHD starts the container on first use, shared by every test, with the user "bram"
+// whose password is "test".
+func startSSHD(t *testing.T) string {
+	sshd.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		sshd.container, sshd.err = testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+			ContainerRequest: testcontainers.ContainerRequest{
+				Image:        sshdImage,
+				ExposedPorts: []string{"22/tcp"},
+				Env: map[string]string{
+					"SSH_USERS":                "bram:1000:1000",
+					"SSH_ENABLE_PASSWORD_AUTH": "true",
+				},
+				Files: []testcontainers.ContainerFile{{
+					HostFilePath:      filepath.Join("tests", "entrypoint.d", "setpasswd.sh"),
+					ContainerFilePath: "/etc/entrypoint.d/setpasswd.sh",
+					FileMode:          0755,
+				}},
+				WaitingFor: tcwait.ForListeningPort("22/tcp").WithStartupTimeout(2 * time.Minute),
+			},
+			Started: true,
+		})
+		if sshd.err != nil {
+			return
+		}
+		host, err := sshd.container.Host(ctx)
+		if err != nil {
+			sshd.err = err
+			return
+		}
+		port, err := sshd.container.MappedPort(ctx, "22/tcp")
+		if err != nil {
+			sshd.err = err
+			return
+		}
+		sshd.addr = host + ":" + port.Port()
+	})
+	if sshd.err != nil {
+		t.Fatalf("failed to start %s: %v", sshdImage, sshd.err)
+	}
+	return sshd.addr
+}
+
+// integrationClient returns a client connected to the container.
+func integrationClient(t *testing.T, configure func(c *ClientConfigurer)) *Client {
+	configurer := NewConfigurer(startSSHD(t), &ssh.ClientConfig{
+		User:            "bram",
+		Auth:            []ssh.AuthMethod{ssh.Password("test")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         30 * time.Second,
+	})
+	if configure != nil {
+		configure(configurer)
+	}
+	client := configurer.Create()
+	if err := client.ConnectRetry(context.Background(), DefaultRetryPolicy); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return &client
+}
+
+// remoteDir creates an empty directory of the test in the container.
+func remoteDir(t *testing.T, client *Client) string {
+	dir := "/tmp/" + strings.NewReplacer("/", "-", " ", "-").Replace(t.Name())
+	if _, err := client.runOutput(context.Background(), "rm -rf "+ShellQuote(dir)+" && mkdir -p "+ShellQuote(dir)); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// remote runs the script in the container and returns its output without the trailing newline.
+func remote(t *testing.T, client *Client, script string) string {
+	out, err := client.runOutput(context.Background(), script)
+	if err != nil {
+		t.Fatalf("%s: %v", script, err)
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+func TestIntegrationUploadDownload(t *testing.T) {
+	client := integrationClient(t, nil)
+	ctx := context.Background()
+	remotePath := remoteDir(t, client) + "/upload.txt"
+
+	contents := "It Works\n"
+	if err := client.Copy(ctx, strings.NewReader(contents), remotePath, "0640", int64(len(contents))); err != nil {
+		t.Fatal(err)
+	}
+	if mode := remote(t, client, "stat -c %a "+remotePath); mode != "640" {
+		t.Errorf("uploaded with mode %s, want 640", mode)
+	}
+
+	var buf bytes.Buffer
+	fileInfos, err := client.CopyFromRemoteWithOptions(ctx, &buf, remotePath, DownloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != contents || fileInfos.Mode() != 0640 {
+		t.Errorf("downloaded %q with mode %v", buf.String(), fileInfos.Mode())
+	}
+}
+
+func TestIntegrationPreserveTimes(t *testing.T) {
+	client := integrationClient(t, nil)
+	ctx := context.Background()
+	remotePath := remoteDir(t, client) + "/preserved.txt"
+
+	local := filepath.Join(t.TempDir(), "preserved.txt")
+	if err := os.WriteFile(local, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := os.Chtimes(local, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := client.CopyFromFilePreserve(ctx, *f, remotePath, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := remote(t, client, "stat -c %Y "+remotePath); got != strconv.FormatInt(mtime.Unix(), 10) {
+		t.Errorf("remote modification time %s, want %d", got, mtime.Unix())
+	}
+
+	fileInfos, err := client.CopyFromRemoteWithOptions(ctx, io.Discard, remotePath, DownloadOptions{PreserveTimes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileInfos.Mtime != mtime.Unix() || fileInfos.Mode() != 0600 {
+		t.Errorf("downloaded %+v, want the time and mode of the upload", fileInfos)
+	}
+}
+
+// patternReader yields n bytes of a repeating pattern, so large files need no memory.
+type patternReader struct{ n, off int64 }
+
+func (p *patternReader) Read(b []byte) (int, error) {
+	if p.off >= p.n {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > p.n-p.off {
+		b = b[:p.n-p.off]
+	}
+	for i := range b {
+		b[i] = byte((p.off + int64(i)) % 251)
+	}
+	p.off += int64(len(b))
+	return len(b), nil
+}
+
+func TestIntegrationLargeFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("transfers 512 MiB")
+	}
+	client := integrationClient(t, nil)
+	ctx := context.Background()
+	remotePath := remoteDir(t, client) + "/large.bin"
+	size := int64(512 << 20)
+
+	want := sha256.New()
+	if _, err := io.Copy(want, &patternReader{n: size}); err != nil {
+		t.Fatal(err)
+	}
+	wantSum := hex.EncodeToString(want.Sum(nil))
+
+	if err := client.Copy(ctx, &patternReader{n: size}, remotePath, "0644", size); err != nil {
+		t.Fatal(err)
+	}
+	if sum := remote(t, client, "sha256sum "+remotePath+" | cut -d' ' -f1"); sum != wantSum {
+		t.Errorf("remote checksum %s, want %s", sum, wantSum)
+	}
+
+	got := sha256.New()
+	if _, err := client.CopyFromRemoteWithOptions(ctx, got, remotePath, DownloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if sum := hex.EncodeToString(got.Sum(nil)); sum != wantSum {
+		t.Errorf("downloaded checksum %s, want %s", sum, wantSum)
+	}
+}
+
+func TestIntegrationWeirdFilenames(t *testing.T) {
+	client := integrationClient(t, nil)
+	ctx := context.Background()
+	dir := remoteDir(t, client)
+
+	for _, name := range []string{
+		"Exöt1ç download file.txt.txt",
+		"with 'single' and \"double\" quotes",
+		"$(touch pwned); `id` & ;|*?",
+		"-leading-dash",
+		"trailing space ",
+		"tab\there",
+		"日本語.txt",
+	} {
+		remotePath := dir + "/" + name
+		if err := client.Copy(ctx, strings.NewReader(name), remotePath, "0644", int64(len(name))); err != nil {
+			t.Errorf("upload of %q failed: %v", name, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if _, err := client.CopyFromRemoteWithOptions(ctx, &buf, remotePath, DownloadOptions{}); err != nil || buf.String() != name {
+			t.Errorf("download of %q = %q, %v", name, buf.String(), err)
+		}
+	}
+	if out := remote(t, client, "ls -A "+ShellQuote(dir)+" | grep -c pwned || true"); out != "0" {
+		t.Error("a file name was run by the remote shell")
+	}
+
+	if err := client.Copy(ctx, strings.NewReader("x"), dir+"/new\nline", "0644", 1); !errors.Is(err, ErrInvalidFilename) {
+		t.Errorf("upload of a name with a newline returned %v, want ErrInvalidFilename", err)
+	}
+}
+
+func TestIntegrationFailures(t *testing.T) {
+	client := integrationClient(t, nil)
+	ctx := context.Background()
+	dir := remoteDir(t, client)
+
+	_, err := client.CopyFromRemoteWithOptions(ctx, io.Discard, dir+"/missing", DownloadOptions{})
+	if !errors.Is(err, ErrRemoteNotFound) {
+		t.Errorf("download of a missing file returned %v, want ErrRemoteNotFound", err)
+	}
+
+	err = client.Copy(ctx, strings.NewReader("x"), "/root/denied.txt", "0644", 1)
+	if !errors.Is(err, ErrRemotePermissionDenied) {
+		t.Errorf("upload into /root returned %v, want ErrRemotePermissionDenied", err)
+	}
+
+	err = client.Copy(ctx, strings.NewReader("short"), dir+"/short.txt", "0644", 100)
+	if err == nil {
+		t.Error("upload of a reader shorter than its size succeeded")
+	}
+
+	missing := integrationClient(t, func(c *ClientConfigurer) { c.RemoteBinary("/nonexistent/scp") })
+	err = missing.Copy(ctx, strings.NewReader("x"), dir+"/x.txt", "0644", 1)
+	if !errors.Is(err, ErrRemoteBinaryMissing) {
+		t.Errorf("upload with a missing remote binary returned %v, want ErrRemoteBinaryMissing", err)
+	}
+}
+
+func TestIntegrationKilledMidTransfer(t *testing.T) {
+	client := integrationClient(t, nil)
+	ctx := context.Background()
+	remotePath := remoteDir(t, client) + "/killed.bin"
+	size := int64(256 << 20)
+	if _, err := client.runOutput(ctx, "head -c 268435456 /dev/zero > "+remotePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// The download stalls on the pipe until the remote scp was killed.
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.CopyFromRemoteWithOptions(ctx, pw, remotePath, DownloadOptions{})
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	if _, err := io.CopyN(io.Discard, pr, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := sshd.container.Exec(ctx, []string{"pkill", "-KILL", "-f", "scp -f " + remotePath}); err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(io.Discard, pr)
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("download of %d bytes succeeded although the remote was killed", size)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("download did not end after the remote was killed")
+	}
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	_, err := client.CopyFromRemoteWithOptions(cancelCtx, &slowWriter{}, remotePath, DownloadOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled download returned %v, want context.Canceled", err)
+	}
+}
+
+// slowWriter accepts writes slowly, so transfers are still running when they are cancelled.
+type slowWriter struct{}
+
+func (slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(10 * time.Millisecond)
+	return len(p), nil
+}
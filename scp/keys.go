@@ -0,0 +1,100 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// keyMap the key bindings of the terminal interfaces. The help view is generated from it,
+// bindings an interface does not support are disabled and thereby left out.
+type keyMap struct {
+	Quit       key.Binding
+	ToggleLog  key.Binding
+	ScrollUp   key.Binding
+	ScrollDown key.Binding
+	Help       key.Binding
+	Complete   key.Binding
+	Accept     key.Binding
+	Reverse    key.Binding
+	Copy       key.Binding
+}
+
+func newKeyMap() keyMap {
+	return keyMap{
+		Quit: key.NewBinding(
+			key.WithKeys("q", "ctrl+c"),
+			key.WithHelp("q", "cancel"),
+		),
+		ToggleLog: key.NewBinding(
+			key.WithKeys("l"),
+			key.WithHelp("l", "toggle log"),
+		),
+		ScrollUp: key.NewBinding(
+			key.WithKeys("up", "k"),
+			key.WithHelp("↑/k", "scroll log up"),
+		),
+		ScrollDown: key.NewBinding(
+			key.WithKeys("down", "j"),
+			key.WithHelp("↓/j", "scroll log down"),
+		),
+		Help: key.NewBinding(
+			key.WithKeys("?"),
+			key.WithHelp("?", "toggle help"),
+		),
+		// Only used by input fields.
+		Complete: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "complete path"),
+			key.WithDisabled(),
+		),
+		Accept: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "accept"),
+			key.WithDisabled(),
+		),
+		// Only used by the history.
+		Reverse: key.NewBinding(
+			key.WithKeys("ctrl+r"),
+			key.WithHelp("ctrl+r", "run reversed"),
+			key.WithDisabled(),
+		),
+		Copy: key.NewBinding(
+			key.WithKeys("ctrl+y"),
+			key.WithHelp("ctrl+y", "copy remote path"),
+			key.WithDisabled(),
+		),
+	}
+}
+
+// ShortHelp the bindings shown at the bottom of the interface.
+func (k keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Complete, k.Accept, k.Reverse, k.Copy, k.Quit, k.ToggleLog, k.Help}
+}
+
+// FullHelp the bindings shown when the help is expanded with "?".
+func (k keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Complete, k.Accept, k.Reverse, k.Copy, k.Quit, k.Help},
+		{k.ToggleLog, k.ScrollUp, k.ScrollDown},
+	}
+}
+
+// newHelp returns a help view drawn in the muted color of the theme.
+func newHelp(theme Theme) help.Model {
+	h := help.New()
+	muted := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted))
+	h.Styles.ShortKey = muted.Copy().Bold(true)
+	h.Styles.ShortDesc = muted
+	h.Styles.ShortSeparator = muted
+	h.Styles.FullKey = muted.Copy().Bold(true)
+	h.Styles.FullDesc = muted
+	h.Styles.FullSeparator = muted
+	return h
+}
@@ -0,0 +1,79 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LogLevel the severity of a LogEntry.
+type LogLevel int
+
+const (
+	LogInfo LogLevel = iota
+	LogWarning
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogWarning:
+		return "warning"
+	case LogError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// LogEntry an event of a Client, such as connecting, detecting the remote or a stalled transfer.
+type LogEntry struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+
+	// Tags the tags of the transfer the event belongs to, see WithTags.
+	Tags Tags
+}
+
+func (e LogEntry) String() string {
+	s := fmt.Sprintf("%s %-7s %s", e.Time.Format("15:04:05"), e.Level, e.Message)
+	if len(e.Tags) > 0 {
+		s += " [" + e.Tags.String() + "]"
+	}
+	return s
+}
+
+// Logger receives the events of a Client, it must not block. Concurrent transfers call it from
+// their own goroutines, possibly at the same time.
+type Logger func(entry LogEntry)
+
+type loggerKey struct{}
+
+// withLogger returns a context whose events are also sent to logger, on top of the Logger of
+// the client. The terminal interfaces use it to show the events of their transfer.
+func withLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// logf sends an event to the Logger of the client and to the one of the context, if any.
+func (a *Client) logf(ctx context.Context, level LogLevel, format string, args ...any) {
+	ctxLogger, _ := ctx.Value(loggerKey{}).(Logger)
+	if a.Logger == nil && ctxLogger == nil {
+		return
+	}
+
+	entry := LogEntry{Time: time.Now(), Level: level, Message: fmt.Sprintf(format, args...), Tags: TagsFromContext(ctx)}
+	if a.Logger != nil {
+		a.Logger(entry)
+	}
+	if ctxLogger != nil {
+		ctxLogger(entry)
+	}
+}
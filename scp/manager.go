@@ -0,0 +1,180 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ConnectionManager shares authenticated SSH connections between clients, similar to
+// OpenSSH's ControlMaster. Clients created for the same host and user reuse a single
+// connection, and its session pool, instead of performing a handshake each.
+// The connection is closed once every client using it has been closed.
+type ConnectionManager struct {
+	// MaxSessionsPerHost bounds the amount of sessions open at the same time to a host,
+	// over all connections to it such as those of different users, to stay below the
+	// MaxSessions and MaxStartups limits of its sshd. Jobs beyond it wait for a session
+	// to close. It must be set before the first client is created, zero imposes no bound.
+	MaxSessionsPerHost int
+
+	mu    sync.Mutex
+	conns map[string]*sharedConn
+	hosts map[string]*sessionPool
+}
+
+// sharedConn a connection shared between clients, together with the amount of clients using it.
+// While the first client dials it, dialing is open and sshClient nil.
+type sharedConn struct {
+	sshClient *ssh.Client
+	sessions  *sessionPool
+	refs      int
+	dialing   chan struct{}
+}
+
+// NewConnectionManager returns an empty ConnectionManager.
+func NewConnectionManager() *ConnectionManager {
+	return &ConnectionManager{conns: map[string]*sharedConn{}, hosts: map[string]*sessionPool{}}
+}
+
+// Client returns a connected client configured by the given configurer. A new connection is only
+// established when no client is connected to the same host as the same user yet.
+// Closing the client releases its share of the connection.
+func (m *ConnectionManager) Client(c *ClientConfigurer) (Client, error) {
+	return m.ClientContext(context.Background(), c)
+}
+
+// ClientContext is the same as Client, but gives up connecting once the context is done.
+// Clients of other hosts are not held up meanwhile, those of the same host wait for the
+// connection being established and try themselves when it fails.
+func (m *ConnectionManager) ClientContext(ctx context.Context, c *ClientConfigurer) (Client, error) {
+	key := connectionKey(c.host, c.clientConfig)
+	client := c.Create()
+
+	m.mu.Lock()
+	conn, ok := m.conns[key]
+	for ok && conn.dialing != nil {
+		dialing := conn.dialing
+		m.mu.Unlock()
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return Client{}, context.Cause(ctx)
+		}
+		m.mu.Lock()
+		conn, ok = m.conns[key]
+	}
+	if !ok {
+		conn = &sharedConn{sessions: newSessionPool(c.maxSessions), dialing: make(chan struct{})}
+		m.conns[key] = conn
+		m.mu.Unlock()
+
+		sshClient, err := client.dial(ctx)
+
+		m.mu.Lock()
+		close(conn.dialing)
+		conn.dialing = nil
+		switch {
+		case m.conns[key] != conn:
+			// Closed by Close while dialing.
+			err = ErrNotConnected
+			if sshClient != nil {
+				sshClient.Close()
+			}
+		case err != nil:
+			delete(m.conns, key)
+		}
+		if err != nil {
+			m.mu.Unlock()
+			return Client{}, err
+		}
+		conn.sshClient = sshClient
+		go m.evict(key, conn)
+	}
+	defer m.mu.Unlock()
+	conn.refs++
+
+	hostSessions, ok := m.hosts[c.host]
+	if !ok {
+		hostSessions = newSessionPool(m.MaxSessionsPerHost)
+		m.hosts[c.host] = hostSessions
+	}
+
+	client.hostSessions = hostSessions
+	client.setConn(conn.sshClient, &releaseSharedConn{manager: m, key: key, conn: conn})
+	client.sessions = conn.sessions
+	return client, nil
+}
+
+// evict forgets the connection once it is gone, such as when the remote dropped it, so the
+// next client dials a new one instead of getting the dead one.
+func (m *ConnectionManager) evict(key string, conn *sharedConn) {
+	conn.sshClient.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conns[key] == conn {
+		delete(m.conns, key)
+	}
+}
+
+// Close closes every connection managed, regardless of clients still using them.
+func (m *ConnectionManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, conn := range m.conns {
+		// Connections still being dialed are closed by the client dialing them.
+		if conn.sshClient != nil {
+			conn.sshClient.Close()
+		}
+		delete(m.conns, key)
+	}
+}
+
+// release drops a reference to the connection and closes it when it was the last one. Connections
+// closed by Close or evicted are not managed anymore, releasing them does nothing.
+func (m *ConnectionManager) release(key string, conn *sharedConn) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conns[key] != conn {
+		return nil
+	}
+	conn.refs--
+	if conn.refs > 0 {
+		return nil
+	}
+	delete(m.conns, key)
+	return CloseSSHCLient{sshClient: conn.sshClient}.Close()
+}
+
+func connectionKey(host string, config *ssh.ClientConfig) string {
+	if config == nil {
+		return host
+	}
+	return config.User + "@" + host
+}
+
+// Close handler releasing a client's share of a managed connection,
+// only the first call releases it.
+type releaseSharedConn struct {
+	manager *ConnectionManager
+	key     string
+	conn    *sharedConn
+	once    sync.Once
+}
+
+func (r *releaseSharedConn) Close() error {
+	var err error
+	r.once.Do(func() {
+		err = r.manager.release(r.key, r.conn)
+	})
+	return err
+}
@@ -0,0 +1,186 @@
+package scp_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"main/scp"
+	"main/scp/scptest"
+)
+
+// recordingDialer dials with a net.Dialer once gate, when set, is closed, and keeps the connections.
+type recordingDialer struct {
+	gate  chan struct{}
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (d *recordingDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if d.gate != nil {
+		select {
+		case <-d.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+	if err == nil {
+		d.mu.Lock()
+		d.conns = append(d.conns, conn)
+		d.mu.Unlock()
+	}
+	return conn, err
+}
+
+func (d *recordingDialer) dials() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+func managedClient(t *testing.T, m *scp.ConnectionManager, c *scp.ClientConfigurer) scp.Client {
+	t.Helper()
+	client, err := m.Client(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func upload(t *testing.T, client scp.Client) {
+	t.Helper()
+	if err := client.CopyFile(context.Background(), strings.NewReader("x"), "file", "0644"); err != nil {
+		t.Fatalf("CopyFile over the managed connection returned %v", err)
+	}
+}
+
+func TestConnectionManagerDialsOutsideTheLock(t *testing.T) {
+	m := scp.NewConnectionManager()
+	defer m.Close()
+	slow, fast := scptest.NewServer(t), scptest.NewServer(t)
+	dialer := &recordingDialer{gate: make(chan struct{})}
+
+	var wg sync.WaitGroup
+	clients := make([]scp.Client, 3)
+	errs := make([]error, len(clients))
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i], errs[i] = m.Client(slow.Configurer().Dialer(dialer))
+		}(i)
+	}
+
+	// The clients of another host do not wait for the slow dial.
+	done := make(chan error, 1)
+	go func() {
+		client, err := m.Client(fast.Configurer())
+		if err == nil {
+			client.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a client of another host waited for the dial of the first")
+	}
+
+	// Nor do those giving up.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := m.ClientContext(ctx, slow.Configurer().Dialer(dialer)); err == nil {
+		t.Error("ClientContext returned a client before the connection was established")
+	}
+
+	close(dialer.gate)
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clients[i].Close()
+	}
+	if n := dialer.dials(); n != 1 {
+		t.Errorf("dialed %d connections for clients of the same host, want one", n)
+	}
+	upload(t, clients[2])
+}
+
+func TestConnectionManagerEvictsDeadConnections(t *testing.T) {
+	m := scp.NewConnectionManager()
+	defer m.Close()
+	server := scptest.NewServer(t)
+	dialer := &recordingDialer{}
+	configure := func() *scp.ClientConfigurer { return server.Configurer().Dialer(dialer) }
+
+	managedClient(t, m, configure())
+	dialer.mu.Lock()
+	dialer.conns[0].Close()
+	dialer.mu.Unlock()
+
+	// The dead connection is forgotten once its client noticed, which happens in the background.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client := managedClient(t, m, configure())
+		if dialer.dials() == 2 {
+			upload(t, client)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the dead connection was handed out again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnectionManagerReleaseAfterClose(t *testing.T) {
+	m := scp.NewConnectionManager()
+	defer m.Close()
+	server := scptest.NewServer(t)
+
+	before := managedClient(t, m, server.Configurer())
+	m.Close()
+	after := managedClient(t, m, server.Configurer())
+
+	// Releasing the connection closed by Close leaves the new one alone.
+	before.Close()
+	upload(t, after)
+}
+
+func TestMaxSessionsPerHost(t *testing.T) {
+	server, most := concurrencyServer(t)
+	m := scp.NewConnectionManager()
+	m.MaxSessionsPerHost = 3
+	defer m.Close()
+
+	// Clients of different users have connections of their own, which share the bound of the host.
+	config := server.ClientConfig()
+	config.User = "other"
+	clients := []scp.Client{
+		managedClient(t, m, server.Configurer()),
+		managedClient(t, m, scp.NewConfigurer(server.Addr, config)),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(client scp.Client) {
+			defer wg.Done()
+			if _, err := client.Ping(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}(clients[i%2])
+	}
+	wg.Wait()
+	if n := most(); n != 3 {
+		t.Errorf("ran %d sessions at once on the host, want MaxSessionsPerHost", n)
+	}
+}
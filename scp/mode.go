@@ -0,0 +1,102 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// DefaultFileMode the permissions of uploads from readers given no permissions, see ParseMode.
+const DefaultFileMode fs.FileMode = 0644
+
+// FormatMode formats the permissions of mode, including the setuid, setgid and sticky bits, as the
+// four octal digits the SCP protocol and chmod expect, such as "0644" for 0644 or "4755" for
+// fs.ModeSetuid|0755. The type bits of mode are ignored.
+func FormatMode(mode fs.FileMode) string {
+	return fmt.Sprintf("%04o", unixMode(mode))
+}
+
+// ParseMode parses the octal permissions taken by the copy functions, such as "0644" or "755", and
+// returns them as an fs.FileMode. Anything that is not an octal number up to 07777, such as "0955"
+// or "rw-r--r--", results in an error matching ErrInvalidPermissions.
+func ParseMode(permissions string) (fs.FileMode, error) {
+	digits := strings.TrimPrefix(permissions, "0o")
+	if digits == "" || len(digits) > 5 {
+		return 0, fmt.Errorf("%w %q, expected octal like 0644", ErrInvalidPermissions, permissions)
+	}
+	octal, err := strconv.ParseUint(digits, 8, 32)
+	if err != nil || octal > 07777 {
+		return 0, fmt.Errorf("%w %q, expected octal like 0644", ErrInvalidPermissions, permissions)
+	}
+	return fileMode(uint32(octal)), nil
+}
+
+// Mode returns the permissions announced by the remote as an fs.FileMode, see FormatMode.
+func (fileInfos *FileInfos) Mode() fs.FileMode {
+	return fileMode(fileInfos.Permissions)
+}
+
+// uploadPermissions validates the permissions of an upload and formats them as FormatMode does,
+// so "644" is sent as "0644". Empty permissions default to fallback.
+func uploadPermissions(permissions string, fallback fs.FileMode) (string, error) {
+	if permissions == "" {
+		return FormatMode(fallback), nil
+	}
+	mode, err := ParseMode(permissions)
+	if err != nil {
+		return permissions, err
+	}
+	return FormatMode(mode), nil
+}
+
+// unixMode the permission bits of mode as laid out by chmod.
+func unixMode(mode fs.FileMode) uint32 {
+	octal := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		octal |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		octal |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		octal |= 01000
+	}
+	return octal
+}
+
+// fileMode the fs.FileMode of the permission bits laid out by chmod, the reverse of unixMode.
+func fileMode(octal uint32) fs.FileMode {
+	mode := fs.FileMode(octal).Perm()
+	if octal&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if octal&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if octal&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// CopyWithMode copies the contents of an io.Reader to a remote location like CopyPassThru, taking the
+// permissions of the remote file as an fs.FileMode, such as 0644 or info.Mode() of a local file.
+func (a *Client) CopyWithMode(
+	ctx context.Context,
+	r io.Reader,
+	remotePath string,
+	mode fs.FileMode,
+	size int64,
+	passThru PassThru,
+) error {
+	return a.CopyPassThru(ctx, r, remotePath, FormatMode(mode), size, passThru)
+}
@@ -0,0 +1,83 @@
+package scp
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := map[string]fs.FileMode{
+		"0644":  0644,
+		"644":   0644,
+		"0o755": 0755,
+		"0":     0,
+		"4755":  fs.ModeSetuid | 0755,
+		"2770":  fs.ModeSetgid | 0770,
+		"1777":  fs.ModeSticky | 0777,
+		"00600": 0600,
+	}
+	for permissions, want := range tests {
+		mode, err := ParseMode(permissions)
+		if err != nil || mode != want {
+			t.Errorf("ParseMode(%q) = %v, %v, want %v", permissions, mode, err, want)
+		}
+	}
+
+	for _, permissions := range []string{"", "0955", "rw-r--r--", "-644", "+644", "17777", "0x1ff", "6_44"} {
+		if _, err := ParseMode(permissions); !errors.Is(err, ErrInvalidPermissions) {
+			t.Errorf("ParseMode(%q) returned %v, want ErrInvalidPermissions", permissions, err)
+		}
+	}
+}
+
+func TestFormatMode(t *testing.T) {
+	tests := map[fs.FileMode]string{
+		0644:                   "0644",
+		0:                      "0000",
+		fs.ModeDir | 0755:      "0755",
+		fs.ModeSetuid | 0755:   "4755",
+		fs.ModeSetgid | 0750:   "2750",
+		fs.ModeSticky | 0777:   "1777",
+		fs.ModeSymlink | 0o777: "0777",
+		fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky | 0777: "7777",
+	}
+	for mode, want := range tests {
+		if got := FormatMode(mode); got != want {
+			t.Errorf("FormatMode(%v) = %s, want %s", mode, got, want)
+		}
+		if mode&(fs.ModeDir|fs.ModeSymlink) != 0 {
+			continue
+		}
+		if parsed, err := ParseMode(want); err != nil || parsed != mode {
+			t.Errorf("ParseMode(FormatMode(%v)) = %v, %v", mode, parsed, err)
+		}
+	}
+}
+
+func TestUploadPermissions(t *testing.T) {
+	if got, err := uploadPermissions("", 0600); err != nil || got != "0600" {
+		t.Errorf("uploadPermissions without permissions = %q, %v, want the fallback", got, err)
+	}
+	if got, err := uploadPermissions("755", 0600); err != nil || got != "0755" {
+		t.Errorf("uploadPermissions(\"755\") = %q, %v, want 0755", got, err)
+	}
+	if _, err := uploadPermissions("0644; rm -rf /", 0600); !errors.Is(err, ErrInvalidPermissions) {
+		t.Errorf("uploadPermissions of a command returned %v, want ErrInvalidPermissions", err)
+	}
+}
+
+func TestParseFileInfosOctalPermissions(t *testing.T) {
+	for _, record := range []string{"C0644 3 file\n", "C644 3 file\n"} {
+		fileInfos := NewFileInfos()
+		if err := ParseFileInfos(record, fileInfos); err != nil {
+			t.Fatal(err)
+		}
+		if fileInfos.Mode() != 0644 {
+			t.Errorf("ParseFileInfos(%q) has the mode %v, want 0644", record, fileInfos.Mode())
+		}
+	}
+	if err := ParseFileInfos("C0989 3 file\n", NewFileInfos()); err == nil {
+		t.Error("ParseFileInfos accepted the permissions 0989")
+	}
+}
@@ -0,0 +1,95 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// PartSuffix the suffix of the file a download is written to until it is complete.
+const PartSuffix = ".part"
+
+// PartialPolicy decides what happens to the .part file of a download that failed midway.
+type PartialPolicy int
+
+const (
+	// PartialDelete removes the incomplete file, the default.
+	PartialDelete PartialPolicy = iota
+
+	// PartialKeep keeps the incomplete file, so the download can be resumed from it.
+	PartialKeep
+)
+
+// CopyFromRemoteToPath downloads a remote file to `localPath`. Unless DirectDownloads is set it is
+// written to `localPath` with PartSuffix appended and only renamed to `localPath` once complete,
+// so an existing file is never left half overwritten. A failed download is cleaned up according to
+// PartialPolicy. The returned FileInfos describe the remote file.
+func (a *Client) CopyFromRemoteToPath(ctx context.Context, remotePath string, localPath string, opts DownloadOptions) (*FileInfos, error) {
+	f, err := a.createPartFile(localPath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfos, err := a.CopyFromRemoteWithOptions(ctx, f, remotePath, opts)
+	if err := f.finish(err, a.PartialPolicy); err != nil {
+		return nil, err
+	}
+	return fileInfos, nil
+}
+
+// partPath the path a download to localPath is written to until it is complete.
+func partPath(localPath string) string {
+	return localPath + PartSuffix
+}
+
+// partFile the local file a download is written to, under a temporary name unless direct.
+type partFile struct {
+	*os.File
+	path   string
+	direct bool
+}
+
+// createPartFile creates the file a download to localPath is written to, truncating it unless
+// the download resumes. It is the .part file of localPath unless DirectDownloads is set.
+func (a *Client) createPartFile(localPath string, resume bool) (*partFile, error) {
+	name := localPath
+	if !a.DirectDownloads {
+		name = partPath(localPath)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY | os.O_CREATE
+	}
+	f, err := os.OpenFile(name, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &partFile{File: f, path: localPath, direct: a.DirectDownloads}, nil
+}
+
+// finish closes the file and, when the download succeeded, moves it to its final path.
+// When it failed, the .part file is removed or kept according to policy.
+func (f *partFile) finish(err error, policy PartialPolicy) error {
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	switch {
+	case f.direct:
+		return err
+	case err == nil:
+		return os.Rename(f.Name(), f.path)
+	case policy == PartialKeep:
+		return err
+	default:
+		return errors.Join(err, os.Remove(f.Name()))
+	}
+}
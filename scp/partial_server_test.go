@@ -0,0 +1,80 @@
+package scp_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"main/scp"
+)
+
+// interruptedDownload is a remote scp sending 5 of the 10 bytes of a file before it dies, once
+// they were received.
+const interruptedDownload = `
+printf 'C0644 10 file\n'
+printf 'hello'
+sleep 0.2
+exit 1
+`
+
+func TestCopyFromRemoteToPath(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	remote, local := filepath.Join(dir, "remote"), filepath.Join(dir, "local")
+	if err := os.WriteFile(remote, []byte("new contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(name)
+		return err == nil
+	}
+	contents := func(name string) string {
+		b, _ := os.ReadFile(name)
+		return string(b)
+	}
+
+	client := newTestClient(t, nil)
+	if err := os.WriteFile(local, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CopyFromRemoteToPath(ctx, remote, local, scp.DownloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if contents(local) != "new contents" || exists(local+scp.PartSuffix) {
+		t.Errorf("the download left %q and a .part file: %v", contents(local), exists(local+scp.PartSuffix))
+	}
+
+	// A failed download leaves the existing file alone.
+	if err := os.WriteFile(local, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	failing := newTestClient(t, func(c *scp.ClientConfigurer) { c.RemoteBinary(fakeRemoteBinary(t, interruptedDownload)) })
+	if _, err := failing.CopyFromRemoteToPath(ctx, remote, local, scp.DownloadOptions{}); err == nil {
+		t.Fatal("the interrupted download succeeded")
+	}
+	if contents(local) != "old" || exists(local+scp.PartSuffix) {
+		t.Errorf("the failed download left %q and a .part file: %v", contents(local), exists(local+scp.PartSuffix))
+	}
+
+	keeping := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.RemoteBinary(fakeRemoteBinary(t, interruptedDownload)).PartialPolicy(scp.PartialKeep)
+	})
+	if _, err := keeping.CopyFromRemoteToPath(ctx, remote, local, scp.DownloadOptions{}); err == nil {
+		t.Fatal("the interrupted download succeeded")
+	}
+	if contents(local) != "old" || contents(local+scp.PartSuffix) != "hello" {
+		t.Errorf("the failed download left %q and %q in the .part file, want it kept for resuming", contents(local), contents(local+scp.PartSuffix))
+	}
+	os.Remove(local + scp.PartSuffix)
+
+	direct := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.RemoteBinary(fakeRemoteBinary(t, interruptedDownload)).DirectDownloads(true)
+	})
+	if _, err := direct.CopyFromRemoteToPath(ctx, remote, local, scp.DownloadOptions{}); err == nil {
+		t.Fatal("the interrupted download succeeded")
+	}
+	if contents(local) != "hello" || exists(local+scp.PartSuffix) {
+		t.Errorf("the direct download left %q and a .part file: %v", contents(local), exists(local+scp.PartSuffix))
+	}
+}
@@ -0,0 +1,91 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// PathData the values the placeholders of a path expanded with ExpandPath refer to,
+// such as `/backups/{{.Date}}/{{.Hostname}}/db.dump`.
+type PathData struct {
+	// Now the time of the transfer, for layouts of its own as in `{{.Now.Format "2006/01"}}`.
+	Now time.Time
+
+	// Date and Time of Now, formatted as 2006-01-02 and 150405.
+	Date string
+	Time string
+
+	// Hostname the name of the local machine.
+	Hostname string
+
+	// Host and User the remote end of the transfer.
+	Host string
+	User string
+
+	// Basename the last element of the source, Ext its extension including the dot.
+	Basename string
+	Ext      string
+
+	// Tags the tags of the transfer, as in `{{.Tags.env}}`.
+	Tags Tags
+}
+
+// NewPathData returns the values describing a transfer from source to host at now.
+// Host is of the form "[user@]host[:port]", source is a local path for uploads and
+// a remote one for downloads.
+func NewPathData(direction Direction, host string, source string, tags Tags, now time.Time) PathData {
+	hostname, _ := os.Hostname()
+	user, host, found := strings.Cut(host, "@")
+	if !found {
+		user, host = "", user
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	base := path.Base(source)
+	if direction == Upload {
+		base = filepath.Base(source)
+	}
+	return PathData{
+		Now:      now,
+		Date:     now.Format("2006-01-02"),
+		Time:     now.Format("150405"),
+		Hostname: hostname,
+		Host:     host,
+		User:     user,
+		Basename: base,
+		Ext:      path.Ext(base),
+		Tags:     tags,
+	}
+}
+
+// ExpandPath replaces the placeholders of p, written as Go templates such as `{{.Date}}`, with
+// the values of data. Paths without placeholders are returned as they are. Placeholders
+// referring to missing tags fail rather than leaving part of the path empty.
+func ExpandPath(p string, data PathData) (string, error) {
+	if !strings.Contains(p, "{{") {
+		return p, nil
+	}
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(p)
+	if err != nil {
+		return "", fmt.Errorf("path %q: %w", p, err)
+	}
+	var expanded strings.Builder
+	if err := tmpl.Execute(&expanded, data); err != nil {
+		return "", fmt.Errorf("path %q: %w", p, err)
+	}
+	return expanded.String(), nil
+}
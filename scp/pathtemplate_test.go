@@ -0,0 +1,43 @@
+package scp
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExpandPath(t *testing.T) {
+	now := time.Date(2024, 3, 9, 14, 5, 7, 0, time.UTC)
+	upload := NewPathData(Upload, "bram@example.com:2222", "/var/db/prod.dump", Tags{"env": "prod"}, now)
+	download := NewPathData(Download, "example.com", "/srv/logs/app.log.gz", nil, now)
+	hostname, _ := os.Hostname()
+
+	tests := []struct {
+		path string
+		data PathData
+		want string
+	}{
+		{"/backups/db.dump", upload, "/backups/db.dump"},
+		{"/backups/{{.Date}}/{{.Time}}", upload, "/backups/2024-03-09/140507"},
+		{`/backups/{{.Now.Format "2006/01"}}/`, upload, "/backups/2024/03/"},
+		{"/{{.User}}@{{.Host}}/{{.Basename}}", upload, "/bram@example.com/prod.dump"},
+		{"{{.Basename}}{{.Ext}}", download, "app.log.gz.gz"},
+		{"/{{.Tags.env}}/{{.Hostname}}", upload, "/prod/" + hostname},
+		{"/{{.User}}x", download, "/x"},
+	}
+	for _, test := range tests {
+		if got, err := ExpandPath(test.path, test.data); err != nil || got != test.want {
+			t.Errorf("ExpandPath(%q) = %q, %v, want %q", test.path, got, err, test.want)
+		}
+	}
+
+	for _, path := range []string{
+		"/{{.Tags.missing}}/db.dump",
+		"/{{.Unknown}}",
+		"/{{.Date",
+	} {
+		if got, err := ExpandPath(path, upload); err == nil {
+			t.Errorf("ExpandPath(%q) = %q, want an error", path, got)
+		}
+	}
+}
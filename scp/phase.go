@@ -0,0 +1,206 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"golang.org/x/crypto/ssh"
+)
+
+// phase a step between launching a transfer and its first byte, shown next to a spinner
+// as these may take several seconds on far away hosts.
+type phase int
+
+const (
+	phaseResolve phase = iota
+	phaseConnect
+	phaseHandshake
+	phaseVerify
+	phaseSession
+	phaseStart
+)
+
+func (p phase) String() string {
+	switch p {
+	case phaseResolve:
+		return "resolving"
+	case phaseConnect:
+		return "connecting to"
+	case phaseHandshake:
+		return "exchanging keys with"
+	case phaseVerify:
+		return "verifying the host key of"
+	case phaseSession:
+		return "opening a session on"
+	default:
+		return "starting the transfer on"
+	}
+}
+
+type phaseKey struct{}
+
+// withPhase returns a context reporting the phases of connecting and opening sessions to report.
+func withPhase(ctx context.Context, report func(phase)) context.Context {
+	return context.WithValue(ctx, phaseKey{}, report)
+}
+
+// reportPhase reports p to the function of the context, if any.
+func reportPhase(ctx context.Context, p phase) {
+	if report, ok := ctx.Value(phaseKey{}).(func(phase)); ok {
+		report(p)
+	}
+}
+
+// reportingDialer returns dialer, or a net.Dialer when it is nil that reports phaseConnect
+// once the address of the host was resolved.
+func reportingDialer(dialer ContextDialer, timeout time.Duration) ContextDialer {
+	if dialer != nil {
+		return dialer
+	}
+	return &net.Dialer{
+		Timeout: timeout,
+		ControlContext: func(ctx context.Context, network, address string, c syscall.RawConn) error {
+			reportPhase(ctx, phaseConnect)
+			return nil
+		},
+	}
+}
+
+// reportingConfig returns a copy of config reporting phaseVerify before the host key is checked,
+// or config itself when the context reports no phases.
+func reportingConfig(ctx context.Context, config *ssh.ClientConfig) *ssh.ClientConfig {
+	if _, ok := ctx.Value(phaseKey{}).(func(phase)); !ok || config.HostKeyCallback == nil {
+		return config
+	}
+	reporting := *config
+	reporting.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		reportPhase(ctx, phaseVerify)
+		return config.HostKeyCallback(hostname, remote, key)
+	}
+	return &reporting
+}
+
+type phaseMsg phase
+
+// connectModel shows a spinner with the phase of connecting to host. It quits once the host key
+// is about to be verified, as verifying it and authenticating may ask questions in the terminal.
+type connectModel struct {
+	spinner spinner.Model
+	host    string
+	phase   phase
+	done    bool
+	theme   Theme
+}
+
+func (m connectModel) Init() tea.Cmd {
+	return m.spinner.Tick
+}
+
+func (m connectModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case phaseMsg:
+		m.phase = phase(msg)
+		if m.phase >= phaseVerify {
+			m.done = true
+			return m, tea.Quit
+		}
+		return m, nil
+
+	case progressDoneMsg:
+		m.done = true
+		return m, tea.Quit
+
+	case spinner.TickMsg:
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+
+	default:
+		return m, nil
+	}
+}
+
+func (m connectModel) View() string {
+	// Leave nothing behind once connected.
+	if m.done {
+		return ""
+	}
+	return strings.Repeat(" ", padding) + m.spinner.View() + " " + style(m.theme.Muted)(m.phase.String()+" "+m.host+" ...") + "\n"
+}
+
+// newSpinner returns the spinner shown while connecting and until the first byte of a transfer.
+func newSpinner(theme Theme) spinner.Model {
+	return spinner.New(spinner.WithSpinner(spinner.MiniDot), spinner.WithStyle(lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Active))))
+}
+
+// runConnectProgress runs connect while showing a spinner with its phase in the terminal.
+// The terminal is left to connect once it verifies the host key, to let it ask about unknown
+// hosts and passwords. Interrupting the spinner cancels the context handed to connect.
+func runConnectProgress(ctx context.Context, theme Theme, host string, connect func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	theme = theme.withDefaults()
+	// The spinner reads no input, which may be a password piped to the standard input.
+	p := tea.NewProgram(connectModel{spinner: newSpinner(theme), host: host, theme: theme}, tea.WithInput(nil))
+	exited := make(chan struct{})
+	ctx = withPhase(ctx, func(ph phase) {
+		p.Send(phaseMsg(ph))
+		if ph >= phaseVerify {
+			<-exited
+		}
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		err := connect(ctx)
+		p.Send(progressDoneMsg{})
+		done <- err
+	}()
+
+	result, runErr := p.Run()
+	close(exited)
+	if runErr != nil || !result.(connectModel).done {
+		// Interrupted before connecting.
+		cancel()
+	}
+	return <-done
+}
+
+// ConnectProgress is the same as ConnectContext but shows a spinner with the current step of
+// connecting in the terminal, until the host key is verified. Only the terminal interface
+// of ProgressOutput shows it.
+func (a *Client) ConnectProgress(ctx context.Context) error {
+	if a.ProgressOutput.resolve() != ProgressTerminal {
+		return a.ConnectContext(ctx)
+	}
+	return runConnectProgress(ctx, a.Theme, connectionKey(a.Host, a.ClientConfig), a.ConnectContext)
+}
+
+// ClientProgress is the same as ClientContext but shows a spinner with the current step of
+// connecting in the terminal, until the host key is verified. Clients sharing an existing
+// connection are returned right away. Only the terminal interface of ProgressOutput shows it.
+func (m *ConnectionManager) ClientProgress(ctx context.Context, c *ClientConfigurer) (Client, error) {
+	if c.output.resolve() != ProgressTerminal {
+		return m.ClientContext(ctx, c)
+	}
+	var client Client
+	err := runConnectProgress(ctx, c.theme, connectionKey(c.host, c.clientConfig), func(ctx context.Context) error {
+		var err error
+		client, err = m.ClientContext(ctx, c)
+		return err
+	})
+	return client, err
+}
@@ -0,0 +1,42 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Ping checks that the connection is alive by running `true` in a new session on the remote, and
+// returns how long that took. This is one round trip to open the session and one to run the
+// command, so it tells whether transfers can be started as well. It gives up once the context
+// is done, so a deadline bounds the wait for a connection that went silent, which callers may
+// take as the cue to reconnect.
+func (a *Client) Ping(ctx context.Context) (time.Duration, error) {
+	if _, err := a.connected(ctx); err != nil {
+		return 0, fmt.Errorf("scp: ping %s: %w", a.Host, err)
+	}
+
+	started := time.Now()
+	// Opening a session does not take a context, waiting for it is given up on instead.
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.runOutput(ctx, "true")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return 0, fmt.Errorf("scp: ping %s: %w", a.Host, err)
+		}
+		return time.Since(started), nil
+	case <-ctx.Done():
+		return 0, fmt.Errorf("scp: ping %s: %w", a.Host, context.Cause(ctx))
+	}
+}
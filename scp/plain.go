@@ -0,0 +1,145 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// plainInterval how often a line with the progress is printed by ProgressPlain.
+const plainInterval = 5 * time.Second
+
+// ProgressOutput how the functions rendering progress, such as CopyToRemoteProgress, show it.
+type ProgressOutput int
+
+const (
+	// ProgressAuto renders the terminal interface when the standard output is a terminal,
+	// and prints plain lines like ProgressPlain otherwise, such as when it is piped or logged by CI.
+	ProgressAuto ProgressOutput = iota
+
+	// ProgressTerminal always renders the terminal interface.
+	ProgressTerminal
+
+	// ProgressPlain prints a line with the progress every few seconds and one once the
+	// transfer finished, without escape codes redrawing the screen.
+	ProgressPlain
+
+	// ProgressNone shows nothing.
+	ProgressNone
+)
+
+func (o ProgressOutput) String() string {
+	switch o {
+	case ProgressAuto:
+		return "auto"
+	case ProgressTerminal:
+		return "terminal"
+	case ProgressPlain:
+		return "plain"
+	case ProgressNone:
+		return "none"
+	default:
+		return "ProgressOutput(" + strconv.Itoa(int(o)) + ")"
+	}
+}
+
+// resolve returns the output ProgressAuto stands for, or o itself.
+func (o ProgressOutput) resolve() ProgressOutput {
+	if o != ProgressAuto {
+		return o
+	}
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		return ProgressTerminal
+	}
+	return ProgressPlain
+}
+
+// textProgress prints the progress of a transfer as a line every interval.
+type textProgress struct {
+	w        io.Writer
+	prefix   string
+	interval time.Duration
+
+	total int64
+	done  int64
+	files int
+	index int
+	name  string
+
+	started time.Time
+	printed time.Time
+}
+
+func newTextProgress(w io.Writer, prefix string, interval time.Duration) *textProgress {
+	now := time.Now()
+	return &textProgress{w: w, prefix: prefix, interval: interval, total: -1, started: now, printed: now}
+}
+
+func (t *textProgress) Start(totalBytes int64, totalFiles int) {
+	t.total = totalBytes
+	t.files = totalFiles
+}
+
+func (t *textProgress) File(name string, _ int64) {
+	t.index++
+	t.name = displayName(name)
+}
+
+func (t *textProgress) Add(n int64) {
+	t.done += n
+	if time.Since(t.printed) >= t.interval {
+		t.printed = time.Now()
+		fmt.Fprintf(t.w, "%s%s %s%s, %s\n", t.prefix, t.fileLine(), sizes(t.done, t.total), t.percent(), t.speed())
+	}
+}
+
+// finish prints the outcome of the transfer.
+func (t *textProgress) finish(err error) {
+	if err != nil {
+		fmt.Fprintf(t.w, "%sfailed after %s: %v\n", t.prefix, formatBytes(t.done), err)
+		return
+	}
+	fmt.Fprintf(t.w, "%sdone, %s in %s, %s\n", t.prefix, formatBytes(t.done), time.Since(t.started).Round(time.Millisecond), t.speed())
+}
+
+func (t *textProgress) fileLine() string {
+	switch {
+	case t.files == 1:
+		return t.name
+	case t.files < 0:
+		return fmt.Sprintf("(%d) %s", t.index, t.name)
+	default:
+		return fmt.Sprintf("(%d/%d) %s", t.index, t.files, t.name)
+	}
+}
+
+func (t *textProgress) percent() string {
+	if t.total <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%.0f%%)", ratio(t.done, t.total)*100)
+}
+
+func (t *textProgress) speed() string {
+	return fmt.Sprintf("%.1f MB/s", float64(t.done)/time.Since(t.started).Seconds()/1e6)
+}
+
+// runPlainProgress runs the transfer described by label, printing its progress to w as plain lines.
+func runPlainProgress(ctx context.Context, w io.Writer, label transferLabel, transfer func(ctx context.Context, progress Progress) error) error {
+	fmt.Fprintln(w, label)
+	progress := newTextProgress(w, "  ", plainInterval)
+	err := transfer(ctx, progress)
+	progress.finish(err)
+	return err
+}
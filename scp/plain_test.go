@@ -0,0 +1,63 @@
+package scp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := newTextProgress(&buf, "  ", 0)
+	p.Start(3<<10, 2)
+	p.File("a", 1<<10)
+	p.Add(1 << 10)
+	p.File("b", 2<<10)
+	p.Add(2 << 10)
+	p.finish(nil)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("printed %q, want a line for every write and one once done", lines)
+	}
+	if !strings.HasPrefix(lines[0], "  (1/2) a 1.0 KiB / 3.0 KiB (33%), ") || !strings.HasPrefix(lines[1], "  (2/2) b 3.0 KiB / 3.0 KiB (100%), ") {
+		t.Errorf("printed the progress as %q", lines[:2])
+	}
+	if !strings.HasPrefix(lines[2], "  done, 3.0 KiB in ") {
+		t.Errorf("printed the end as %q", lines[2])
+	}
+	if strings.Contains(buf.String(), "\x1b") {
+		t.Errorf("printed escape codes: %q", buf.String())
+	}
+
+	// Lines are printed at most every interval.
+	buf.Reset()
+	p = newTextProgress(&buf, "", time.Hour)
+	p.Start(-1, -1)
+	p.File("c", 10)
+	p.Add(10)
+	p.finish(errors.New("connection lost"))
+	if got := buf.String(); got != "failed after 10 B: connection lost\n" {
+		t.Errorf("printed %q, want only the failure", got)
+	}
+}
+
+func TestRunPlainProgress(t *testing.T) {
+	var buf bytes.Buffer
+	label := transferLabel{direction: Upload, name: "file", host: "example.com:22"}
+	err := runPlainProgress(context.Background(), &buf, label, func(ctx context.Context, progress Progress) error {
+		progress.Start(5, 1)
+		progress.File("file", 5)
+		progress.Add(5)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.HasPrefix(got, "↑ file → example.com:22\n  done, 5 B in ") {
+		t.Errorf("printed %q, want the label and the outcome", got)
+	}
+}
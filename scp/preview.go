@@ -0,0 +1,144 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pkg/sftp"
+)
+
+// DefaultPreviewSize the amount of bytes Preview reads when no size is given.
+const DefaultPreviewSize = 16 * 1024
+
+// previewHeight the amount of lines of the preview pane.
+const previewHeight = 20
+
+// Preview reads at most `size` bytes from the start of the remote file, without transferring the rest of it.
+// A size of zero or less reads DefaultPreviewSize bytes. The SCP backend runs `head` on the remote.
+func (a *Client) Preview(ctx context.Context, remotePath string, size int64) ([]byte, error) {
+	if size <= 0 {
+		size = DefaultPreviewSize
+	}
+
+	backend, err := a.resolveBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if backend == BackendSFTP {
+		var head []byte
+		err := a.withSFTP(ctx, newWatchdog(), func(client *sftp.Client) error {
+			f, err := client.Open(remotePath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			head, err = io.ReadAll(io.LimitReader(f, size))
+			return err
+		})
+		return head, err
+	}
+
+	out, err := a.runOutput(ctx, fmt.Sprintf("[ -f %s ] && head -c %d %s", a.shellPath(remotePath), size, a.shellPath(remotePath)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to preview %s: %w", remotePath, err)
+	}
+	return out, nil
+}
+
+// PreviewProgress shows the head of the remote file, as read by Preview, in a scrollable pane in the terminal.
+func (a *Client) PreviewProgress(ctx context.Context, remotePath string, size int64) error {
+	head, err := a.Preview(ctx, remotePath, size)
+	if err != nil {
+		return err
+	}
+
+	theme := a.Theme.withDefaults()
+	keys := newKeyMap()
+	keys.Quit.SetHelp("q", "close")
+	keys.ToggleLog.SetEnabled(false)
+	keys.ScrollUp.SetHelp("↑/k", "scroll up")
+	keys.ScrollDown.SetHelp("↓/j", "scroll down")
+
+	pane := viewport.New(theme.Width, previewHeight)
+	pane.SetContent(previewText(head))
+
+	_, err = tea.NewProgram(previewModel{
+		name:  remotePath,
+		pane:  pane,
+		keys:  keys,
+		help:  newHelp(theme),
+		theme: theme,
+	}).Run()
+	return err
+}
+
+// previewText makes the head of a file presentable, binary content is not shown.
+func previewText(head []byte) string {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return "(binary file)"
+	}
+	// The preview may have cut a multi-byte character in half.
+	return strings.ReplaceAll(string(bytes.ToValidUTF8(head, nil)), "\t", "    ")
+}
+
+// previewModel a scrollable pane with the head of a remote file.
+type previewModel struct {
+	name  string
+	pane  viewport.Model
+	keys  keyMap
+	help  help.Model
+	theme Theme
+}
+
+func (m previewModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m previewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		case key.Matches(msg, m.keys.ScrollUp):
+			m.pane.LineUp(1)
+		case key.Matches(msg, m.keys.ScrollDown):
+			m.pane.LineDown(1)
+		}
+		return m, nil
+
+	case tea.WindowSizeMsg:
+		width := msg.Width - padding*2 - 4
+		if width > m.theme.Width {
+			width = m.theme.Width
+		}
+		m.pane.Width = width
+		m.help.Width = width
+		return m, nil
+
+	default:
+		return m, nil
+	}
+}
+
+func (m previewModel) View() string {
+	pad := strings.Repeat(" ", padding)
+	return "\n" + pad + style(m.theme.Muted)(m.name) + "\n\n" +
+		indent(m.pane.View(), pad) + "\n\n" +
+		indent(m.help.View(m.keys), pad) + "\n"
+}
@@ -0,0 +1,52 @@
+package scp_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"main/scp"
+	"main/scp/scptest"
+)
+
+func TestPreview(t *testing.T) {
+	sftpServer := scptest.NewShellServer(t)
+	sftpServer.SFTP = true
+	backends := map[string]*scp.ClientConfigurer{
+		"scp":  scptest.NewShellServer(t).Configurer(),
+		"sftp": sftpServer.Configurer().Backend(scp.BackendSFTP),
+	}
+	dir := t.TempDir()
+	contents := strings.Repeat("0123456789", 10)
+	name := filepath.Join(dir, "file")
+	if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for backend, configurer := range backends {
+		t.Run(backend, func(t *testing.T) {
+			client := configurer.Create()
+			if err := client.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			ctx := context.Background()
+
+			if head, err := client.Preview(ctx, name, 15); err != nil || string(head) != contents[:15] {
+				t.Errorf("Preview of 15 bytes returned %q, %v", head, err)
+			}
+			// The default size is more than the file has.
+			if head, err := client.Preview(ctx, name, 0); err != nil || string(head) != contents {
+				t.Errorf("Preview of the default size returned %q, %v", head, err)
+			}
+			if _, err := client.Preview(ctx, filepath.Join(dir, "missing"), 0); err == nil {
+				t.Error("Preview of a missing file succeeded")
+			}
+			if _, err := client.Preview(ctx, dir, 0); err == nil {
+				t.Error("Preview of a directory succeeded")
+			}
+		})
+	}
+}
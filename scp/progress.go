@@ -0,0 +1,463 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	padding = 2
+
+	// logHeight the amount of lines of the log pane.
+	logHeight = 8
+
+	// redrawInterval how often the bytes transferred meanwhile are shown. They are added up instead of
+	// being sent to the interface on every write, which would keep it busy on fast links.
+	redrawInterval = 100 * time.Millisecond
+
+	// sizeWidth the width kept next to a progress bar for the amount transferred, as in "1023.9 MiB / 1023.9 MiB".
+	sizeWidth = 24
+)
+
+// programOptions the options of the programs rendering the progress of transfers. The tests run
+// them without a terminal.
+var programOptions []tea.ProgramOption
+
+// Progress receives the progress of a transfer.
+type Progress interface {
+	// Start is called once before any data flows with the total amount of bytes
+	// and files to transfer. A negative total means it is unknown.
+	Start(totalBytes int64, totalFiles int)
+
+	// File is called when the transfer of a new file begins.
+	File(name string, size int64)
+
+	// Add reports that n more bytes of the current file were transferred.
+	Add(n int64)
+}
+
+// noProgress discards all progress updates.
+type noProgress struct{}
+
+func (noProgress) Start(int64, int)   {}
+func (noProgress) File(string, int64) {}
+func (noProgress) Add(int64)          {}
+
+// progressOrNop returns p, or a Progress discarding all updates when p is nil.
+func progressOrNop(p Progress) Progress {
+	if p == nil {
+		return noProgress{}
+	}
+	return p
+}
+
+// progressReader reports every read to the Progress. Its WriteTo copies through buffers of bufferSize.
+type progressReader struct {
+	r          io.Reader
+	progress   Progress
+	bufferSize int
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.progress.Add(int64(n))
+	}
+	return n, err
+}
+
+func (p *progressReader) WriteTo(w io.Writer) (int64, error) {
+	return copyThroughBuffer(w, p, p.bufferSize)
+}
+
+// progressPassThru returns a PassThru reporting the transfer of a single file to progress,
+// wrapping the reader of passThru when it is not nil. Copies go through buffers of bufferSize.
+func progressPassThru(progress Progress, name string, passThru PassThru, bufferSize int) PassThru {
+	return func(r io.Reader, total int64) io.Reader {
+		if passThru != nil {
+			r = passThru(r, total)
+		}
+		progress.Start(total, 1)
+		progress.File(name, total)
+		return &progressReader{r: r, progress: progress, bufferSize: bufferSize}
+	}
+}
+
+type startMsg struct {
+	total int64
+	files int
+}
+
+type fileMsg struct {
+	name string
+	size int64
+}
+
+type redrawMsg struct{}
+
+// redraw schedules the next redrawMsg.
+func redraw() tea.Cmd {
+	return tea.Tick(redrawInterval, func(time.Time) tea.Msg { return redrawMsg{} })
+}
+
+type progressErrMsg struct{ err error }
+
+type progressDoneMsg struct{}
+
+type logMsg LogEntry
+
+// teaProgress forwards progress updates into a running bubbletea program. The bytes
+// transferred are added to pending, which the model collects every redrawInterval.
+type teaProgress struct {
+	p       *tea.Program
+	pending *atomic.Int64
+}
+
+func (t teaProgress) Start(totalBytes int64, totalFiles int) {
+	t.p.Send(startMsg{total: totalBytes, files: totalFiles})
+}
+
+func (t teaProgress) File(name string, size int64) {
+	t.p.Send(fileMsg{name: name, size: size})
+}
+
+func (t teaProgress) Add(n int64) {
+	t.pending.Add(n)
+}
+
+// transferLabel describes what a progress bar is about: the direction, the file or
+// directory transferred, the remote host and the tags of the transfer.
+type transferLabel struct {
+	direction Direction
+	name      string
+	host      string
+	tags      Tags
+}
+
+// label returns the transferLabel of a transfer of name to or from the host of the client.
+func (a *Client) label(direction Direction, name string) transferLabel {
+	return transferLabel{direction: direction, name: name, host: connectionKey(a.Host, a.ClientConfig)}
+}
+
+// String renders the label as "↑ name → host" for uploads and "↓ name ← host" for downloads,
+// followed by the tags in brackets.
+func (l transferLabel) String() string {
+	s := "↑ " + displayName(l.name) + " → " + l.host
+	if l.direction == Download {
+		s = "↓ " + displayName(l.name) + " ← " + l.host
+	}
+	if len(l.tags) > 0 {
+		s += " [" + l.tags.String() + "]"
+	}
+	return s
+}
+
+// model renders an overall progress bar and, when more than one file is
+// transferred, a second bar for the file currently in flight. Above them a line tells
+// what is transferred from where to where, beneath them a scrollable log pane shows
+// the events of the client.
+type model struct {
+	label transferLabel
+
+	overall progress.Model
+	file    progress.Model
+
+	total int64
+	done  int64
+	files int
+	index int
+
+	name     string
+	fileSize int64
+	fileDone int64
+
+	// started when the first bytes flowed, to compute the speed. Until then a spinner
+	// shows the phase of opening the session.
+	started time.Time
+	spinner spinner.Model
+	phase   phase
+
+	// pending the bytes transferred since they were last collected.
+	pending *atomic.Int64
+
+	logs    []string
+	log     viewport.Model
+	showLog bool
+
+	keys keyMap
+	help help.Model
+
+	theme Theme
+	err   error
+}
+
+func newModel(theme Theme, label transferLabel) model {
+	theme = theme.withDefaults()
+	overall, file := theme.progressBar(), theme.progressBar()
+	overall.Width = max(10, theme.Width-sizeWidth)
+	file.Width = overall.Width
+	return model{
+		label:   label,
+		spinner: newSpinner(theme),
+		phase:   phaseSession,
+		pending: new(atomic.Int64),
+		overall: overall,
+		file:    file,
+		log:     viewport.New(theme.Width, logHeight),
+		keys:    newKeyMap(),
+		help:    newHelp(theme),
+		theme:   theme,
+		total:   -1,
+		showLog: true,
+	}
+}
+
+func (m model) Init() tea.Cmd {
+	return tea.Batch(m.spinner.Tick, redraw())
+}
+
+// collect adds the bytes transferred since the last call.
+func (m *model) collect() {
+	n := m.pending.Swap(0)
+	if n == 0 {
+		return
+	}
+	if m.started.IsZero() {
+		m.started = time.Now()
+	}
+	m.done += n
+	m.fileDone += n
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		case key.Matches(msg, m.keys.ToggleLog):
+			m.showLog = !m.showLog
+		case key.Matches(msg, m.keys.ScrollUp):
+			m.log.LineUp(1)
+		case key.Matches(msg, m.keys.ScrollDown):
+			m.log.LineDown(1)
+		}
+		return m, nil
+
+	case tea.WindowSizeMsg:
+		width := msg.Width - padding*2 - 4
+		if width > m.theme.Width {
+			width = m.theme.Width
+		}
+		m.overall.Width = max(10, width-sizeWidth)
+		m.file.Width = max(10, width-sizeWidth)
+		m.log.Width = width
+		m.help.Width = width
+		return m, nil
+
+	case logMsg:
+		// Keep following new lines unless the user scrolled up.
+		follow := m.log.AtBottom()
+		m.logs = append(m.logs, LogEntry(msg).String())
+		m.log.SetContent(strings.Join(m.logs, "\n"))
+		if follow {
+			m.log.GotoBottom()
+		}
+		return m, nil
+
+	case phaseMsg:
+		m.phase = phase(msg)
+		return m, nil
+
+	case spinner.TickMsg:
+		// Stop spinning once the data flows.
+		if !m.started.IsZero() {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+
+	case startMsg:
+		m.total = msg.total
+		m.files = msg.files
+		return m, nil
+
+	case fileMsg:
+		// The bytes of the previous file.
+		m.collect()
+		m.index++
+		m.name = displayName(msg.name)
+		m.fileSize = msg.size
+		m.fileDone = 0
+		return m, nil
+
+	case redrawMsg:
+		m.collect()
+		return m, redraw()
+
+	case progressErrMsg:
+		m.err = msg.err
+		return m, tea.Quit
+
+	case progressDoneMsg:
+		m.collect()
+		return m, tea.Quit
+
+	default:
+		return m, nil
+	}
+}
+
+func (m model) View() string {
+	if m.err != nil {
+		return "Error transferring: " + m.err.Error() + "\n"
+	}
+
+	pad := strings.Repeat(" ", padding)
+	view := "\n" + pad + style(m.theme.Active)(m.label.String()) + "\n"
+	view += pad + m.overall.ViewAs(ratio(m.done, m.total)) + " " + style(m.theme.Muted)(sizes(m.done, m.total)) + "\n"
+	if m.started.IsZero() {
+		view += pad + m.spinner.View() + " " + style(m.theme.Muted)(m.phase.String()+" "+m.label.host+" ...") + "\n"
+	} else if stats := m.stats(); stats != "" {
+		view += pad + style(m.theme.Muted)(stats) + "\n"
+	}
+	if m.files != 1 && m.index > 0 {
+		view += pad + style(m.theme.Muted)(m.fileLine()) + "\n" +
+			pad + m.file.ViewAs(ratio(m.fileDone, m.fileSize)) + " " + style(m.theme.Muted)(sizes(m.fileDone, m.fileSize)) + "\n"
+	}
+	if m.showLog && len(m.logs) > 0 {
+		view += "\n" + indent(m.log.View(), pad) + "\n"
+	}
+	return view + "\n" + indent(m.help.View(m.keys), pad) + "\n"
+}
+
+// indent prefixes every line of s with pad.
+func indent(s string, pad string) string {
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+// stats describes the speed of the transfer and, when the total is known, the time left.
+func (m model) stats() string {
+	elapsed := time.Since(m.started)
+	if m.started.IsZero() || elapsed < time.Second {
+		return ""
+	}
+
+	speed := float64(m.done) / elapsed.Seconds()
+	stats := fmt.Sprintf("%.1f MB/s", speed/1e6)
+	if m.total > 0 && speed > 0 && m.done <= m.total {
+		left := time.Duration(float64(m.total-m.done) / speed * float64(time.Second))
+		stats += fmt.Sprintf(", %s left", left.Round(time.Second))
+	}
+	return stats
+}
+
+func (m model) fileLine() string {
+	if m.files < 0 {
+		return fmt.Sprintf("(%d) %s", m.index, m.name)
+	}
+	return fmt.Sprintf("(%d/%d) %s", m.index, m.files, m.name)
+}
+
+// sizes renders the amount transferred out of the total, as in "123.4 MiB / 2.0 GiB",
+// only the amount transferred when the total is unknown (negative).
+func sizes(done int64, total int64) string {
+	if total < 0 {
+		return formatBytes(done)
+	}
+	return formatBytes(done) + " / " + formatBytes(total)
+}
+
+// formatBytes renders n bytes in binary units, such as 123.4 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for rest := n / unit; rest >= unit; rest /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ratio returns done/total clamped to [0, 1]. An empty total counts as done,
+// an unknown (negative) one as not started.
+func ratio(done int64, total int64) float64 {
+	if total == 0 {
+		return 1
+	}
+	if total < 0 {
+		return 0
+	}
+	r := float64(done) / float64(total)
+	if r > 1 {
+		return 1
+	}
+	return r
+}
+
+// runProgress runs the transfer described by label while showing its progress as set by ProgressOutput
+// and returns the error of the transfer. Quitting the interface cancels the context handed to the transfer.
+func (a *Client) runProgress(ctx context.Context, label transferLabel, transfer func(ctx context.Context, progress Progress) error) error {
+	label.tags = TagsFromContext(ctx)
+	transfer = a.withRecords(label, transfer)
+	switch a.ProgressOutput.resolve() {
+	case ProgressNone:
+		return transfer(ctx, noProgress{})
+	case ProgressPlain:
+		return runPlainProgress(ctx, os.Stdout, label, transfer)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m := newModel(a.Theme, label)
+	p := tea.NewProgram(m, programOptions...)
+	ctx = withLogger(ctx, func(entry LogEntry) { p.Send(logMsg(entry)) })
+	ctx = withPhase(ctx, func(ph phase) { p.Send(phaseMsg(ph)) })
+
+	done := make(chan error, 1)
+	go func() {
+		err := transfer(ctx, teaProgress{p: p, pending: m.pending})
+		if err != nil {
+			p.Send(progressErrMsg{err})
+		} else {
+			p.Send(progressDoneMsg{})
+		}
+		done <- err
+	}()
+
+	_, runErr := p.Run()
+	cancel()
+	// The interface only displays the error of the transfer, it always reaches the caller,
+	// also when the interface itself failed.
+	err := <-done
+	if runErr != nil {
+		return errors.Join(err, runErr)
+	}
+	return err
+}
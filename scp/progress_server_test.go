@@ -0,0 +1,125 @@
+package scp_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"main/scp"
+)
+
+// readRecords parses the lines of JSON written to Client.ProgressJSON.
+func readRecords(t *testing.T, r *bytes.Buffer) []scp.ProgressRecord {
+	t.Helper()
+	var records []scp.ProgressRecord
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var record scp.ProgressRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestUploadProgress(t *testing.T) {
+	var buf bytes.Buffer
+	client := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.ProgressOutput(scp.ProgressNone).ProgressJSON(&buf)
+	})
+	dir := t.TempDir()
+	local, remote := filepath.Join(dir, "local"), filepath.Join(dir, "remote")
+	if err := os.WriteFile(local, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	uploads := map[string]func() error{
+		"CopyToRemoteProgress": func() error {
+			return client.CopyToRemoteProgress(context.Background(), strings.NewReader("hello world"), remote, "0644", 11)
+		},
+		"CopyFromFileProgress": func() error {
+			return client.CopyFromFileProgress(context.Background(), *f, remote, "")
+		},
+	}
+	for name, upload := range uploads {
+		buf.Reset()
+		if err := upload(); err != nil {
+			t.Fatalf("%s returned %v", name, err)
+		}
+		if got, err := os.ReadFile(remote); err != nil || string(got) != "hello world" {
+			t.Errorf("%s uploaded %q, %v", name, got, err)
+		}
+
+		records := readRecords(t, &buf)
+		if len(records) < 2 {
+			t.Fatalf("%s wrote the records %+v, want it started and done", name, records)
+		}
+		first, last := records[0], records[len(records)-1]
+		if first.State != scp.ProgressStarted || first.Direction != scp.Upload || first.Name != "remote" || first.Total != 11 {
+			t.Errorf("%s started with %+v", name, first)
+		}
+		if last.State != scp.ProgressDone || last.Bytes != 11 {
+			t.Errorf("%s finished with %+v", name, last)
+		}
+	}
+}
+
+func TestProgressReturnsTransferError(t *testing.T) {
+	client := newTestClient(t, func(c *scp.ClientConfigurer) { c.ProgressOutput(scp.ProgressTerminal) })
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "local"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The interface shows the failure and quits without an error of its own.
+	err = client.CopyFromRemoteProgress(context.Background(), f, filepath.Join(dir, "missing"))
+	if err == nil || errors.Is(err, context.Canceled) {
+		t.Errorf("download of a missing file with the terminal interface returned %v, want its failure", err)
+	}
+}
+
+func TestDownloadProgressToPipe(t *testing.T) {
+	// Like cat, which writes nothing but the file to the standard output.
+	var records bytes.Buffer
+	client := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.ProgressOutput(scp.ProgressNone).ProgressJSON(&records)
+	})
+	remote := filepath.Join(t.TempDir(), "app.log")
+	contents := strings.Repeat("ERROR something failed\n", 1000)
+	if err := os.WriteFile(remote, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w := io.Pipe()
+	received := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(r)
+		received <- data
+	}()
+	err := client.CopyFromRemoteProgressPassThru(context.Background(), w, remote, nil)
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; string(got) != contents {
+		t.Errorf("the pipe received %d bytes, want only the %d of the file", len(got), len(contents))
+	}
+	if last := readRecords(t, &records); len(last) == 0 || last[len(last)-1].State != scp.ProgressDone {
+		t.Errorf("the records of the download are %+v", last)
+	}
+}
@@ -0,0 +1,195 @@
+package scp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+)
+
+// update hands the messages to the model one after the other.
+func update(m tea.Model, msgs ...tea.Msg) tea.Model {
+	for _, msg := range msgs {
+		m, _ = m.Update(msg)
+	}
+	return m
+}
+
+func runes(s string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+func TestLogPane(t *testing.T) {
+	var m tea.Model = newModel(Theme{}, transferLabel{name: "file", host: "example.com:22"})
+	for i := 0; i < 20; i++ {
+		m = update(m, logMsg(LogEntry{Level: LogWarning, Message: fmt.Sprintf("event %02d", i)}))
+	}
+	// The pane follows the newest lines.
+	view := m.View()
+	if !strings.Contains(view, "warning event 19") || strings.Contains(view, "event 00") {
+		t.Errorf("the log pane does not show the last of the events:\n%s", view)
+	}
+
+	// Until it is scrolled up.
+	m = update(m, tea.KeyMsg{Type: tea.KeyUp}, logMsg(LogEntry{Message: "event 20"}))
+	if view := m.View(); !strings.Contains(view, "event 18") || strings.Contains(view, "event 19") || strings.Contains(view, "event 20") {
+		t.Errorf("the scrolled up log pane moved on to new events:\n%s", view)
+	}
+
+	m = update(m, runes("l"))
+	if view := m.View(); strings.Contains(view, "event") {
+		t.Errorf("the hidden log pane is still shown:\n%s", view)
+	}
+	m = update(m, runes("l"))
+	if view := m.View(); !strings.Contains(view, "event 18") {
+		t.Errorf("the log pane is not shown again:\n%s", view)
+	}
+}
+
+func TestHelp(t *testing.T) {
+	var m tea.Model = newModel(Theme{}, transferLabel{name: "file", host: "example.com:22"})
+	view := m.View()
+	if !strings.Contains(view, "toggle log") || strings.Contains(view, "scroll log up") {
+		t.Errorf("the short help is not shown:\n%s", view)
+	}
+	// Bindings the interface does not use are left out.
+	if strings.Contains(view, "complete path") {
+		t.Errorf("the help lists a disabled binding:\n%s", view)
+	}
+
+	m = update(m, runes("?"))
+	if view := m.View(); !strings.Contains(view, "scroll log up") || !strings.Contains(view, "scroll log down") {
+		t.Errorf("the full help is not shown:\n%s", view)
+	}
+	m = update(m, runes("?"))
+	if view := m.View(); strings.Contains(view, "scroll log up") {
+		t.Errorf("the full help is still shown:\n%s", view)
+	}
+}
+
+func TestTransferLabel(t *testing.T) {
+	tests := []struct {
+		label transferLabel
+		want  string
+	}{
+		{transferLabel{direction: Upload, name: "app.tar.gz", host: "deploy@web1:22"}, "↑ app.tar.gz → deploy@web1:22"},
+		{transferLabel{direction: Download, name: "app.log", host: "web1:22"}, "↓ app.log ← web1:22"},
+		{transferLabel{direction: Upload, name: "line\nbreak", host: "web1:22"}, `↑ "line\nbreak" → web1:22`},
+		{transferLabel{direction: Upload, name: "app", host: "web1:22", tags: Tags{"ticket": "OPS-1", "env": "prod"}}, "↑ app → web1:22 [env=prod ticket=OPS-1]"},
+	}
+	for _, test := range tests {
+		if got := test.label.String(); got != test.want {
+			t.Errorf("the label of %+v is %q, want %q", test.label, got, test.want)
+		}
+	}
+
+	client := NewConfigurer("web1:22", &ssh.ClientConfig{User: "deploy"}).Create()
+	label := client.label(Download, "app.log")
+	if label.host != "deploy@web1:22" {
+		t.Errorf("the label names the host %q, want it with the user", label.host)
+	}
+	if view := newModel(Theme{}, label).View(); !strings.Contains(view, "↓ app.log ← deploy@web1:22") {
+		t.Errorf("the view does not show the label:\n%s", view)
+	}
+}
+
+func TestPhaseSpinner(t *testing.T) {
+	var m tea.Model = newModel(Theme{}, transferLabel{name: "file", host: "example.com:22"})
+	if view := m.View(); !strings.Contains(view, "opening a session on example.com:22 ...") {
+		t.Errorf("the view does not show the phase before the transfer started:\n%s", view)
+	}
+	m = update(m, phaseMsg(phaseStart))
+	if view := m.View(); !strings.Contains(view, "starting the transfer on example.com:22 ...") {
+		t.Errorf("the view does not show the new phase:\n%s", view)
+	}
+
+	// The spinner is gone with the first byte.
+	m.(model).pending.Add(1)
+	m = update(m, redrawMsg{})
+	if view := m.View(); strings.Contains(view, "example.com:22 ...") {
+		t.Errorf("the view shows the phase after the first byte:\n%s", view)
+	}
+	if _, cmd := m.Update(m.(model).spinner.Tick()); cmd != nil {
+		t.Error("the spinner keeps spinning after the first byte")
+	}
+}
+
+func TestConnectSpinner(t *testing.T) {
+	theme := Theme{}.withDefaults()
+	var m tea.Model = connectModel{spinner: newSpinner(theme), host: "example.com:22", theme: theme}
+	m = update(m, phaseMsg(phaseHandshake))
+	if view := m.View(); !strings.Contains(view, "exchanging keys with example.com:22 ...") {
+		t.Errorf("the view does not show the phase of connecting:\n%s", view)
+	}
+
+	// The terminal is left to verifying the host key, which may ask about it.
+	m, cmd := m.Update(phaseMsg(phaseVerify))
+	if cmd == nil || cmd() != tea.Quit() {
+		t.Error("the spinner did not quit before the host key is verified")
+	}
+	if view := m.View(); view != "" {
+		t.Errorf("the spinner left %q behind", view)
+	}
+}
+
+func TestSizes(t *testing.T) {
+	tests := map[int64]string{
+		0:                 "0 B",
+		1023:              "1023 B",
+		1024:              "1.0 KiB",
+		1536:              "1.5 KiB",
+		123<<20 + 400<<10: "123.4 MiB",
+		2 << 30:           "2.0 GiB",
+		5 << 40:           "5.0 TiB",
+	}
+	for n, want := range tests {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+	if got := sizes(1536, -1); got != "1.5 KiB" {
+		t.Errorf("the sizes of an unknown total are %q, want only the amount transferred", got)
+	}
+
+	var m tea.Model = newModel(Theme{}, transferLabel{name: "file", host: "example.com:22"})
+	m.(model).pending.Add(1536)
+	m = update(m, startMsg{total: 3 << 10, files: 1}, redrawMsg{})
+	if view := m.View(); !strings.Contains(view, "1.5 KiB / 3.0 KiB") {
+		t.Errorf("the view does not show the amount transferred and the total:\n%s", view)
+	}
+}
+
+func TestProgressCollectsBetweenRedraws(t *testing.T) {
+	m := newModel(Theme{}, transferLabel{name: "dir", host: "example.com:22"})
+	progress := teaProgress{pending: m.pending}
+
+	// Writes only add up, without a message each.
+	for i := 0; i < 1000; i++ {
+		progress.Add(10)
+	}
+	if m.done != 0 || m.pending.Load() != 10000 {
+		t.Fatalf("the model has %d bytes done and %d pending before the redraw", m.done, m.pending.Load())
+	}
+	next, cmd := m.Update(redrawMsg{})
+	if m = next.(model); m.done != 10000 || m.pending.Load() != 0 {
+		t.Errorf("the redraw collected %d bytes and left %d pending", m.done, m.pending.Load())
+	}
+	if cmd == nil {
+		t.Error("the redraw did not schedule the next")
+	}
+
+	// The bytes of a file are collected before the next file starts.
+	m = update(m, fileMsg{name: "a", size: 20}).(model)
+	progress.Add(20)
+	m = update(m, fileMsg{name: "b", size: 5}).(model)
+	if m.done != 10020 || m.fileDone != 0 || m.name != "b" {
+		t.Errorf("after file a the model has %d bytes done, %d of %s", m.done, m.fileDone, m.name)
+	}
+	progress.Add(5)
+	m = update(m, progressDoneMsg{}).(model)
+	if m.done != 10025 || m.fileDone != 5 {
+		t.Errorf("once done the model has %d bytes done, %d of the last file", m.done, m.fileDone)
+	}
+}
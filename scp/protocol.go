@@ -23,6 +23,9 @@ const (
 	Error   ResponseType = 2
 	Create  ResponseType = 'C'
 	Time    ResponseType = 'T'
+	// Directory and EndDirectory enter and leave a directory of a recursive transfer.
+	Directory    ResponseType = 'D'
+	EndDirectory ResponseType = 'E'
 )
 
 // ParseResponse reads from the given reader (assuming it is the output of the remote) and parses it into a Response structure.
@@ -40,11 +43,18 @@ func ParseResponse(reader io.Reader, writer io.Writer) (*FileInfos, error) {
 	if responseType > 0 {
 		bufferedReader := bufio.NewReader(reader)
 		message, err = bufferedReader.ReadString('\n')
+		// Dropbear's scp writes its last error without a newline before it exits.
+		if err == io.EOF && message != "" && (responseType == Warning || responseType == Error) {
+			err = nil
+		}
 		if err != nil {
 			return fileInfos, err
 		}
 
-		if responseType == Warning || responseType == Error {
+		if responseType == Warning {
+			return fileInfos, &RemoteWarning{Message: strings.TrimSuffix(message, "\n")}
+		}
+		if responseType == Error {
 			return fileInfos, errors.New(message)
 		}
 
@@ -98,6 +108,17 @@ func ParseResponse(reader io.Reader, writer io.Writer) (*FileInfos, error) {
 	return fileInfos, nil
 }
 
+// RemoteWarning a warning the remote scp sent in place of a response, such as failing to set the
+// times of a file or to read one of several files. The remote carries on after warnings, so
+// transfers log them and continue, and only fail on errors or the remote exiting with one.
+type RemoteWarning struct {
+	Message string
+}
+
+func (w *RemoteWarning) Error() string {
+	return w.Message
+}
+
 type FileInfos struct {
 	Message     string
 	Filename    string
@@ -132,32 +153,48 @@ func (fileInfos *FileInfos) Update(new *FileInfos) {
 	}
 }
 
+// createRecord formats the `C` record announcing a file of size bytes, as parsed by ParseFileInfos.
+func createRecord(permissions string, size int64, filename string) string {
+	return fmt.Sprintf("C%s %d %s\n", permissions, size, filename)
+}
+
 func ParseFileInfos(message string, fileInfos *FileInfos) error {
-	processMessage := strings.ReplaceAll(message, "\n", "")
-	parts := strings.Split(processMessage, " ")
+	// The name is the rest of the line, spaces, control characters and invalid UTF-8 included.
+	parts := strings.SplitN(strings.TrimSuffix(message, "\n"), " ", 3)
 	if len(parts) < 3 {
 		return errors.New("unable to parse Chmod protocol")
 	}
+	if err := checkFilename(parts[2]); err != nil {
+		return err
+	}
 
-	permissions, err := strconv.ParseUint(parts[0][1:], 0, 32)
+	// Always octal, also without the leading zero.
+	permissions, err := strconv.ParseUint(parts[0][1:], 8, 32)
 	if err != nil {
 		return err
 	}
 
-	size, err := strconv.Atoi(parts[1])
+	// Sizes beyond 4 GiB do not fit an int on 32-bit platforms.
+	size, err := strconv.ParseInt(parts[1], 10, 64)
 	if err != nil {
 		return err
 	}
+	if size < 0 {
+		return fmt.Errorf("invalid size %d in Chmod protocol", size)
+	}
 
 	fileInfos.Update(&FileInfos{
 		Filename:    parts[2],
 		Permissions: uint32(permissions),
-		Size:        int64(size),
+		Size:        size,
 	})
 
 	return nil
 }
 
+// ParseFileTime parses the times of a Time record, without its leading T, such as "1700000000 0 1700000000 0".
+// Times are seconds of any length, as devices without a real-time clock, such as routers running
+// Dropbear, send times close to 1970.
 func ParseFileTime(
 	message string,
 	fileInfos *FileInfos,
@@ -168,25 +205,19 @@ func ParseFileTime(
 		return errors.New("unable to parse Time protocol")
 	}
 
-	if len(parts[0]) != 10 {
-		return errors.New("length of ATime is not 10")
-	}
-	mTime, err := strconv.Atoi(parts[0][0:10])
-	if err != nil {
-		return errors.New("unable to parse ATime component of message")
+	mTime, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || mTime < 0 {
+		return errors.New("unable to parse MTime component of message")
 	}
 
-	if len(parts[2]) != 10 {
-		return errors.New("length of MTime is not 10")
-	}
-	aTime, err := strconv.Atoi(parts[2][0:10])
-	if err != nil {
-		return errors.New("unable to parse MTime component of message")
+	aTime, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || aTime < 0 {
+		return errors.New("unable to parse ATime component of message")
 	}
 
 	fileInfos.Update(&FileInfos{
-		Atime: int64(aTime),
-		Mtime: int64(mTime),
+		Atime: aTime,
+		Mtime: mTime,
 	})
 	return nil
 }
@@ -0,0 +1,188 @@
+package scp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+// hugeSizes sizes that do not fit 32 bits, signed or unsigned.
+var hugeSizes = []int64{
+	math.MaxInt32 + 1,
+	math.MaxUint32,
+	math.MaxUint32 + 1,
+	5 << 30,
+	1 << 40,
+	math.MaxInt64,
+}
+
+func TestCreateRecordRoundTrip(t *testing.T) {
+	for _, size := range append([]int64{0, 1, 4096}, hugeSizes...) {
+		record := createRecord("0644", size, "huge.bin")
+		fileInfos := NewFileInfos()
+		if err := ParseFileInfos(record, fileInfos); err != nil {
+			t.Errorf("ParseFileInfos(%q) failed: %v", record, err)
+			continue
+		}
+		if fileInfos.Size != size || fileInfos.Filename != "huge.bin" || fileInfos.Permissions != 0644 {
+			t.Errorf("ParseFileInfos(%q) = %+v, want size %d", record, fileInfos, size)
+		}
+	}
+}
+
+func TestParseFileInfosRejectsInvalidSizes(t *testing.T) {
+	for _, record := range []string{
+		"C0644 -1 file\n",
+		"C0644 9223372036854775808 file\n",
+		"C0644 12x file\n",
+	} {
+		if err := ParseFileInfos(record, NewFileInfos()); err == nil {
+			t.Errorf("ParseFileInfos(%q) succeeded", record)
+		}
+	}
+}
+
+func TestParseResponseHugeFile(t *testing.T) {
+	size := int64(5 << 30)
+	remote := strings.NewReader("T1700000000 0 1700000001 0\n" + createRecord("0600", size, "disk.img"))
+
+	var acks bytes.Buffer
+	fileInfos, err := ParseResponse(remote, &acks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileInfos.Size != size || fileInfos.Mtime != 1700000000 || fileInfos.Atime != 1700000001 {
+		t.Errorf("ParseResponse = %+v, want size %d and the times of the T record", fileInfos, size)
+	}
+}
+
+// zeroReader yields n bytes without filling the buffers, to stream sizes beyond 4 GiB quickly.
+type zeroReader struct{ n int64 }
+
+func (z *zeroReader) Read(p []byte) (int, error) {
+	if z.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > z.n {
+		p = p[:z.n]
+	}
+	z.n -= int64(len(p))
+	return len(p), nil
+}
+
+// countingWriter counts the bytes written to it and discards them.
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestCopyNHugeStream(t *testing.T) {
+	size := int64(5 << 30)
+	// The stream is longer than size, the rest must be left unread.
+	src := &zeroReader{n: size + 1234}
+	var dst countingWriter
+	n, err := copyN(context.Background(), &dst, src, size, DefaultBufferSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != size || dst.n != size {
+		t.Errorf("copyN copied %d bytes and wrote %d, want %d", n, dst.n, size)
+	}
+	if src.n != 1234 {
+		t.Errorf("copyN left %d bytes of the stream, want 1234", src.n)
+	}
+}
+
+func TestCopyNShortStream(t *testing.T) {
+	var dst countingWriter
+	n, err := CopyN(&dst, &zeroReader{n: 100}, 1<<33)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("CopyN of a short stream returned %v, want io.ErrUnexpectedEOF", err)
+	}
+	if n != 100 {
+		t.Errorf("CopyN of a short stream returned %d bytes, want 100", n)
+	}
+}
+
+func TestSizedReaderHugeStream(t *testing.T) {
+	size := int64(5 << 30)
+	var dst countingWriter
+	n, err := copyBuffer(&dst, &sizedReader{r: &zeroReader{n: size}, left: size}, DefaultBufferSize)
+	if err != nil || n != size {
+		t.Errorf("sizedReader yielded %d bytes (%v), want %d", n, err, size)
+	}
+
+	_, err = copyBuffer(&dst, &sizedReader{r: &zeroReader{n: size}, left: size - 1}, DefaultBufferSize)
+	if !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("sizedReader of a longer stream returned %v, want ErrSizeMismatch", err)
+	}
+}
+
+func TestParseResponseWarning(t *testing.T) {
+	_, err := ParseResponse(strings.NewReader("\x01scp: file: set times: Operation not permitted\n"), nil)
+	var warning *RemoteWarning
+	if !errors.As(err, &warning) || warning.Message != "scp: file: set times: Operation not permitted" {
+		t.Errorf("ParseResponse of a warning returned %#v", err)
+	}
+
+	_, err = ParseResponse(strings.NewReader("\x02scp: fatal\n"), nil)
+	if err == nil || errors.As(err, &warning) {
+		t.Errorf("ParseResponse of an error returned %#v", err)
+	}
+
+	// Dropbear's scp exits right after its last error, without a newline.
+	_, err = ParseResponse(strings.NewReader("\x02scp: /x: No such file or directory"), nil)
+	if err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Errorf("ParseResponse of an unterminated error returned %#v", err)
+	}
+}
+
+func TestParseFileTimeEarlyTimes(t *testing.T) {
+	// Devices without a real-time clock start in 1970.
+	fileInfos := NewFileInfos()
+	if err := ParseFileTime("86400 0 946684800 0\n", fileInfos); err != nil {
+		t.Fatal(err)
+	}
+	if fileInfos.Mtime != 86400 || fileInfos.Atime != 946684800 {
+		t.Errorf("parsed mtime %d and atime %d", fileInfos.Mtime, fileInfos.Atime)
+	}
+	for _, message := range []string{"-1 0 1 0\n", "x 0 1 0\n", "1 0\n"} {
+		if err := ParseFileTime(message, NewFileInfos()); err == nil {
+			t.Errorf("ParseFileTime(%q) succeeded", message)
+		}
+	}
+}
+
+// cancelWriter cancels its context once it was written more than after bytes.
+type cancelWriter struct {
+	countingWriter
+	after  int64
+	cancel context.CancelCauseFunc
+}
+
+func (c *cancelWriter) Write(p []byte) (int, error) {
+	n, err := c.countingWriter.Write(p)
+	if c.n > c.after {
+		c.cancel(ErrStalled)
+	}
+	return n, err
+}
+
+func TestCopyNContextStopsWhileDataFlows(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	// The source never runs dry, only the context stops the copy.
+	dst := &cancelWriter{after: 1 << 20, cancel: cancel}
+	n, err := copyN(ctx, dst, &zeroReader{n: 1 << 40}, 1<<40, 64<<10)
+	if !errors.Is(err, ErrStalled) {
+		t.Fatalf("copyN returned %v, want the cause of the context", err)
+	}
+	if n > 2<<20 {
+		t.Errorf("copyN copied %d bytes after the context was done", n)
+	}
+}
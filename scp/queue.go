@@ -0,0 +1,451 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Direction the direction of a transfer.
+type Direction string
+
+const (
+	Upload   Direction = "upload"
+	Download Direction = "download"
+)
+
+// JobStatus the state of a queued transfer.
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// queueSaveInterval how often the progress of a running job is written to disk.
+const queueSaveInterval = time.Second
+
+// Job a transfer in a Queue. Source and Destination are a local path and a remote path,
+// in the order given by Direction.
+type Job struct {
+	ID          string    `json:"id"`
+	Direction   Direction `json:"direction"`
+	Host        string    `json:"host"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Permissions string    `json:"permissions,omitempty"`
+	Size        int64     `json:"size"`
+	BytesDone   int64     `json:"bytes_done"`
+	Status      JobStatus `json:"status"`
+	Error       string    `json:"error,omitempty"`
+
+	// ChecksumState the marshalled SHA-256 state over the first BytesDone bytes,
+	// allowing the checksum to continue where an interrupted transfer stopped.
+	ChecksumState []byte `json:"checksum_state,omitempty"`
+
+	// Checksum the hex encoded SHA-256 of the file, set once the job is done.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Tags attached to the transfer of the job, see WithTags. They are kept in the queue,
+	// so resumed jobs carry them as well.
+	Tags Tags `json:"tags,omitempty"`
+
+	// Before the hooks run in order before the transfer starts, every time the job runs.
+	// One failing aborts the job. After the hooks run in order once the job succeeded.
+	// Both are kept in the queue like Tags.
+	Before []Hook `json:"before,omitempty"`
+	After  []Hook `json:"after,omitempty"`
+}
+
+// Queue a list of transfers persisted to a JSON file, so jobs that were queued or
+// interrupted can be resumed after a restart.
+type Queue struct {
+	mu   sync.Mutex
+	path string
+	next int
+
+	Jobs []*Job `json:"jobs"`
+}
+
+// LoadQueue reads the queue persisted at path, a missing file results in an empty queue.
+// Jobs that were running when the queue was last written were interrupted and are
+// marked as queued again.
+func LoadQueue(path string) (*Queue, error) {
+	q := &Queue{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, q); err != nil {
+		return nil, fmt.Errorf("failed to parse queue %s: %w", path, err)
+	}
+
+	for _, job := range q.Jobs {
+		if job.Status == JobRunning {
+			job.Status = JobQueued
+		}
+		if id, err := strconv.Atoi(job.ID); err == nil && id >= q.next {
+			q.next = id + 1
+		}
+	}
+	return q, nil
+}
+
+// Add appends the job to the queue as queued and persists the queue.
+func (q *Queue) Add(job *Job) error {
+	q.mu.Lock()
+	job.ID = strconv.Itoa(q.next)
+	job.Status = JobQueued
+	q.next++
+	q.Jobs = append(q.Jobs, job)
+	q.mu.Unlock()
+
+	return q.Save()
+}
+
+// Pending returns the jobs which are not done yet, including failed ones.
+func (q *Queue) Pending() []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var pending []*Job
+	for _, job := range q.Jobs {
+		if job.Status != JobDone {
+			pending = append(pending, job)
+		}
+	}
+	return pending
+}
+
+// Prune removes every finished job from the queue and persists it.
+func (q *Queue) Prune() error {
+	q.mu.Lock()
+	jobs := q.Jobs[:0]
+	for _, job := range q.Jobs {
+		if job.Status != JobDone {
+			jobs = append(jobs, job)
+		}
+	}
+	q.Jobs = jobs
+	q.mu.Unlock()
+
+	return q.Save()
+}
+
+// Save writes the queue to disk, replacing the previous file atomically.
+func (q *Queue) Save() error {
+	q.mu.Lock()
+	data, err := json.MarshalIndent(q, "", "  ")
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it to path,
+// so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// update applies fn to the job while holding the lock of the queue.
+func (q *Queue) update(fn func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fn()
+}
+
+// RunJob runs a job of the queue with this client, persisting its progress as it goes.
+// Interrupted uploads continue where they stopped, by appending the rest of the file with `cat`
+// unless the SFTP backend is used, and are verified against the size and checksum of the source
+// once complete. Interrupted downloads only continue with the SFTP backend, chosen or detected by
+// BackendAuto, the SCP protocol can not start at an offset so they are restarted from the beginning instead.
+// Its Before hooks run before the transfer starts, one failing fails the job without transferring.
+// Once the job succeeded its After hooks run, one failing is returned but leaves the job done.
+func (a *Client) RunJob(ctx context.Context, q *Queue, job *Job) error {
+	sum := sha256.New()
+	// A job that failed after all its bytes were sent, such as one failing verification, starts over.
+	// So do encrypted and converted ones, the partial file can not be compared with the source.
+	resume := job.BytesDone > 0 && job.BytesDone < job.Size && (job.Direction == Upload || a.resumesDownloads(ctx)) &&
+		a.Cipher == nil && a.TextMode == TextOff && a.partialSize(ctx, job) == job.BytesDone
+	if resume {
+		err := sum.(encoding.BinaryUnmarshaler).UnmarshalBinary(job.ChecksumState)
+		resume = err == nil
+	}
+
+	q.update(func() {
+		job.Status = JobRunning
+		job.Error = ""
+		if !resume {
+			job.BytesDone = 0
+			job.ChecksumState = nil
+		}
+	})
+	if err := q.Save(); err != nil {
+		return err
+	}
+
+	ctx = WithTags(ctx, job.Tags)
+	name := path.Base(job.Source)
+	if job.Direction == Upload {
+		name = filepath.Base(job.Source)
+	}
+	err := a.runHooks(ctx, job.Before, job)
+	if err == nil {
+		err = a.withRecords(a.label(job.Direction, name), func(ctx context.Context, progress Progress) error {
+			tracker := &jobTracker{queue: q, job: job, sum: sum, progress: progressOrNop(progress), bufferSize: a.BufferSize}
+			switch job.Direction {
+			case Upload:
+				return a.runUpload(ctx, job, tracker)
+			case Download:
+				return a.runDownload(ctx, job, tracker)
+			default:
+				return fmt.Errorf("unknown direction %q", job.Direction)
+			}
+		})(ctx, nil)
+	}
+
+	q.update(func() {
+		if err != nil {
+			job.Status = JobFailed
+			job.Error = err.Error()
+			return
+		}
+		job.Status = JobDone
+		job.Checksum = hex.EncodeToString(sum.Sum(nil))
+	})
+	if saveErr := q.Save(); err == nil {
+		err = saveErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// The transfer is done either way, a failing hook is only reported.
+	return a.runHooks(ctx, job.After, job)
+}
+
+// runHooks runs hooks in order with the environment of job, stopping at the first failing one.
+func (a *Client) runHooks(ctx context.Context, hooks []Hook, job *Job) error {
+	for _, hook := range hooks {
+		if err := a.RunHook(ctx, hook, job.Env()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partialSize returns the size of the destination of an interrupted job, or -1 if it is
+// unknown. Only when it matches the recorded progress the job can safely be resumed,
+// bytes read from the source are not necessarily bytes that arrived.
+func (a *Client) partialSize(ctx context.Context, job *Job) int64 {
+	if job.Direction == Download {
+		name := job.Destination
+		if !a.DirectDownloads {
+			name = partPath(job.Destination)
+		}
+		stat, err := os.Stat(name)
+		if err != nil {
+			return -1
+		}
+		return stat.Size()
+	}
+
+	stat, err := a.Stat(ctx, job.Destination)
+	if err != nil {
+		return -1
+	}
+	return stat.Size
+}
+
+// resumesDownloads reports whether downloads can continue at an offset, which only SFTP can.
+func (a *Client) resumesDownloads(ctx context.Context) bool {
+	backend, err := a.resolveBackend(ctx)
+	return err == nil && backend == BackendSFTP
+}
+
+func (a *Client) runUpload(ctx context.Context, job *Job, tracker *jobTracker) error {
+	f, err := os.Open(job.Source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	permissions := job.Permissions
+	if permissions == "" {
+		permissions = FormatMode(stat.Mode())
+	}
+	tracker.queue.update(func() { job.Size = stat.Size() })
+
+	offset := job.BytesDone
+	tracker.start(offset)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if offset > 0 {
+		if err := a.resumeUpload(ctx, tracker.Reader(f), job, permissions, offset); err != nil {
+			return err
+		}
+		return a.verifyUpload(ctx, job, tracker.sum)
+	}
+	return a.Copy(ctx, tracker.Reader(f), job.Destination, permissions, job.Size)
+}
+
+// resumeUpload writes the rest of the job from offset on to its destination, which already holds
+// the bytes before it. The SCP protocol can only write whole files, so without SFTP it is appended
+// with `cat` on the remote.
+func (a *Client) resumeUpload(ctx context.Context, r io.Reader, job *Job, permissions string, offset int64) error {
+	backend, err := a.resolveBackend(ctx)
+	if err != nil {
+		return err
+	}
+	if backend == BackendSFTP {
+		result := &UploadResult{
+			RemotePath:  job.Destination,
+			Filename:    path.Base(job.Destination),
+			Permissions: permissions,
+			Size:        job.Size,
+		}
+		_, err = a.sftpUpload(ctx, r, result, nil, offset, nil)
+		return err
+	}
+
+	a.logf(ctx, LogInfo, "resuming the upload of %s at %d bytes", job.Destination, offset)
+	// Appended by the shell, `dd oflag=append` is only known to GNU dd.
+	cmd := fmt.Sprintf("cat >> %s", a.shellPath(job.Destination))
+	return a.runStream(ctx, cmd, func(stdin io.WriteCloser, _ io.Reader) error {
+		if _, err := copyBuffer(stdin, io.LimitReader(r, job.Size-offset), a.BufferSize); err != nil {
+			return err
+		}
+		return stdin.Close()
+	})
+}
+
+// verifyUpload compares the destination of a resumed upload to the checksum of the bytes read
+// from its source, see verifyChecksum.
+func (a *Client) verifyUpload(ctx context.Context, job *Job, sum hash.Hash) error {
+	return a.verifyChecksum(ctx, job.Destination, job.Size, sum, ErrResumeMismatch)
+}
+
+func (a *Client) runDownload(ctx context.Context, job *Job, tracker *jobTracker) (err error) {
+	f, err := a.createPartFile(job.Destination, job.BytesDone > 0)
+	if err != nil {
+		return err
+	}
+	// Keep what was downloaded, the job resumes from it.
+	defer func() { err = f.finish(err, PartialKeep) }()
+
+	offset := job.BytesDone
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	passThru := func(r io.Reader, total int64) io.Reader {
+		tracker.queue.update(func() { job.Size = offset + total })
+		tracker.start(offset)
+		return tracker.Reader(r)
+	}
+	if offset > 0 {
+		_, err = a.sftpDownload(ctx, f, job.Source, passThru, offset)
+		return err
+	}
+	return a.CopyFromRemotePassThru(ctx, f, job.Source, passThru)
+}
+
+// jobTracker follows the bytes of a job flowing through its reader, checksumming them
+// and periodically persisting the progress to the queue.
+type jobTracker struct {
+	queue *Queue
+	job   *Job
+	sum   hash.Hash
+	saved time.Time
+
+	// progress receives the bytes of the job as they flow.
+	progress Progress
+
+	// bufferSize the size of the buffers the readers of the tracker copy through.
+	bufferSize int
+}
+
+// start reports the job to the progress of the tracker, counting the offset it resumes from as done.
+func (t *jobTracker) start(offset int64) {
+	t.progress.Start(t.job.Size, 1)
+	t.progress.File(t.job.Source, t.job.Size)
+	if offset > 0 {
+		t.progress.Add(offset)
+	}
+}
+
+func (t *jobTracker) Reader(r io.Reader) io.Reader {
+	return &jobReader{r: r, tracker: t}
+}
+
+func (t *jobTracker) add(b []byte) {
+	t.sum.Write(b)
+	t.progress.Add(int64(len(b)))
+
+	state, _ := t.sum.(encoding.BinaryMarshaler).MarshalBinary()
+	t.queue.update(func() {
+		t.job.BytesDone += int64(len(b))
+		t.job.ChecksumState = state
+	})
+
+	if time.Since(t.saved) >= queueSaveInterval {
+		t.saved = time.Now()
+		_ = t.queue.Save()
+	}
+}
+
+type jobReader struct {
+	r       io.Reader
+	tracker *jobTracker
+}
+
+func (j *jobReader) Read(p []byte) (int, error) {
+	n, err := j.r.Read(p)
+	if n > 0 {
+		j.tracker.add(p[:n])
+	}
+	return n, err
+}
+
+func (j *jobReader) WriteTo(w io.Writer) (int64, error) {
+	return copyThroughBuffer(w, j, j.tracker.bufferSize)
+}
@@ -0,0 +1,122 @@
+package scp_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"main/scp"
+	"main/scp/scptest"
+)
+
+func TestRunJobResumesUpload(t *testing.T) {
+	server := scptest.NewShellServer(t)
+	// Like a remote with BusyBox or BSD dd, which do not know oflag.
+	server.Exec = func(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+		if strings.Contains(command, "oflag=") {
+			io.WriteString(stderr, "dd: unknown operand oflag=append\n")
+			return 1
+		}
+		return server.Shell(command, stdin, stdout, stderr)
+	}
+	client := server.Configurer().Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	dir := t.TempDir()
+	contents := strings.Repeat("resumed upload ", 1000)
+	source, destination := filepath.Join(dir, "source"), filepath.Join(dir, "destination")
+	if err := os.WriteFile(source, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// An earlier run sent the first part before it was interrupted.
+	const done = 4000
+	if err := os.WriteFile(destination, []byte(contents[:done]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.New()
+	sum.Write([]byte(contents[:done]))
+	state, err := sum.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := scp.LoadQueue(filepath.Join(dir, "queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &scp.Job{Direction: scp.Upload, Source: source, Destination: destination, Size: int64(len(contents))}
+	if err := q.Add(job); err != nil {
+		t.Fatal(err)
+	}
+	job.BytesDone, job.ChecksumState = done, state
+
+	if err := client.RunJob(context.Background(), q, job); err != nil {
+		t.Fatalf("RunJob returned %v", err)
+	}
+	if got, err := os.ReadFile(destination); err != nil || string(got) != contents {
+		t.Errorf("the destination holds %d bytes, %v, want the %d of the source", len(got), err, len(contents))
+	}
+	want := sha256.Sum256([]byte(contents))
+	if job.Status != scp.JobDone || job.Checksum != hex.EncodeToString(want[:]) {
+		t.Errorf("the job is %s with checksum %s", job.Status, job.Checksum)
+	}
+}
+
+func TestRunJobResumesDownloadWithAutoBackend(t *testing.T) {
+	// Like OpenSSH 9 without an scp binary, which BackendAuto transfers from with SFTP.
+	server := scptest.NewShellServer(t)
+	server.Exec = nil
+	server.SFTP = true
+	server.SetVersion("SSH-2.0-OpenSSH_9.6")
+	client := server.Configurer().Backend(scp.BackendAuto).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	dir := t.TempDir()
+	contents := strings.Repeat("resumed download ", 1000)
+	source, destination := filepath.Join(dir, "source"), filepath.Join(dir, "destination")
+	if err := os.WriteFile(source, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// An earlier run received the first part, and a different one, before it was interrupted.
+	const done = 4000
+	if err := os.WriteFile(destination+scp.PartSuffix, []byte(strings.ToUpper(contents[:done])), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.New()
+	sum.Write([]byte(contents[:done]))
+	state, err := sum.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := scp.LoadQueue(filepath.Join(dir, "queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &scp.Job{Direction: scp.Download, Source: source, Destination: destination, Size: int64(len(contents))}
+	if err := q.Add(job); err != nil {
+		t.Fatal(err)
+	}
+	job.BytesDone, job.ChecksumState = done, state
+
+	if err := client.RunJob(context.Background(), q, job); err != nil {
+		t.Fatalf("RunJob returned %v", err)
+	}
+	// Only the rest was downloaded, the first part is still the one of the earlier run.
+	want := strings.ToUpper(contents[:done]) + contents[done:]
+	if got, err := os.ReadFile(destination); err != nil || string(got) != want {
+		t.Errorf("the destination holds %d bytes, %v, want the earlier part and the rest of the source", len(got), err)
+	}
+}
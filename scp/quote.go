@@ -0,0 +1,75 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import "strings"
+
+// ShellQuote quotes s as a single word for a POSIX shell. The remote shell re-parses the command
+// line of every transfer, so paths are quoted to reach the remote command as they are, including
+// spaces, `$`, backticks, quotes and newlines. The result is wrapped in single quotes, in which
+// the shell interprets nothing. Single quotes in s end the quoting, are escaped with a backslash
+// and start it again.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quotePath quotes a remote path for the command line of the remote scp, see NoShellQuoting.
+func (a *Client) quotePath(remotePath string) string {
+	if a.NoShellQuoting {
+		return argPath(remotePath)
+	}
+	return a.shellPath(remotePath)
+}
+
+// shellPath quotes a remote path for the remote shell. With ExpandTilde a leading `~` or `~user`
+// is left unquoted, together with the slash ending it, so the shell expands it to the home directory.
+// The paths of Windows remotes are quoted for their shell instead, see RemoteOS.
+func (a *Client) shellPath(remotePath string) string {
+	remotePath = argPath(remotePath)
+	switch a.remoteOS() {
+	case RemoteWindows:
+		return CmdQuote(a.windowsPath(remotePath))
+	case RemoteWindowsPowerShell:
+		return PowerShellQuote(a.windowsPath(remotePath))
+	}
+	if !a.ExpandTilde || !strings.HasPrefix(remotePath, "~") {
+		return ShellQuote(remotePath)
+	}
+
+	prefix, rest, slash := strings.Cut(remotePath, "/")
+	if !isTildePrefix(prefix) {
+		return ShellQuote(remotePath)
+	}
+	if !slash {
+		return prefix
+	}
+	if rest == "" {
+		return prefix + "/"
+	}
+	return prefix + "/" + ShellQuote(rest)
+}
+
+// argPath prefixes a relative path starting with `-` with "./", so the remote commands it is passed
+// to, such as scp, rm or chmod, do not take it for an option.
+func argPath(remotePath string) string {
+	if strings.HasPrefix(remotePath, "-") {
+		return "./" + remotePath
+	}
+	return remotePath
+}
+
+// isTildePrefix reports whether prefix is `~` or `~user` with a portable user name,
+// which the shell expands without any further interpretation.
+func isTildePrefix(prefix string) bool {
+	for i, r := range prefix[1:] {
+		valid := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-' && i > 0
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package scp
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// hostileNames file names that break a command line re-parsed by the remote shell when not quoted.
+var hostileNames = []string{
+	"",
+	"plain.txt",
+	"with space.txt",
+	"  leading and trailing  ",
+	"dollar $HOME and ${PATH}",
+	"backtick `id`",
+	"subshell $(id)",
+	"single 'quote'",
+	"'",
+	"''",
+	`double "quote"`,
+	`back\slash\`,
+	"semi;colon && pipe | amp &",
+	"glob * ? [a-z]",
+	"redirect > out < in",
+	"new\nline",
+	"tab\tseparated",
+	"-starts-with-dash",
+	"#hash",
+	"~tilde",
+	"!bang",
+	"Exöt1ç ünïcode ☃.txt",
+}
+
+func TestShellQuote(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no POSIX shell available")
+	}
+
+	for _, name := range hostileNames {
+		out, err := exec.Command("sh", "-c", "printf '%s' "+ShellQuote(name)).Output()
+		if err != nil {
+			t.Errorf("the shell failed on %q quoted as %s: %v", name, ShellQuote(name), err)
+			continue
+		}
+		if string(out) != name {
+			t.Errorf("%q quoted as %s reached the command as %q", name, ShellQuote(name), out)
+		}
+	}
+}
+
+func TestShellPathExpandTilde(t *testing.T) {
+	a := &Client{ExpandTilde: true}
+	tests := map[string]string{
+		"~":             "~",
+		"~/":            "~/",
+		"~/file name":   "~/'file name'",
+		"~bram/a b":     "~bram/'a b'",
+		"~bram":         "~bram",
+		"~$(id)/x":      `'~$(id)/x'`,
+		"~-/x":          "'~-/x'",
+		"/abs/~/x":      "'/abs/~/x'",
+		"rel/path":      "'rel/path'",
+		"~/a/../$HOME/": "~/'a/../$HOME/'",
+	}
+	for path, want := range tests {
+		if got := a.shellPath(path); got != want {
+			t.Errorf("shellPath(%q) = %s, want %s", path, got, want)
+		}
+	}
+
+	a.ExpandTilde = false
+	if got := a.shellPath("~/x"); got != "'~/x'" {
+		t.Errorf("shellPath(%q) = %s without ExpandTilde, want it literal", "~/x", got)
+	}
+}
+
+func TestQuotePath(t *testing.T) {
+	a := &Client{}
+	if got := a.quotePath("a b"); got != "'a b'" {
+		t.Errorf("quotePath(%q) = %s, want it quoted", "a b", got)
+	}
+
+	a.NoShellQuoting = true
+	if got := a.quotePath("a b"); got != "a b" {
+		t.Errorf("quotePath(%q) = %s with NoShellQuoting, want it unchanged", "a b", got)
+	}
+}
+
+func TestShellPathStartingWithDash(t *testing.T) {
+	a := &Client{}
+	tests := map[string]string{
+		"-rf":        "'./-rf'",
+		"-":          "'./-'",
+		"--help x":   "'./--help x'",
+		"a/-b":       "'a/-b'",
+		"/abs/-x":    "'/abs/-x'",
+		"./-already": "'./-already'",
+	}
+	for path, want := range tests {
+		if got := a.shellPath(path); got != want {
+			t.Errorf("shellPath(%q) = %s, want %s", path, got, want)
+		}
+	}
+	a.NoShellQuoting = true
+	if got := a.quotePath("-x"); got != "./-x" {
+		t.Errorf("quotePath(%q) = %s with NoShellQuoting, want it to not start with a dash", "-x", got)
+	}
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no POSIX shell available")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "-n"), []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", "cat "+(&Client{}).shellPath("-n"))
+	cmd.Dir = dir
+	if out, err := cmd.Output(); err != nil || string(out) != "kept" {
+		t.Errorf("cat of the file named -n printed %q, %v", out, err)
+	}
+}
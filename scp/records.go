@@ -0,0 +1,153 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// recordInterval how often a ProgressRunning record is written while bytes flow.
+const recordInterval = 500 * time.Millisecond
+
+// ProgressState the state of a transfer in a ProgressRecord.
+type ProgressState string
+
+const (
+	// ProgressStarted the transfer is about to send its first byte, Total is known when not negative.
+	ProgressStarted ProgressState = "started"
+
+	// ProgressRunning bytes are flowing.
+	ProgressRunning ProgressState = "running"
+
+	// ProgressDone the transfer succeeded.
+	ProgressDone ProgressState = "done"
+
+	// ProgressFailed the transfer failed, see ProgressRecord.Error.
+	ProgressFailed ProgressState = "failed"
+)
+
+// ProgressRecord a line written to Client.ProgressJSON, for wrappers and graphical interfaces
+// rendering the progress themselves.
+type ProgressRecord struct {
+	Time      time.Time     `json:"time"`
+	State     ProgressState `json:"state"`
+	Direction Direction     `json:"direction"`
+	Host      string        `json:"host"`
+
+	// Name the file or directory transferred, File the file currently in flight within it.
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+
+	Bytes int64 `json:"bytes"`
+	Total int64 `json:"total"`
+	Files int   `json:"files"`
+
+	// Rate the average bytes per second since the transfer started, ETA the seconds left at
+	// that rate when the total is known.
+	Rate float64  `json:"rate"`
+	ETA  *float64 `json:"eta,omitempty"`
+
+	Error string `json:"error,omitempty"`
+
+	// Tags the tags of the transfer, see WithTags.
+	Tags Tags `json:"tags,omitempty"`
+}
+
+// recordsMu keeps the records of concurrent transfers, such as those of a Broadcast, from interleaving.
+var recordsMu sync.Mutex
+
+// recordProgress writes a ProgressRecord to w as a line of JSON for every state of a transfer,
+// and while bytes flow every recordInterval.
+type recordProgress struct {
+	w      io.Writer
+	record ProgressRecord
+
+	started   time.Time
+	written   time.Time
+	announced bool
+}
+
+func newRecordProgress(w io.Writer, label transferLabel, tags Tags) *recordProgress {
+	return &recordProgress{
+		w:       w,
+		record:  ProgressRecord{Direction: label.direction, Host: label.host, Name: label.name, Total: -1, Tags: tags},
+		started: time.Now(),
+	}
+}
+
+func (r *recordProgress) Start(totalBytes int64, totalFiles int) {
+	r.record.Total = totalBytes
+	r.record.Files = totalFiles
+}
+
+// The ProgressStarted record is written with the first file, to name it.
+func (r *recordProgress) File(name string, _ int64) {
+	r.record.File = name
+	r.announce()
+}
+
+func (r *recordProgress) Add(n int64) {
+	r.announce()
+	r.record.Bytes += n
+	if time.Since(r.written) >= recordInterval {
+		r.write(ProgressRunning)
+	}
+}
+
+func (r *recordProgress) announce() {
+	if !r.announced {
+		r.announced = true
+		r.write(ProgressStarted)
+	}
+}
+
+// finish writes the outcome of the transfer.
+func (r *recordProgress) finish(err error) {
+	if err != nil {
+		r.record.Error = err.Error()
+		r.write(ProgressFailed)
+		return
+	}
+	r.write(ProgressDone)
+}
+
+func (r *recordProgress) write(state ProgressState) {
+	r.written = time.Now()
+	r.record.Time = r.written
+	r.record.State = state
+	r.record.Rate = float64(r.record.Bytes) / r.written.Sub(r.started).Seconds()
+	r.record.ETA = nil
+	if r.record.Total >= 0 && r.record.Rate > 0 && r.record.Bytes <= r.record.Total {
+		eta := float64(r.record.Total-r.record.Bytes) / r.record.Rate
+		r.record.ETA = &eta
+	}
+
+	line, err := json.Marshal(r.record)
+	if err != nil {
+		return
+	}
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
+	_, _ = r.w.Write(append(line, '\n'))
+}
+
+// withRecords returns transfer reporting its progress as records to a.ProgressJSON as well, when it is set.
+func (a *Client) withRecords(label transferLabel, transfer func(ctx context.Context, progress Progress) error) func(ctx context.Context, progress Progress) error {
+	if a.ProgressJSON == nil {
+		return transfer
+	}
+	return func(ctx context.Context, progress Progress) error {
+		records := newRecordProgress(a.ProgressJSON, label, TagsFromContext(ctx))
+		err := transfer(ctx, multiProgress{progressOrNop(progress), records})
+		records.finish(err)
+		return err
+	}
+}
@@ -0,0 +1,64 @@
+package scp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestRecordProgress(t *testing.T) {
+	var buf bytes.Buffer
+	label := transferLabel{direction: Download, name: "logs", host: "deploy@web1:22"}
+	r := newRecordProgress(&buf, label, Tags{"env": "prod"})
+	r.Start(100, 2)
+	r.File("logs/a", 40)
+	r.Add(40)
+	r.File("logs/b", 60)
+	// Written with the first bytes, running records follow every recordInterval.
+	r.written = r.written.Add(-recordInterval)
+	r.Add(30)
+	r.finish(errors.New("connection lost"))
+
+	var records []ProgressRecord
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record ProgressRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("%v in %s", err, scanner.Bytes())
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("wrote %d records, want started, running and failed", len(records))
+	}
+
+	started, running, failed := records[0], records[1], records[2]
+	if started.State != ProgressStarted || started.File != "logs/a" || started.Bytes != 0 || started.Total != 100 || started.Files != 2 {
+		t.Errorf("the first record is %+v", started)
+	}
+	if started.Direction != Download || started.Host != "deploy@web1:22" || started.Name != "logs" || started.Tags["env"] != "prod" {
+		t.Errorf("the first record is of %s %s %s with tags %v", started.Direction, started.Host, started.Name, started.Tags)
+	}
+	if running.State != ProgressRunning || running.File != "logs/b" || running.Bytes != 70 || running.Rate <= 0 || running.ETA == nil || *running.ETA <= 0 {
+		t.Errorf("the running record is %+v", running)
+	}
+	if failed.State != ProgressFailed || failed.Error != "connection lost" || failed.Bytes != 70 {
+		t.Errorf("the last record is %+v", failed)
+	}
+
+	// Without a total there is no time left to tell.
+	buf.Reset()
+	r = newRecordProgress(&buf, label, nil)
+	r.Add(10)
+	r.finish(nil)
+	var done ProgressRecord
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if err := json.Unmarshal(lines[len(lines)-1], &done); err != nil {
+		t.Fatal(err)
+	}
+	if done.State != ProgressDone || done.Total != -1 || done.ETA != nil || done.Bytes != 10 {
+		t.Errorf("the record of a transfer without a total is %+v", done)
+	}
+}
@@ -0,0 +1,123 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// exitNotExist the exit status of the remote scripts below for a missing path.
+const exitNotExist = 3
+
+// Exists reports whether anything, including a dangling symlink, exists at the remote path.
+// A missing path is not an error, an error means the remote could not be asked.
+func (a *Client) Exists(ctx context.Context, remotePath string) (bool, error) {
+	kind, err := a.pathKind(ctx, remotePath)
+	return kind != "", err
+}
+
+// IsDir reports whether the remote path is a directory, or a symlink to one. A missing path
+// results in an error matching fs.ErrNotExist.
+func (a *Client) IsDir(ctx context.Context, remotePath string) (bool, error) {
+	kind, err := a.pathKind(ctx, remotePath)
+	if err != nil {
+		return false, err
+	}
+	if kind == "" {
+		return false, &fs.PathError{Op: "stat", Path: remotePath, Err: fs.ErrNotExist}
+	}
+	return kind == "dir", nil
+}
+
+// pathKind returns "dir" or "file" for what exists at the remote path, or "" when nothing does.
+func (a *Client) pathKind(ctx context.Context, remotePath string) (string, error) {
+	p := a.shellPath(remotePath)
+	out, err := a.runOutput(ctx, fmt.Sprintf(
+		"if [ -d %s ]; then echo dir; elif [ -e %s ] || [ -L %s ]; then echo file; fi", p, p, p,
+	))
+	if err != nil {
+		return "", fmt.Errorf("failed to look up %s: %w", remotePath, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Remove removes the remote file, symlink or empty directory, like os.Remove. A missing path
+// results in an error matching fs.ErrNotExist.
+func (a *Client) Remove(ctx context.Context, remotePath string) error {
+	p := a.shellPath(remotePath)
+	_, err := a.runOutput(ctx, fmt.Sprintf(
+		"{ [ -e %s ] || [ -L %s ]; } || exit %d; if [ -d %s ] && [ ! -L %s ]; then rmdir %s; else rm -f %s; fi",
+		p, p, exitNotExist, p, p, p, p,
+	))
+	return a.pathError("remove", remotePath, err)
+}
+
+// RemoveAll removes the remote path and everything below it, like os.RemoveAll.
+// A missing path is not an error.
+func (a *Client) RemoveAll(ctx context.Context, remotePath string) error {
+	_, err := a.runOutput(ctx, "rm -rf "+a.shellPath(remotePath))
+	return a.pathError("remove", remotePath, err)
+}
+
+// MkdirAll creates the remote directory along with any missing parents, like os.MkdirAll.
+// The permissions given by mode, which are not subject to the umask of the remote, are applied to
+// remoteDir when it is created; created parents get the default permissions of the remote.
+func (a *Client) MkdirAll(ctx context.Context, remoteDir string, mode fs.FileMode) error {
+	_, err := a.runOutput(ctx, fmt.Sprintf("mkdir -p -m %04o %s", mode.Perm(), a.shellPath(remoteDir)))
+	return a.pathError("mkdir", remoteDir, err)
+}
+
+// Chmod changes the permissions of the remote path, including the setuid, setgid and sticky bits,
+// like os.Chmod. A missing path results in an error matching fs.ErrNotExist.
+func (a *Client) Chmod(ctx context.Context, remotePath string, mode fs.FileMode) error {
+	return a.runOnPath(ctx, "chmod", remotePath, "chmod "+FormatMode(mode))
+}
+
+// Chown changes the numeric user and group owning the remote path, like os.Chown, which usually
+// requires root on the remote. A uid or gid of -1 leaves it unchanged. A missing path results in
+// an error matching fs.ErrNotExist.
+func (a *Client) Chown(ctx context.Context, remotePath string, uid int, gid int) error {
+	var owner string
+	switch {
+	case uid == -1 && gid == -1:
+		return nil
+	case gid == -1:
+		owner = strconv.Itoa(uid)
+	case uid == -1:
+		owner = ":" + strconv.Itoa(gid)
+	default:
+		owner = fmt.Sprintf("%d:%d", uid, gid)
+	}
+	return a.runOnPath(ctx, "chown", remotePath, "chown "+owner)
+}
+
+// runOnPath runs the command with the remote path as its last argument, once it checked the path exists.
+func (a *Client) runOnPath(ctx context.Context, op string, remotePath string, command string) error {
+	p := a.shellPath(remotePath)
+	_, err := a.runOutput(ctx, fmt.Sprintf("{ [ -e %s ] || [ -L %s ]; } || exit %d; %s %s", p, p, exitNotExist, command, p))
+	return a.pathError(op, remotePath, err)
+}
+
+// pathError maps the error of a remote script working on remotePath to a *fs.PathError,
+// with fs.ErrNotExist for the exit status exitNotExist.
+func (a *Client) pathError(op string, remotePath string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) && exit.ExitStatus() == exitNotExist {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: remotePath, Err: err}
+}
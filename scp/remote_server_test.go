@@ -0,0 +1,153 @@
+package scp_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExistsAndIsDir(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	dir := t.TempDir()
+	file, link, dangling := filepath.Join(dir, "file"), filepath.Join(dir, "link"), filepath.Join(dir, "dangling")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "missing"), dangling); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		exists bool
+		isDir  bool
+	}{
+		{dir, true, true},
+		{file, true, false},
+		{link, true, true},
+		{dangling, true, false},
+		{filepath.Join(dir, "missing"), false, false},
+	}
+	for _, test := range tests {
+		if exists, err := client.Exists(ctx, test.path); err != nil || exists != test.exists {
+			t.Errorf("Exists(%s) = %v, %v, want %v", filepath.Base(test.path), exists, err, test.exists)
+		}
+		isDir, err := client.IsDir(ctx, test.path)
+		if !test.exists {
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("IsDir of a missing path returned %v, want fs.ErrNotExist", err)
+			}
+			continue
+		}
+		if err != nil || isDir != test.isDir {
+			t.Errorf("IsDir(%s) = %v, %v, want %v", filepath.Base(test.path), isDir, err, test.isDir)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	dir := t.TempDir()
+	exists := func(name string) bool {
+		_, err := os.Lstat(name)
+		return err == nil
+	}
+	writeTree(t, dir, map[string]string{"file with space": "x", "full/file": "x"}, time.Now())
+	for _, name := range []string{"empty", "linked"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Removing the link leaves the directory it points to.
+	if err := os.Symlink(filepath.Join(dir, "linked"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"file with space", "empty", "link"} {
+		if err := client.Remove(ctx, filepath.Join(dir, name)); err != nil || exists(filepath.Join(dir, name)) {
+			t.Errorf("Remove(%s) returned %v", name, err)
+		}
+	}
+	if !exists(filepath.Join(dir, "linked")) {
+		t.Error("removing a symlink removed the directory it points to")
+	}
+	if err := client.Remove(ctx, filepath.Join(dir, "full")); err == nil || !exists(filepath.Join(dir, "full", "file")) {
+		t.Errorf("Remove of a directory that is not empty returned %v", err)
+	}
+	if err := client.Remove(ctx, filepath.Join(dir, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Remove of a missing path returned %v, want fs.ErrNotExist", err)
+	}
+
+	if err := client.RemoveAll(ctx, filepath.Join(dir, "full")); err != nil || exists(filepath.Join(dir, "full")) {
+		t.Errorf("RemoveAll returned %v", err)
+	}
+	if err := client.RemoveAll(ctx, filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("RemoveAll of a missing path returned %v", err)
+	}
+}
+
+func TestMkdirAll(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	dir := filepath.Join(t.TempDir(), "a b", "c")
+	if err := client.MkdirAll(ctx, dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := os.Stat(dir); err != nil || !stat.IsDir() || stat.Mode().Perm() != 0700 {
+		t.Errorf("MkdirAll created %v, %v, want a directory with mode 0700", stat, err)
+	}
+	// Like os.MkdirAll, an existing directory is fine.
+	if err := client.MkdirAll(ctx, dir, 0755); err != nil {
+		t.Errorf("MkdirAll of an existing directory returned %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var pathErr *fs.PathError
+	if err := client.MkdirAll(ctx, filepath.Join(file, "dir"), 0755); !errors.As(err, &pathErr) || pathErr.Op != "mkdir" {
+		t.Errorf("MkdirAll below a file returned %v, want a *fs.PathError", err)
+	}
+}
+
+func TestChmodAndChown(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Chmod(ctx, file, 0750|fs.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := os.Stat(file); err != nil || stat.Mode() != 0750|fs.ModeSetgid {
+		t.Errorf("Chmod set the mode %v, %v", stat.Mode(), err)
+	}
+
+	// Owned by the user already, which needs no privileges.
+	uid, gid := os.Getuid(), os.Getgid()
+	for _, owner := range [][2]int{{uid, gid}, {uid, -1}, {-1, gid}, {-1, -1}} {
+		if err := client.Chown(ctx, file, owner[0], owner[1]); err != nil {
+			t.Errorf("Chown(%d, %d) returned %v", owner[0], owner[1], err)
+		}
+	}
+
+	missing := filepath.Join(filepath.Dir(file), "missing")
+	if err := client.Chmod(ctx, missing, 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Chmod of a missing path returned %v, want fs.ErrNotExist", err)
+	}
+	if err := client.Chown(ctx, missing, uid, gid); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Chown of a missing path returned %v, want fs.ErrNotExist", err)
+	}
+}
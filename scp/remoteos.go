@@ -0,0 +1,209 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RemoteOS the operating system of the remote and the shell it runs commands with, which decide
+// how the command lines of the remote scp are written.
+//
+// Windows remotes are supported for transfers with the remote scp and the SFTP backend. Features
+// running POSIX shell commands on the remote, such as CheckRemoteSpace, VerifyUploads, GzipUploads,
+// directory transfers with tar or sync, need a RemoteUnix remote.
+type RemoteOS int
+
+const (
+	// RemoteUnix a remote running commands with a POSIX shell, such as OpenSSH on Linux, the BSDs or macOS.
+	RemoteUnix RemoteOS = iota
+
+	// RemoteWindows Windows OpenSSH running commands with cmd.exe, its default shell.
+	RemoteWindows
+
+	// RemoteWindowsPowerShell Windows OpenSSH with PowerShell configured as its DefaultShell.
+	RemoteWindowsPowerShell
+
+	// RemoteOSAuto detects the remote before the first transfer, see DetectRemoteOS.
+	RemoteOSAuto
+)
+
+func (o RemoteOS) String() string {
+	switch o {
+	case RemoteUnix:
+		return "unix"
+	case RemoteWindows:
+		return "windows"
+	case RemoteWindowsPowerShell:
+		return "powershell"
+	case RemoteOSAuto:
+		return "auto"
+	default:
+		return "RemoteOS(" + strconv.Itoa(int(o)) + ")"
+	}
+}
+
+// windows tells whether the remote runs Windows, with either shell.
+func (o RemoteOS) windows() bool {
+	return o == RemoteWindows || o == RemoteWindowsPowerShell
+}
+
+// windowsBinaryDir where Windows installs OpenSSH, looked at when scp.exe is not on the PATH.
+const windowsBinaryDir = `C:\Windows\System32\OpenSSH`
+
+// osDetection remembers the operating system detected for RemoteOSAuto,
+// it is shared by copies of a Client so the remote is only probed once.
+// Failing to probe is no outcome, the next transfer probes again.
+type osDetection struct {
+	mu       sync.Mutex
+	done     bool
+	remoteOS RemoteOS
+}
+
+// DetectRemoteOS tells the operating system of the remote from the version it announced, as Windows
+// OpenSSH announces itself as such, like "SSH-2.0-OpenSSH_for_Windows_8.1". Only Windows remotes
+// are probed further, with `echo %OS%`, which cmd.exe expands to "Windows_NT" and PowerShell leaves
+// as it is. Every other remote is taken as RemoteUnix.
+func (a *Client) DetectRemoteOS(ctx context.Context) (RemoteOS, error) {
+	client, err := a.connected(ctx)
+	if err != nil {
+		return RemoteUnix, err
+	}
+	if !bytes.Contains(client.ServerVersion(), []byte("Windows")) {
+		return RemoteUnix, nil
+	}
+
+	out, err := a.runOutput(ctx, "echo %OS%")
+	if err != nil {
+		return RemoteWindows, fmt.Errorf("failed to detect the shell of the Windows remote: %w", err)
+	}
+	if strings.TrimSpace(string(out)) == "Windows_NT" {
+		return RemoteWindows, nil
+	}
+	return RemoteWindowsPowerShell, nil
+}
+
+// resolveRemoteOS detects the operating system before the first transfer for RemoteOSAuto, see remoteOS.
+// The outcome of the first detection is reused afterwards, failures to probe are not.
+func (a *Client) resolveRemoteOS(ctx context.Context) error {
+	if a.osDetection == nil {
+		return nil
+	}
+	// Not being connected yet is no outcome of the detection to remember.
+	if _, err := a.connected(ctx); err != nil {
+		return err
+	}
+
+	a.osDetection.mu.Lock()
+	defer a.osDetection.mu.Unlock()
+	if a.osDetection.done {
+		return nil
+	}
+	remoteOS, err := a.DetectRemoteOS(ctx)
+	if err != nil {
+		a.logf(ctx, LogError, "failed to detect the remote operating system: %v", err)
+		return err
+	}
+	a.osDetection.done, a.osDetection.remoteOS = true, remoteOS
+	a.logf(ctx, LogInfo, "the remote runs %s", remoteOS)
+	return nil
+}
+
+// remoteOS the operating system transfers write their commands for: the one detected by resolveRemoteOS
+// or RemoteOS. Clients not built by a configurer take RemoteOSAuto as RemoteUnix.
+func (a *Client) remoteOS() RemoteOS {
+	if a.osDetection != nil {
+		return a.osDetection.remoteOS
+	}
+	if a.RemoteOS == RemoteOSAuto {
+		return RemoteUnix
+	}
+	return a.RemoteOS
+}
+
+// scpCommand the command line running the remote scp with flags on remotePath, without those it lacks.
+func (a *Client) scpCommand(flags string, remotePath string) string {
+	flags = a.scpFlags(flags)
+	binary := a.remoteBinary()
+	switch remoteOS := a.remoteOS(); {
+	case remoteOS == RemoteWindows && strings.ContainsAny(binary, " &()^"):
+		// cmd.exe removes the first and last quote of a command line holding more than two, so the
+		// quoted binary and path are wrapped in another pair.
+		return `"` + CmdQuote(binary) + " " + flags + " " + a.quotePath(remotePath) + `"`
+	case remoteOS == RemoteWindowsPowerShell && strings.ContainsAny(binary, " &()'$`"):
+		// PowerShell runs a quoted command with its call operator only.
+		binary = "& " + PowerShellQuote(binary)
+	}
+	return binary + " " + flags + " " + a.quotePath(remotePath)
+}
+
+// CmdQuote quotes s as a single argument of a Windows program run by cmd.exe, such as scp.exe. It is
+// wrapped in double quotes, within which cmd.exe leaves spaces, `&`, `|`, `<` and `>` alone, and the
+// backslashes and quotes that would end it are escaped the way programs split their command line.
+// cmd.exe still expands variables such as %PATH% within quotes, which can not be prevented.
+func CmdQuote(s string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	backslashes := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			backslashes++
+		case '"':
+			quoted.WriteString(strings.Repeat(`\`, backslashes+1))
+			backslashes = 0
+		default:
+			backslashes = 0
+		}
+		quoted.WriteByte(s[i])
+	}
+	// Backslashes before the closing quote would escape it.
+	quoted.WriteString(strings.Repeat(`\`, backslashes))
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// PowerShellQuote quotes s as a single word for PowerShell. It is wrapped in single quotes, in which
+// PowerShell interprets nothing but the quotes themselves, which are doubled. PowerShell takes the
+// typographic single quotes as quotes as well, so they are doubled too.
+func PowerShellQuote(s string) string {
+	return "'" + powerShellQuotes.Replace(s) + "'"
+}
+
+var powerShellQuotes = strings.NewReplacer("'", "''", "\u2018", "\u2018\u2018", "\u2019", "\u2019\u2019", "\u201a", "\u201a\u201a", "\u201b", "\u201b\u201b")
+
+// windowsPath writes remotePath the way it is given to scp.exe: with forward slashes, which Windows
+// takes as well and transfers split remote paths on, and with a drive letter given like in SFTP,
+// "/C:/Users", as "C:/Users". With ExpandTilde a leading `~/` is dropped, as relative paths start
+// at the home directory already.
+func (a *Client) windowsPath(remotePath string) string {
+	remotePath = strings.ReplaceAll(remotePath, `\`, "/")
+	if len(remotePath) >= 3 && remotePath[0] == '/' && remotePath[2] == ':' && isDriveLetter(remotePath[1]) {
+		remotePath = remotePath[1:]
+	}
+	if a.ExpandTilde {
+		if remotePath == "~" {
+			return "."
+		}
+		if rest, ok := strings.CutPrefix(remotePath, "~/"); ok {
+			if rest == "" {
+				return "."
+			}
+			return rest
+		}
+	}
+	return remotePath
+}
+
+func isDriveLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,28 @@
+package scp_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"main/scp"
+)
+
+func TestDetectRemoteOS(t *testing.T) {
+	client := newTestClient(t, func(c *scp.ClientConfigurer) { c.RemoteOS(scp.RemoteOSAuto) })
+	remoteOS, err := client.DetectRemoteOS(context.Background())
+	if err != nil || remoteOS != scp.RemoteUnix {
+		t.Errorf("DetectRemoteOS = %s, %v, want unix", remoteOS, err)
+	}
+
+	// Transfers detect it first and carry on with the POSIX shell.
+	remotePath := filepath.Join(t.TempDir(), "auto's file.txt")
+	if err := client.CopyFile(context.Background(), strings.NewReader("auto"), remotePath, "0644"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(remotePath); err != nil || string(got) != "auto" {
+		t.Errorf("the remote file holds %q, %v", got, err)
+	}
+}
@@ -0,0 +1,69 @@
+package scp
+
+import "testing"
+
+func TestCmdQuote(t *testing.T) {
+	tests := map[string]string{
+		`C:\Users\bram\file.txt`: `"C:\Users\bram\file.txt"`,
+		`with space & amp`:       `"with space & amp"`,
+		`C:\dir\`:                `"C:\dir\\"`,
+		`a\"b`:                   `"a\\\"b"`,
+		``:                       `""`,
+	}
+	for s, want := range tests {
+		if got := CmdQuote(s); got != want {
+			t.Errorf("CmdQuote(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestPowerShellQuote(t *testing.T) {
+	tests := map[string]string{
+		`C:\a b\$env:PATH`: `'C:\a b\$env:PATH'`,
+		`it's`:             `'it''s'`,
+		"it\u2019s":        "'it\u2019\u2019s'",
+	}
+	for s, want := range tests {
+		if got := PowerShellQuote(s); got != want {
+			t.Errorf("PowerShellQuote(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestWindowsPath(t *testing.T) {
+	a := &Client{ExpandTilde: true}
+	tests := map[string]string{
+		`C:\Users\bram\a.txt`: "C:/Users/bram/a.txt",
+		"/C:/Users/bram":      "C:/Users/bram",
+		"/data/a.txt":         "/data/a.txt",
+		"~/Desktop/a.txt":     "Desktop/a.txt",
+		"~":                   ".",
+		`dir\a.txt`:           "dir/a.txt",
+	}
+	for remotePath, want := range tests {
+		if got := a.windowsPath(remotePath); got != want {
+			t.Errorf("windowsPath(%q) = %q, want %q", remotePath, got, want)
+		}
+	}
+}
+
+func TestScpCommand(t *testing.T) {
+	tests := []struct {
+		remoteOS RemoteOS
+		binary   string
+		want     string
+	}{
+		{RemoteUnix, "scp", `scp -qt 'C:\a b'`},
+		{RemoteWindows, "scp", `scp -qt "C:/a b"`},
+		{RemoteWindows, `C:\Program Files\OpenSSH\scp.exe`, `""C:\Program Files\OpenSSH\scp.exe" -qt "C:/a b""`},
+		{RemoteWindowsPowerShell, "scp.exe", `scp.exe -qt 'C:/a b'`},
+		{RemoteWindowsPowerShell, `C:\Program Files\OpenSSH\scp.exe`, `& 'C:\Program Files\OpenSSH\scp.exe' -qt 'C:/a b'`},
+		{RemoteOSAuto, "scp", `scp -qt 'C:\a b'`},
+	}
+	for _, test := range tests {
+		a := &Client{RemoteOS: test.remoteOS, RemoteBinary: test.binary}
+		if got := a.scpCommand("-qt", `C:\a b`); got != test.want {
+			t.Errorf("scpCommand for %s with %s = %s, want %s", test.remoteOS, test.binary, got, test.want)
+		}
+	}
+}
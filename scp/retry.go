@@ -0,0 +1,112 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy how ConnectRetry repeats failed attempts to connect, waiting a delay growing
+// exponentially between them.
+type RetryPolicy struct {
+	// MaxAttempts the maximal amount of attempts, the first one included. Zero or less keeps
+	// trying until the context is done.
+	MaxAttempts int
+
+	// InitialDelay the delay before the second attempt, doubled after every further attempt.
+	InitialDelay time.Duration
+
+	// MaxDelay bounds the delay between attempts, zero leaves it unbounded.
+	MaxDelay time.Duration
+
+	// Jitter the fraction by which every delay is randomly shortened or lengthened, so clients
+	// failing at the same time do not retry in lockstep. 0.2 waits between 80% and 120% of the delay.
+	Jitter float64
+}
+
+// DefaultRetryPolicy tries to connect five times over about half a minute.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: time.Second,
+	MaxDelay:     15 * time.Second,
+	Jitter:       0.2,
+}
+
+// delay returns the time to wait after the failed attempt, counted from 1.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// ConnectRetry connects to the remote SSH server like ConnectContext, retrying according to policy
+// when an attempt fails for a reason that may pass, such as a host that is still booting or a
+// flaky network: failing to resolve the host or to reach it, and the connection breaking or timing
+// out during the handshake. Rejected credentials and host keys fail right away. Every failed attempt
+// is logged as a warning. It returns the error of the last attempt, or the cause of the context
+// when it is done while waiting.
+func (a *Client) ConnectRetry(ctx context.Context, policy RetryPolicy) error {
+	for attempt := 1; ; attempt++ {
+		err := a.ConnectContext(ctx)
+		if err == nil || ctx.Err() != nil || !isTransientConnectError(err) {
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			a.logf(ctx, LogError, "giving up connecting to %s after %d attempts", a.Host, attempt)
+			return err
+		}
+
+		delay := policy.delay(attempt)
+		a.logf(ctx, LogWarning, "attempt %d to connect to %s failed, retrying in %s: %v", attempt, a.Host, delay.Round(time.Millisecond), err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// isTransientConnectError tells whether connecting failed for a reason that may pass when trying again.
+func isTransientConnectError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		// Not found covers hosts whose name is only registered once they booted.
+		return dnsErr.IsTemporary || dnsErr.IsTimeout || dnsErr.IsNotFound
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		// Refused, unreachable or timed out.
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	// Servers still starting, or over their limit of unauthenticated connections, drop new ones
+	// during the handshake.
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED)
+}
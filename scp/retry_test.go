@@ -0,0 +1,83 @@
+package scp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt, want := range []time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 60: 5 * time.Second} {
+		if want == 0 {
+			continue
+		}
+		if got := policy.delay(attempt); got != want {
+			t.Errorf("delay after attempt %d = %s, want %s", attempt, got, want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.delay(2); got < time.Second || got > 3*time.Second {
+			t.Fatalf("delay with jitter = %s, want between 1s and 3s", got)
+		}
+	}
+}
+
+func TestIsTransientConnectError(t *testing.T) {
+	transient := []error{
+		&net.DNSError{Err: "no such host", Name: "booting.example.com", IsNotFound: true},
+		&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+		fmt.Errorf("ssh: handshake failed: %w", io.EOF),
+		fmt.Errorf("ssh: handshake failed: %w", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}),
+		context.DeadlineExceeded,
+	}
+	for _, err := range transient {
+		if !isTransientConnectError(err) {
+			t.Errorf("%v is not transient", err)
+		}
+	}
+
+	permanent := []error{
+		errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain"),
+		fmt.Errorf("ssh: handshake failed: %w", ErrHostKeyRejected),
+		errors.New("ssh: handshake failed: knownhosts: key mismatch"),
+	}
+	for _, err := range permanent {
+		if isTransientConnectError(err) {
+			t.Errorf("%v is transient", err)
+		}
+	}
+}
+
+func TestConnectRetryGivesUp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing listens on the port anymore, so connecting is refused.
+	host := listener.Addr().String()
+	listener.Close()
+
+	var warnings int
+	client := NewConfigurer(host, &ssh.ClientConfig{}).Logger(func(entry LogEntry) {
+		if entry.Level == LogWarning {
+			warnings++
+		}
+	}).Create()
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond}
+	if err := client.ConnectRetry(context.Background(), policy); err == nil {
+		t.Fatal("connected to a closed port")
+	}
+	if warnings != 2 {
+		t.Errorf("logged %d failed attempts before giving up, want 2", warnings)
+	}
+}
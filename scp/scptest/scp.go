@@ -0,0 +1,484 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scptest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+
+	"main/scp"
+)
+
+// scpOptions the flags of an scp command, as sent by clients: -t to run as the sink, -f as the
+// source, -r, -p and -d. -q and -v are accepted and ignored.
+type scpOptions struct {
+	sink, source, recursive, preserve, targetDirectory bool
+}
+
+// scp runs the scp command with args, talking the SCP protocol with the client over stdin and
+// stdout, and returns its exit status: 0, or 1 when any file failed, like OpenSSH's scp.
+func (s *Server) scp(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	var opts scpOptions
+	var paths []string
+	for i, arg := range args {
+		if arg == "--" {
+			paths = append(paths, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			paths = append(paths, arg)
+			continue
+		}
+		for _, flag := range arg[1:] {
+			switch flag {
+			case 't':
+				opts.sink = true
+			case 'f':
+				opts.source = true
+			case 'r':
+				opts.recursive = true
+			case 'p':
+				opts.preserve = true
+			case 'd':
+				opts.targetDirectory = true
+			case 'q', 'v':
+			default:
+				fmt.Fprintf(stderr, "scp: unknown option -- %c\n", flag)
+				return 1
+			}
+		}
+	}
+
+	session := &scpSession{server: s, opts: opts, in: bufio.NewReader(stdin), out: stdout}
+	switch {
+	case opts.sink == opts.source:
+		io.WriteString(stderr, "scp: exactly one of -t and -f is supported\n")
+		return 1
+	case opts.sink && len(paths) != 1:
+		io.WriteString(stderr, "scp: ambiguous target\n")
+		return 1
+	case opts.sink:
+		session.sink(paths[0])
+	default:
+		session.source(paths)
+	}
+	if session.failed {
+		return 1
+	}
+	return 0
+}
+
+// scpSession one run of the scp command, as the sink or the source of a transfer.
+type scpSession struct {
+	server *Server
+	opts   scpOptions
+	in     *bufio.Reader
+	out    io.Writer
+	// failed whether any file failed, which makes scp exit with status 1.
+	failed bool
+}
+
+// runErr sends the message as a warning, which the other side carries on after, like OpenSSH's
+// run_err. The session exits with status 1 at the end.
+func (c *scpSession) runErr(format string, args ...any) {
+	c.failed = true
+	fmt.Fprintf(c.out, "\x01scp: "+format+"\n", args...)
+}
+
+// fatal sends the message as an error, which ends the session.
+func (c *scpSession) fatal(format string, args ...any) error {
+	c.failed = true
+	fmt.Fprintf(c.out, "\x02scp: "+format+"\n", args...)
+	return &responseError{fatal: true, message: fmt.Sprintf(format, args...)}
+}
+
+func (c *scpSession) ack() error {
+	_, err := c.out.Write([]byte{scp.Ok})
+	return err
+}
+
+// response reads the response of the other side to the last record or body. A warning or error
+// is returned as a responseError.
+func (c *scpSession) response() error {
+	kind, err := c.in.ReadByte()
+	if err != nil {
+		return err
+	}
+	if kind == scp.Ok {
+		return nil
+	}
+	message, err := c.in.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	c.failed = true
+	return &responseError{fatal: kind != scp.Warning, message: strings.TrimSuffix(message, "\n")}
+}
+
+// responseError a warning or error the other side responded with.
+type responseError struct {
+	fatal   bool
+	message string
+}
+
+func (e *responseError) Error() string {
+	return e.message
+}
+
+// carryOn returns nil for a warning of the other side, after which the session carries on with
+// the next file, and err otherwise.
+func carryOn(err error) error {
+	var response *responseError
+	if errors.As(err, &response) && !response.fatal {
+		return nil
+	}
+	return err
+}
+
+// sinkDir a directory entered by a Directory record, with the times to give it once left.
+type sinkDir struct {
+	remotePath string
+	times      *scp.Record
+}
+
+// sink receives files into target, a file or a directory, until the source closes its side.
+func (c *scpSession) sink(target string) {
+	if c.opts.targetDirectory {
+		if info, err := os.Stat(c.server.Path(target)); err != nil || !info.IsDir() {
+			c.runErr("%s: Not a directory", target)
+			return
+		}
+	}
+	if c.ack() != nil {
+		return
+	}
+
+	var dirs []sinkDir
+	var times *scp.Record
+	for {
+		line, err := c.in.ReadString('\n')
+		if err != nil {
+			if line != "" {
+				c.failed = true
+			}
+			return
+		}
+		switch line[0] {
+		case scp.Warning, scp.Error:
+			// The source failed to send a file and tells why.
+			c.failed = true
+			if line[0] == scp.Error {
+				return
+			}
+			continue
+		}
+
+		record, err := scp.ParseRecord(line)
+		if err != nil {
+			c.fatal("protocol error: %v", err)
+			return
+		}
+		switch record.Type {
+		case scp.Time:
+			times = &record
+			err = c.ack()
+		case scp.Directory:
+			if !c.opts.recursive {
+				c.fatal("received directory without -r")
+				return
+			}
+			remotePath := c.destination(target, dirs, record.Name)
+			err = c.enterDir(remotePath, record)
+			dirs = append(dirs, sinkDir{remotePath: remotePath, times: times})
+			times = nil
+		case scp.EndDirectory:
+			if len(dirs) == 0 {
+				c.fatal("protocol error: unexpected <newline>")
+				return
+			}
+			dir := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			if dir.times != nil {
+				os.Chtimes(c.server.Path(dir.remotePath), dir.times.Atime, dir.times.Mtime)
+			}
+			err = c.ack()
+		case scp.Create:
+			err = c.receiveFile(c.destination(target, dirs, record.Name), record, times)
+			times = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// destination the remote path a record of the sink of target is written to.
+func (c *scpSession) destination(target string, dirs []sinkDir, name string) string {
+	if len(dirs) > 0 {
+		return path.Join(dirs[len(dirs)-1].remotePath, name)
+	}
+	if info, err := os.Stat(c.server.Path(target)); err == nil && info.IsDir() {
+		return path.Join(target, name)
+	}
+	return target
+}
+
+// enterDir creates the directory of a Directory record unless it exists.
+func (c *scpSession) enterDir(remotePath string, record scp.Record) error {
+	localPath := c.server.Path(remotePath)
+	if info, err := os.Stat(localPath); err == nil {
+		if !info.IsDir() {
+			return c.fatal("%s: Not a directory", remotePath)
+		}
+		if c.opts.preserve {
+			os.Chmod(localPath, record.Mode)
+		}
+		return c.ack()
+	}
+	if err := os.Mkdir(localPath, record.Mode|0700); err != nil {
+		return c.fatal("%s: %s", remotePath, strerror(err))
+	}
+	return c.ack()
+}
+
+// receiveFile receives the body of the file of a Create record into remotePath. A file that can
+// not be written is reported once its body was read, so the session can carry on.
+func (c *scpSession) receiveFile(remotePath string, record scp.Record, times *scp.Record) error {
+	localPath := c.server.Path(remotePath)
+	file, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, record.Mode)
+	if err != nil {
+		c.runErr("%s: %s", remotePath, strerror(err))
+		return nil
+	}
+	defer file.Close()
+	if err := c.ack(); err != nil {
+		return err
+	}
+
+	w := &sinkWriter{w: file}
+	if _, err := io.CopyN(w, c.in, record.Size); err != nil {
+		// The source went away in the middle of the body.
+		c.failed = true
+		return err
+	}
+	if err := carryOn(c.response()); err != nil {
+		return err
+	}
+
+	err = w.err
+	if err == nil {
+		err = file.Close()
+	}
+	if err == nil {
+		err = os.Chmod(localPath, record.Mode)
+	}
+	if err == nil && times != nil {
+		err = os.Chtimes(localPath, times.Atime, times.Mtime)
+	}
+	if err != nil {
+		c.runErr("%s: %s", remotePath, strerror(err))
+		return nil
+	}
+	return c.ack()
+}
+
+// sinkWriter writes a body to a file until that fails, and discards the rest of it.
+type sinkWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *sinkWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// source sends the files and directories of paths once the sink is ready.
+func (c *scpSession) source(paths []string) {
+	if c.response() != nil {
+		return
+	}
+	for _, remotePath := range paths {
+		if err := c.send(remotePath); err != nil {
+			c.failed = true
+			return
+		}
+	}
+}
+
+// send sends the file or directory remotePath, and the contents of a directory with -r.
+// Files that can not be sent are reported as warnings and skipped, as are files the sink
+// responds to with a warning.
+func (c *scpSession) send(remotePath string) error {
+	localPath := c.server.Path(remotePath)
+	info, err := os.Stat(localPath)
+	if err != nil {
+		c.runErr("%s: %s", remotePath, strerror(err))
+		return nil
+	}
+	name := path.Base(path.Clean("/" + remotePath))
+
+	switch {
+	case info.IsDir() && c.opts.recursive:
+		return c.sendDir(remotePath, name, info)
+	case !info.Mode().IsRegular():
+		c.runErr("%s: not a regular file", remotePath)
+		return nil
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		c.runErr("%s: %s", remotePath, strerror(err))
+		return nil
+	}
+	defer file.Close()
+
+	if err := c.sendTimes(info); err != nil {
+		return carryOn(err)
+	}
+	if _, err := io.WriteString(c.out, scp.FileRecord(name, info.Mode(), info.Size()).String()); err != nil {
+		return err
+	}
+	if err := c.response(); err != nil {
+		return carryOn(err)
+	}
+	n, err := io.CopyN(c.out, file, info.Size())
+	if err != nil {
+		// The file shrank, like OpenSSH's scp the body is padded and the failure sent in place of the status.
+		if _, err := io.CopyN(c.out, zeroReader{}, info.Size()-n); err != nil {
+			return err
+		}
+		c.runErr("%s: %s", remotePath, strerror(err))
+	} else if err := c.ack(); err != nil {
+		return err
+	}
+	return carryOn(c.response())
+}
+
+// zeroReader reads zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// sendDir sends a directory, its contents and the end of it.
+func (c *scpSession) sendDir(remotePath string, name string, info fs.FileInfo) error {
+	entries, err := os.ReadDir(c.server.Path(remotePath))
+	if err != nil {
+		c.runErr("%s: %s", remotePath, strerror(err))
+		return nil
+	}
+	if err := c.sendTimes(info); err != nil {
+		return carryOn(err)
+	}
+	if _, err := io.WriteString(c.out, scp.DirRecord(name, info.Mode()).String()); err != nil {
+		return err
+	}
+	if err := c.response(); err != nil {
+		return carryOn(err)
+	}
+	for _, entry := range entries {
+		if err := c.send(path.Join(remotePath, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(c.out, scp.Record{Type: scp.EndDirectory}.String()); err != nil {
+		return err
+	}
+	return carryOn(c.response())
+}
+
+// sendTimes sends the Time record of a file or directory with -p.
+func (c *scpSession) sendTimes(info fs.FileInfo) error {
+	if !c.opts.preserve {
+		return nil
+	}
+	if _, err := io.WriteString(c.out, scp.TimeRecord(info.ModTime(), info.ModTime()).String()); err != nil {
+		return err
+	}
+	return c.response()
+}
+
+// strerror describes err like the C library, such as "No such file or directory", which clients
+// match the messages of the remote on.
+func strerror(err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	message := []rune(err.Error())
+	if len(message) > 0 {
+		message[0] = unicode.ToUpper(message[0])
+	}
+	return string(message)
+}
+
+// splitCommand splits a command line into words like a POSIX shell, with single and double quotes
+// and backslashes, as clients quote the paths of scp commands. Other shell syntax, such as
+// variables, redirections or several commands, is not supported.
+func splitCommand(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(command); i++ {
+		ch := command[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case ch == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated quoted string")
+			}
+			word.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+		case ch == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("$`\"\\\n", command[i+1]) >= 0 {
+					i++
+				} else if command[i] == '$' || command[i] == '`' {
+					return nil, fmt.Errorf("unsupported shell syntax %q", command[i])
+				}
+				word.WriteByte(command[i])
+			}
+			if i == len(command) {
+				return nil, errors.New("unterminated quoted string")
+			}
+		case ch == '\\':
+			if i+1 < len(command) {
+				i++
+				word.WriteByte(command[i])
+			}
+		case strings.IndexByte("|&;<>()$`*?[#", ch) >= 0:
+			return nil, fmt.Errorf("unsupported shell syntax %q", ch)
+		default:
+			word.WriteByte(ch)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,346 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+// Package scptest provides an SSH server for tests. It runs within the test process and answers the
+// scp commands of its sessions itself, with files under a local directory. Clients can so be tested
+// from Connect to the end of a transfer in plain `go test`, without sshd, an scp binary, Docker or
+// access to the network.
+package scptest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"main/scp"
+)
+
+// Server an SSH server listening on the loopback interface, which accepts any client and runs
+// the scp commands of its sessions with the files under Root.
+type Server struct {
+	// Addr the address the server listens on, such as "127.0.0.1:40123".
+	Addr string
+
+	// Root the directory remote paths are resolved within, see Path.
+	Root string
+
+	// Home the directory relative paths and those starting at `~` are resolved within, Root when
+	// empty. It must be within Root.
+	Home string
+
+	// HostKey the public key the server identifies itself with.
+	HostKey ssh.PublicKey
+
+	// Exec runs the commands other than scp and returns their exit status. Without it they fail
+	// with exit status 127, like a shell not finding them. It must be set before clients connect.
+	Exec func(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int
+
+	// SFTP whether the sftp subsystem is served. Like Shell it serves the local file system, its
+	// paths are local paths whatever the Root, relative ones within Home. It must be set before
+	// clients connect.
+	SFTP bool
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	mu      sync.Mutex
+	version string
+	conns   map[net.Conn]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewServer starts a server with a temporary directory of t as its Root, and closes it once
+// the test finished.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	s, err := Start(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// NewShellServer starts a server sharing the local file system, like a remote on the same machine:
+// its Root is "/", so remote paths are local paths, and its Home a temporary directory of t. The
+// commands other than scp run with the local sh, see Shell. It skips the test without sh.
+func NewShellServer(t testing.TB) *Server {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("the shell server needs sh: %v", err)
+	}
+	s, err := Start(string(filepath.Separator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Home = t.TempDir()
+	s.Exec = s.Shell
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Start starts a server with root as its Root. It must be closed once no longer needed.
+func Start(root string) (*Server, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		Root:     root,
+		HostKey:  signer.PublicKey(),
+		listener: listener,
+		config:   config,
+		conns:    map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// ClientConfig returns a configuration for clients of the server, which checks its host key.
+func (s *Server) ClientConfig() *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.FixedHostKey(s.HostKey),
+	}
+}
+
+// Configurer returns a configurer for clients of the server.
+func (s *Server) Configurer() *scp.ClientConfigurer {
+	return scp.NewConfigurer(s.Addr, s.ClientConfig())
+}
+
+// Client returns a client connected to the server, which is closed once the test finished.
+func (s *Server) Client(t testing.TB) *scp.Client {
+	t.Helper()
+	client := s.Configurer().Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return &client
+}
+
+// Path returns the local path of the remote path remotePath. Absolute paths are resolved within
+// Root, relative ones and those starting at the home directory `~` within Home, and neither can
+// leave them: without a Home "/a/b", "a/b" and "~/a/b" are all Root/a/b.
+func (s *Server) Path(remotePath string) string {
+	if remotePath == "~" || strings.HasPrefix(remotePath, "~/") {
+		remotePath = remotePath[1:]
+	} else if s.Home != "" && !path.IsAbs(remotePath) {
+		remotePath = "/" + remotePath
+	} else {
+		return filepath.Join(s.Root, filepath.FromSlash(path.Clean("/"+remotePath)))
+	}
+	home := s.Home
+	if home == "" {
+		home = s.Root
+	}
+	return filepath.Join(home, filepath.FromSlash(path.Clean("/"+remotePath)))
+}
+
+// Shell runs command with the local sh in Home, which is its HOME as well, and returns its exit
+// status, or 128 plus the number of the signal that ended it, like a shell. Set it as Exec to run
+// commands like a remote on the same machine, their paths are local paths whatever the Root.
+func (s *Server) Shell(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	home := s.Home
+	if home == "" {
+		home = s.Root
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = home
+	cmd.Env = append(os.Environ(), "HOME="+home)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Copied apart from the command, which would otherwise wait for the client to close its side.
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		io.WriteString(stderr, err.Error()+"\n")
+		return 126
+	}
+	go func() {
+		io.Copy(in, stdin)
+		in.Close()
+	}()
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		io.WriteString(stderr, "sh: "+err.Error()+"\n")
+		return 127
+	}
+	return 0
+}
+
+// SetVersion sets the version banner the server identifies itself with to the clients connecting
+// afterwards, such as "SSH-2.0-OpenSSH_9.6". Defaults to "SSH-2.0-Go".
+func (s *Server) SetVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+}
+
+// Close stops the server and closes the connections of its clients.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// serve accepts connections until the server is closed.
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		config := *s.config
+		config.ServerVersion = s.version
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn, &config)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// serveConn runs the commands of the sessions of conn until it is closed.
+func (s *Server) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	var sessions sync.WaitGroup
+	defer sessions.Wait()
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			s.serveSession(channel, requests)
+		}()
+	}
+}
+
+// serveSession runs the command of the first exec request of a session, or the sftp subsystem when
+// served, and refuses any other request, such as for a shell or a terminal.
+func (s *Server) serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	started := false
+	for req := range requests {
+		var exec struct{ Command string }
+		if started || ssh.Unmarshal(req.Payload, &exec) != nil ||
+			req.Type != "exec" && (req.Type != "subsystem" || exec.Command != "sftp" || !s.SFTP) {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		started = true
+		run := s.run
+		if req.Type == "subsystem" {
+			run = s.sftp
+		}
+		go func() {
+			status := run(exec.Command, channel)
+			channel.CloseWrite()
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+			channel.Close()
+		}()
+	}
+}
+
+// run runs command with the channel as its standard input and output and returns its exit status.
+func (s *Server) run(command string, channel ssh.Channel) int {
+	args, err := splitCommand(command)
+	if err == nil && len(args) > 0 && path.Base(args[0]) == "scp" {
+		return s.scp(args[1:], channel, channel, channel.Stderr())
+	}
+	if s.Exec != nil {
+		return s.Exec(command, channel, channel, channel.Stderr())
+	}
+	io.WriteString(channel.Stderr(), "sh: "+command+": not found\n")
+	return 127
+}
+
+// sftp serves the sftp subsystem on the channel until the client closes it.
+func (s *Server) sftp(_ string, channel ssh.Channel) int {
+	home := s.Home
+	if home == "" {
+		home = s.Root
+	}
+	server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(home))
+	if err != nil {
+		io.WriteString(channel.Stderr(), err.Error()+"\n")
+		return 1
+	}
+	if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,222 @@
+package scptest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"main/scp"
+)
+
+func TestCopyRoundTrip(t *testing.T) {
+	server := NewServer(t)
+	client := server.Client(t)
+	ctx := context.Background()
+
+	if err := os.Mkdir(server.Path("/upload"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CopyFile(ctx, strings.NewReader("hello"), "/upload/hello.txt", "0640"); err != nil {
+		t.Fatal(err)
+	}
+	local := server.Path("/upload/hello.txt")
+	if got, err := os.ReadFile(local); err != nil || string(got) != "hello" {
+		t.Errorf("the remote file holds %q, %v", got, err)
+	}
+	if info, err := os.Stat(local); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("the remote file has mode %v, %v", info.Mode(), err)
+	}
+
+	var body bytes.Buffer
+	if err := client.CopyFromRemotePassThru(ctx, &body, "~/upload/hello.txt", nil); err != nil {
+		t.Fatal(err)
+	}
+	if body.String() != "hello" {
+		t.Errorf("downloaded %q, want %q", body.String(), "hello")
+	}
+}
+
+func TestRecursiveSessions(t *testing.T) {
+	server := NewServer(t)
+	client := server.Client(t)
+	ctx := context.Background()
+
+	source, err := client.NewSourceSession(ctx, "/", scp.SessionOptions{Recursive: true, TargetDirectory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1700000000, 0)
+	steps := []func() error{
+		func() error { return source.SendDir("dir", 0750) },
+		func() error { return source.SendTimes(mtime, mtime) },
+		func() error { return source.SendFile("it's a file.txt", 0600, 4, strings.NewReader("data")) },
+		source.EndDir,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(server.Root, "dir", "it's a file.txt")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Fatalf("the file was not received with its time: %v", err)
+	}
+
+	sink, err := client.NewSinkSession(ctx, "/dir", scp.SessionOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		record, err := sink.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record.String())
+		if record.Type == scp.Create {
+			var body bytes.Buffer
+			if _, err := sink.ReadBody(&body); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, body.String())
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"D0750 0 dir\n", "C0600 4 it's a file.txt\n", "data", "E\n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("received %q, want %q", got, want)
+	}
+}
+
+func TestFailures(t *testing.T) {
+	server := NewServer(t)
+	client := server.Client(t)
+	ctx := context.Background()
+
+	err := client.CopyFromRemotePassThru(ctx, io.Discard, "/missing.txt", nil)
+	if !errors.Is(err, scp.ErrRemoteNotFound) || !strings.Contains(err.Error(), "/missing.txt") {
+		t.Errorf("downloading a missing file returned %v, want ErrRemoteNotFound", err)
+	}
+
+	if _, err := client.Ping(ctx); err == nil {
+		t.Error("a command other than scp succeeded without Exec")
+	}
+}
+
+func TestExec(t *testing.T) {
+	server := NewServer(t)
+	server.Exec = func(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+		if command != "true" {
+			return 127
+		}
+		return 0
+	}
+	if _, err := server.Client(t).Ping(context.Background()); err != nil {
+		t.Errorf("Ping failed with Exec running true: %v", err)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	for command, want := range map[string][]string{
+		`scp -qt '/a b'`:              {"scp", "-qt", "/a b"},
+		`scp -f '/it'\''s'`:           {"scp", "-f", "/it's"},
+		`/usr/bin/scp -pf "/x \"y\""`: {"/usr/bin/scp", "-pf", `/x "y"`},
+		`scp -t ~/a\ b`:               {"scp", "-t", "~/a b"},
+	} {
+		got, err := splitCommand(command)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("splitCommand(%q) = %q, %v, want %q", command, got, err, want)
+		}
+	}
+	for _, command := range []string{`scp -t 'a`, `scp -t a; rm -rf b`, `scp -t "$HOME"`} {
+		if _, err := splitCommand(command); err == nil {
+			t.Errorf("splitCommand(%q) succeeded", command)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	server := &Server{Root: "/root"}
+	for remotePath, want := range map[string]string{
+		"/a/b":     "/root/a/b",
+		"a/b":      "/root/a/b",
+		"~/a/b":    "/root/a/b",
+		"../../..": "/root",
+	} {
+		if got := server.Path(remotePath); got != filepath.FromSlash(want) {
+			t.Errorf("Path(%q) = %q, want %q", remotePath, got, want)
+		}
+	}
+}
+
+func TestShellServer(t *testing.T) {
+	server := NewShellServer(t)
+	if got := server.Path("~/a/b"); got != filepath.Join(server.Home, "a", "b") {
+		t.Errorf("Path(~/a/b) = %q, want it within Home", got)
+	}
+	if got := server.Path("rel"); got != filepath.Join(server.Home, "rel") {
+		t.Errorf("Path(rel) = %q, want it within Home", got)
+	}
+
+	client := server.Client(t)
+	ctx := context.Background()
+	if err := client.CopyFile(ctx, strings.NewReader("home"), "~/file.txt", "0644"); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(t.TempDir(), "file.txt")
+	if err := client.CopyFile(ctx, strings.NewReader("local"), local, "0644"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(local); err != nil || string(got) != "local" {
+		t.Errorf("the local path holds %q, %v", got, err)
+	}
+
+	// Commands run in Home with the local sh.
+	var out bytes.Buffer
+	if status := server.Shell("cat file.txt; exit 3", strings.NewReader(""), &out, io.Discard); status != 3 || out.String() != "home" {
+		t.Errorf("Shell = %d, %q, want 3 and the uploaded file", status, out.String())
+	}
+	if status := server.Shell("kill -KILL $$", strings.NewReader(""), io.Discard, io.Discard); status != 137 {
+		t.Errorf("Shell of a killed command = %d, want 137", status)
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	server := NewShellServer(t)
+	client := server.Configurer().Backend(scp.BackendSFTP).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	local := filepath.Join(t.TempDir(), "file.txt")
+	if err := client.CopyFile(context.Background(), strings.NewReader("sftp"), local, "0644"); err == nil {
+		t.Error("SFTP upload succeeded without the subsystem")
+	}
+
+	server.SFTP = true
+	client = server.Configurer().Backend(scp.BackendSFTP).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.CopyFile(context.Background(), strings.NewReader("sftp"), local, "0644"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(local); err != nil || string(got) != "sftp" {
+		t.Errorf("the local path holds %q, %v", got, err)
+	}
+}
@@ -0,0 +1,53 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// PromptSecret returns a function asking for a secret in the terminal without showing it, such as the
+// verification code of a second factor. It returns context.Canceled when the prompt is dismissed,
+// and is meant to be passed to auth.Challenge.
+func PromptSecret(theme Theme) func(prompt string) ([]byte, error) {
+	theme = theme.withDefaults()
+	return func(prompt string) ([]byte, error) {
+		keys := newKeyMap()
+		keys.Quit.SetKeys("esc", "ctrl+c")
+		keys.Quit.SetHelp("esc", "cancel")
+		keys.ToggleLog.SetEnabled(false)
+		keys.ScrollUp.SetEnabled(false)
+		keys.ScrollDown.SetEnabled(false)
+		keys.Help.SetEnabled(false)
+		keys.Accept.SetEnabled(true)
+
+		input := textinput.New()
+		input.Prompt = strings.TrimSpace(prompt) + " "
+		input.EchoMode = textinput.EchoPassword
+		input.Focus()
+
+		result, err := tea.NewProgram(promptModel{
+			input: input,
+			keys:  keys,
+			help:  newHelp(theme),
+			theme: theme,
+		}).Run()
+		if err != nil {
+			return nil, err
+		}
+
+		m := result.(promptModel)
+		if !m.accepted {
+			return nil, context.Canceled
+		}
+		return []byte(m.input.Value()), nil
+	}
+}
@@ -0,0 +1,129 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultMaxSessions the amount of sessions a Client opens concurrently by default,
+// equal to the default MaxSessions of OpenSSH's sshd.
+const DefaultMaxSessions = 10
+
+// sessionPool bounds the amount of sessions open at the same time over a single
+// SSH connection. Sessions can not be reused once their command exited, the pool
+// therefore hands out slots rather than sessions.
+// A nil pool does not impose any bound.
+type sessionPool struct {
+	slots chan struct{}
+}
+
+func newSessionPool(max int) *sessionPool {
+	if max <= 0 {
+		return nil
+	}
+	return &sessionPool{slots: make(chan struct{}, max)}
+}
+
+// acquire blocks until a slot is free or the context is done.
+func (p *sessionPool) acquire(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+func (p *sessionPool) release() {
+	if p == nil {
+		return
+	}
+	<-p.slots
+}
+
+// newSession opens a new session over the SSH connection once the pool has room for it.
+// The returned function closes the session and returns its slot to the pool, it must
+// be called exactly once when the session is no longer needed.
+func (a *Client) newSession(ctx context.Context) (*ssh.Session, func(), error) {
+	client, err := a.connected(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := a.hostSessions.acquire(ctx); err != nil {
+		return nil, nil, err
+	}
+	if err := a.sessions.acquire(ctx); err != nil {
+		a.hostSessions.release()
+		return nil, nil, err
+	}
+
+	reportPhase(ctx, phaseSession)
+	session, err := client.NewSession()
+	if err != nil {
+		a.logf(ctx, LogError, "failed to open a session: %v", err)
+		a.sessions.release()
+		a.hostSessions.release()
+		return nil, nil, err
+	}
+
+	// Commands that set their own keep it, see remoteError.
+	session.Stderr = &stderrBuffer{}
+
+	reportPhase(ctx, phaseStart)
+	return session, func() {
+		session.Close()
+		a.sessions.release()
+		a.hostSessions.release()
+	}, nil
+}
+
+// maxStderr how much of the standard error of a remote command is kept to explain it failing.
+const maxStderr = 4 << 10
+
+// stderrBuffer keeps the start of the standard error of a remote command, where the cause of a
+// failure is usually told, and discards the rest so a chatty command can not exhaust the memory.
+type stderrBuffer struct {
+	mu        sync.Mutex
+	buf       []byte
+	truncated bool
+}
+
+func (b *stderrBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := min(len(p), maxStderr-len(b.buf))
+	b.buf = append(b.buf, p[:n]...)
+	if n < len(p) {
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// String returns the lines kept, joined by semicolons.
+func (b *stderrBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []string
+	for _, line := range strings.Split(string(b.buf), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	output := strings.Join(lines, "; ")
+	if b.truncated && output != "" {
+		output += " ..."
+	}
+	return output
+}
@@ -0,0 +1,59 @@
+package scp_test
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"main/scp/scptest"
+)
+
+// concurrencyServer returns a server whose commands take a while, and a function returning the most
+// of them that ran at the same time.
+func concurrencyServer(t *testing.T) (*scptest.Server, func() int) {
+	server := scptest.NewServer(t)
+	var mu sync.Mutex
+	running, most := 0, 0
+	server.Exec = func(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return 0
+	}
+	return server, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return most
+	}
+}
+
+func TestMaxSessions(t *testing.T) {
+	server, most := concurrencyServer(t)
+	client := server.Configurer().MaxSessions(2).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Ping(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := most(); n != 2 {
+		t.Errorf("ran %d sessions at once, want MaxSessions of them", n)
+	}
+}
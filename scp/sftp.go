@@ -0,0 +1,192 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// withSFTP opens an SFTP client on a new session and runs fn with it. The session
+// is torn down when the context is done before fn returns.
+func (a *Client) withSFTP(ctx context.Context, dog *watchdog, fn func(client *sftp.Client) error) error {
+	session, release, err := a.newSession(ctx)
+	if err != nil {
+		return fmt.Errorf("Error creating ssh session for sftp: %w", err)
+	}
+	defer release()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return fmt.Errorf("failed to start the sftp subsystem: %w", err)
+	}
+
+	client, err := sftp.NewClientPipe(dog.Reader(stdout, a.BufferSize), activityWriter{stdin, dog})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	errCh := make(chan error, 1)
+	go func() {
+		defer wg.Done()
+		errCh <- fn(client)
+	}()
+
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	ctx, stopWatch := a.watchIdle(ctx, dog)
+	defer stopWatch()
+
+	if err := wait(&wg, ctx, session); err != nil {
+		return err
+	}
+
+	return <-errCh
+}
+
+// sftpUpload is the SFTP backend counterpart of copyToRemote. When offset is positive the
+// remote file is kept up to offset and r is written from there on, r then only holds the remainder.
+func (a *Client) sftpUpload(
+	ctx context.Context,
+	r io.Reader,
+	result *UploadResult,
+	passThru PassThru,
+	offset int64,
+	times *FileInfos,
+) (*UploadResult, error) {
+	mode, err := ParseMode(result.Permissions)
+	if err != nil {
+		return result, err
+	}
+
+	if passThru != nil {
+		r = passThru(r, result.Size)
+	}
+
+	dog := newWatchdog()
+	err = a.withSFTP(ctx, dog, func(client *sftp.Client) error {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if offset > 0 {
+			flags = os.O_WRONLY | os.O_CREATE
+		}
+		f, err := client.OpenFile(result.RemotePath, flags)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if offset > 0 {
+			// Whatever follows the part kept would show through the holes left by writeSparse.
+			if err := f.Truncate(offset); err != nil {
+				return err
+			}
+		}
+		// A read of r that blocks would keep withSFTP from returning once ctx is done, see wait.
+		// Blocks of zeros are left out, so sparse files stay sparse on the remote.
+		result.BytesWritten, err = writeSparse(f, io.LimitReader(&interruptibleReader{ctx: ctx, r: r}, result.Size-offset), offset, a.BufferSize)
+		if err != nil {
+			return err
+		}
+		if err := f.Chmod(mode); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if times != nil {
+			err := client.Chtimes(result.RemotePath, time.Unix(times.Atime, 0), time.Unix(times.Mtime, 0))
+			if err != nil {
+				return err
+			}
+		}
+
+		result.Acked = true
+		return nil
+	})
+
+	return result, err
+}
+
+// sftpDownload is the SFTP backend counterpart of copyFromRemote. When offset is positive
+// the first offset bytes of the remote file are skipped.
+func (a *Client) sftpDownload(
+	ctx context.Context,
+	w io.Writer,
+	remotePath string,
+	passThru PassThru,
+	offset int64,
+) (*FileInfos, error) {
+	var fileInfos *FileInfos
+
+	dog := newWatchdog()
+	err := a.withSFTP(ctx, dog, func(client *sftp.Client) error {
+		f, err := client.Open(remotePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		stat, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		fileInfos = sftpFileInfos(stat)
+		if offset > fileInfos.Size {
+			return fmt.Errorf("offset %d is beyond the end of %s", offset, remotePath)
+		}
+		if err := checkLocalSpace(w, fileInfos.Size-offset); err != nil {
+			return err
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		var r io.Reader = f
+		if passThru != nil {
+			r = passThru(r, fileInfos.Size-offset)
+		}
+
+		_, err = copyN(ctx, w, r, fileInfos.Size-offset, a.BufferSize)
+		return err
+	})
+
+	return fileInfos, err
+}
+
+// sftpFileInfos converts the result of an SFTP stat into FileInfos.
+func sftpFileInfos(stat os.FileInfo) *FileInfos {
+	fileInfos := &FileInfos{
+		Filename:    stat.Name(),
+		Permissions: uint32(stat.Mode().Perm()),
+		Size:        stat.Size(),
+		Mtime:       stat.ModTime().Unix(),
+	}
+	if fileStat, ok := stat.Sys().(*sftp.FileStat); ok {
+		fileInfos.Atime = int64(fileStat.Atime)
+	}
+	return fileInfos
+}
@@ -0,0 +1,68 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// checkLocalSpace fails with ErrInsufficientSpace when w is a file, such as an *os.File, on a
+// filesystem without room for size more bytes. Other writers, and filesystems whose free space
+// can not be determined, pass.
+func checkLocalSpace(w io.Writer, size int64) error {
+	f, ok := w.(interface {
+		Fd() uintptr
+		Name() string
+	})
+	if !ok {
+		return nil
+	}
+	free, err := freeSpace(f.Fd())
+	if err != nil || free < 0 || size <= free {
+		return nil
+	}
+	return fmt.Errorf("%w: %s needs %d bytes, %d are free", ErrInsufficientSpace, f.Name(), size, free)
+}
+
+// checkRemoteSpace fails with ErrInsufficientSpace when the remote filesystem holding dir has no
+// room for size more bytes. A free space that can not be determined is logged and passes.
+func (a *Client) checkRemoteSpace(ctx context.Context, dir string, size int64) error {
+	free, err := a.remoteFreeSpace(ctx, dir)
+	if err != nil {
+		a.logf(ctx, LogWarning, "could not determine the free space of %s: %v", dir, err)
+		return nil
+	}
+	if size <= free {
+		return nil
+	}
+	return fmt.Errorf("%w: %s on %s needs %d bytes, %d are free", ErrInsufficientSpace, dir, a.Host, size, free)
+}
+
+// remoteFreeSpace returns the bytes available on the remote filesystem holding dir, using the
+// portable output format of df.
+func (a *Client) remoteFreeSpace(ctx context.Context, dir string) (int64, error) {
+	out, err := a.runOutput(ctx, "df -P -k "+a.shellPath(dir))
+	if err != nil {
+		return 0, err
+	}
+
+	// Filesystem 1024-blocks Used Available Capacity Mounted on
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 4 {
+		return 0, fmt.Errorf("unexpected output of df: %q", out)
+	}
+	available, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected output of df: %q", out)
+	}
+	return available * 1024, nil
+}
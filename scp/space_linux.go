@@ -0,0 +1,20 @@
+//go:build linux
+
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the filesystem holding the open file.
+func freeSpace(fd uintptr) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Fstatfs(int(fd), &stat); err != nil {
+		return -1, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
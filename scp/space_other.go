@@ -0,0 +1,14 @@
+//go:build !linux
+
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+// freeSpace the free space is not portably available outside of Linux, -1 tells it is unknown.
+func freeSpace(fd uintptr) (int64, error) {
+	return -1, nil
+}
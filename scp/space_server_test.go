@@ -0,0 +1,79 @@
+package scp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"main/scp"
+	"main/scp/scptest"
+)
+
+func TestLocalSpace(t *testing.T) {
+	// The remote announces a file larger than any disk, and would then wait for it to be acknowledged.
+	client := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.RemoteBinary(fakeRemoteBinary(t, `
+printf 'C0644 1000000000000000000 file\n'
+cat >/dev/null
+`))
+	})
+	f, err := os.Create(filepath.Join(t.TempDir(), "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := client.CopyFromRemote(context.Background(), f, "file"); !errors.Is(err, scp.ErrInsufficientSpace) {
+		t.Errorf("download of a file larger than the free space returned %v, want ErrInsufficientSpace", err)
+	}
+}
+
+func TestRemoteSpace(t *testing.T) {
+	server := scptest.NewShellServer(t)
+	var mu sync.Mutex
+	df := "Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/sda1 100 99 1 99% /\n"
+	server.Exec = func(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+		if strings.HasPrefix(command, "df ") {
+			mu.Lock()
+			defer mu.Unlock()
+			if df == "" {
+				return 127
+			}
+			io.WriteString(stdout, df)
+			return 0
+		}
+		return server.Shell(command, stdin, stdout, stderr)
+	}
+	client := server.Configurer().CheckRemoteSpace(true).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+	remote := filepath.Join(t.TempDir(), "file")
+
+	// 1KiB are free.
+	err := client.Copy(ctx, strings.NewReader(strings.Repeat("x", 2000)), remote, "0644", 2000)
+	if !errors.Is(err, scp.ErrInsufficientSpace) || !strings.Contains(err.Error(), "1024 are free") {
+		t.Errorf("upload larger than the free space returned %v, want ErrInsufficientSpace with the free space", err)
+	}
+	if _, err := os.Stat(remote); err == nil {
+		t.Error("the upload was sent although it does not fit")
+	}
+	if err := client.Copy(ctx, strings.NewReader("fits"), remote, "0644", 4); err != nil {
+		t.Errorf("upload that fits returned %v", err)
+	}
+
+	// Without df the upload is tried anyway.
+	mu.Lock()
+	df = ""
+	mu.Unlock()
+	if err := client.Copy(ctx, strings.NewReader(strings.Repeat("x", 2000)), remote, "0644", 2000); err != nil {
+		t.Errorf("upload to a remote without df returned %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"io"
+)
+
+// sparseBlockSize the granularity at which runs of zeros are detected,
+// matches the block size of most file systems.
+const sparseBlockSize = 4096
+
+// SparseFile is the destination of a sparse download, *os.File satisfies it.
+type SparseFile interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+}
+
+// sparseWriter writes to a SparseFile but seeks over blocks only containing
+// zeros instead of writing them, leaving holes in the destination file.
+type sparseWriter struct {
+	file SparseFile
+	// pos the logical position in the file, including skipped zeros
+	pos int64
+	// hole the amount of zeros skipped since the last real write
+	hole int64
+}
+
+func newSparseWriter(file SparseFile) (*sparseWriter, error) {
+	pos, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return &sparseWriter{file: file, pos: pos}, nil
+}
+
+func (s *sparseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := sparseBlockSize - int(s.pos%sparseBlockSize)
+		if n > len(p) {
+			n = len(p)
+		}
+		block := p[:n]
+
+		if isZero(block) {
+			s.hole += int64(n)
+		} else {
+			if s.hole > 0 {
+				if _, err := s.file.Seek(s.hole, io.SeekCurrent); err != nil {
+					return written, err
+				}
+				s.hole = 0
+			}
+			if _, err := s.file.Write(block); err != nil {
+				return written, err
+			}
+		}
+
+		s.pos += int64(n)
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Finish materializes a trailing hole by extending the file to its full size,
+// seeking alone does not change the size of a file.
+func (s *sparseWriter) Finish() error {
+	if s.hole == 0 {
+		return nil
+	}
+	if err := s.file.Truncate(s.pos); err != nil {
+		return err
+	}
+	_, err := s.file.Seek(s.pos, io.SeekStart)
+	s.hole = 0
+	return err
+}
+
+// sparseRegion a run of data in a sparse file, the rest of which are holes reading as zeros.
+type sparseRegion struct {
+	offset int64
+	length int64
+}
+
+// sparseWriterAt the destination of writeSparse, *os.File and *sftp.File satisfy it.
+type sparseWriterAt interface {
+	io.WriterAt
+	Truncate(size int64) error
+}
+
+// writeSparse copies r to f from offset on through a buffer of bufferSize, but leaves out the blocks
+// only containing zeros so they become holes of f. The runs of data in between are written with
+// WriteAt in one go each, and f is extended to its full size when it ends with a hole. As nothing
+// is written over the holes, f must hold nothing beyond offset. It returns the bytes read from r.
+func writeSparse(f sparseWriterAt, r io.Reader, offset int64, bufferSize int) (int64, error) {
+	pool := bufferPool(bufferSize)
+	buf := pool.Get().(*[]byte)
+	defer pool.Put(buf)
+
+	// end the end of the data written so far, pos the one of the data read.
+	pos, end := offset, offset
+	for {
+		n, err := io.ReadFull(r, *buf)
+		data := (*buf)[:n]
+		for len(data) > 0 {
+			run := zeroBlocks(data, pos, false)
+			if run > 0 {
+				if _, err := f.WriteAt(data[:run], pos); err != nil {
+					return pos - offset, err
+				}
+				pos += int64(run)
+				end = pos
+				data = data[run:]
+			}
+			hole := zeroBlocks(data, pos, true)
+			pos += int64(hole)
+			data = data[hole:]
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return pos - offset, err
+		}
+	}
+
+	if pos > end {
+		if err := f.Truncate(pos); err != nil {
+			return pos - offset, err
+		}
+	}
+	return pos - offset, nil
+}
+
+// zeroBlocks returns the length of the leading blocks of p, which starts at pos in the file, that
+// only contain zeros when zero is set, or that contain anything else when it is not.
+func zeroBlocks(p []byte, pos int64, zero bool) int {
+	n := 0
+	for n < len(p) {
+		size := min(sparseBlockSize-int((pos+int64(n))%sparseBlockSize), len(p)-n)
+		if isZero(p[n:n+size]) != zero {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataRegions returns the regions of the first size bytes of f holding data, found with SEEK_DATA
+// and SEEK_HOLE, and leaves the offset of f at the start. File systems without holes report a
+// single region of everything, as does a failure to tell them apart.
+func dataRegions(f *os.File, size int64) []sparseRegion {
+	regions := []sparseRegion{}
+	for offset := int64(0); offset < size; {
+		data, err := f.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// Only a hole is left.
+			break
+		}
+		if err != nil {
+			regions = []sparseRegion{{offset: 0, length: size}}
+			break
+		}
+		if data >= size {
+			break
+		}
+		hole, err := f.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			regions = []sparseRegion{{offset: 0, length: size}}
+			break
+		}
+		hole = min(hole, size)
+		regions = append(regions, sparseRegion{offset: data, length: hole - data})
+		offset = hole
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return []sparseRegion{{offset: 0, length: size}}
+	}
+	return regions
+}
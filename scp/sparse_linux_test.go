@@ -0,0 +1,77 @@
+package scp
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSparseFile creates a file of size bytes holding data at the given offsets and holes elsewhere,
+// skipping the test when the file system of the temporary directory has no holes.
+func writeSparseFile(t *testing.T, name string, size int64, data map[int64]string) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	for offset, s := range data {
+		if _, err := f.WriteAt([]byte(s), offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if regions := dataRegions(f, size); len(regions) != len(data) {
+		t.Skipf("the file system has the data regions %v instead of holes", regions)
+	}
+}
+
+// countingProgress counts the bytes reported to it.
+type countingProgress struct{ bytes int64 }
+
+func (p *countingProgress) Start(int64, int)   {}
+func (p *countingProgress) File(string, int64) {}
+func (p *countingProgress) Add(n int64)        { p.bytes += n }
+
+func TestSparseTarEntry(t *testing.T) {
+	dir := t.TempDir()
+	const size = 8 << 20
+	writeSparseFile(t, filepath.Join(dir, "disk.img"), size, map[int64]string{1 << 20: "boot", 5 << 20: "data"})
+
+	var archive bytes.Buffer
+	progress := &countingProgress{}
+	if _, err := writeTar(&archive, dir, TarOptions{}, progress, 0); err != nil {
+		t.Fatal(err)
+	}
+	// The holes are left out of the archive, but count as transferred.
+	if archive.Len() > 64<<10 {
+		t.Errorf("the archive of a sparse file of %d bytes has %d bytes", size, archive.Len())
+	}
+	if progress.bytes != size {
+		t.Errorf("the progress received %d bytes, want the %d of the file", progress.bytes, size)
+	}
+
+	tr := tar.NewReader(&archive)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "disk.img" || hdr.Size != size {
+		t.Errorf("the archive holds %s of %d bytes", hdr.Name, hdr.Size)
+	}
+	got, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, size)
+	copy(want[1<<20:], "boot")
+	copy(want[5<<20:], "data")
+	if !bytes.Equal(got, want) {
+		t.Error("the archive does not hold the contents of the file")
+	}
+}
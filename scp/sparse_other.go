@@ -0,0 +1,16 @@
+//go:build !linux
+
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import "os"
+
+// dataRegions holes are only detected on Linux, elsewhere all of the file is reported as data.
+func dataRegions(f *os.File, size int64) []sparseRegion {
+	return []sparseRegion{{offset: 0, length: size}}
+}
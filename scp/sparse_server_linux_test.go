@@ -0,0 +1,105 @@
+package scp_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"main/scp"
+	"main/scp/scptest"
+)
+
+const sparseSize = 8 << 20
+
+// sparseFile creates a file of sparseSize bytes holding data only at 1 and 5 MiB, skipping
+// the test when the file system of the temporary directory has no holes.
+func sparseFile(t *testing.T, name string) []byte {
+	t.Helper()
+	contents := make([]byte, sparseSize)
+	copy(contents[1<<20:], "boot")
+	copy(contents[5<<20:], "data")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(sparseSize); err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []int64{1 << 20, 5 << 20} {
+		if _, err := f.WriteAt(contents[offset:offset+4], offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if allocated(t, name) >= sparseSize {
+		t.Skip("the file system has no holes")
+	}
+	return contents
+}
+
+// allocated returns the bytes the file system stores for name.
+func allocated(t *testing.T, name string) int64 {
+	t.Helper()
+	var stat syscall.Stat_t
+	if err := syscall.Stat(name, &stat); err != nil {
+		t.Fatal(err)
+	}
+	return stat.Blocks * 512
+}
+
+// checkSparse fails unless name holds contents and has kept its holes.
+func checkSparse(t *testing.T, how string, name string, contents []byte) {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, contents) {
+		t.Errorf("%s changed the contents of the sparse file", how)
+	}
+	if n := allocated(t, name); n > 1<<20 {
+		t.Errorf("%s stored %d bytes of the sparse file of %d bytes", how, n, sparseSize)
+	}
+}
+
+func TestSparseUploadSFTP(t *testing.T) {
+	server := scptest.NewShellServer(t)
+	server.SFTP = true
+	client := server.Configurer().Backend(scp.BackendSFTP).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	local := filepath.Join(t.TempDir(), "disk.img")
+	contents := sparseFile(t, local)
+	f, err := os.Open(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	remote := filepath.Join(t.TempDir(), "disk.img")
+	if err := client.CopyFromFile(context.Background(), *f, remote, "0644"); err != nil {
+		t.Fatal(err)
+	}
+	checkSparse(t, "the SFTP upload", remote, contents)
+}
+
+func TestSparseUploadTar(t *testing.T) {
+	client := newTestClient(t, nil)
+	local := t.TempDir()
+	contents := sparseFile(t, filepath.Join(local, "disk.img"))
+
+	remote := t.TempDir()
+	progress := &recordingProgress{}
+	if _, err := client.CopyDirToRemoteTar(context.Background(), local, remote, scp.TarOptions{Progress: progress}); err != nil {
+		t.Fatal(err)
+	}
+	checkSparse(t, "the tar upload", filepath.Join(remote, "disk.img"), contents)
+	if progress.bytes != sparseSize {
+		t.Errorf("the progress received %d bytes, want the %d of the file with its holes", progress.bytes, sparseSize)
+	}
+}
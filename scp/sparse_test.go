@@ -0,0 +1,96 @@
+package scp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countingFile counts the bytes written to the file.
+type countingFile struct {
+	*os.File
+	written int
+}
+
+func (f *countingFile) Write(p []byte) (int, error) {
+	f.written += len(p)
+	return f.File.Write(p)
+}
+
+func (f *countingFile) WriteAt(p []byte, off int64) (int, error) {
+	f.written += len(p)
+	return f.File.WriteAt(p, off)
+}
+
+// sparseData returns data with runs of zeros, ending with one.
+func sparseData() []byte {
+	data := make([]byte, 0, 6*sparseBlockSize)
+	data = append(data, bytes.Repeat([]byte("a"), sparseBlockSize+10)...)
+	data = append(data, make([]byte, 3*sparseBlockSize)...)
+	data = append(data, bytes.Repeat([]byte("b"), 100)...)
+	// A trailing hole, which seeking alone would lose.
+	return append(data, make([]byte, 2*sparseBlockSize)...)
+}
+
+func TestSparseWriter(t *testing.T) {
+	data := sparseData()
+
+	name := filepath.Join(t.TempDir(), "sparse")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	file := &countingFile{File: f}
+	w, err := newSparseWriter(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// In pieces not aligned with the blocks, like the reads of a transfer.
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 1000)
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := os.ReadFile(name); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("the file holds %d bytes, %v, want the %d written", len(got), err, len(data))
+	}
+	// Only the parts of blocks holding anything but zeros are written, the three of them at most.
+	if file.written < sparseBlockSize+110 || file.written > 3*sparseBlockSize {
+		t.Errorf("wrote %d of the %d bytes", file.written, len(data))
+	}
+}
+
+func TestWriteSparse(t *testing.T) {
+	data := sparseData()
+	name := filepath.Join(t.TempDir(), "sparse")
+	// The part of an earlier run, which is kept.
+	if err := os.WriteFile(name, []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	file := &countingFile{File: f}
+
+	// Through a buffer not aligned with the blocks.
+	n, err := writeSparse(file, bytes.NewReader(data), 4, 1000)
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("writeSparse copied %d bytes, %v, want %d", n, err, len(data))
+	}
+	if got, err := os.ReadFile(name); err != nil || string(got[:4]) != "kept" || !bytes.Equal(got[4:], data) {
+		t.Errorf("the file holds %d bytes, %v, want the part kept and the %d written", len(got), err, len(data))
+	}
+	if file.written < sparseBlockSize+110 || file.written > 3*sparseBlockSize {
+		t.Errorf("wrote %d of the %d bytes", file.written, len(data))
+	}
+}
@@ -0,0 +1,62 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultSpoolThreshold how much of a reader of unknown size is held in memory by default,
+// see Client.SpoolThreshold.
+const DefaultSpoolThreshold = 32 << 20
+
+// spool reads r until it ends to learn its size, which the protocol announces before the contents.
+// Up to SpoolThreshold bytes are held in memory, longer readers are spooled to a temporary file.
+// The returned reader yields the contents of r, release frees them.
+func (a *Client) spool(ctx context.Context, r io.Reader) (contents io.Reader, size int64, release func(), err error) {
+	threshold := a.SpoolThreshold
+	if threshold == 0 {
+		threshold = DefaultSpoolThreshold
+	}
+	r = contextReader{ctx, r}
+
+	var head []byte
+	if threshold > 0 {
+		// One byte more tells whether r goes on beyond the threshold.
+		head, err = io.ReadAll(io.LimitReader(r, threshold+1))
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("failed to read all data from reader: %w", err)
+		}
+		if int64(len(head)) <= threshold {
+			return bytes.NewReader(head), int64(len(head)), func() {}, nil
+		}
+	}
+
+	file, err := os.CreateTemp("", "go-scp-tui-*")
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to spool the reader: %w", err)
+	}
+	release = func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	a.logf(ctx, LogInfo, "spooling to %s", file.Name())
+	size, err = copyBuffer(file, io.MultiReader(bytes.NewReader(head), r), a.BufferSize)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		release()
+		return nil, 0, nil, fmt.Errorf("failed to spool the reader: %w", err)
+	}
+	return file, size, release, nil
+}
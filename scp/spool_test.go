@@ -0,0 +1,43 @@
+package scp
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	for _, test := range []struct {
+		threshold int64
+		data      string
+		onDisk    bool
+	}{
+		{threshold: 0, data: "small", onDisk: false},
+		{threshold: 5, data: "12345", onDisk: false},
+		{threshold: 5, data: "123456", onDisk: true},
+		{threshold: -1, data: "", onDisk: true},
+		{threshold: -1, data: "always", onDisk: true},
+	} {
+		client := &Client{SpoolThreshold: test.threshold}
+		contents, size, release, err := client.spool(context.Background(), strings.NewReader(test.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, onDisk := contents.(*os.File)
+		if onDisk != test.onDisk {
+			t.Errorf("spooling %q with the threshold %d went to disk: %v", test.data, test.threshold, onDisk)
+		}
+		data, _ := io.ReadAll(contents)
+		if string(data) != test.data || size != int64(len(test.data)) {
+			t.Errorf("spooling %q yielded %q of the size %d", test.data, data, size)
+		}
+		release()
+		if onDisk {
+			if _, err := os.Stat(file.Name()); !os.IsNotExist(err) {
+				t.Errorf("release left %s behind", file.Name())
+			}
+		}
+	}
+}
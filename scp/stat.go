@@ -0,0 +1,136 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Stat returns the size, permissions and modification and access times of the remote file,
+// without transferring its contents, to decide cheaply whether to overwrite or resume it.
+// With the SCP backend the remote scp is asked for the file and stopped once it announced it,
+// which only works for regular files; the SFTP backend describes directories as well.
+// The scp of CompatBusyBox announces no times, they are read with `stat -c` instead.
+func (a *Client) Stat(ctx context.Context, remotePath string) (*FileInfos, error) {
+	backend, err := a.resolveBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if backend == BackendSFTP {
+		var fileInfos *FileInfos
+		err := a.withSFTP(ctx, newWatchdog(), func(client *sftp.Client) error {
+			stat, err := client.Stat(remotePath)
+			if err != nil {
+				return err
+			}
+			fileInfos = sftpFileInfos(stat)
+			return nil
+		})
+		return fileInfos, err
+	}
+
+	fileInfos, err := a.scpStat(ctx, remotePath)
+	if err != nil || a.compat() != CompatBusyBox {
+		return fileInfos, err
+	}
+	if fileInfos.Mtime, fileInfos.Atime, err = a.remoteTimes(ctx, remotePath); err != nil {
+		return nil, fmt.Errorf("failed to read the times of %s: %w", remotePath, err)
+	}
+	return fileInfos, nil
+}
+
+// scpStat is Stat for the SCP backend, probing the file with the remote scp.
+func (a *Client) scpStat(ctx context.Context, remotePath string) (*FileInfos, error) {
+	if err := a.resolveRemoteBinary(ctx); err != nil {
+		return nil, err
+	}
+
+	session, release, err := a.newSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating ssh session in stat: %w", err)
+	}
+	defer release()
+
+	wg := sync.WaitGroup{}
+	errCh := make(chan error, 1)
+	var fileInfos *FileInfos
+	dog := newWatchdog()
+
+	wg.Add(1)
+	go func(ctx context.Context) {
+		defer wg.Done()
+
+		var err error
+		fileInfos, err = a.probeFile(ctx, session, dog, remotePath)
+		errCh <- err
+	}(ctx)
+
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	ctx, stopWatch := a.watchIdle(ctx, dog)
+	defer stopWatch()
+
+	if err := wait(&wg, ctx, session); err != nil {
+		return nil, err
+	}
+
+	return fileInfos, remoteError(session, <-errCh)
+}
+
+// remoteTimes returns the modification and access times of the remote file in seconds, read with
+// `stat -c`, which GNU and BusyBox stat have, for remotes whose scp does not announce them.
+func (a *Client) remoteTimes(ctx context.Context, remotePath string) (mtime int64, atime int64, err error) {
+	out, err := a.runOutput(ctx, "stat -c '%Y %X' "+a.shellPath(remotePath))
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := fmt.Sscanf(string(out), "%d %d", &mtime, &atime); err != nil {
+		return 0, 0, fmt.Errorf("unexpected output of stat: %q", out)
+	}
+	return mtime, atime, nil
+}
+
+// probeFile runs the remote scp in source mode on the session, like receiveFile, but stops it
+// once it announced the file instead of acknowledging it, so the contents are never sent.
+func (a *Client) probeFile(ctx context.Context, session *ssh.Session, dog *watchdog, remotePath string) (*FileInfos, error) {
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(dog.Reader(stdout, a.BufferSize))
+
+	in, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	if err := session.Start(a.scpCommand("-pf", remotePath)); err != nil {
+		return nil, err
+	}
+
+	if err := Ack(in); err != nil {
+		return nil, a.remoteStartError(session, err)
+	}
+
+	fileInfos, err := a.readFileRecord(ctx, r, in)
+	if err != nil {
+		return nil, a.remoteStartError(session, err)
+	}
+
+	terminate(session)
+	return fileInfos, nil
+}
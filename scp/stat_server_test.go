@@ -0,0 +1,79 @@
+package scp_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"main/scp"
+	"main/scp/scptest"
+)
+
+func TestStat(t *testing.T) {
+	sftpServer := scptest.NewShellServer(t)
+	sftpServer.SFTP = true
+	backends := map[string]*scp.ClientConfigurer{
+		"scp":  scptest.NewShellServer(t).Configurer(),
+		"sftp": sftpServer.Configurer().Backend(scp.BackendSFTP),
+	}
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file")
+	if err := os.WriteFile(name, []byte("hello world"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(name, 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1700000000, 0)
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	for backend, configurer := range backends {
+		t.Run(backend, func(t *testing.T) {
+			client := configurer.Create()
+			if err := client.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			ctx := context.Background()
+
+			stat, err := client.Stat(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat.Size != 11 || stat.Permissions&0777 != 0640 || stat.Mtime != mtime.Unix() {
+				t.Errorf("Stat returned %+v", stat)
+			}
+			if _, err := client.Stat(ctx, filepath.Join(dir, "missing")); err == nil {
+				t.Error("Stat of a missing file succeeded")
+			}
+		})
+	}
+}
+
+func TestStatBusyBox(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(name, []byte("hello world"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime, atime := time.Unix(1700000000, 0), time.Unix(1700000100, 0)
+	if err := os.Chtimes(name, atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	// Run without -p, the scp of BusyBox announces the file without its times.
+	script := "[ \"$1\" = -f ] || exit 1\nprintf 'C0640 11 file\\n'\ncat >/dev/null\n"
+	client := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.Compat(scp.CompatBusyBox).RemoteBinary(fakeRemoteBinary(t, script))
+	})
+
+	stat, err := client.Stat(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size != 11 || stat.Mtime != mtime.Unix() || stat.Atime != atime.Unix() {
+		t.Errorf("Stat returned %+v, want the times read with stat", stat)
+	}
+}
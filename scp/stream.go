@@ -0,0 +1,193 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// CopyStream uploads the contents of r, such as the standard input of a pipeline, to remotePath.
+// The protocol announces the size of a file before its contents, so size is the amount of bytes
+// r yields, failing the transfer with ErrSizeMismatch when it yields another amount. When size is
+// negative r is read until it ends first, spooled to a temporary file beyond SpoolThreshold bytes.
+func (a *Client) CopyStream(
+	ctx context.Context,
+	r io.Reader,
+	remotePath string,
+	permissions string,
+	size int64,
+) error {
+	return a.copyStream(ctx, r, size, func(r io.Reader, size int64) error {
+		return a.Copy(ctx, r, remotePath, permissions, size)
+	})
+}
+
+// CopyStreamProgress is the same as CopyStream but renders a progress bar with the speed and the
+// estimated time left in the terminal. Without a size it shows up once r is spooled.
+func (a *Client) CopyStreamProgress(
+	ctx context.Context,
+	r io.Reader,
+	remotePath string,
+	permissions string,
+	size int64,
+) error {
+	return a.copyStream(ctx, r, size, func(r io.Reader, size int64) error {
+		return a.CopyToRemoteProgress(ctx, r, remotePath, permissions, size)
+	})
+}
+
+func (a *Client) copyStream(ctx context.Context, r io.Reader, size int64, upload func(r io.Reader, size int64) error) error {
+	if size >= 0 {
+		return upload(&sizedReader{r: r, left: size}, size)
+	}
+
+	contents, size, release, err := a.spool(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return upload(contents, size)
+}
+
+// sizedReader reads exactly left bytes from r, failing with ErrSizeMismatch when r ends
+// before or continues after them.
+type sizedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (s *sizedReader) Read(p []byte) (int, error) {
+	if s.left <= 0 {
+		var extra [1]byte
+		if n, _ := io.ReadFull(s.r, extra[:]); n > 0 {
+			return 0, fmt.Errorf("%w: the stream is longer than announced", ErrSizeMismatch)
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > s.left {
+		p = p[:s.left]
+	}
+	n, err := s.r.Read(p)
+	s.left -= int64(n)
+	if err == io.EOF && s.left > 0 {
+		return n, fmt.Errorf("%w: the stream ended %d bytes short", ErrSizeMismatch, s.left)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// contextReader stops reading once its context is done, for readers such as the standard
+// input that block with no way of cancelling them.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := context.Cause(c.ctx); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// interruptibleReader is a contextReader which also gives up on a read that is blocked when its
+// context is done. That read is left to finish on its own, into a buffer of the reader rather
+// than p, which the caller may reuse once Read returned.
+type interruptibleReader struct {
+	ctx context.Context
+	r   io.Reader
+	buf []byte
+}
+
+func (c *interruptibleReader) Read(p []byte) (int, error) {
+	if err := context.Cause(c.ctx); err != nil {
+		return 0, err
+	}
+	if len(c.buf) < len(p) {
+		c.buf = make([]byte, len(p))
+	}
+	buf := c.buf[:len(p)]
+
+	type read struct {
+		n   int
+		err error
+	}
+	done := make(chan read, 1)
+	go func() {
+		n, err := c.r.Read(buf)
+		done <- read{n, err}
+	}()
+	select {
+	case read := <-done:
+		return copy(p, buf[:read.n]), read.err
+	case <-c.ctx.Done():
+		// The abandoned read still fills buf.
+		c.buf = nil
+		return 0, context.Cause(c.ctx)
+	}
+}
+
+// pipeUpload encodes r with the writer returned by encode into decode on the remote, a command reading
+// the encoded stream from its standard input and writing the file to its standard output, which is
+// redirected to result.RemotePath. The size of the encoded stream is not known ahead, so passThru is
+// handed a negative total. Times are applied with `touch -d`, which needs GNU or BusyBox touch.
+func (a *Client) pipeUpload(
+	ctx context.Context,
+	r io.Reader,
+	result *UploadResult,
+	passThru PassThru,
+	times *FileInfos,
+	decode string,
+	encode func(w io.Writer) (io.WriteCloser, error),
+) (*UploadResult, error) {
+	target := a.shellPath(result.RemotePath)
+	cmd := fmt.Sprintf("%s > %s && chmod %s %s", decode, target, ShellQuote(result.Permissions), target)
+	if times != nil {
+		cmd += fmt.Sprintf(" && touch -a -d @%d %s && touch -m -d @%d %s", times.Atime, target, times.Mtime, target)
+	}
+
+	err := a.runStream(ctx, cmd, func(stdin io.WriteCloser, _ io.Reader) error {
+		encoded, w := io.Pipe()
+		read := make(chan int64, 1)
+		go func() {
+			var n int64
+			enc, err := encode(w)
+			if err == nil {
+				n, err = copyBuffer(enc, r, a.BufferSize)
+				// The encoder failing tells more than the write failing with it.
+				if closeErr := enc.Close(); closeErr != nil {
+					err = closeErr
+				}
+			}
+			read <- n
+			w.CloseWithError(err)
+		}()
+
+		var wire io.Reader = encoded
+		if passThru != nil {
+			wire = passThru(wire, -1)
+		}
+		_, err := copyBuffer(stdin, wire, a.BufferSize)
+		// Stops the encoding goroutine when sending failed.
+		encoded.CloseWithError(err)
+		if err != nil {
+			return err
+		}
+		result.BytesWritten = <-read
+		return stdin.Close()
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Acked = true
+	return result, nil
+}
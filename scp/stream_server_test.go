@@ -0,0 +1,43 @@
+package scp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"main/scp"
+)
+
+func TestCopyStream(t *testing.T) {
+	// Spooled to disk beyond the threshold.
+	client := newTestClient(t, func(c *scp.ClientConfigurer) { c.SpoolThreshold(8) })
+	dir := t.TempDir()
+	contents := strings.Repeat("pg_dump output\n", 100)
+
+	for name, size := range map[string]int64{"unknown": -1, "given": int64(len(contents))} {
+		// Like the standard input, a pipe that neither tells its size nor seeks.
+		r, w := io.Pipe()
+		go func() {
+			io.Copy(w, strings.NewReader(contents))
+			w.Close()
+		}()
+		remote := filepath.Join(dir, name)
+		if err := client.CopyStream(context.Background(), r, remote, "0640", size); err != nil {
+			t.Fatalf("CopyStream of a stream with the %s size returned %v", name, err)
+		}
+		if got, err := os.ReadFile(remote); err != nil || string(got) != contents {
+			t.Errorf("the stream with the %s size was uploaded as %d bytes, %v", name, len(got), err)
+		}
+	}
+
+	for name, size := range map[string]int64{"shorter": int64(len(contents)) + 1, "longer": int64(len(contents)) - 1} {
+		err := client.CopyStream(context.Background(), strings.NewReader(contents), filepath.Join(dir, name), "0640", size)
+		if !errors.Is(err, scp.ErrSizeMismatch) {
+			t.Errorf("CopyStream of a stream %s than announced returned %v, want ErrSizeMismatch", name, err)
+		}
+	}
+}
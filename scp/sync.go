@@ -0,0 +1,561 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyncAction what a sync does with a file.
+type SyncAction string
+
+const (
+	// SyncCopy the file is missing at the destination and is copied.
+	SyncCopy SyncAction = "copy"
+
+	// SyncUpdate the file differs at the destination and is replaced.
+	SyncUpdate SyncAction = "update"
+
+	// SyncSkip the file is the same at the destination and left alone.
+	SyncSkip SyncAction = "skip"
+
+	// SyncDelete the file only exists at the destination and is removed.
+	SyncDelete SyncAction = "delete"
+)
+
+// DefaultMaxDeletePercent the share of destination files a sync deletes at most
+// when SyncOptions.MaxDeletePercent is negative.
+const DefaultMaxDeletePercent = 50
+
+// SyncOptions configures SyncToRemote and SyncFromRemote.
+type SyncOptions struct {
+	// Checksum compares the MD5 of files of the same size instead of their modification
+	// times. Slower, but catches changes that kept the modification time.
+	// The remote needs md5sum.
+	Checksum bool
+
+	// DryRun only computes the plan, nothing is transferred.
+	DryRun bool
+
+	// Progress receives the progress of the files transferred, may be nil.
+	Progress Progress
+
+	// Delete removes destination files that do not exist in the source. Directories left
+	// empty are kept. Deleting requires ConfirmDelete.
+	Delete bool
+
+	// ConfirmDelete is shown the planned deletions before anything is changed and
+	// must return true for the sync to go ahead. Not called for a DryRun.
+	ConfirmDelete func(deletes []SyncEntry) bool
+
+	// MaxDeletePercent refuses a sync that would delete more than this percentage of the
+	// destination files with ErrTooManyDeletes. Zero refuses any deletion, 100 allows deleting
+	// everything and a negative percentage uses DefaultMaxDeletePercent.
+	MaxDeletePercent int
+
+	// SkipEmptyDirs leaves out source directories without any files below them. By default they are
+	// created at the destination, like `scp -r` does.
+	SkipEmptyDirs bool
+}
+
+// SyncEntry the plan, and outcome, for a single file of a sync. Size, Mode and ModTime describe
+// the source file, or the destination file for deletions.
+type SyncEntry struct {
+	TransferEntry
+	Action SyncAction
+}
+
+// syncFile a regular file found while listing a tree.
+type syncFile struct {
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	md5     string
+}
+
+// SyncToRemote makes `remoteDir` mirror the files of `localDir`, only uploading files that are missing
+// or differ in size or modification time. Uploaded files keep their permissions and modification times.
+// It returns the plan with the action taken for every local file. The remote needs GNU find.
+func (a *Client) SyncToRemote(ctx context.Context, localDir string, remoteDir string, opts SyncOptions) ([]SyncEntry, error) {
+	source, err := listLocalTree(localDir, opts.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	destination, err := a.listRemoteTree(ctx, remoteDir, opts.Checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := syncPlan(source, destination, Upload, TagsFromContext(ctx), opts)
+	if err := checkDeletes(plan, len(destination), opts); err != nil || opts.DryRun {
+		return plan, err
+	}
+
+	err = a.runSync(ctx, plan, opts, func(entry *SyncEntry, passThru PassThru) error {
+		remote := path.Join(remoteDir, entry.Path)
+		if entry.Action == SyncDelete {
+			return a.Remove(ctx, remote)
+		}
+
+		return a.pushFile(ctx, filepath.Join(localDir, filepath.FromSlash(entry.Path)), remote, passThru)
+	})
+	if err != nil {
+		return plan, err
+	}
+
+	dirs, err := listLocalDirs(localDir)
+	if err != nil {
+		return plan, err
+	}
+	// Directories holding files were created along with them.
+	full := dirsWithFiles(source)
+	if !opts.SkipEmptyDirs {
+		var empty []string
+		for _, dir := range deepestFirst(dirs) {
+			if !full[dir] {
+				empty = append(empty, a.shellPath(path.Join(remoteDir, dir)))
+			}
+		}
+		if err := a.runBatched(ctx, "mkdir -p", empty); err != nil {
+			return plan, fmt.Errorf("failed to create the empty directories of %s: %w", remoteDir, err)
+		}
+	}
+
+	byMode := map[fs.FileMode][]string{}
+	for _, dir := range deepestFirst(dirs) {
+		if full[dir] || !opts.SkipEmptyDirs {
+			byMode[dirs[dir]] = append(byMode[dirs[dir]], a.shellPath(path.Join(remoteDir, dir)))
+		}
+	}
+	for mode, names := range byMode {
+		if err := a.runBatched(ctx, fmt.Sprintf("chmod %04o", mode), names); err != nil {
+			return plan, fmt.Errorf("failed to set the permissions of the directories of %s: %w", remoteDir, err)
+		}
+	}
+	return plan, nil
+}
+
+// pushFile uploads the local file to remote, creating its parent directories and keeping
+// its permissions and modification time.
+func (a *Client) pushFile(ctx context.Context, local string, remote string, passThru PassThru) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := a.MkdirAll(ctx, path.Dir(remote), 0755); err != nil {
+		return err
+	}
+	return a.CopyFromFilePreserve(ctx, *f, remote, FormatMode(stat.Mode().Perm()), passThru)
+}
+
+// SyncFromRemote makes `localDir` mirror the files of `remoteDir`, only downloading files that are missing
+// or differ in size or modification time. Downloaded files keep their permissions and modification times.
+// It returns the plan with the action taken for every remote file. The remote needs GNU find.
+func (a *Client) SyncFromRemote(ctx context.Context, remoteDir string, localDir string, opts SyncOptions) ([]SyncEntry, error) {
+	source, err := a.listRemoteTree(ctx, remoteDir, opts.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	destination, err := listLocalTree(localDir, opts.Checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := syncPlan(source, destination, Download, TagsFromContext(ctx), opts)
+	if err := checkDeletes(plan, len(destination), opts); err != nil || opts.DryRun {
+		return plan, err
+	}
+
+	err = a.runSync(ctx, plan, opts, func(entry *SyncEntry, passThru PassThru) error {
+		local := filepath.Join(localDir, filepath.FromSlash(entry.Path))
+		if entry.Action == SyncDelete {
+			return os.Remove(local)
+		}
+
+		remote := path.Join(remoteDir, entry.Path)
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			return err
+		}
+
+		fileInfos, err := a.CopyFromRemoteToPath(ctx, remote, local, DownloadOptions{PreserveTimes: true, PassThru: passThru})
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(local, os.FileMode(fileInfos.Permissions).Perm()); err != nil {
+			return err
+		}
+		return os.Chtimes(local, time.Unix(fileInfos.Atime, 0), time.Unix(fileInfos.Mtime, 0))
+	})
+	if err != nil {
+		return plan, err
+	}
+
+	dirs, err := a.listRemoteDirs(ctx, remoteDir)
+	if err != nil {
+		return plan, err
+	}
+	// Directories holding files were created along with them.
+	full := dirsWithFiles(source)
+	for _, dir := range deepestFirst(dirs) {
+		if opts.SkipEmptyDirs && !full[dir] {
+			continue
+		}
+		local := filepath.Join(localDir, filepath.FromSlash(dir))
+		if err := os.MkdirAll(local, 0755); err != nil {
+			return plan, err
+		}
+		if err := os.Chmod(local, dirs[dir]); err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+// dirsWithFiles returns the directories with any of the files below them.
+func dirsWithFiles(files map[string]syncFile) map[string]bool {
+	full := map[string]bool{}
+	for name := range files {
+		for dir := path.Dir(name); dir != "." && !full[dir]; dir = path.Dir(dir) {
+			full[dir] = true
+		}
+	}
+	return full
+}
+
+// deepestFirst orders the directories so that every directory comes before its parent, which
+// allows setting permissions that no longer let the owner into a directory, such as 0500.
+func deepestFirst(dirs map[string]fs.FileMode) []string {
+	names := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		names = append(names, dir)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names
+}
+
+// runSync transfers, or deletes, every entry of the plan that is not skipped, recording
+// failures on the entries. It returns the first failure, after trying every entry.
+func (a *Client) runSync(ctx context.Context, plan []SyncEntry, opts SyncOptions, transfer func(entry *SyncEntry, passThru PassThru) error) error {
+	progress := progressOrNop(opts.Progress)
+
+	var totalBytes int64
+	var totalFiles int
+	for _, entry := range plan {
+		if entry.Action == SyncCopy || entry.Action == SyncUpdate {
+			totalBytes += entry.Size
+			totalFiles++
+		}
+	}
+	progress.Start(totalBytes, totalFiles)
+
+	var firstErr error
+	for i := range plan {
+		entry := &plan[i]
+		if entry.Action == SyncSkip {
+			continue
+		}
+
+		if entry.Action != SyncDelete {
+			progress.File(entry.Path, entry.Size)
+		}
+		entry.finish(transfer(entry, func(r io.Reader, total int64) io.Reader {
+			return &progressReader{r: r, progress: progress, bufferSize: a.BufferSize}
+		}))
+		if entry.Err != nil {
+			a.logf(ctx, LogError, "failed to sync %s: %v", entry.Path, entry.Err)
+		}
+		if entry.Err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to sync %s: %w", entry.Path, entry.Err)
+		}
+	}
+	return firstErr
+}
+
+// syncPlan decides what to do with every file of the source, and with Delete the files only
+// found at the destination, sorted by path. Its entries carry the tags of the sync.
+func syncPlan(source map[string]syncFile, destination map[string]syncFile, direction Direction, tags Tags, opts SyncOptions) []SyncEntry {
+	checksum := opts.Checksum
+	plan := make([]SyncEntry, 0, len(source))
+	for name, file := range source {
+		entry := SyncEntry{TransferEntry: file.entry(name, direction, TransferSkipped, tags), Action: SyncSkip}
+
+		existing, ok := destination[name]
+		switch {
+		case !ok:
+			entry.Action, entry.Status = SyncCopy, TransferPending
+		case existing.size != file.size,
+			checksum && existing.md5 != file.md5,
+			!checksum && !existing.modTime.Equal(file.modTime):
+			entry.Action, entry.Status = SyncUpdate, TransferPending
+		}
+		plan = append(plan, entry)
+	}
+
+	if opts.Delete {
+		for name, file := range destination {
+			if _, ok := source[name]; !ok {
+				plan = append(plan, SyncEntry{TransferEntry: file.entry(name, direction, TransferPending, tags), Action: SyncDelete})
+			}
+		}
+	}
+
+	sort.Slice(plan, func(i, j int) bool { return plan[i].Path < plan[j].Path })
+	return plan
+}
+
+// entry describes the file as the entry of a transfer with the given tags.
+func (f syncFile) entry(name string, direction Direction, status TransferStatus, tags Tags) TransferEntry {
+	return TransferEntry{Path: name, Size: f.size, Mode: f.mode, ModTime: f.modTime, Direction: direction, Status: status, Tags: tags}
+}
+
+// checkDeletes enforces the protection limit on the deletions of the plan and, unless it
+// is a dry run, asks for them to be confirmed.
+func checkDeletes(plan []SyncEntry, destinationFiles int, opts SyncOptions) error {
+	var deletes []SyncEntry
+	for _, entry := range plan {
+		if entry.Action == SyncDelete {
+			deletes = append(deletes, entry)
+		}
+	}
+	if len(deletes) == 0 {
+		return nil
+	}
+
+	limit := opts.MaxDeletePercent
+	if limit < 0 {
+		limit = DefaultMaxDeletePercent
+	}
+	if len(deletes)*100 > limit*destinationFiles {
+		return fmt.Errorf("%w: %d of %d files, the limit is %d%%", ErrTooManyDeletes, len(deletes), destinationFiles, limit)
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+	if opts.ConfirmDelete == nil || !opts.ConfirmDelete(deletes) {
+		return ErrDeleteNotConfirmed
+	}
+	return nil
+}
+
+// listLocalTree lists the regular files below dir by their slash separated relative path.
+// Modification times are truncated to seconds, the precision of the SCP protocol.
+// A missing directory is an empty tree.
+func listLocalTree(dir string, checksum bool) (map[string]syncFile, error) {
+	files := map[string]syncFile{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && p == dir {
+			return filepath.SkipDir
+		}
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		file := syncFile{size: info.Size(), mode: info.Mode().Perm(), modTime: info.ModTime().Truncate(time.Second)}
+		if checksum {
+			if file.md5, err = md5File(p); err != nil {
+				return err
+			}
+		}
+		files[filepath.ToSlash(rel)] = file
+		return nil
+	})
+	return files, err
+}
+
+// listLocalDirs lists the permissions of the directories below dir by their slash separated
+// relative path. A missing directory has none.
+func listLocalDirs(dir string) (map[string]fs.FileMode, error) {
+	dirs := map[string]fs.FileMode{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && p == dir {
+			return filepath.SkipDir
+		}
+		if err != nil || !d.IsDir() || p == dir {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		dirs[filepath.ToSlash(rel)] = info.Mode().Perm()
+		return nil
+	})
+	return dirs, err
+}
+
+func md5File(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sum := md5.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// listRemoteDirs lists the permissions of the directories below dir on the remote by their
+// slash separated relative path. A missing directory has none.
+func (a *Client) listRemoteDirs(ctx context.Context, dir string) (map[string]fs.FileMode, error) {
+	// Separated by NULs, the only byte no name can hold.
+	script := fmt.Sprintf("[ -d %s ] || exit 0; find %s -mindepth 1 -type d -printf '%%m %%P\\0'", a.shellPath(dir), a.shellPath(dir))
+	out, err := a.runOutput(ctx, script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the directories of %s: %w", dir, err)
+	}
+
+	dirs := map[string]fs.FileMode{}
+	for _, line := range strings.Split(string(out), "\x00") {
+		if line == "" {
+			continue
+		}
+		// <octal permissions> <path>
+		perm, name, ok := strings.Cut(line, " ")
+		mode, err := strconv.ParseUint(perm, 8, 32)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("unexpected line listing %s: %q", dir, line)
+		}
+		dirs[name] = fs.FileMode(mode).Perm()
+	}
+	return dirs, nil
+}
+
+// unescapeChecksumName undoes the escaping of md5sum, which writes backslashes as \\, newlines as \n
+// and carriage returns as \r in the names of its lines.
+func unescapeChecksumName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+1 < len(name) {
+			i++
+			switch name[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(name[i])
+			}
+			continue
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+// runBatched runs the command with the given, already quoted, arguments on the remote, split over
+// as many invocations as needed to keep every command line well below the limits of the remote.
+func (a *Client) runBatched(ctx context.Context, command string, args []string) error {
+	const maxCommandLine = 64 * 1024
+
+	for len(args) > 0 {
+		script := command
+		n := 0
+		for n < len(args) && (n == 0 || len(script)+1+len(args[n]) <= maxCommandLine) {
+			script += " " + args[n]
+			n++
+		}
+		if _, err := a.runOutput(ctx, script); err != nil {
+			return err
+		}
+		args = args[n:]
+	}
+	return nil
+}
+
+// listRemoteTree lists the regular files below dir on the remote by their slash separated
+// relative path. A missing directory is an empty tree.
+func (a *Client) listRemoteTree(ctx context.Context, dir string, checksum bool) (map[string]syncFile, error) {
+	// Separated by NULs, the only byte no name can hold.
+	script := fmt.Sprintf("[ -d %s ] || exit 0; find %s -type f -printf '%%s %%T@ %%m %%P\\0'", a.shellPath(dir), a.shellPath(dir))
+	out, err := a.runOutput(ctx, script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+
+	files := map[string]syncFile{}
+	for _, line := range strings.Split(string(out), "\x00") {
+		if line == "" {
+			continue
+		}
+		// <size> <seconds>.<fraction> <octal permissions> <path>
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected line listing %s: %q", dir, line)
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		seconds, _, _ := strings.Cut(fields[1], ".")
+		mtime, err := strconv.ParseInt(seconds, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		mode, err := strconv.ParseUint(fields[2], 8, 32)
+		if err != nil {
+			return nil, err
+		}
+		files[fields[3]] = syncFile{size: size, mode: fs.FileMode(mode).Perm(), modTime: time.Unix(mtime, 0)}
+	}
+
+	if checksum && len(files) > 0 {
+		out, err := a.runOutput(ctx, fmt.Sprintf("cd %s && find . -type f -exec md5sum {} +", a.shellPath(dir)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to checksum %s: %w", dir, err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+			// <md5>  ./<path>, or \<md5>  ./<escaped path> for names holding a backslash or newline.
+			sum, name, ok := strings.Cut(line, "  ")
+			if !ok {
+				continue
+			}
+			if escaped, ok := strings.CutPrefix(sum, "\\"); ok {
+				sum, name = escaped, unescapeChecksumName(name)
+			}
+			name = strings.TrimPrefix(name, "./")
+			if file, ok := files[name]; ok {
+				file.md5 = sum
+				files[name] = file
+			}
+		}
+	}
+	return files, nil
+}
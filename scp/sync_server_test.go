@@ -0,0 +1,187 @@
+package scp_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"main/scp"
+)
+
+// writeTree writes the files, by slash separated path, below dir with the given modification time.
+func writeTree(t *testing.T, dir string, files map[string]string, modTime time.Time) {
+	t.Helper()
+	for name, contents := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// actions returns the action of every entry of the plan by its path.
+func actions(plan []scp.SyncEntry) map[string]scp.SyncAction {
+	got := map[string]scp.SyncAction{}
+	for _, entry := range plan {
+		got[entry.Path] = entry.Action
+	}
+	return got
+}
+
+func checkActions(t *testing.T, plan []scp.SyncEntry, want map[string]scp.SyncAction) {
+	t.Helper()
+	got := actions(plan)
+	if len(got) != len(want) {
+		t.Errorf("the plan is %v, want %v", got, want)
+	}
+	for name, action := range want {
+		if got[name] != action {
+			t.Errorf("the plan is to %s %s, want %s", got[name], name, action)
+		}
+	}
+}
+
+func TestSyncToRemote(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	local, remote := t.TempDir(), t.TempDir()
+	modTime := time.Unix(1700000000, 0)
+	writeTree(t, local, map[string]string{"same": "same", "sub/grown": "grown", "sub/touched": "touched", "new": "new"}, modTime)
+	writeTree(t, remote, map[string]string{"same": "same", "sub/grown": "grow", "sub/touched": "touched"}, modTime)
+	writeTree(t, remote, map[string]string{"sub/touched": "TOUCHED"}, modTime.Add(time.Hour))
+
+	plan, err := client.SyncToRemote(ctx, local, remote, scp.SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkActions(t, plan, map[string]scp.SyncAction{
+		"same": scp.SyncSkip, "sub/grown": scp.SyncUpdate, "sub/touched": scp.SyncUpdate, "new": scp.SyncCopy,
+	})
+	for _, name := range []string{"same", "sub/grown", "sub/touched", "new"} {
+		got, err := os.ReadFile(filepath.Join(remote, filepath.FromSlash(name)))
+		want, _ := os.ReadFile(filepath.Join(local, filepath.FromSlash(name)))
+		if err != nil || string(got) != string(want) {
+			t.Errorf("the remote %s holds %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if stat, err := os.Stat(filepath.Join(remote, "new")); err != nil || !stat.ModTime().Equal(modTime) {
+		t.Errorf("the uploaded file was not given the modification time of the local one: %v", err)
+	}
+
+	// Everything is the same now.
+	plan, err = client.SyncToRemote(ctx, local, remote, scp.SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkActions(t, plan, map[string]scp.SyncAction{
+		"same": scp.SyncSkip, "sub/grown": scp.SyncSkip, "sub/touched": scp.SyncSkip, "new": scp.SyncSkip,
+	})
+}
+
+func TestSyncFromRemoteChecksum(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	remote, local := t.TempDir(), t.TempDir()
+	modTime := time.Unix(1700000000, 0)
+	writeTree(t, remote, map[string]string{"same": "same", "changed": "after", "new": "new"}, modTime)
+	// Touched without changing, and changed without touching.
+	writeTree(t, local, map[string]string{"same": "same"}, modTime.Add(time.Hour))
+	writeTree(t, local, map[string]string{"changed": "befor"}, modTime)
+
+	plan, err := client.SyncFromRemote(ctx, remote, local, scp.SyncOptions{Checksum: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkActions(t, plan, map[string]scp.SyncAction{"same": scp.SyncSkip, "changed": scp.SyncUpdate, "new": scp.SyncCopy})
+	if _, err := os.Stat(filepath.Join(local, "new")); !os.IsNotExist(err) {
+		t.Errorf("the dry run downloaded a file: %v", err)
+	}
+
+	if _, err := client.SyncFromRemote(ctx, remote, local, scp.SyncOptions{Checksum: true}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"same": "same", "changed": "after", "new": "new"} {
+		if got, err := os.ReadFile(filepath.Join(local, name)); err != nil || string(got) != want {
+			t.Errorf("the local %s holds %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestSyncFromRemoteChecksumOddNames(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	remote, local := t.TempDir(), t.TempDir()
+	modTime := time.Unix(1700000000, 0)
+	// md5sum escapes the names holding a backslash or newline, which the listings separate lines by.
+	files := map[string]string{"back\\slash": "back", "new\nline": "new", "dir\nbreak/file": "file", "trailing  ": "spaces"}
+	writeTree(t, remote, files, modTime)
+	writeTree(t, local, files, modTime.Add(time.Hour))
+
+	plan, err := client.SyncFromRemote(ctx, remote, local, scp.SyncOptions{Checksum: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]scp.SyncAction{}
+	for name := range files {
+		want[name] = scp.SyncSkip
+	}
+	checkActions(t, plan, want)
+}
+
+func TestSyncDelete(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	local, remote := t.TempDir(), t.TempDir()
+	modTime := time.Unix(1700000000, 0)
+	writeTree(t, local, map[string]string{"a": "a", "b": "b", "c": "c"}, modTime)
+	writeTree(t, remote, map[string]string{"a": "a", "b": "b", "c": "c", "sub/gone": "gone"}, modTime)
+	exists := func() bool {
+		_, err := os.Stat(filepath.Join(remote, "sub", "gone"))
+		return err == nil
+	}
+
+	var confirmed []scp.SyncEntry
+	opts := scp.SyncOptions{Delete: true, MaxDeletePercent: -1, ConfirmDelete: func(deletes []scp.SyncEntry) bool {
+		confirmed = deletes
+		return false
+	}}
+	plan, err := client.SyncToRemote(ctx, local, remote, opts)
+	if !errors.Is(err, scp.ErrDeleteNotConfirmed) || !exists() {
+		t.Errorf("unconfirmed deletion returned %v", err)
+	}
+	if len(confirmed) != 1 || confirmed[0].Path != "sub/gone" || actions(plan)["sub/gone"] != scp.SyncDelete {
+		t.Errorf("confirming %v, want the remote only file", confirmed)
+	}
+
+	// Deleting one of four files is more than allowed.
+	opts.MaxDeletePercent = 20
+	if _, err := client.SyncToRemote(ctx, local, remote, opts); !errors.Is(err, scp.ErrTooManyDeletes) || !exists() {
+		t.Errorf("deleting above the limit returned %v, want ErrTooManyDeletes", err)
+	}
+
+	// Zero allows no deletion at all.
+	opts.MaxDeletePercent = 0
+	if _, err := client.SyncToRemote(ctx, local, remote, opts); !errors.Is(err, scp.ErrTooManyDeletes) || !exists() {
+		t.Errorf("deleting with a limit of zero returned %v, want ErrTooManyDeletes", err)
+	}
+
+	// A negative limit is DefaultMaxDeletePercent, which allows deleting one of four files.
+	opts.MaxDeletePercent = -1
+	opts.ConfirmDelete = func([]scp.SyncEntry) bool { return true }
+	if _, err := client.SyncToRemote(ctx, local, remote, opts); err != nil || exists() {
+		t.Errorf("confirmed deletion returned %v, the file exists: %v", err, exists())
+	}
+	// Directories left empty are kept.
+	if stat, err := os.Stat(filepath.Join(remote, "sub")); err != nil || !stat.IsDir() {
+		t.Errorf("the emptied directory was removed: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// Tags key/value metadata attached to a transfer, such as a job ID, environment or ticket number.
+// They are echoed on the LogEntry and WatchEvent values of the transfer, so these can be related to
+// the systems that started it.
+type Tags map[string]string
+
+// String formats the tags as space separated key=value pairs, ordered by key.
+func (t Tags) String() string {
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + t[key]
+	}
+	return strings.Join(pairs, " ")
+}
+
+type tagsKey struct{}
+
+// WithTags returns a context attaching the tags to the transfers started with it, on top of
+// the tags already attached to ctx. Tags given here win over those of ctx with the same key.
+func WithTags(ctx context.Context, tags Tags) context.Context {
+	if len(tags) == 0 {
+		return ctx
+	}
+
+	merged := Tags{}
+	for key, value := range TagsFromContext(ctx) {
+		merged[key] = value
+	}
+	for key, value := range tags {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, tagsKey{}, merged)
+}
+
+// TagsFromContext returns the tags attached to ctx with WithTags, nil when there are none.
+// The returned map must not be modified.
+func TagsFromContext(ctx context.Context) Tags {
+	tags, _ := ctx.Value(tagsKey{}).(Tags)
+	return tags
+}
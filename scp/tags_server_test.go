@@ -0,0 +1,65 @@
+package scp_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"main/scp"
+)
+
+func TestTagsReachEntriesAndRecords(t *testing.T) {
+	var records bytes.Buffer
+	client := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.ProgressOutput(scp.ProgressNone).ProgressJSON(&records)
+	})
+	tags := scp.Tags{"job": "42", "env": "prod"}
+	ctx := scp.WithTags(context.Background(), tags)
+
+	local, remote := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(local, "file"), []byte("tagged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := client.CopyDirToRemoteTarProgress(ctx, local, remote, scp.TarOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		t.Fatal("the transfer returned no entries")
+	}
+	for _, entry := range entries {
+		if entry.Status != scp.TransferDone || !reflect.DeepEqual(entry.Tags, tags) {
+			t.Errorf("entry %s is %s with tags %v, want done with %v", entry.Path, entry.Status, entry.Tags, tags)
+		}
+	}
+
+	scanner := bufio.NewScanner(&records)
+	n := 0
+	for ; scanner.Scan(); n++ {
+		var record scp.ProgressRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(record.Tags, tags) {
+			t.Errorf("the %s record has tags %v, want %v", record.State, record.Tags, tags)
+		}
+	}
+	if n == 0 {
+		t.Error("no progress records were written")
+	}
+
+	plan, err := client.SyncToRemote(ctx, local, remote, scp.SyncOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range plan {
+		if !reflect.DeepEqual(entry.Tags, tags) {
+			t.Errorf("sync entry %s has tags %v, want %v", entry.Path, entry.Tags, tags)
+		}
+	}
+}
@@ -0,0 +1,685 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// paxXattrPrefix prefix of the PAX records holding extended attributes,
+// as written and read by GNU tar and bsdtar. ACLs and SELinux contexts
+// have records of their own, see paxRecord.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// TarOptions configures the metadata preserved by the tar-over-SSH transfers.
+// The remote side is expected to run GNU tar, the flags are passed on as-is.
+type TarOptions struct {
+	// Xattrs preserve extended attributes (tar --xattrs).
+	Xattrs bool
+
+	// ACLs preserve POSIX ACLs (tar --acls).
+	ACLs bool
+
+	// SELinux preserve SELinux contexts (tar --selinux).
+	SELinux bool
+
+	// Owner preserves the owning user and group of every file and directory. Changing them requires
+	// privileges, usually root, on the extracting side: locally for downloads, on the remote for uploads
+	// (tar --same-owner). Permissions are preserved regardless.
+	Owner bool
+
+	// SkipEmptyDirs leaves out directories without anything below them. By default they are
+	// created at the destination, like `scp -r` does.
+	SkipEmptyDirs bool
+
+	// Progress receives the overall progress and the file currently in flight, may be nil.
+	Progress Progress
+}
+
+// flags returns the command line flags for the remote tar.
+func (o TarOptions) flags() string {
+	var flags []string
+	if o.Xattrs {
+		flags = append(flags, "--xattrs")
+	}
+	if o.ACLs {
+		flags = append(flags, "--acls")
+	}
+	if o.SELinux {
+		flags = append(flags, "--selinux")
+	}
+	if len(flags) == 0 {
+		return ""
+	}
+	return " " + strings.Join(flags, " ")
+}
+
+// keepXattr tells whether the extended attribute with the given name is preserved.
+// On Linux ACLs and SELinux contexts are exposed as extended attributes as well,
+// which is how they are preserved locally.
+func (o TarOptions) keepXattr(name string) bool {
+	switch {
+	case strings.HasPrefix(name, "system.posix_acl_"):
+		return o.ACLs
+	case name == "security.selinux":
+		return o.SELinux
+	default:
+		return o.Xattrs
+	}
+}
+
+func (o TarOptions) anyXattrs() bool {
+	return o.Xattrs || o.ACLs || o.SELinux
+}
+
+// CopyDirToRemoteTar copies the local directory `localDir` into `remoteDir` by streaming a tar archive
+// into `tar -x` on the remote. This is much faster than copying many small files one by one and
+// allows preserving metadata plain SCP loses, see TarOptions. `remoteDir` must exist.
+// It returns an entry for every file and directory written to the archive; an error of the
+// remote tar is only part of the returned error.
+func (a *Client) CopyDirToRemoteTar(ctx context.Context, localDir string, remoteDir string, opts TarOptions) ([]TransferEntry, error) {
+	flags := opts.flags()
+	if opts.Owner {
+		flags += " --same-owner"
+	}
+	cmd := fmt.Sprintf("tar%s -x -f - -C %s", flags, a.shellPath(remoteDir))
+	progress := progressOrNop(opts.Progress)
+
+	totalBytes, totalFiles, err := scanTree(localDir)
+	if err != nil {
+		return nil, err
+	}
+	progress.Start(totalBytes, totalFiles)
+
+	var entries []TransferEntry
+	err = a.runStream(ctx, cmd, func(stdin io.WriteCloser, _ io.Reader) error {
+		defer stdin.Close()
+		var err error
+		entries, err = writeTar(stdin, localDir, opts, progress, a.BufferSize)
+		return err
+	})
+	return tagEntries(ctx, entries), err
+}
+
+// CopyDirToRemoteTarProgress is the same as CopyDirToRemoteTar but renders an overall progress bar
+// and a progress bar for the file currently in flight in the terminal.
+func (a *Client) CopyDirToRemoteTarProgress(ctx context.Context, localDir string, remoteDir string, opts TarOptions) ([]TransferEntry, error) {
+	var entries []TransferEntry
+	err := a.runProgress(ctx, a.label(Upload, filepath.Base(localDir)+"/"), func(ctx context.Context, progress Progress) error {
+		opts.Progress = progress
+		var err error
+		entries, err = a.CopyDirToRemoteTar(ctx, localDir, remoteDir, opts)
+		return err
+	})
+	return entries, err
+}
+
+// CopyDirFromRemoteTar copies the remote directory `remoteDir` into `localDir` by running `tar -c` on
+// the remote and extracting the archive locally. `localDir` is created if it does not exist.
+// It returns an entry for every file and directory read from the archive.
+func (a *Client) CopyDirFromRemoteTar(ctx context.Context, remoteDir string, localDir string, opts TarOptions) ([]TransferEntry, error) {
+	cmd := fmt.Sprintf("tar%s -c -f - -C %s .", opts.flags(), a.shellPath(remoteDir))
+	progress := progressOrNop(opts.Progress)
+
+	if opts.Progress != nil {
+		totalBytes, totalFiles, err := a.scanRemoteTree(ctx, remoteDir)
+		if err != nil {
+			// The scan is only used for reporting, carry on without totals.
+			totalBytes, totalFiles = -1, -1
+		}
+		progress.Start(totalBytes, totalFiles)
+	}
+
+	var entries []TransferEntry
+	err := a.runStream(ctx, cmd, func(stdin io.WriteCloser, stdout io.Reader) error {
+		stdin.Close()
+		var err error
+		entries, err = extractTar(stdout, localDir, opts, progress, a.BufferSize)
+		return err
+	})
+	return tagEntries(ctx, entries), err
+}
+
+// CopyDirFromRemoteTarProgress is the same as CopyDirFromRemoteTar but renders an overall progress bar
+// and a progress bar for the file currently in flight in the terminal.
+func (a *Client) CopyDirFromRemoteTarProgress(ctx context.Context, remoteDir string, localDir string, opts TarOptions) ([]TransferEntry, error) {
+	var entries []TransferEntry
+	err := a.runProgress(ctx, a.label(Download, path.Base(remoteDir)+"/"), func(ctx context.Context, progress Progress) error {
+		opts.Progress = progress
+		var err error
+		entries, err = a.CopyDirFromRemoteTar(ctx, remoteDir, localDir, opts)
+		return err
+	})
+	return entries, err
+}
+
+// scanTree returns the amount of bytes and regular files in the tree rooted at dir.
+func scanTree(dir string) (int64, int, error) {
+	return scanFS(os.DirFS(dir), []string{"."})
+}
+
+// scanFS returns the amount of bytes and regular files in the trees rooted at the roots in fsys.
+func scanFS(fsys fs.FS, roots []string) (int64, int, error) {
+	var bytes int64
+	var files int
+	for _, root := range roots {
+		err := fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			bytes += info.Size()
+			files++
+			return nil
+		})
+		if err != nil {
+			return bytes, files, err
+		}
+	}
+	return bytes, files, nil
+}
+
+// scanRemoteTree returns the amount of bytes and regular files in the remote tree rooted at dir.
+// It relies on GNU find being available on the remote.
+func (a *Client) scanRemoteTree(ctx context.Context, dir string) (int64, int, error) {
+	session, release, err := a.newSession(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer release()
+
+	out, err := session.Output(fmt.Sprintf("find %s -type f -printf '%%s\\n'", a.shellPath(dir)))
+	if err != nil {
+		return 0, 0, remoteError(session, err)
+	}
+
+	var bytes int64
+	var files int
+	for _, line := range strings.Fields(string(out)) {
+		size, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		bytes += size
+		files++
+	}
+	return bytes, files, nil
+}
+
+// runStream runs the given command on the remote and lets stream feed its input or consume its output.
+func (a *Client) runStream(
+	ctx context.Context,
+	cmd string,
+	stream func(stdin io.WriteCloser, stdout io.Reader) error,
+) error {
+	session, release, err := a.newSession(ctx)
+	if err != nil {
+		return fmt.Errorf("Error creating ssh session in stream: %w", err)
+	}
+	defer release()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	dog := newWatchdog()
+	stdout = dog.Reader(stdout, a.BufferSize)
+
+	err = session.Start(cmd)
+	if err != nil {
+		return err
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	errCh := make(chan error, 2)
+
+	go func() {
+		defer wg.Done()
+		if err := stream(activityWriter{stdin, dog}, stdout); err != nil {
+			errCh <- err
+			return
+		}
+		if err := session.Wait(); err != nil {
+			errCh <- err
+		}
+	}()
+
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	ctx, stopWatch := a.watchIdle(ctx, dog)
+	defer stopWatch()
+
+	if err := wait(&wg, ctx, session); err != nil {
+		return err
+	}
+
+	close(errCh)
+	return remoteError(session, <-errCh)
+}
+
+// activityWriter reports every successful write to the watchdog.
+type activityWriter struct {
+	io.WriteCloser
+	dog *watchdog
+}
+
+func (a activityWriter) Write(p []byte) (int, error) {
+	n, err := a.WriteCloser.Write(p)
+	if n > 0 {
+		a.dog.touch()
+	}
+	return n, err
+}
+
+// writeTar writes the directory tree rooted at dir as a tar archive to w,
+// returning an entry for every file and directory written.
+func writeTar(w io.Writer, dir string, opts TarOptions, progress Progress, bufferSize int) ([]TransferEntry, error) {
+	return writeTarFS(w, os.DirFS(dir), []string{"."}, dir, opts, progress, bufferSize)
+}
+
+// writeTarFS writes the trees rooted at the roots in fsys as a tar archive to w, each named after
+// its last element, or without a name of its own for ".". When fsys is the local directory local,
+// symlinks and extended attributes are read from it; other file systems only hold regular files
+// and directories, anything else is skipped. It returns an entry for every file and directory.
+func writeTarFS(w io.Writer, fsys fs.FS, roots []string, local string, opts TarOptions, progress Progress, bufferSize int) ([]TransferEntry, error) {
+	tw := tar.NewWriter(w)
+
+	var entries []TransferEntry
+	record := func(hdr *tar.Header, err error) error {
+		entry := tarEntry(hdr, Upload)
+		entry.finish(err)
+		entries = append(entries, entry)
+		return err
+	}
+
+	for _, root := range roots {
+		held := emptyDirs{skip: opts.SkipEmptyDirs}
+		parent := path.Dir(root)
+		err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel := strings.TrimPrefix(name, parent+"/")
+			if parent == "." {
+				rel = name
+			}
+			if rel == "." {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			link := ""
+			switch {
+			case info.Mode()&os.ModeSymlink != 0 && local != "":
+				if link, err = os.Readlink(filepath.Join(local, filepath.FromSlash(name))); err != nil {
+					return err
+				}
+			case !info.Mode().IsRegular() && !info.IsDir() && local == "":
+				entries = append(entries, TransferEntry{Path: rel, Mode: info.Mode(), ModTime: info.ModTime(), Direction: Upload, Status: TransferSkipped})
+				return nil
+			}
+			hdr, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			hdr.Name = rel
+			if d.IsDir() {
+				hdr.Name += "/"
+			}
+			if hdr.ModTime.IsZero() {
+				// Such as the files of an embed.FS, which have no modification time.
+				hdr.ModTime = time.Now()
+			}
+
+			if opts.anyXattrs() && local != "" {
+				localPath := filepath.Join(local, filepath.FromSlash(name))
+				xattrs, err := readXattrs(localPath, opts.keepXattr)
+				if err != nil {
+					return fmt.Errorf("failed to read extended attributes of %s: %w", localPath, err)
+				}
+				for name, value := range xattrs {
+					key, value, err := paxRecord(name, value)
+					if err != nil {
+						return fmt.Errorf("failed to read extended attributes of %s: %w", localPath, err)
+					}
+					if hdr.PAXRecords == nil {
+						hdr.PAXRecords = map[string]string{}
+					}
+					hdr.PAXRecords[key] = value
+				}
+				if hdr.PAXRecords != nil {
+					hdr.Format = tar.FormatPAX
+				}
+			}
+
+			return held.write(hdr, func(hdr *tar.Header) error {
+				// Directories held back only need their header, which is all writeTarEntry writes of them.
+				return record(hdr, writeTarEntry(w, tw, fsys, name, hdr, progress, bufferSize))
+			})
+		})
+		if err != nil {
+			return entries, err
+		}
+	}
+
+	return entries, tw.Close()
+}
+
+// writeTarEntry writes the header, and for regular files the contents read from name in fsys, to tw.
+// Local files with holes are written as sparse entries to w, the writer of tw, see writeSparseTarEntry.
+func writeTarEntry(w io.Writer, tw *tar.Writer, fsys fs.FS, name string, hdr *tar.Header, progress Progress, bufferSize int) error {
+	if hdr.Typeflag != tar.TypeReg {
+		return tw.WriteHeader(hdr)
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if file, ok := f.(*os.File); ok {
+		regions := dataRegions(file, hdr.Size)
+		var data int64
+		for _, region := range regions {
+			data += region.length
+		}
+		if data < hdr.Size {
+			return writeSparseTarEntry(w, tw, file, hdr, regions, progress, bufferSize)
+		}
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	progress.File(hdr.Name, hdr.Size)
+	_, err = copyBuffer(tw, &progressReader{r: f, progress: progress, bufferSize: bufferSize}, bufferSize)
+	return err
+}
+
+// extractTar extracts the tar archive read from r into dir,
+// returning an entry for every file and directory read.
+func extractTar(r io.Reader, dir string, opts TarOptions, progress Progress, bufferSize int) ([]TransferEntry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	held := emptyDirs{skip: opts.SkipEmptyDirs}
+	// The metadata of directories is set once their contents are extracted, so writing into
+	// them neither fails on read-only permissions nor changes their modification times.
+	type dirEntry struct {
+		hdr   *tar.Header
+		entry int
+	}
+	var dirs []dirEntry
+	var entries []TransferEntry
+	extract := func(tr *tar.Reader, hdr *tar.Header) error {
+		err := extractEntry(tr, dir, hdr, opts, progress, bufferSize)
+		if target, targetErr := tarTarget(dir, hdr.Name); targetErr == nil && target == "" {
+			// The root of the archive is not an entry of the transfer.
+			return err
+		}
+		if hdr.Typeflag == tar.TypeDir && err == nil {
+			dirs = append(dirs, dirEntry{hdr, len(entries)})
+		}
+		entry := tarEntry(hdr, Download)
+		entry.finish(err)
+		entries = append(entries, entry)
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return entries, err
+		}
+
+		err = held.write(hdr, func(hdr *tar.Header) error { return extract(tr, hdr) })
+		if err != nil {
+			return entries, err
+		}
+	}
+
+	// Directories follow their parent in the archive, so this handles children first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entry := &entries[dirs[i].entry]
+		target, _ := tarTarget(dir, dirs[i].hdr.Name)
+		entry.finish(setTarMetadata(target, dirs[i].hdr, opts))
+		if entry.Err != nil {
+			return entries, entry.Err
+		}
+	}
+	return entries, nil
+}
+
+// extractEntry extracts a single archive entry into dir, reading the contents of files from tr.
+func extractEntry(tr *tar.Reader, dir string, hdr *tar.Header, opts TarOptions, progress Progress, bufferSize int) error {
+	target, err := tarTarget(dir, hdr.Name)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		return nil
+	}
+	// The archive may hold symlinks, which must not lead the entries after them out of dir.
+	if err := tarCheckParents(dir, target); err != nil {
+		return err
+	}
+	mode := hdr.FileInfo().Mode()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract %q through the symlink %s", hdr.Name, target)
+		}
+		// Its metadata is set by extractTar once its contents are extracted.
+		return os.MkdirAll(target, 0755)
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		// Like tar, a symlink in place of the file is replaced rather than written through,
+		// and so is a read-only file, which could not be opened for writing.
+		if err := removeTarTarget(target); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+		if err != nil {
+			return err
+		}
+		progress.File(hdr.Name, hdr.Size)
+		_, err = copyBuffer(f, &progressReader{r: tr, progress: progress, bufferSize: bufferSize}, bufferSize)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := removeTarTarget(target); err != nil {
+			return err
+		}
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+		if opts.Owner {
+			return os.Lchown(target, hdr.Uid, hdr.Gid)
+		}
+		return nil
+	default:
+		// Devices, fifos and hard links are not supported, skip them.
+		return nil
+	}
+
+	return setTarMetadata(target, hdr, opts)
+}
+
+// removeTarTarget removes whatever but a directory is in place of target, like tar extracting
+// over an earlier extraction, so the entry is created anew.
+func removeTarTarget(target string) error {
+	info, err := os.Lstat(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil || info.IsDir() {
+		return err
+	}
+	return os.Remove(target)
+}
+
+// setTarMetadata applies the ownership, permissions, extended attributes and modification time
+// of the archive entry to the extracted file or directory. Chmod and Chtimes follow symlinks,
+// so a target replaced by one is refused.
+func setTarMetadata(target string, hdr *tar.Header, opts TarOptions) error {
+	info, err := os.Lstat(target)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("refusing to set the metadata of %s through a symlink", target)
+	}
+	if opts.Owner {
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	// Set after changing the owner, which clears the setuid and setgid bits.
+	mode := hdr.FileInfo().Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	if err := os.Chmod(target, mode); err != nil {
+		return err
+	}
+	for key, value := range hdr.PAXRecords {
+		name, value, ok, err := paxXattr(key, value)
+		if err != nil {
+			return fmt.Errorf("failed to set the metadata of %s: %w", target, err)
+		}
+		if !ok || !opts.keepXattr(name) {
+			continue
+		}
+		if err := writeXattr(target, name, value); err != nil {
+			return fmt.Errorf("failed to set extended attribute %s on %s: %w", name, target, err)
+		}
+	}
+	return os.Chtimes(target, time.Now(), hdr.ModTime)
+}
+
+// tarEntry describes the archive entry as the entry of a transfer.
+func tarEntry(hdr *tar.Header, direction Direction) TransferEntry {
+	entry := TransferEntry{
+		Path:      path.Clean(hdr.Name),
+		Mode:      hdr.FileInfo().Mode(),
+		ModTime:   hdr.ModTime,
+		Direction: direction,
+		Status:    TransferPending,
+	}
+	if hdr.Typeflag == tar.TypeReg {
+		entry.Size = hdr.Size
+	}
+	return entry
+}
+
+// emptyDirs writes the entries of an archive in order, holding back the directories when skip is set
+// until an entry below them is written, so directories without anything below them are left out.
+type emptyDirs struct {
+	skip bool
+
+	// pending the chain of directories above the current entry.
+	pending []*tar.Header
+}
+
+// write writes hdr with write, after the directories held back above it, or holds it back itself.
+func (e *emptyDirs) write(hdr *tar.Header, write func(hdr *tar.Header) error) error {
+	if !e.skip {
+		return write(hdr)
+	}
+	for len(e.pending) > 0 && !tarContains(e.pending[len(e.pending)-1].Name, hdr.Name) {
+		e.pending = e.pending[:len(e.pending)-1]
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		e.pending = append(e.pending, hdr)
+		return nil
+	}
+	for _, dir := range e.pending {
+		if err := write(dir); err != nil {
+			return err
+		}
+	}
+	e.pending = nil
+	return write(hdr)
+}
+
+// tarContains reports whether the archive entry name lies below the directory entry dir.
+func tarContains(dir string, name string) bool {
+	dir = path.Clean(dir)
+	return dir == "." || strings.HasPrefix(path.Clean(name), dir+"/")
+}
+
+// tarTarget resolves the name of an archive entry within dir, refusing
+// entries that would escape it. The root of the archive resolves to "".
+func tarTarget(dir string, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if clean == "." {
+		return "", nil
+	}
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to extract %q outside of %s", name, dir)
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// tarCheckParents refuses a target resolved by tarTarget when one of the directories between dir and
+// target is a symlink, which would lead the extraction outside of dir. Missing directories are fine,
+// they are created by the extraction.
+func tarCheckParents(dir string, target string) error {
+	rel, err := filepath.Rel(dir, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+	parent := dir
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		parent = filepath.Join(parent, name)
+		info, err := os.Lstat(parent)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract %s through the symlink %s", target, parent)
+		}
+	}
+	return nil
+}
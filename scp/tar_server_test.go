@@ -0,0 +1,135 @@
+package scp_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"main/scp"
+)
+
+// recordingProgress keeps the progress reported to it.
+type recordingProgress struct {
+	mu         sync.Mutex
+	totalBytes int64
+	totalFiles int
+	files      map[string]int64
+	bytes      int64
+}
+
+func (p *recordingProgress) Start(totalBytes int64, totalFiles int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.totalBytes, p.totalFiles = totalBytes, totalFiles
+}
+
+func (p *recordingProgress) File(name string, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.files == nil {
+		p.files = map[string]int64{}
+	}
+	p.files[name] = size
+}
+
+func (p *recordingProgress) Add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes += n
+}
+
+func TestCopyDirProgress(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	local, remote := t.TempDir(), t.TempDir()
+	for name, contents := range map[string]string{"a.txt": "hello", "sub/b.txt": "hello world"} {
+		name = filepath.Join(local, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	upload := &recordingProgress{}
+	if _, err := client.CopyDirToRemoteTar(ctx, local, remote, scp.TarOptions{Progress: upload}); err != nil {
+		t.Fatal(err)
+	}
+	download := &recordingProgress{}
+	if _, err := client.CopyDirFromRemoteTar(ctx, remote, t.TempDir(), scp.TarOptions{Progress: download}); err != nil {
+		t.Fatal(err)
+	}
+
+	for direction, progress := range map[string]*recordingProgress{"upload": upload, "download": download} {
+		if progress.totalBytes != 16 || progress.totalFiles != 2 || progress.bytes != 16 {
+			t.Errorf("the %s reported %d of %d bytes in %d files, want all 16 in 2", direction, progress.bytes, progress.totalBytes, progress.totalFiles)
+		}
+		var names []string
+		for name := range progress.files {
+			names = append(names, filepath.Base(name))
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, []string{"a.txt", "b.txt"}) {
+			t.Errorf("the %s reported the files %q", direction, names)
+		}
+	}
+}
+
+func TestDirModes(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+	local := t.TempDir()
+	modes := map[string]os.FileMode{"sub": 0750, "sub/secret": 0600, "run": 0755, "plain": 0644}
+	for _, name := range []string{"sub", "sub/secret", "run", "plain"} {
+		file := filepath.Join(local, filepath.FromSlash(name))
+		if name == "sub" {
+			if err := os.Mkdir(file, 0700); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.WriteFile(file, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Set apart from creating them to escape the umask.
+	for name, mode := range modes {
+		if err := os.Chmod(filepath.Join(local, filepath.FromSlash(name)), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkModes := func(how string, dir string) {
+		t.Helper()
+		for name, want := range modes {
+			stat, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+			if err != nil || stat.Mode().Perm() != want {
+				t.Errorf("%s gave %s the mode %v, %v, want %v", how, name, stat.Mode().Perm(), err, want)
+			}
+		}
+	}
+
+	remote := t.TempDir()
+	if _, err := client.CopyDirToRemoteTar(ctx, local, remote, scp.TarOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkModes("the tar upload", remote)
+	downloaded := t.TempDir()
+	if _, err := client.CopyDirFromRemoteTar(ctx, remote, downloaded, scp.TarOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkModes("the tar download", downloaded)
+
+	synced := t.TempDir()
+	if _, err := client.SyncToRemote(ctx, local, synced, scp.SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkModes("the sync upload", synced)
+	synced = t.TempDir()
+	if _, err := client.SyncFromRemote(ctx, remote, synced, scp.SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkModes("the sync download", synced)
+}
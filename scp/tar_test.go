@@ -0,0 +1,143 @@
+package scp
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExtractTarSymlinkEscape(t *testing.T) {
+	outside := t.TempDir()
+	victim := filepath.Join(outside, "victim")
+	if err := os.WriteFile(victim, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]*tar.Header{
+		"through a linked directory": {
+			{Name: "./evil", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+			{Name: "./evil/.bashrc", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		},
+		"through a nested linked directory": {
+			{Name: "./a/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "./a/evil", Typeflag: tar.TypeSymlink, Linkname: "../../" + filepath.Base(outside), Mode: 0777},
+			{Name: "./a/evil/b/.bashrc", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		},
+		"as a directory": {
+			{Name: "./evil", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+			{Name: "./evil/", Typeflag: tar.TypeDir, Mode: 0700},
+		},
+	}
+	for name, headers := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if _, err := extractTar(testArchive(t, headers...), dir, TarOptions{}, noProgress{}, DefaultBufferSize); err == nil {
+				t.Error("extracted an archive writing through a symlink")
+			}
+			entries, _ := os.ReadDir(outside)
+			if len(entries) != 1 {
+				t.Errorf("the archive wrote %v outside of the directory", entries)
+			}
+			if info, _ := os.Stat(outside); info.Mode().Perm() == 0700 {
+				t.Error("the archive changed the mode of a directory outside")
+			}
+		})
+	}
+
+	// A file replacing a symlink replaces the link, not the file it leads to.
+	dir := t.TempDir()
+	archive := testArchive(t,
+		&tar.Header{Name: "./file", Typeflag: tar.TypeSymlink, Linkname: victim, Mode: 0777},
+		&tar.Header{Name: "./file", Typeflag: tar.TypeReg, Mode: 0600, Size: 4},
+	)
+	if _, err := extractTar(archive, dir, TarOptions{}, noProgress{}, DefaultBufferSize); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(victim); string(got) != "keep" {
+		t.Errorf("the file the symlink led to holds %q", got)
+	}
+	if info, err := os.Lstat(filepath.Join(dir, "file")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("the symlink was not replaced by the file: %v", err)
+	}
+}
+
+func TestExtractTarTwice(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		archive := testArchive(t,
+			&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+			&tar.Header{Name: "./readonly", Typeflag: tar.TypeReg, Mode: 0444, Size: 4},
+			&tar.Header{Name: "./link", Typeflag: tar.TypeSymlink, Linkname: "readonly", Mode: 0777},
+		)
+		// Over the tree extracted before, like tar the files and links are replaced.
+		if _, err := extractTar(archive, dir, TarOptions{}, noProgress{}, DefaultBufferSize); err != nil {
+			t.Fatalf("extraction %d: %v", i+1, err)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "link")); err != nil || string(got) != "xxxx" {
+		t.Errorf("the link leads to %q, %v", got, err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "readonly")); err != nil || info.Mode().Perm() != 0444 {
+		t.Errorf("the read-only file has the mode %v, %v", info.Mode().Perm(), err)
+	}
+}
+
+func TestACLRecords(t *testing.T) {
+	text := "user::rw-,user:1000:r--,group::r-x,group:20:rwx,mask::rwx,other::---"
+	acl, err := aclXattr("other::---\ngroup:20:rwx\nuser::rw-\nuser:bram:r--:1000 #effective:r--\ngroup::r-x,mask::rwx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := aclText(acl); err != nil || got != text {
+		t.Errorf("aclText = %q, %v, want %q", got, err, text)
+	}
+
+	key, value, err := paxRecord(xattrACLAccess, string(acl))
+	if err != nil || key != paxACLAccess || value != text {
+		t.Errorf("paxRecord = %q, %q, %v", key, value, err)
+	}
+	name, value, ok, err := paxXattr(paxACLDefault, text)
+	if err != nil || !ok || name != xattrACLDefault || value != string(acl) {
+		t.Errorf("paxXattr = %q, %v, %v", name, ok, err)
+	}
+	if name, value, ok, _ := paxXattr(paxSELinux, "system_u:object_r:user_home_t:s0"); !ok || name != xattrSELinux || value != "system_u:object_r:user_home_t:s0" {
+		t.Errorf("paxXattr for SELinux = %q, %q", name, value)
+	}
+	if _, _, ok, _ := paxXattr("SCHILY.dev", "1"); ok {
+		t.Error("paxXattr took a record other than an attribute")
+	}
+	if _, err := aclXattr("user::rwz"); err == nil {
+		t.Error("aclXattr took a malformed entry")
+	}
+}
+
+func TestEmptyDirs(t *testing.T) {
+	archive := []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir},
+		{Name: "./empty/", Typeflag: tar.TypeDir},
+		{Name: "./empty/nested/", Typeflag: tar.TypeDir},
+		{Name: "./a/", Typeflag: tar.TypeDir},
+		{Name: "./a/b/", Typeflag: tar.TypeDir},
+		{Name: "./a/b/file", Typeflag: tar.TypeReg},
+		{Name: "./a/c/", Typeflag: tar.TypeDir},
+		{Name: "./a/link", Typeflag: tar.TypeSymlink},
+	}
+	for skip, want := range map[bool][]string{
+		false: {"./", "./empty/", "./empty/nested/", "./a/", "./a/b/", "./a/b/file", "./a/c/", "./a/link"},
+		true:  {"./", "./a/", "./a/b/", "./a/b/file", "./a/link"},
+	} {
+		held := emptyDirs{skip: skip}
+		var written []string
+		for _, hdr := range archive {
+			held.write(hdr, func(hdr *tar.Header) error {
+				written = append(written, hdr.Name)
+				return nil
+			})
+		}
+		if !reflect.DeepEqual(written, want) {
+			t.Errorf("with skip %v wrote %q, want %q", skip, written, want)
+		}
+	}
+}
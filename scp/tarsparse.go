@@ -0,0 +1,172 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tarBlockSize the size of the blocks a tar archive consists of.
+const tarBlockSize = 512
+
+// writeSparseTarEntry writes the file f, whose data is only in regions, as a sparse entry of the PAX
+// format 1.0 of GNU tar: a map of the regions followed by their data, which GNU tar and archive/tar
+// extract with holes in between. archive/tar does not write sparse entries and drops their records,
+// so the entry is written to w, the writer of tw, directly. The holes count as transferred for the progress.
+func writeSparseTarEntry(w io.Writer, tw *tar.Writer, f *os.File, hdr *tar.Header, regions []sparseRegion, progress Progress, bufferSize int) error {
+	// Pads the entry before, so the archive continues on w.
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	var data int64
+	var end int64
+	for _, region := range regions {
+		data += region.length
+		end = region.offset + region.length
+	}
+	// Like GNU tar, a file ending in a hole ends in an empty region, as extracting stops at the last one.
+	mapped := regions
+	if end < hdr.Size {
+		mapped = append(mapped[:len(mapped):len(mapped)], sparseRegion{offset: hdr.Size})
+	}
+	var sparseMap strings.Builder
+	fmt.Fprintf(&sparseMap, "%d\n", len(mapped))
+	for _, region := range mapped {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", region.offset, region.length)
+	}
+	size := int64(tarPadded(sparseMap.Len())) + data
+
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     hdr.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(hdr.Size, 10),
+		"size":                strconv.FormatInt(size, 10),
+		"uid":                 strconv.Itoa(hdr.Uid),
+		"gid":                 strconv.Itoa(hdr.Gid),
+		"mtime":               strconv.FormatInt(hdr.ModTime.Unix(), 10),
+	}
+	if hdr.Uname != "" {
+		records["uname"] = hdr.Uname
+	}
+	if hdr.Gname != "" {
+		records["gname"] = hdr.Gname
+	}
+	for key, value := range hdr.PAXRecords {
+		records[key] = value
+	}
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pax strings.Builder
+	for _, key := range keys {
+		pax.WriteString(paxLine(key, records[key]))
+	}
+
+	dir, name := path.Split(hdr.Name)
+	blocks := tarHeaderBlock(path.Join(dir, "PaxHeaders.0", name), int64(pax.Len()), tar.TypeXHeader, hdr)
+	blocks = append(blocks, tarPad([]byte(pax.String()))...)
+	blocks = append(blocks, tarHeaderBlock(path.Join(dir, "GNUSparseFile.0", name), size, tar.TypeReg, hdr)...)
+	blocks = append(blocks, tarPad([]byte(sparseMap.String()))...)
+	if _, err := w.Write(blocks); err != nil {
+		return err
+	}
+
+	progress.File(hdr.Name, hdr.Size)
+	var pos int64
+	for _, region := range regions {
+		if hole := region.offset - pos; hole > 0 {
+			progress.Add(hole)
+		}
+		section := io.NewSectionReader(f, region.offset, region.length)
+		n, err := copyBuffer(w, &progressReader{r: section, progress: progress, bufferSize: bufferSize}, bufferSize)
+		if err != nil {
+			return err
+		}
+		if n != region.length {
+			return fmt.Errorf("%s changed while it was archived", hdr.Name)
+		}
+		pos = region.offset + region.length
+	}
+	if hole := hdr.Size - pos; hole > 0 {
+		progress.Add(hole)
+	}
+
+	_, err := w.Write(make([]byte, tarPadded(int(data%tarBlockSize))-int(data%tarBlockSize)))
+	return err
+}
+
+// tarHeaderBlock returns a ustar header block of an entry of the given name, size and type, with the
+// mode and owner of hdr. Names and numbers not fitting are cut off, the PAX records preceding it hold them.
+func tarHeaderBlock(name string, size int64, typeflag byte, hdr *tar.Header) []byte {
+	block := make([]byte, tarBlockSize)
+	field := func(offset int, length int, value string) {
+		copy(block[offset:offset+length], value)
+	}
+	octal := func(offset int, length int, value int64) {
+		s := strconv.FormatInt(value, 8)
+		if value < 0 || len(s) > length-1 {
+			s = "0"
+		}
+		field(offset, length, strings.Repeat("0", length-1-len(s))+s)
+	}
+
+	field(0, 100, name)
+	octal(100, 8, hdr.Mode&0o7777)
+	octal(108, 8, int64(hdr.Uid))
+	octal(116, 8, int64(hdr.Gid))
+	octal(124, 12, size)
+	octal(136, 12, hdr.ModTime.Unix())
+	block[156] = typeflag
+	field(257, 6, "ustar\x00")
+	field(263, 2, "00")
+	field(265, 32, hdr.Uname)
+	field(297, 32, hdr.Gname)
+
+	// The checksum is computed with its own field taken as spaces.
+	field(148, 8, "        ")
+	var sum int64
+	for _, b := range block {
+		sum += int64(b)
+	}
+	octal(148, 7, sum)
+	block[155] = ' '
+	return block
+}
+
+// paxLine formats a PAX record, which starts with its own length.
+func paxLine(key string, value string) string {
+	record := " " + key + "=" + value + "\n"
+	size := len(record)
+	for {
+		line := strconv.Itoa(size) + record
+		if len(line) == size {
+			return line
+		}
+		size = len(line)
+	}
+}
+
+// tarPadded returns n rounded up to whole blocks.
+func tarPadded(n int) int {
+	return (n + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+}
+
+// tarPad returns b padded with zeros to whole blocks.
+func tarPad(b []byte) []byte {
+	return append(b, make([]byte, tarPadded(len(b))-len(b))...)
+}
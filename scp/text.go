@@ -0,0 +1,132 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// TextMode converts the line endings of files while they are transferred, like the ASCII mode of FTP,
+// for text such as configuration files moved between Windows and Unix hosts.
+type TextMode int
+
+const (
+	// TextOff transfers files as they are.
+	TextOff TextMode = iota
+
+	// TextRemoteLF writes uploads with LF line endings on the remote and downloads with CRLF line
+	// endings locally, for a Windows machine talking to a Unix remote.
+	TextRemoteLF
+
+	// TextRemoteCRLF writes uploads with CRLF line endings on the remote and downloads with LF line
+	// endings locally, for a Unix machine talking to a Windows remote.
+	TextRemoteCRLF
+)
+
+func (m TextMode) String() string {
+	switch m {
+	case TextOff:
+		return "off"
+	case TextRemoteLF:
+		return "lf"
+	case TextRemoteCRLF:
+		return "crlf"
+	default:
+		return "TextMode(" + strconv.Itoa(int(m)) + ")"
+	}
+}
+
+// textUpload converts the line endings of r for the remote. The size of the converted file is only
+// known once all of it was converted, so it is spooled to a temporary file, which upload reads.
+func (a *Client) textUpload(ctx context.Context, r io.Reader, upload func(r io.Reader, size int64) error) error {
+	spool, err := os.CreateTemp("", "go-scp-tui-*")
+	if err != nil {
+		return fmt.Errorf("failed to convert the line endings: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	converted := newLineEndingWriter(spool, a.TextMode == TextRemoteCRLF)
+	if _, err := copyBuffer(converted, contextReader{ctx, r}, a.BufferSize); err != nil {
+		return fmt.Errorf("failed to convert the line endings: %w", err)
+	}
+	if err := converted.Flush(); err != nil {
+		return fmt.Errorf("failed to convert the line endings: %w", err)
+	}
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to convert the line endings: %w", err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to convert the line endings: %w", err)
+	}
+	return upload(spool, size)
+}
+
+// textDownload writes the file received by download to w with the line endings converted for the
+// local machine. The progress counts the bytes on the wire, those of the file on the remote.
+func (a *Client) textDownload(w io.Writer, download func(w io.Writer) (*FileInfos, error)) (*FileInfos, error) {
+	converted := newLineEndingWriter(w, a.TextMode == TextRemoteLF)
+	fileInfos, err := download(converted)
+	if err != nil {
+		return fileInfos, err
+	}
+	return fileInfos, converted.Flush()
+}
+
+// lineEndingWriter converts the line endings of what is written to it to CRLF, or to LF, before
+// writing it to w. Lines ending in a lone CR are left as they are. Flush writes a CR held back
+// at the end of the last write.
+type lineEndingWriter struct {
+	w     io.Writer
+	crlf  bool
+	buf   []byte
+	lastC byte
+}
+
+func newLineEndingWriter(w io.Writer, crlf bool) *lineEndingWriter {
+	return &lineEndingWriter{w: w, crlf: crlf}
+}
+
+func (l *lineEndingWriter) Write(p []byte) (int, error) {
+	l.buf = l.buf[:0]
+	for _, c := range p {
+		switch {
+		case l.crlf && c == '\n' && l.lastC != '\r':
+			l.buf = append(l.buf, '\r', '\n')
+		case !l.crlf && l.lastC == '\r' && c != '\n':
+			// The CR held back did not start a CRLF.
+			l.buf = append(l.buf, '\r')
+			if c != '\r' {
+				l.buf = append(l.buf, c)
+			}
+		case !l.crlf && c == '\r':
+			// Held back until the next byte tells whether it starts a CRLF.
+		default:
+			l.buf = append(l.buf, c)
+		}
+		l.lastC = c
+	}
+	if _, err := l.w.Write(l.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes the CR held back when the last byte written was one.
+func (l *lineEndingWriter) Flush() error {
+	if l.crlf || l.lastC != '\r' {
+		return nil
+	}
+	l.lastC = 0
+	_, err := l.w.Write([]byte{'\r'})
+	return err
+}
@@ -0,0 +1,37 @@
+package scp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLineEndingWriter(t *testing.T) {
+	tests := []struct {
+		crlf   bool
+		writes []string
+		want   string
+	}{
+		{crlf: false, writes: []string{"a\r\nb\r\n"}, want: "a\nb\n"},
+		{crlf: false, writes: []string{"a\r", "\nb\r", "\n"}, want: "a\nb\n"},
+		{crlf: false, writes: []string{"lone\rcr\r\r\n"}, want: "lone\rcr\r\n"},
+		{crlf: false, writes: []string{"trailing\r"}, want: "trailing\r"},
+		{crlf: true, writes: []string{"a\nb\n"}, want: "a\r\nb\r\n"},
+		{crlf: true, writes: []string{"a\r", "\nb\n"}, want: "a\r\nb\r\n"},
+		{crlf: true, writes: []string{"lone\rcr"}, want: "lone\rcr"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		w := newLineEndingWriter(&out, test.crlf)
+		for _, s := range test.writes {
+			if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+				t.Fatalf("Write(%q) = %d, %v", s, n, err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.want {
+			t.Errorf("converting %q with crlf %v = %q, want %q", test.writes, test.crlf, out.String(), test.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme the look of the terminal interfaces. Colors are hex values such as "#5A56E0"
+// or ANSI color numbers. Empty fields fall back to DefaultTheme, so a theme only
+// needs to set what it changes. The JSON keys are the ones of the config file.
+type Theme struct {
+	// GradientStart and GradientEnd the colors the progress bars blend between.
+	GradientStart string `json:"gradient_start,omitempty"`
+	GradientEnd   string `json:"gradient_end,omitempty"`
+
+	// Color fills the progress bars with a single color instead of the gradient.
+	Color string `json:"color,omitempty"`
+
+	// Full and Empty the characters drawing the done and remaining parts of a progress bar.
+	Full  string `json:"full,omitempty"`
+	Empty string `json:"empty,omitempty"`
+
+	// EmptyColor the color of the remaining part of a progress bar.
+	EmptyColor string `json:"empty_color,omitempty"`
+
+	// Width the maximal width of the progress bars and the log pane.
+	Width int `json:"width,omitempty"`
+
+	// Muted the color of help texts and file names.
+	Muted string `json:"muted,omitempty"`
+
+	// Active, Success and Failure the colors of files being transferred,
+	// transferred and failed in lists such as the one of WatchProgress.
+	Active  string `json:"active,omitempty"`
+	Success string `json:"success,omitempty"`
+	Failure string `json:"failure,omitempty"`
+}
+
+// DefaultTheme the theme used when none is configured.
+func DefaultTheme() Theme {
+	return Theme{
+		GradientStart: "#5A56E0",
+		GradientEnd:   "#EE6FF8",
+		Full:          "█",
+		Empty:         "░",
+		EmptyColor:    "#606060",
+		Width:         80,
+		Muted:         "#626262",
+		Active:        "#5A56E0",
+		Success:       "#04B575",
+		Failure:       "#FF4672",
+	}
+}
+
+// withDefaults fills the empty fields of the theme from DefaultTheme.
+func (t Theme) withDefaults() Theme {
+	d := DefaultTheme()
+	fill := func(value *string, def string) {
+		if *value == "" {
+			*value = def
+		}
+	}
+	fill(&t.GradientStart, d.GradientStart)
+	fill(&t.GradientEnd, d.GradientEnd)
+	fill(&t.Full, d.Full)
+	fill(&t.Empty, d.Empty)
+	fill(&t.EmptyColor, d.EmptyColor)
+	fill(&t.Muted, d.Muted)
+	fill(&t.Active, d.Active)
+	fill(&t.Success, d.Success)
+	fill(&t.Failure, d.Failure)
+	if t.Width <= 0 {
+		t.Width = d.Width
+	}
+	return t
+}
+
+// progressBar builds a progress bar drawn in the theme.
+func (t Theme) progressBar() progress.Model {
+	full, _ := utf8.DecodeRuneInString(t.Full)
+	empty, _ := utf8.DecodeRuneInString(t.Empty)
+
+	fill := progress.WithGradient(t.GradientStart, t.GradientEnd)
+	if t.Color != "" {
+		fill = progress.WithSolidFill(t.Color)
+	}
+	bar := progress.New(fill, progress.WithWidth(t.Width))
+	bar.Full = full
+	bar.Empty = empty
+	bar.EmptyColor = t.EmptyColor
+	return bar
+}
+
+// style renders text in the given color of the theme.
+func style(color string) func(strs ...string) string {
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(color)).Render
+}
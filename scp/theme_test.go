@@ -0,0 +1,32 @@
+package scp
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTheme(t *testing.T) {
+	var theme Theme
+	if err := json.Unmarshal([]byte(`{"full": "#", "empty": "-", "color": "#FF0000", "width": 40}`), &theme); err != nil {
+		t.Fatal(err)
+	}
+	theme = theme.withDefaults()
+	// What the config sets is kept, the rest comes from the default theme.
+	d := DefaultTheme()
+	if theme.Full != "#" || theme.Empty != "-" || theme.Color != "#FF0000" || theme.Width != 40 {
+		t.Errorf("the configured fields were replaced: %+v", theme)
+	}
+	if theme.Muted != d.Muted || theme.GradientStart != d.GradientStart || theme.Failure != d.Failure {
+		t.Errorf("the fields left out were not filled from the default theme: %+v", theme)
+	}
+
+	bar := theme.progressBar()
+	view := bar.ViewAs(0.5)
+	if !strings.Contains(view, "#") || !strings.Contains(view, "-") || strings.Contains(view, d.Full) {
+		t.Errorf("the progress bar is not drawn with the characters of the theme: %q", view)
+	}
+	if bar.Width != 40 {
+		t.Errorf("the progress bar is %d wide, want the width of the theme", bar.Width)
+	}
+}
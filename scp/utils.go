@@ -6,19 +6,102 @@
 
 package scp
 
-import "io"
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+)
+
+// DefaultBufferSize the size of the buffers file contents are copied through by default.
+// It is larger than the 32KiB io.Copy uses, which results in fewer and larger writes to
+// the SSH channel.
+const DefaultBufferSize = 256 * 1024
+
+// copyBuffer copies from src to dst until EOF through a buffer of the given size, DefaultBufferSize
+// when it is zero or less. Sources implementing io.WriterTo copy themselves, such as the readers
+// wrapping a transfer, which copy through copyThroughBuffer. io.ReaderFrom is skipped, since the
+// generic one of *os.File copies through 32KiB again, and so is the io.WriterTo of *os.File.
+func copyBuffer(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if wt, ok := src.(io.WriterTo); ok {
+		if _, file := src.(*os.File); !file {
+			return wt.WriteTo(dst)
+		}
+	}
+	return copyThroughBuffer(dst, src, size)
+}
+
+// copyThroughBuffer copies from src to dst through a pooled buffer of the given size, only using
+// their Read and Write methods. Readers use it to implement io.WriterTo without recursing into it.
+func copyThroughBuffer(dst io.Writer, src io.Reader, size int) (int64, error) {
+	pool := bufferPool(size)
+	buf := pool.Get().(*[]byte)
+	defer pool.Put(buf)
+
+	return io.CopyBuffer(writerOnly{dst}, readerOnly{src}, *buf)
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = map[int]*sync.Pool{}
+)
+
+// bufferPool returns the pool of buffers of the given size, shared across transfers so that
+// many parallel copies do not each allocate their own. Pointers are pooled to keep Put from allocating.
+func bufferPool(size int) *sync.Pool {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	pool, ok := pools[size]
+	if !ok {
+		pool = &sync.Pool{
+			New: func() any {
+				buf := make([]byte, size)
+				return &buf
+			},
+		}
+		pools[size] = pool
+	}
+	return pool
+}
+
+// readerOnly hides every method but Read, such as io.WriterTo.
+type readerOnly struct{ io.Reader }
+
+// writerOnly hides every method but Write, such as io.ReaderFrom.
+type writerOnly struct{ io.Writer }
 
 // CopyN an adaptation of io.CopyN that keeps reading if it did not return
-// a sufficient amount of bytes.
+// a sufficient amount of bytes. It fails with io.ErrUnexpectedEOF when src
+// ends before size bytes were copied.
 func CopyN(writer io.Writer, src io.Reader, size int64) (int64, error) {
+	return copyN(context.Background(), writer, src, size, DefaultBufferSize)
+}
+
+// CopyNContext is the same as CopyN, but stops with the cause of the context once it is done.
+// The context is checked before every chunk read from src, so a cancelled copy stops promptly
+// even when src keeps delivering data.
+func CopyNContext(ctx context.Context, writer io.Writer, src io.Reader, size int64) (int64, error) {
+	return copyN(ctx, writer, src, size, DefaultBufferSize)
+}
+
+// copyN is CopyNContext copying through buffers of the given size.
+func copyN(ctx context.Context, writer io.Writer, src io.Reader, size int64, bufferSize int) (int64, error) {
+	src = contextReader{ctx, src}
 	var total int64
-	total = 0
 	for total < size {
-		n, err := io.CopyN(writer, src, size)
+		n, err := copyBuffer(writer, io.LimitReader(src, size-total), bufferSize)
+		total += n
 		if err != nil {
-			return 0, err
+			return total, err
+		}
+		if n == 0 {
+			return total, io.ErrUnexpectedEOF
 		}
-		total += n
 	}
 
 	return total, nil
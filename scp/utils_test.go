@@ -0,0 +1,99 @@
+package scp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// chunkWriter records the size of the largest write.
+type chunkWriter struct {
+	n       int64
+	largest int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	w.largest = max(w.largest, len(p))
+	return len(p), nil
+}
+
+// copyingSource a reader with a fast path, which copies through buffers of 1000 bytes.
+type copyingSource struct {
+	io.Reader
+	used bool
+}
+
+func (s *copyingSource) WriteTo(w io.Writer) (int64, error) {
+	s.used = true
+	return copyThroughBuffer(w, s.Reader, 1000)
+}
+
+func TestCopyBuffer(t *testing.T) {
+	data := make([]byte, 4*DefaultBufferSize)
+	w := &chunkWriter{}
+	if n, err := copyBuffer(w, io.LimitReader(bytes.NewReader(data), int64(len(data))), 0); err != nil || n != int64(len(data)) || w.n != n {
+		t.Fatalf("copyBuffer copied %d of %d bytes, %v", w.n, len(data), err)
+	}
+	if w.largest != DefaultBufferSize {
+		t.Errorf("copied in chunks of up to %d bytes, want DefaultBufferSize", w.largest)
+	}
+
+	w = &chunkWriter{}
+	if _, err := copyBuffer(w, io.LimitReader(bytes.NewReader(data), int64(len(data))), 4096); err != nil || w.largest != 4096 {
+		t.Errorf("copied in chunks of up to %d bytes, %v, want the size given", w.largest, err)
+	}
+
+	// Sources with an io.WriterTo copy themselves.
+	w = &chunkWriter{}
+	source := &copyingSource{Reader: bytes.NewReader(data)}
+	if n, err := copyBuffer(w, source, 0); err != nil || n != int64(len(data)) || !source.used || w.largest != 1000 {
+		t.Errorf("copied %d bytes in chunks of up to %d, %v, the WriteTo of the source was used: %v", n, w.largest, err, source.used)
+	}
+
+	// Like the readers wrapping a transfer, which copy through the large buffers as well.
+	w = &chunkWriter{}
+	reader := &progressReader{r: bytes.NewReader(data), progress: noProgress{}}
+	if _, err := io.Copy(w, reader); err != nil || w.largest != DefaultBufferSize {
+		t.Errorf("io.Copy from a progressReader copied in chunks of up to %d bytes, %v", w.largest, err)
+	}
+}
+
+func TestWriteToBufferSize(t *testing.T) {
+	q, err := LoadQueue(filepath.Join(t.TempDir(), "queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := &jobTracker{queue: q, job: &Job{}, sum: sha256.New(), progress: noProgress{}, bufferSize: 4096}
+
+	data := make([]byte, 4*DefaultBufferSize)
+	readers := map[string]func(r io.Reader) io.Reader{
+		"progressReader": func(r io.Reader) io.Reader {
+			return progressPassThru(noProgress{}, "file", nil, 4096)(r, int64(len(data)))
+		},
+		"jobReader":      tracker.Reader,
+		"activityReader": func(r io.Reader) io.Reader { return newWatchdog().Reader(r, 4096) },
+		"hashingReader":  func(r io.Reader) io.Reader { return &hashingReader{r: r, sum: sha256.New(), bufferSize: 4096} },
+	}
+	for name, wrap := range readers {
+		// The WriteTo used by io.Copy, such as the one copying the stdin of an SSH session.
+		w := &chunkWriter{}
+		if n, err := io.Copy(w, wrap(bytes.NewReader(data))); err != nil || n != int64(len(data)) || w.largest != 4096 {
+			t.Errorf("io.Copy from a %s copied %d bytes in chunks of up to %d, %v, want the buffer size it was given", name, n, w.largest, err)
+		}
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	if bufferPool(0) != bufferPool(DefaultBufferSize) {
+		t.Error("the default size does not share the pool of DefaultBufferSize")
+	}
+	if bufferPool(4096) != bufferPool(4096) || bufferPool(4096) == bufferPool(8192) {
+		t.Error("buffers are not pooled by their size")
+	}
+	if buf := bufferPool(4096).Get().(*[]byte); len(*buf) != 4096 {
+		t.Errorf("the pool of 4096 byte buffers handed out one of %d bytes", len(*buf))
+	}
+}
@@ -0,0 +1,135 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultVerifyRetries how often an upload failing verification is repeated by default.
+const DefaultVerifyRetries = 2
+
+// verifiedUpload runs upload with r and compares the sha256sum of remotePath with the checksum of
+// the bytes it read, see verifyChecksum. When they differ the upload is repeated after seeking r back
+// to where it started, as long as r is an io.Seeker and VerifyRetries allows.
+func (a *Client) verifiedUpload(ctx context.Context, r io.Reader, remotePath string, upload func(r io.Reader) (*UploadResult, error)) (*UploadResult, error) {
+	seeker, _ := r.(io.Seeker)
+	var start int64
+	if seeker != nil {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+	file, _ := r.(*os.File)
+
+	for attempt := 0; ; attempt++ {
+		sum := sha256.New()
+		var result *UploadResult
+		var size int64
+		var err error
+		if file != nil && seeker != nil {
+			// Handed on as it is, so the upload can tell it is a file, and hashed afterwards.
+			result, err = upload(file)
+			if err == nil {
+				size, err = hashFile(file, start, sum)
+			}
+		} else {
+			hashing := &hashingReader{r: r, sum: sum, bufferSize: a.BufferSize}
+			result, err = upload(hashing)
+			size = hashing.n
+		}
+		if err != nil {
+			return result, err
+		}
+
+		err = a.verifyChecksum(ctx, remotePath, size, sum, ErrVerifyFailed)
+		if !errors.Is(err, ErrVerifyFailed) || seeker == nil || attempt >= a.VerifyRetries {
+			return result, err
+		}
+		a.logf(ctx, LogWarning, "%v, uploading it again", err)
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return result, err
+		}
+	}
+}
+
+// verifyChecksum compares the sha256sum of remotePath with sum, the checksum of the size bytes sent,
+// and returns mismatch when they differ. It is the check of both VerifyUploads and resumed uploads.
+// A remote without sha256sum can not checksum the file, only its size is compared then, which is
+// logged as a warning. Other failures to checksum it fail the verification.
+func (a *Client) verifyChecksum(ctx context.Context, remotePath string, size int64, sum hash.Hash, mismatch error) error {
+	remote, err := a.remoteSHA256(ctx, remotePath)
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) && exit.ExitStatus() == 127 {
+		a.logf(ctx, LogWarning, "the remote has no sha256sum, only the size of %s is verified", remotePath)
+		stat, err := a.Stat(ctx, remotePath)
+		if err != nil {
+			return fmt.Errorf("could not stat %s on the remote to verify it: %w", remotePath, err)
+		}
+		if stat.Size != size {
+			return fmt.Errorf("%w: %s has %d bytes instead of %d", mismatch, remotePath, stat.Size, size)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not checksum %s on the remote to verify it: %w", remotePath, err)
+	}
+	if local := hex.EncodeToString(sum.Sum(nil)); remote != local {
+		return fmt.Errorf("%w: %s has checksum %s instead of %s", mismatch, remotePath, remote, local)
+	}
+	return nil
+}
+
+// remoteSHA256 returns the hex encoded SHA-256 of the remote file, computed by sha256sum.
+func (a *Client) remoteSHA256(ctx context.Context, remotePath string) (string, error) {
+	out, err := a.runOutput(ctx, "sha256sum "+a.shellPath(remotePath))
+	if err != nil {
+		return "", err
+	}
+	sum, _, _ := strings.Cut(string(out), " ")
+	return sum, nil
+}
+
+// hashFile writes the bytes of f from start up to its offset to sum and returns their amount.
+func hashFile(f *os.File, start int64, sum hash.Hash) (int64, error) {
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	return copyBuffer(sum, io.NewSectionReader(f, start, end-start), DefaultBufferSize)
+}
+
+// hashingReader writes every read to sum and counts the bytes read in n.
+// Its WriteTo copies through buffers of bufferSize.
+type hashingReader struct {
+	r          io.Reader
+	sum        hash.Hash
+	n          int64
+	bufferSize int
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.sum.Write(p[:n])
+	h.n += int64(n)
+	return n, err
+}
+
+func (h *hashingReader) WriteTo(w io.Writer) (int64, error) {
+	return copyThroughBuffer(w, h, h.bufferSize)
+}
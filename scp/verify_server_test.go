@@ -0,0 +1,97 @@
+package scp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"main/scp"
+	"main/scp/scptest"
+)
+
+func TestVerifyUploads(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, func(c *scp.ClientConfigurer) { c.VerifyUploads(true) })
+	dir := t.TempDir()
+
+	local := filepath.Join(dir, "local")
+	if err := os.WriteFile(local, []byte("skip this, verify that"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// Only the bytes from the offset on are uploaded, and so verified.
+	if _, err := f.Seek(11, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Copy(ctx, f, filepath.Join(dir, "file"), "0644", 11); err != nil {
+		t.Errorf("verified upload of a file returned %v", err)
+	}
+	if err := client.Copy(ctx, io.MultiReader(strings.NewReader("verify that")), filepath.Join(dir, "reader"), "0644", 11); err != nil {
+		t.Errorf("verified upload of a reader returned %v", err)
+	}
+}
+
+func TestVerifyUploadsMismatch(t *testing.T) {
+	server := scptest.NewShellServer(t)
+	var mu sync.Mutex
+	checksums := 0
+	server.Exec = func(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+		if strings.HasPrefix(command, "sha256sum ") {
+			mu.Lock()
+			checksums++
+			mu.Unlock()
+			io.WriteString(stdout, strings.Repeat("0", 64)+"  file\n")
+			return 0
+		}
+		return server.Shell(command, stdin, stdout, stderr)
+	}
+	client := server.Configurer().VerifyUploads(true).VerifyRetries(1).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	remote := filepath.Join(t.TempDir(), "file")
+	if err := client.Copy(context.Background(), strings.NewReader("hello"), remote, "0644", 5); !errors.Is(err, scp.ErrVerifyFailed) {
+		t.Errorf("upload not matching its checksum returned %v, want ErrVerifyFailed", err)
+	}
+	if checksums != 2 {
+		t.Errorf("checksummed %d times, want once more for the retry", checksums)
+	}
+}
+
+func TestVerifyUploadsWithoutSHA256Sum(t *testing.T) {
+	// Commands other than scp, sha256sum among them, are missing on this server.
+	server := scptest.NewServer(t)
+	var mu sync.Mutex
+	var warnings []string
+	client := server.Configurer().VerifyUploads(true).Logger(func(entry scp.LogEntry) {
+		if entry.Level == scp.LogWarning {
+			mu.Lock()
+			warnings = append(warnings, entry.Message)
+			mu.Unlock()
+		}
+	}).Create()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Copy(context.Background(), strings.NewReader("hello"), "file", "0644", 5); err != nil {
+		t.Errorf("upload to a remote without sha256sum returned %v, want its size to be verified", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "no sha256sum") {
+		t.Errorf("logged warnings %q", warnings)
+	}
+}
@@ -0,0 +1,344 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultWatchDebounce how long Watch waits for a directory to settle before uploading.
+const DefaultWatchDebounce = 300 * time.Millisecond
+
+// watchListSize the amount of files listed by WatchProgress.
+const watchListSize = 15
+
+// WatchStatus the state of a file picked up by Watch.
+type WatchStatus string
+
+const (
+	// WatchPending the file changed and will be uploaded once the directory settles.
+	WatchPending WatchStatus = "pending"
+
+	// WatchUploading the file is being uploaded.
+	WatchUploading WatchStatus = "uploading"
+
+	// WatchUploaded the file was uploaded.
+	WatchUploaded WatchStatus = "uploaded"
+
+	// WatchFailed uploading the file failed, see WatchEvent.Err.
+	WatchFailed WatchStatus = "failed"
+)
+
+// WatchEvent reports a change of status of a file picked up by Watch.
+type WatchEvent struct {
+	// Path the path of the file relative to the watched directory, separated by slashes.
+	Path   string
+	Status WatchStatus
+	Err    error
+	Time   time.Time
+
+	// Tags the tags attached to the context of Watch, see WithTags.
+	Tags Tags
+}
+
+// line renders the event as a line of plain text.
+func (e WatchEvent) line() string {
+	return e.Time.Format("15:04:05") + " " + fmt.Sprintf("%-9s", e.Status) + " " + e.message()
+}
+
+// message the path of the file, followed by the error when it failed.
+func (e WatchEvent) message() string {
+	if e.Err != nil {
+		return e.Path + ": " + e.Err.Error()
+	}
+	return e.Path
+}
+
+// WatchOptions configures Watch.
+type WatchOptions struct {
+	// Debounce how long no changes must happen before the changed files are uploaded,
+	// so a burst of writes results in a single upload. Defaults to DefaultWatchDebounce.
+	Debounce time.Duration
+
+	// Events is called with every change of status of a file, may be nil.
+	Events func(event WatchEvent)
+}
+
+// Watch monitors `localDir`, including directories created later, and uploads every file that is
+// created or written to the same relative path below `remoteDir`, keeping its permissions and modification
+// time. Deleted files are not removed from the remote. It runs until the context is done, returning its error,
+// or watching fails.
+func (a *Client) Watch(ctx context.Context, localDir string, remoteDir string, opts WatchOptions) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := addWatches(watcher, localDir); err != nil {
+		return err
+	}
+
+	debounce := opts.Debounce
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+	emit := func(name string, status WatchStatus, err error) {
+		if opts.Events != nil {
+			opts.Events(WatchEvent{Path: name, Status: status, Err: err, Time: time.Now(), Tags: TagsFromContext(ctx)})
+		}
+	}
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	pending := map[string]bool{}
+
+	queue := func(local string) {
+		rel, err := filepath.Rel(localDir, local)
+		if err != nil {
+			return
+		}
+		rel = filepath.ToSlash(rel)
+		if !pending[rel] {
+			pending[rel] = true
+			emit(rel, WatchPending, nil)
+		}
+		timer.Reset(debounce)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			stat, err := os.Lstat(event.Name)
+			if err != nil {
+				continue
+			}
+			if stat.IsDir() {
+				// Files may have been created in the directory before it was watched.
+				_ = addWatches(watcher, event.Name)
+				_ = filepath.WalkDir(event.Name, func(p string, d fs.DirEntry, err error) error {
+					if err == nil && d.Type().IsRegular() {
+						queue(p)
+					}
+					return nil
+				})
+				continue
+			}
+			if stat.Mode().IsRegular() {
+				queue(event.Name)
+			}
+
+		case <-timer.C:
+			names := make([]string, 0, len(pending))
+			for name := range pending {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			pending = map[string]bool{}
+
+			for _, name := range names {
+				local := filepath.Join(localDir, filepath.FromSlash(name))
+				if _, err := os.Stat(local); errors.Is(err, fs.ErrNotExist) {
+					// Removed again before it could be uploaded.
+					continue
+				}
+
+				emit(name, WatchUploading, nil)
+				if err := a.pushFile(ctx, local, path.Join(remoteDir, name), nil); err != nil {
+					a.logf(ctx, LogError, "failed to upload %s: %v", name, err)
+					emit(name, WatchFailed, err)
+					continue
+				}
+				emit(name, WatchUploaded, nil)
+			}
+		}
+	}
+}
+
+// addWatches watches dir and every directory below it.
+func addWatches(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return watcher.Add(p)
+	})
+}
+
+// WatchProgress is the same as Watch but renders a live list of the most recently changed files
+// and their status in the terminal. Quitting the interface stops watching without an error.
+func (a *Client) WatchProgress(ctx context.Context, localDir string, remoteDir string, opts WatchOptions) error {
+	switch a.ProgressOutput.resolve() {
+	case ProgressNone:
+		return a.Watch(ctx, localDir, remoteDir, opts)
+	case ProgressPlain:
+		return a.watchPlain(ctx, os.Stdout, localDir, remoteDir, opts)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	theme := a.Theme.withDefaults()
+	keys := newKeyMap()
+	keys.Quit.SetHelp("q", "stop watching")
+	keys.ToggleLog.SetEnabled(false)
+	keys.ScrollUp.SetEnabled(false)
+	keys.ScrollDown.SetEnabled(false)
+	p := tea.NewProgram(watchModel{keys: keys, help: newHelp(theme), theme: theme, localDir: localDir, remoteDir: remoteDir}, programOptions...)
+
+	events := opts.Events
+	opts.Events = func(event WatchEvent) {
+		if events != nil {
+			events(event)
+		}
+		p.Send(event)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := a.Watch(ctx, localDir, remoteDir, opts)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			p.Send(progressErrMsg{err})
+		} else {
+			p.Send(progressDoneMsg{})
+		}
+		done <- err
+	}()
+
+	_, runErr := p.Run()
+	cancel()
+	err := <-done
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	if runErr != nil {
+		return errors.Join(err, runErr)
+	}
+	return err
+}
+
+// watchPlain is the same as Watch but prints every change of status of a file to w as a line.
+func (a *Client) watchPlain(ctx context.Context, w io.Writer, localDir string, remoteDir string, opts WatchOptions) error {
+	fmt.Fprintln(w, "Watching "+localDir+" -> "+remoteDir)
+	events := opts.Events
+	opts.Events = func(event WatchEvent) {
+		if events != nil {
+			events(event)
+		}
+		fmt.Fprintln(w, event.line())
+	}
+	return a.Watch(ctx, localDir, remoteDir, opts)
+}
+
+// watchModel lists the most recently changed files, newest first.
+type watchModel struct {
+	keys      keyMap
+	help      help.Model
+	theme     Theme
+	localDir  string
+	remoteDir string
+	files     []WatchEvent
+	err       error
+}
+
+// statusColor the color of a file with the given status in the list.
+func (t Theme) statusColor(status WatchStatus) string {
+	switch status {
+	case WatchUploading:
+		return t.Active
+	case WatchUploaded:
+		return t.Success
+	case WatchFailed:
+		return t.Failure
+	default:
+		return t.Muted
+	}
+}
+
+func (m watchModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m watchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		}
+		return m, nil
+
+	case WatchEvent:
+		files := []WatchEvent{msg}
+		for _, file := range m.files {
+			if file.Path != msg.Path && len(files) < watchListSize {
+				files = append(files, file)
+			}
+		}
+		m.files = files
+		return m, nil
+
+	case progressErrMsg:
+		m.err = msg.err
+		return m, tea.Quit
+
+	case progressDoneMsg:
+		return m, tea.Quit
+
+	default:
+		return m, nil
+	}
+}
+
+func (m watchModel) View() string {
+	if m.err != nil {
+		return "Error watching: " + m.err.Error() + "\n"
+	}
+
+	pad := strings.Repeat(" ", padding)
+	view := "\n" + pad + "Watching " + m.localDir + " -> " + m.remoteDir + "\n\n"
+	if len(m.files) == 0 {
+		view += pad + style(m.theme.Muted)("Waiting for changes...") + "\n"
+	}
+	for _, file := range m.files {
+		view += pad + file.Time.Format("15:04:05") + " " +
+			style(m.theme.statusColor(file.Status))(fmt.Sprintf("%-9s", file.Status)) + " " + file.message() + "\n"
+	}
+	return view + "\n" + indent(m.help.View(m.keys), pad) + "\n"
+}
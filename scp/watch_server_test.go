@@ -0,0 +1,74 @@
+package scp_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"main/scp"
+)
+
+func TestWatch(t *testing.T) {
+	client := newTestClient(t, nil)
+	local, remote := t.TempDir(), t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan scp.WatchEvent, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Watch(ctx, local, remote, scp.WatchOptions{
+			Debounce: 50 * time.Millisecond,
+			Events:   func(event scp.WatchEvent) { events <- event },
+		})
+	}()
+	// Give the watcher time to start before the first change.
+	time.Sleep(100 * time.Millisecond)
+
+	// A burst of writes is a single upload, of the file as it ended up.
+	file := filepath.Join(local, "file")
+	for _, contents := range []string{"one", "two", "three"} {
+		if err := os.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Directories created later are watched as well.
+	if err := os.MkdirAll(filepath.Join(local, "sub", "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(local, "sub", "dir", "nested"), []byte("nested"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uploads := map[string]int{}
+	timeout := time.After(5 * time.Second)
+	for uploads["file"] == 0 || uploads["sub/dir/nested"] == 0 {
+		select {
+		case event := <-events:
+			if event.Status == scp.WatchFailed {
+				t.Fatalf("uploading %s failed: %v", event.Path, event.Err)
+			}
+			if event.Status == scp.WatchUploaded {
+				uploads[event.Path]++
+			}
+		case <-timeout:
+			t.Fatalf("uploaded %v, want both files", uploads)
+		}
+	}
+	if uploads["file"] != 1 {
+		t.Errorf("uploaded the written file %d times, want once", uploads["file"])
+	}
+	for name, want := range map[string]string{"file": "three", "sub/dir/nested": "nested"} {
+		if got, err := os.ReadFile(filepath.Join(remote, filepath.FromSlash(name))); err != nil || string(got) != want {
+			t.Errorf("the remote %s holds %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Watch returned %v once its context was canceled", err)
+	}
+}
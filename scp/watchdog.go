@@ -0,0 +1,80 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// watchdog keeps track of the last moment bytes flowed through a transfer.
+type watchdog struct {
+	last atomic.Int64
+}
+
+func newWatchdog() *watchdog {
+	w := &watchdog{}
+	w.touch()
+	return w
+}
+
+func (w *watchdog) touch() {
+	w.last.Store(time.Now().UnixNano())
+}
+
+func (w *watchdog) idle() time.Duration {
+	return time.Since(time.Unix(0, w.last.Load()))
+}
+
+// Reader wraps the given reader so that every successful read counts as activity.
+// Its WriteTo copies through buffers of bufferSize.
+func (w *watchdog) Reader(r io.Reader, bufferSize int) io.Reader {
+	return &activityReader{r: r, dog: w, bufferSize: bufferSize}
+}
+
+// watch cancels the context with ErrStalled as soon as no activity has been seen
+// for longer than the timeout. It returns when the context is done.
+func (w *watchdog) watch(ctx context.Context, timeout time.Duration, cancel context.CancelCauseFunc) {
+	interval := timeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if w.idle() >= timeout {
+				cancel(ErrStalled)
+				return
+			}
+		}
+	}
+}
+
+type activityReader struct {
+	r          io.Reader
+	dog        *watchdog
+	bufferSize int
+}
+
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.dog.touch()
+	}
+	return n, err
+}
+
+func (a *activityReader) WriteTo(w io.Writer) (int64, error) {
+	return copyThroughBuffer(w, a, a.bufferSize)
+}
@@ -0,0 +1,42 @@
+package scp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"main/scp"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+	stalled := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.IdleTimeout(200 * time.Millisecond).RemoteBinary(fakeRemoteBinary(t, "cat >/dev/null\n"))
+	})
+	started := time.Now()
+	if _, err := stalled.CopyFromRemoteWithOptions(ctx, io.Discard, "file", scp.DownloadOptions{}); !errors.Is(err, scp.ErrStalled) {
+		t.Errorf("download from a remote sending nothing returned %v, want ErrStalled", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("the stalled download was aborted after %s", elapsed)
+	}
+
+	// Slow is not stalled: bytes keep flowing, if only every so often.
+	slow := newTestClient(t, func(c *scp.ClientConfigurer) {
+		c.IdleTimeout(300 * time.Millisecond).RemoteBinary(fakeRemoteBinary(t, `
+head -c 1 >/dev/null
+printf 'C0644 4 file\n'
+head -c 1 >/dev/null
+for i in 1 2 3 4; do printf x; sleep 0.15; done
+printf '\000'
+head -c 1 >/dev/null
+`))
+	})
+	var buf bytes.Buffer
+	if _, err := slow.CopyFromRemoteWithOptions(ctx, &buf, "file", scp.DownloadOptions{}); err != nil || buf.String() != "xxxx" {
+		t.Errorf("slow download returned %q, %v", buf.String(), err)
+	}
+}
@@ -0,0 +1,453 @@
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Record a line of the SCP protocol: a file or directory announced by a Create or Directory
+// record, the times of the next one in a Time record, or the end of a directory in an
+// EndDirectory record.
+type Record struct {
+	// Type Create, Directory, EndDirectory or Time.
+	Type ResponseType
+
+	// Mode the permissions of a file or directory, see FormatMode.
+	Mode fs.FileMode
+
+	// Size the length of the contents of a file.
+	Size int64
+
+	// Name the name of a file or directory, without any slash.
+	Name string
+
+	// Mtime and Atime the modification and access times of a Time record, sent in whole seconds.
+	Mtime time.Time
+	Atime time.Time
+}
+
+// FileRecord returns the Create record announcing a file of size bytes.
+func FileRecord(name string, mode fs.FileMode, size int64) Record {
+	return Record{Type: Create, Mode: mode, Size: size, Name: name}
+}
+
+// DirRecord returns the Directory record entering a directory, left by an EndDirectory record.
+func DirRecord(name string, mode fs.FileMode) Record {
+	return Record{Type: Directory, Mode: mode, Name: name}
+}
+
+// TimeRecord returns the Time record preceding a file or directory, to preserve its times.
+func TimeRecord(mtime time.Time, atime time.Time) Record {
+	return Record{Type: Time, Mtime: mtime, Atime: atime}
+}
+
+// String formats the record as it is sent, newline included.
+func (r Record) String() string {
+	switch r.Type {
+	case Create:
+		return createRecord(FormatMode(r.Mode), r.Size, r.Name)
+	case Directory:
+		return fmt.Sprintf("D%s 0 %s\n", FormatMode(r.Mode), r.Name)
+	case EndDirectory:
+		return "E\n"
+	case Time:
+		return fmt.Sprintf("T%d 0 %d 0\n", r.Mtime.Unix(), r.Atime.Unix())
+	}
+	return fmt.Sprintf("%c\n", r.Type)
+}
+
+// validate tells whether the record can be sent.
+func (r Record) validate() error {
+	switch r.Type {
+	case Create, Directory:
+		if r.Size < 0 {
+			return fmt.Errorf("invalid size %d in record", r.Size)
+		}
+		return checkFilename(r.Name)
+	case EndDirectory, Time:
+		return nil
+	}
+	return fmt.Errorf("unknown record type %q", r.Type)
+}
+
+// ParseRecord parses a record as sent by the remote, such as "C0644 12 file.txt\n".
+func ParseRecord(line string) (Record, error) {
+	if line == "" {
+		return Record{}, errors.New("empty record")
+	}
+	fileInfos := NewFileInfos()
+	record := Record{Type: line[0]}
+	switch record.Type {
+	case Create, Directory:
+		if err := ParseFileInfos(line, fileInfos); err != nil {
+			return Record{}, err
+		}
+		record.Mode, record.Size, record.Name = fileInfos.Mode(), fileInfos.Size, fileInfos.Filename
+	case Time:
+		if err := ParseFileTime(line[1:], fileInfos); err != nil {
+			return Record{}, err
+		}
+		record.Mtime, record.Atime = time.Unix(fileInfos.Mtime, 0), time.Unix(fileInfos.Atime, 0)
+	case EndDirectory:
+	default:
+		return Record{}, fmt.Errorf("Message does not follow scp protocol: %q", line)
+	}
+	return record, nil
+}
+
+// SessionOptions the flags the remote scp of a SourceSession or SinkSession runs with.
+type SessionOptions struct {
+	// Recursive allows directories, entered by Directory records.
+	Recursive bool
+
+	// PreserveTimes has the source send a Time record before every file and directory.
+	// A SourceSession may send them either way.
+	PreserveTimes bool
+
+	// TargetDirectory has the remote sink of a SourceSession fail unless its path is a directory.
+	TargetDirectory bool
+}
+
+// flags the flags of the remote scp, following mode: "t" to run it as the sink, "f" as the source.
+func (opts SessionOptions) flags(mode string) string {
+	flags := "-q" + mode
+	if opts.Recursive {
+		flags += "r"
+	}
+	if opts.PreserveTimes {
+		flags += "p"
+	}
+	if opts.TargetDirectory && mode == "t" {
+		flags += "d"
+	}
+	return flags
+}
+
+// wireSession the remote scp a SourceSession or SinkSession talks the protocol to.
+type wireSession struct {
+	client     *Client
+	ctx        context.Context
+	session    *ssh.Session
+	release    func()
+	stop       func() bool
+	stdin      io.WriteCloser
+	stdout     *bufio.Reader
+	bufferSize int
+	closed     bool
+	// warning the last warning of the remote, see explainExit.
+	warning *RemoteWarning
+}
+
+// startWire runs the remote scp on remotePath with flags in a new session. Once the context is
+// done the session is terminated.
+func (a *Client) startWire(ctx context.Context, remotePath string, flags string) (*wireSession, error) {
+	if err := checkNUL(remotePath); err != nil {
+		return nil, err
+	}
+	if err := a.resolveRemoteBinary(ctx); err != nil {
+		return nil, err
+	}
+	session, release, err := a.newSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating ssh session in protocol session: %w", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if err := session.Start(a.scpCommand(flags, remotePath)); err != nil {
+		release()
+		return nil, err
+	}
+
+	return &wireSession{
+		client:     a,
+		ctx:        ctx,
+		session:    session,
+		release:    release,
+		stop:       context.AfterFunc(ctx, func() { terminate(session) }),
+		stdin:      stdin,
+		stdout:     bufio.NewReader(stdout),
+		bufferSize: a.BufferSize,
+	}, nil
+}
+
+// err returns the cause of the context once it is done, which is why reading or writing failed.
+func (w *wireSession) err(err error) error {
+	if err != nil && w.ctx.Err() != nil {
+		return context.Cause(w.ctx)
+	}
+	return err
+}
+
+// readStatus reads an acknowledgement, or the message of an error. A warning sent in its place is
+// logged and taken as the acknowledgement, as the remote carries on after it.
+func (w *wireSession) readStatus() error {
+	status, err := w.stdout.ReadByte()
+	if err != nil {
+		return w.err(err)
+	}
+	switch status {
+	case Ok:
+		return nil
+	case Warning, Error:
+		err := w.readMessage(status)
+		if errors.As(err, &w.warning) {
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("Message does not follow scp protocol: unexpected status %q", status)
+}
+
+// readMessage reads the message of a warning or error, which ends with a newline. Warnings
+// are logged and returned as a RemoteWarning.
+func (w *wireSession) readMessage(status byte) error {
+	message, err := w.stdout.ReadString('\n')
+	if err != nil {
+		return w.err(err)
+	}
+	message = strings.TrimSuffix(message, "\n")
+	if status == Warning {
+		w.client.logf(w.ctx, LogWarning, "%s: %s", w.client.Host, message)
+		return &RemoteWarning{Message: message}
+	}
+	return errors.New(message)
+}
+
+// close ends the input of the remote scp and waits for it to exit.
+func (w *wireSession) close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.release()
+	defer w.stop()
+
+	w.stdin.Close()
+	return w.err(remoteError(w.session, explainExit(w.client.exitError(w.session.Wait()), w.warning)))
+}
+
+// SourceSession sends files to the remote scp running as the sink, one record at a time, for
+// transfers the copy functions do not cover such as several files or whole trees over one session.
+// Files are sent by SendFile, or by WriteRecord, AwaitAck, WriteBody and AwaitAck again. A
+// SourceSession is not safe for concurrent use and always speaks SCP, whatever the Backend.
+type SourceSession struct {
+	wire *wireSession
+}
+
+// NewSourceSession runs the remote scp receiving into remotePath, a directory unless a single file
+// is sent to it, and waits until it is ready. Once the context is done the session is terminated.
+// The session must be closed by Close.
+func (a *Client) NewSourceSession(ctx context.Context, remotePath string, opts SessionOptions) (*SourceSession, error) {
+	wire, err := a.startWire(ctx, remotePath, opts.flags("t"))
+	if err != nil {
+		return nil, err
+	}
+	// The sink acknowledges that it started before the first record.
+	if err := wire.readStatus(); err != nil {
+		// remoteStartError may have waited for the remote already, which close would do again.
+		wire.stdin.Close()
+		err = remoteError(wire.session, a.remoteStartError(wire.session, err))
+		wire.stop()
+		wire.release()
+		return nil, err
+	}
+	return &SourceSession{wire: wire}, nil
+}
+
+// WriteRecord writes the record without waiting for the remote to acknowledge it, see AwaitAck.
+func (s *SourceSession) WriteRecord(record Record) error {
+	if err := record.validate(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(s.wire.stdin, record.String())
+	return s.wire.err(err)
+}
+
+// AwaitAck waits for the remote to acknowledge the last record or body, and returns the message
+// it sent instead when it refused it.
+func (s *SourceSession) AwaitAck() error {
+	return s.wire.readStatus()
+}
+
+// WriteBody writes the contents of the file announced last, exactly size bytes read from r,
+// followed by the byte ending them. The remote acknowledges them once written, see AwaitAck.
+func (s *SourceSession) WriteBody(r io.Reader, size int64) error {
+	if _, err := copyN(s.wire.ctx, s.wire.stdin, r, size, s.wire.bufferSize); err != nil {
+		return s.wire.err(err)
+	}
+	_, err := s.wire.stdin.Write([]byte{Ok})
+	return s.wire.err(err)
+}
+
+// Send writes the record and waits for the remote to acknowledge it.
+func (s *SourceSession) Send(record Record) error {
+	if err := s.WriteRecord(record); err != nil {
+		return err
+	}
+	return s.AwaitAck()
+}
+
+// SendFile sends the file name with size bytes read from r, in the current directory of the remote.
+func (s *SourceSession) SendFile(name string, mode fs.FileMode, size int64, r io.Reader) error {
+	if err := s.Send(FileRecord(name, mode, size)); err != nil {
+		return err
+	}
+	if err := s.WriteBody(r, size); err != nil {
+		return err
+	}
+	return s.AwaitAck()
+}
+
+// SendDir enters the directory name, created when missing, until EndDir. The session must be recursive.
+func (s *SourceSession) SendDir(name string, mode fs.FileMode) error {
+	return s.Send(DirRecord(name, mode))
+}
+
+// EndDir leaves the directory entered last.
+func (s *SourceSession) EndDir() error {
+	return s.Send(Record{Type: EndDirectory})
+}
+
+// SendTimes sets the times of the file or directory sent next.
+func (s *SourceSession) SendTimes(mtime time.Time, atime time.Time) error {
+	return s.Send(TimeRecord(mtime, atime))
+}
+
+// Close ends the transfer and waits for the remote scp to exit, returning its exit error. It may be
+// called more than once, only the first call has an effect.
+func (s *SourceSession) Close() error {
+	return s.wire.close()
+}
+
+// SinkSession receives files from the remote scp running as the source, one record at a time.
+// Every record is read by Next, which acknowledges the one before, and the contents of files by
+// ReadBody. A SinkSession is not safe for concurrent use and always speaks SCP, whatever the Backend.
+type SinkSession struct {
+	wire *wireSession
+	// body the size of the contents of the file announced last, not read yet.
+	body    int64
+	pending bool
+}
+
+// NewSinkSession runs the remote scp sending remotePath, which may contain wildcards expanded by the
+// remote shell when NoShellQuoting is set. Once the context is done the session is terminated.
+// The session must be closed by Close.
+func (a *Client) NewSinkSession(ctx context.Context, remotePath string, opts SessionOptions) (*SinkSession, error) {
+	wire, err := a.startWire(ctx, remotePath, opts.flags("f"))
+	if err != nil {
+		return nil, err
+	}
+	return &SinkSession{wire: wire}, nil
+}
+
+// Ack acknowledges the last record or body, or asks the source to start before the first record.
+// Files are accepted by ReadBody instead, which acknowledges them before reading their contents.
+func (s *SinkSession) Ack() error {
+	if s.pending {
+		return errors.New("the contents of the last file were not read")
+	}
+	_, err := s.wire.stdin.Write([]byte{Ok})
+	return s.wire.err(err)
+}
+
+// ReadRecord reads the next record without acknowledging the last one, see Ack. It returns
+// io.EOF once the source sent everything. Warnings sent in place of a record, such as for a file
+// that could not be read, are logged and skipped, the last of them is returned instead of io.EOF
+// when no record follows. Errors of the remote are returned as they are.
+func (s *SinkSession) ReadRecord() (Record, error) {
+	if s.pending {
+		return Record{}, errors.New("the contents of the last file were not read")
+	}
+	var warning *RemoteWarning
+	recordType, err := s.wire.stdout.ReadByte()
+	for err == nil && recordType == Warning {
+		// Such as for a file that could not be read, the remote carries on with the next one.
+		if err := s.wire.readMessage(recordType); !errors.As(err, &warning) {
+			return Record{}, err
+		}
+		s.wire.warning = warning
+		recordType, err = s.wire.stdout.ReadByte()
+	}
+	if errors.Is(err, io.EOF) && s.wire.ctx.Err() == nil {
+		if warning != nil {
+			// Nothing but warnings were sent, such as for a single file that does not exist.
+			return Record{}, warning
+		}
+		return Record{}, io.EOF
+	}
+	if err != nil {
+		return Record{}, s.wire.err(err)
+	}
+	if recordType == Error {
+		return Record{}, s.wire.readMessage(recordType)
+	}
+
+	line, err := s.wire.stdout.ReadString('\n')
+	if err != nil {
+		return Record{}, s.wire.err(err)
+	}
+	record, err := ParseRecord(string(recordType) + line)
+	if err != nil {
+		return Record{}, err
+	}
+	if record.Type == Create {
+		s.body, s.pending = record.Size, true
+	}
+	return record, nil
+}
+
+// Next acknowledges the last record or body, or starts the source, and reads the next record.
+// It returns io.EOF once the source sent everything.
+func (s *SinkSession) Next() (Record, error) {
+	if err := s.Ack(); err != nil {
+		return Record{}, err
+	}
+	return s.ReadRecord()
+}
+
+// ReadBody acknowledges the file announced last, which has the remote send its contents, writes them
+// to w and reads the byte ending them, returning the amount of bytes written. The contents are
+// acknowledged by Ack or the next call to Next.
+func (s *SinkSession) ReadBody(w io.Writer) (int64, error) {
+	if !s.pending {
+		return 0, errors.New("no file was announced")
+	}
+	s.pending = false
+	if err := s.Ack(); err != nil {
+		return 0, err
+	}
+	n, err := copyN(s.wire.ctx, w, s.wire.stdout, s.body, s.wire.bufferSize)
+	if err != nil {
+		return n, s.wire.err(err)
+	}
+	return n, s.wire.readStatus()
+}
+
+// Close stops receiving and waits for the remote scp to exit, returning its exit error. The remote
+// exits with an error when it was stopped before it sent everything. It may be called more than
+// once, only the first call has an effect.
+func (s *SinkSession) Close() error {
+	return s.wire.close()
+}
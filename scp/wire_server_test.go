@@ -0,0 +1,94 @@
+package scp_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"main/scp"
+)
+
+func TestSourceAndSinkSessions(t *testing.T) {
+	client := newTestClient(t, nil)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	source, err := client.NewSourceSession(ctx, dir, scp.SessionOptions{Recursive: true, TargetDirectory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1700000000, 0)
+	steps := []func() error{
+		func() error { return source.SendFile("top.txt", 0600, 3, strings.NewReader("top")) },
+		func() error { return source.SendDir("sub", 0755) },
+		func() error { return source.SendTimes(mtime, mtime) },
+		func() error { return source.SendFile("nested.txt", 0644, 6, strings.NewReader("nested")) },
+		source.EndDir,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := os.ReadFile(filepath.Join(dir, "top.txt")); err != nil || string(got) != "top" {
+		t.Errorf("top.txt = %q, %v", got, err)
+	}
+	nested := filepath.Join(dir, "sub", "nested.txt")
+	if got, err := os.ReadFile(nested); err != nil || string(got) != "nested" {
+		t.Errorf("sub/nested.txt = %q, %v", got, err)
+	}
+	if info, err := os.Stat(nested); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("sub/nested.txt was not given the time sent: %v", info.ModTime())
+	}
+
+	sink, err := client.NewSinkSession(ctx, filepath.Join(dir, "sub"), scp.SessionOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		record, err := sink.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record.String())
+		if record.Type == scp.Create {
+			var body bytes.Buffer
+			if _, err := sink.ReadBody(&body); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, body.String())
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"D0755 0 sub\n", "C0644 6 nested.txt\n", "nested", "E\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("received %q, want %q", got, want)
+	}
+}
+
+func TestSinkSessionRemoteError(t *testing.T) {
+	client := newTestClient(t, nil)
+	sink, err := client.NewSinkSession(context.Background(), filepath.Join(t.TempDir(), "missing"), scp.SessionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if _, err := sink.Next(); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Next for a missing file returned %v, want the message of the remote", err)
+	}
+}
@@ -0,0 +1,37 @@
+package scp
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	for _, record := range []Record{
+		FileRecord("file.txt", 0644, 12),
+		FileRecord("setuid", fs.ModeSetuid|0755, 5<<30),
+		DirRecord("dir", 0750),
+		{Type: EndDirectory},
+		TimeRecord(mtime, mtime.Add(time.Second)),
+	} {
+		parsed, err := ParseRecord(record.String())
+		if err != nil {
+			t.Errorf("ParseRecord(%q) failed: %v", record, err)
+			continue
+		}
+		if parsed.Type != record.Type || parsed.Mode != record.Mode || parsed.Size != record.Size || parsed.Name != record.Name ||
+			!parsed.Mtime.Equal(record.Mtime) || !parsed.Atime.Equal(record.Atime) {
+			t.Errorf("ParseRecord(%q) = %+v, want %+v", record, parsed, record)
+		}
+	}
+
+	for _, line := range []string{"", "X\n", "C0644 12\n", "D0755 0 a/b\n"} {
+		if _, err := ParseRecord(line); err == nil {
+			t.Errorf("ParseRecord(%q) succeeded", line)
+		}
+	}
+	if err := FileRecord("a\nb", 0644, 1).validate(); err == nil {
+		t.Error("a file name with a newline is valid")
+	}
+}
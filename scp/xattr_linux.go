@@ -0,0 +1,56 @@
+//go:build linux
+
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of path for which keep returns true.
+// Symbolic links are not followed.
+func readXattrs(path string, keep func(name string) bool) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	list := make([]byte, size)
+	size, err = unix.Llistxattr(path, list)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string]string{}
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) == 0 || !keep(string(name)) {
+			continue
+		}
+		n, err := unix.Lgetxattr(path, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, n)
+		n, err = unix.Lgetxattr(path, string(name), value)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = string(value[:n])
+	}
+	return xattrs, nil
+}
+
+// writeXattr sets the extended attribute name of path, without following symbolic links.
+func writeXattr(path string, name string, value string) error {
+	return unix.Lsetxattr(path, name, []byte(value), 0)
+}
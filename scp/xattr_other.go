@@ -0,0 +1,21 @@
+//go:build !linux
+
+/* Copyright (c) 2024 Bram Vandenbogaerde And Contributors
+ * You may use, distribute or modify this code under the
+ * terms of the Mozilla Public License 2.0, which is distributed
+ * along with the source code.
+ */
+
+package scp
+
+// readXattrs extended attributes are only supported on Linux,
+// elsewhere no attributes are reported.
+func readXattrs(path string, keep func(name string) bool) (map[string]string, error) {
+	return nil, nil
+}
+
+// writeXattr extended attributes are only supported on Linux,
+// elsewhere they are dropped.
+func writeXattr(path string, name string, value string) error {
+	return nil
+}